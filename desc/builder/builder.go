@@ -0,0 +1,459 @@
+// Package builder contains a means of building and modifying proto
+// descriptors programmatically.
+//
+// There are builders for files, messages, fields, oneofs, enums, enum values,
+// services, and methods. Builders are mutable and are assembled into a tree,
+// just like the elements they describe: a file contains messages, a message
+// contains fields, and so on. Calling Build on any builder produces a
+// validated descriptor (via desc.CreateFileDescriptor).
+//
+// Builders can refer to other builders. For example, a field's type can be a
+// message builder, even one in a different file builder. Such references are
+// resolved when the descriptors are built. Any builders that are referenced
+// but are not part of a file are put into a synthesized file, whose name is
+// unique but unspecified.
+//
+// Field tag numbers and enum value numbers need not be assigned explicitly.
+// Fields that have no tag number are assigned the lowest available number,
+// and enum values that have no number are assigned the number after that of
+// the previous value (or zero for the first value).
+//
+// Methods whose names start with "Try" return an error if the operation is
+// not valid, for example because it would result in two elements with the
+// same name. The corresponding methods without the "Try" prefix instead
+// panic, and they return the builder so that calls can be chained.
+package builder
+
+import (
+	"fmt"
+
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/jhump/protoreflect/desc"
+)
+
+// Builder is the interface that is implemented by all builders. It allows
+// clients to access and modify the properties that are common to all
+// elements.
+type Builder interface {
+	// GetName returns this element's name. The name returned is a simple
+	// name, not a qualified name.
+	GetName() string
+
+	// TrySetName attempts to set the name of this element. It returns an
+	// error if the given name is not a valid identifier or if this element's
+	// parent already has a different element with the same name.
+	TrySetName(newName string) error
+
+	// GetParent returns this element's parent. It returns nil if this
+	// element has not been added to a parent.
+	GetParent() Builder
+
+	// GetFile returns the file builder that contains this element, or nil
+	// if this element is not (directly or indirectly) part of a file.
+	GetFile() *FileBuilder
+
+	// GetComments returns the comments for this element. The returned value
+	// can be modified to change the element's comments, which are included
+	// in the source code info of the built file.
+	GetComments() *Comments
+
+	// BuildDescriptor is a generic form of the builder's Build method. It
+	// produces the descriptor for this element.
+	BuildDescriptor() (desc.Descriptor, error)
+
+	setParent(parent Builder)
+	findChild(name string) Builder
+	removeChild(b Builder)
+}
+
+// Comments represents the comments associated with an element. These are
+// the same as the comments that are part of a descriptor's source code info.
+type Comments struct {
+	LeadingDetachedComments []string
+	LeadingComment          string
+	TrailingComment         string
+}
+
+type baseBuilder struct {
+	name     string
+	parent   Builder
+	comments Comments
+}
+
+// GetName returns the name of this element.
+func (b *baseBuilder) GetName() string {
+	return b.name
+}
+
+// GetParent returns the parent of this element, or nil if it has no parent.
+func (b *baseBuilder) GetParent() Builder {
+	return b.parent
+}
+
+// GetComments returns the comments for this element.
+func (b *baseBuilder) GetComments() *Comments {
+	return &b.comments
+}
+
+func (b *baseBuilder) setParent(parent Builder) {
+	b.parent = parent
+}
+
+func fileOf(b Builder) *FileBuilder {
+	for b != nil {
+		if fb, ok := b.(*FileBuilder); ok {
+			return fb
+		}
+		b = b.GetParent()
+	}
+	return nil
+}
+
+// checkRename verifies that the given element can be given the given names
+// (usually just one, but fields for groups and maps define two names) without
+// conflicting with other elements in its parent's namespace.
+func checkRename(b Builder, newNames ...string) error {
+	for _, n := range newNames {
+		if err := checkName(n); err != nil {
+			return err
+		}
+	}
+	if b.GetParent() == nil {
+		return nil
+	}
+	ns := namespaceOf(b.GetParent())
+	for _, n := range newNames {
+		if existing := ns.findChild(n); existing != nil && existing != b && existing.GetParent() != b {
+			return fmt.Errorf("%s already contains an element named %q", ns.GetName(), n)
+		}
+	}
+	return nil
+}
+
+func checkName(name string) error {
+	if name == "" {
+		return fmt.Errorf("name must not be empty")
+	}
+	for i, r := range name {
+		letter := r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+		if !letter && (i == 0 || r < '0' || r > '9') {
+			return fmt.Errorf("name %q is not a valid identifier", name)
+		}
+	}
+	return nil
+}
+
+// namespaceOf returns the builder whose namespace contains the names of the
+// given builder's children. Fields in a oneof, for example, are in the
+// namespace of the enclosing message.
+func namespaceOf(b Builder) Builder {
+	switch b := b.(type) {
+	case *OneOfBuilder:
+		if b.parent != nil {
+			return namespaceOf(b.parent)
+		}
+	case *FieldBuilder:
+		if b.parent != nil {
+			return namespaceOf(b.parent)
+		}
+	}
+	return b
+}
+
+// definedNames returns all of the names that the given element defines in
+// its parent's namespace.
+func definedNames(b Builder) []string {
+	names := []string{b.GetName()}
+	switch b := b.(type) {
+	case *FieldBuilder:
+		if b.msgType != nil {
+			names = append(names, b.msgType.GetName())
+		}
+	case *OneOfBuilder:
+		for _, c := range b.choices {
+			names = append(names, definedNames(c)...)
+		}
+	}
+	return names
+}
+
+// matchChild returns the given child if it has the given name. If the child
+// is a group or map field or a oneof, the elements it contains are also
+// checked.
+func matchChild(child Builder, name string) Builder {
+	if child.GetName() == name {
+		return child
+	}
+	switch child := child.(type) {
+	case *FieldBuilder:
+		if child.msgType != nil && child.msgType.GetName() == name {
+			return child.msgType
+		}
+	case *OneOfBuilder:
+		for _, c := range child.choices {
+			if m := matchChild(c, name); m != nil {
+				return m
+			}
+		}
+	}
+	return nil
+}
+
+// addChild verifies that the given child can be added to the given parent,
+// and sets the child's parent if so.
+func addChild(parent Builder, child Builder) error {
+	if child.GetParent() != nil {
+		return fmt.Errorf("%s is already part of %s", child.GetName(), child.GetParent().GetName())
+	}
+	ns := namespaceOf(parent)
+	for _, n := range definedNames(child) {
+		if ns.findChild(n) != nil {
+			return fmt.Errorf("%s already contains an element named %q", ns.GetName(), n)
+		}
+	}
+	child.setParent(parent)
+	return nil
+}
+
+// removeMessage removes the given builder from the given slice and returns
+// the new slice. The other remove functions are the same but for other kinds
+// of builders.
+func removeMessage(s []*MessageBuilder, b Builder) []*MessageBuilder {
+	for i, e := range s {
+		if Builder(e) == b {
+			return append(s[:i:i], s[i+1:]...)
+		}
+	}
+	return s
+}
+
+func removeEnum(s []*EnumBuilder, b Builder) []*EnumBuilder {
+	for i, e := range s {
+		if Builder(e) == b {
+			return append(s[:i:i], s[i+1:]...)
+		}
+	}
+	return s
+}
+
+func removeField(s []*FieldBuilder, b Builder) []*FieldBuilder {
+	for i, e := range s {
+		if Builder(e) == b {
+			return append(s[:i:i], s[i+1:]...)
+		}
+	}
+	return s
+}
+
+// FieldType represents the type of a field or extension. It can represent a
+// scalar type, a message or enum defined by a builder, or a message or enum
+// defined by an existing descriptor.
+type FieldType struct {
+	fieldType       dpb.FieldDescriptorProto_Type
+	localMsgType    *MessageBuilder
+	foreignMsgType  *desc.MessageDescriptor
+	localEnumType   *EnumBuilder
+	foreignEnumType *desc.EnumDescriptor
+}
+
+// GetType returns the kind of this field type. For message types, this
+// returns TYPE_MESSAGE; for enum types, TYPE_ENUM.
+func (ft *FieldType) GetType() dpb.FieldDescriptorProto_Type {
+	return ft.fieldType
+}
+
+// GetTypeName returns the fully-qualified name of the referenced message or
+// enum type, or the empty string for scalar types. If the type is a builder,
+// the name is that of the builder as if it were built now.
+func (ft *FieldType) GetTypeName() string {
+	switch {
+	case ft.localMsgType != nil:
+		return qualifiedName(ft.localMsgType)
+	case ft.foreignMsgType != nil:
+		return ft.foreignMsgType.GetFullyQualifiedName()
+	case ft.localEnumType != nil:
+		return qualifiedName(ft.localEnumType)
+	case ft.foreignEnumType != nil:
+		return ft.foreignEnumType.GetFullyQualifiedName()
+	default:
+		return ""
+	}
+}
+
+var scalarTypes = map[dpb.FieldDescriptorProto_Type]*FieldType{}
+
+func init() {
+	for t := range dpb.FieldDescriptorProto_Type_name {
+		typ := dpb.FieldDescriptorProto_Type(t)
+		switch typ {
+		case dpb.FieldDescriptorProto_TYPE_MESSAGE, dpb.FieldDescriptorProto_TYPE_GROUP, dpb.FieldDescriptorProto_TYPE_ENUM:
+			continue
+		}
+		scalarTypes[typ] = &FieldType{fieldType: typ}
+	}
+}
+
+// FieldTypeScalar returns a FieldType for the given scalar type. It panics
+// if the given type is not a scalar type (e.g. it is TYPE_MESSAGE, TYPE_GROUP,
+// or TYPE_ENUM).
+func FieldTypeScalar(t dpb.FieldDescriptorProto_Type) *FieldType {
+	ft := scalarTypes[t]
+	if ft == nil {
+		panic(fmt.Sprintf("field type %v is not a scalar type", t))
+	}
+	return ft
+}
+
+// FieldTypeInt32 returns a FieldType for the int32 scalar type.
+func FieldTypeInt32() *FieldType { return FieldTypeScalar(dpb.FieldDescriptorProto_TYPE_INT32) }
+
+// FieldTypeUInt32 returns a FieldType for the uint32 scalar type.
+func FieldTypeUInt32() *FieldType { return FieldTypeScalar(dpb.FieldDescriptorProto_TYPE_UINT32) }
+
+// FieldTypeSInt32 returns a FieldType for the sint32 scalar type.
+func FieldTypeSInt32() *FieldType { return FieldTypeScalar(dpb.FieldDescriptorProto_TYPE_SINT32) }
+
+// FieldTypeFixed32 returns a FieldType for the fixed32 scalar type.
+func FieldTypeFixed32() *FieldType { return FieldTypeScalar(dpb.FieldDescriptorProto_TYPE_FIXED32) }
+
+// FieldTypeSFixed32 returns a FieldType for the sfixed32 scalar type.
+func FieldTypeSFixed32() *FieldType { return FieldTypeScalar(dpb.FieldDescriptorProto_TYPE_SFIXED32) }
+
+// FieldTypeInt64 returns a FieldType for the int64 scalar type.
+func FieldTypeInt64() *FieldType { return FieldTypeScalar(dpb.FieldDescriptorProto_TYPE_INT64) }
+
+// FieldTypeUInt64 returns a FieldType for the uint64 scalar type.
+func FieldTypeUInt64() *FieldType { return FieldTypeScalar(dpb.FieldDescriptorProto_TYPE_UINT64) }
+
+// FieldTypeSInt64 returns a FieldType for the sint64 scalar type.
+func FieldTypeSInt64() *FieldType { return FieldTypeScalar(dpb.FieldDescriptorProto_TYPE_SINT64) }
+
+// FieldTypeFixed64 returns a FieldType for the fixed64 scalar type.
+func FieldTypeFixed64() *FieldType { return FieldTypeScalar(dpb.FieldDescriptorProto_TYPE_FIXED64) }
+
+// FieldTypeSFixed64 returns a FieldType for the sfixed64 scalar type.
+func FieldTypeSFixed64() *FieldType { return FieldTypeScalar(dpb.FieldDescriptorProto_TYPE_SFIXED64) }
+
+// FieldTypeFloat returns a FieldType for the float scalar type.
+func FieldTypeFloat() *FieldType { return FieldTypeScalar(dpb.FieldDescriptorProto_TYPE_FLOAT) }
+
+// FieldTypeDouble returns a FieldType for the double scalar type.
+func FieldTypeDouble() *FieldType { return FieldTypeScalar(dpb.FieldDescriptorProto_TYPE_DOUBLE) }
+
+// FieldTypeBool returns a FieldType for the bool scalar type.
+func FieldTypeBool() *FieldType { return FieldTypeScalar(dpb.FieldDescriptorProto_TYPE_BOOL) }
+
+// FieldTypeString returns a FieldType for the string scalar type.
+func FieldTypeString() *FieldType { return FieldTypeScalar(dpb.FieldDescriptorProto_TYPE_STRING) }
+
+// FieldTypeBytes returns a FieldType for the bytes scalar type.
+func FieldTypeBytes() *FieldType { return FieldTypeScalar(dpb.FieldDescriptorProto_TYPE_BYTES) }
+
+// FieldTypeMessage returns a FieldType for the message type defined by the
+// given builder.
+func FieldTypeMessage(mb *MessageBuilder) *FieldType {
+	return &FieldType{fieldType: dpb.FieldDescriptorProto_TYPE_MESSAGE, localMsgType: mb}
+}
+
+// FieldTypeImportedMessage returns a FieldType for the given message type.
+// The file that defines the message is imported by the file that uses it.
+func FieldTypeImportedMessage(md *desc.MessageDescriptor) *FieldType {
+	return &FieldType{fieldType: dpb.FieldDescriptorProto_TYPE_MESSAGE, foreignMsgType: md}
+}
+
+// FieldTypeEnum returns a FieldType for the enum type defined by the given
+// builder.
+func FieldTypeEnum(eb *EnumBuilder) *FieldType {
+	return &FieldType{fieldType: dpb.FieldDescriptorProto_TYPE_ENUM, localEnumType: eb}
+}
+
+// FieldTypeImportedEnum returns a FieldType for the given enum type. The
+// file that defines the enum is imported by the file that uses it.
+func FieldTypeImportedEnum(ed *desc.EnumDescriptor) *FieldType {
+	return &FieldType{fieldType: dpb.FieldDescriptorProto_TYPE_ENUM, foreignEnumType: ed}
+}
+
+// RpcType represents the type of an RPC request or response: a message type
+// and whether it is streamed.
+type RpcType struct {
+	IsStream bool
+
+	localType   *MessageBuilder
+	foreignType *desc.MessageDescriptor
+}
+
+// RpcTypeMessage returns an RpcType for the message type defined by the
+// given builder.
+func RpcTypeMessage(mb *MessageBuilder, stream bool) *RpcType {
+	return &RpcType{IsStream: stream, localType: mb}
+}
+
+// RpcTypeImportedMessage returns an RpcType for the given message type. The
+// file that defines the message is imported by the file that uses it.
+func RpcTypeImportedMessage(md *desc.MessageDescriptor, stream bool) *RpcType {
+	return &RpcType{IsStream: stream, foreignType: md}
+}
+
+// GetTypeName returns the fully-qualified name of the message type.
+func (rt *RpcType) GetTypeName() string {
+	if rt.localType != nil {
+		return qualifiedName(rt.localType)
+	}
+	return rt.foreignType.GetFullyQualifiedName()
+}
+
+// scopeOf is the builder counterpart to desc.ScopeOf, which can't be used here
+// because the element has no descriptor until it is built. It follows the same
+// rules, except that builders for group and map entry messages and for fields
+// in a one-of have parents that descriptors don't (the field and the one-of,
+// respectively), so those are skipped.
+func scopeOf(b Builder) string {
+	switch p := b.GetParent().(type) {
+	case nil:
+		return ""
+	case *FileBuilder:
+		return p.Package
+	case *FieldBuilder:
+		// a group or map entry message is defined in the same scope as
+		// its field
+		return scopeOf(p)
+	case *OneOfBuilder:
+		// fields in a oneof are defined in the enclosing message
+		if p.parent == nil {
+			return ""
+		}
+		return qualifiedName(p.parent)
+	default:
+		return qualifiedName(p)
+	}
+}
+
+// qualifiedName returns the fully-qualified name of the given element.
+func qualifiedName(b Builder) string {
+	if fb, ok := b.(*FileBuilder); ok {
+		return fb.Package
+	}
+	if scope := scopeOf(b); scope != "" {
+		return scope + "." + b.GetName()
+	}
+	return b.GetName()
+}
+
+// doBuild builds the file that contains the given element and then returns
+// the descriptor for the element.
+func doBuild(b Builder) (desc.Descriptor, error) {
+	r := newResolver()
+	fd, err := r.build(b)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := b.(*FileBuilder); ok {
+		return fd, nil
+	}
+	d := fd.FindSymbol(qualifiedName(b))
+	if d == nil {
+		return nil, fmt.Errorf("could not find %s in built file %s", qualifiedName(b), fd.GetName())
+	}
+	return d, nil
+}
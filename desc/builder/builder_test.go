@@ -0,0 +1,282 @@
+package builder
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/desc_test"
+)
+
+func TestBuildFile(t *testing.T) {
+	en := NewEnum("Kind").
+		AddValue(NewEnumValue("UNKNOWN")).
+		AddValue(NewEnumValue("FOO")).
+		AddValue(NewEnumValue("BAR").SetNumber(10)).
+		AddValue(NewEnumValue("BAZ"))
+	msg := NewMessage("Foo").
+		AddReservedRange(2, 4).
+		AddField(NewField("id", FieldTypeInt64()).SetRequired()).
+		AddField(NewField("name", FieldTypeString()).SetNumber(1)).
+		AddField(NewField("kind", FieldTypeEnum(en)).SetDefaultValue("FOO")).
+		AddField(NewField("children", FieldTypeMessage(NewMessage("Foo"))).SetRepeated()).
+		AddField(NewMapField("attrs_by_name", FieldTypeString(), FieldTypeInt32())).
+		AddField(NewGroup(NewMessage("Details").AddField(NewField("notes", FieldTypeString())))).
+		AddOneOf(NewOneOf("choice").
+			AddChoice(NewField("a", FieldTypeBool())).
+			AddChoice(NewField("b", FieldTypeBytes()))).
+		AddNestedEnum(en)
+	// replace the placeholder message type with a self-reference
+	msg.GetField("children").SetType(FieldTypeMessage(msg))
+	svc := NewService("FooService").
+		AddMethod(NewMethod("GetFoo", RpcTypeMessage(msg, false), RpcTypeMessage(msg, true)))
+	fb := NewFile("foo.proto").SetPackageName("foo.bar").
+		AddMessage(msg).
+		AddService(svc)
+
+	fd, err := fb.Build()
+	ok(t, err)
+	eq(t, "foo.proto", fd.GetName())
+	eq(t, "foo.bar", fd.GetPackage())
+	eq(t, 0, len(fd.GetDependencies()))
+
+	md := fd.FindMessage("foo.bar.Foo")
+	expectedTags := map[string]int32{"id": 4, "name": 1, "kind": 5, "children": 6, "attrs_by_name": 7, "details": 8, "a": 9, "b": 10}
+	eq(t, len(expectedTags), len(md.GetFields()))
+	for _, fld := range md.GetFields() {
+		eq(t, expectedTags[fld.GetName()], fld.GetNumber(), fld.GetName())
+	}
+	eq(t, dpb.FieldDescriptorProto_LABEL_REQUIRED, md.FindFieldByName("id").GetLabel())
+	eq(t, "attrsByName", md.FindFieldByName("attrs_by_name").GetJSONName())
+	eq(t, "foo.bar.Foo.Kind", md.FindFieldByName("kind").GetEnumType().GetFullyQualifiedName())
+	eq(t, "FOO", md.FindFieldByName("kind").AsFieldDescriptorProto().GetDefaultValue())
+	eq(t, md, md.FindFieldByName("children").GetMessageType())
+	eq(t, true, md.FindFieldByName("attrs_by_name").IsMap())
+	eq(t, "foo.bar.Foo.AttrsByNameEntry", md.FindFieldByName("attrs_by_name").GetMessageType().GetFullyQualifiedName())
+	eq(t, dpb.FieldDescriptorProto_TYPE_GROUP, md.FindFieldByName("details").GetType())
+	eq(t, "foo.bar.Foo.Details", md.FindFieldByName("details").GetMessageType().GetFullyQualifiedName())
+	eq(t, 1, len(md.GetOneOfs()))
+	eq(t, 2, len(md.GetOneOfs()[0].GetChoices()))
+	eq(t, md.GetOneOfs()[0], md.FindFieldByName("b").GetOneOf())
+
+	ed := md.GetNestedEnumTypes()[0]
+	var nums []int32
+	for _, v := range ed.GetValues() {
+		nums = append(nums, v.GetNumber())
+	}
+	eq(t, "[0 1 10 11]", fmt.Sprint(nums))
+	sd := fd.FindService("foo.bar.FooService")
+	mtd := sd.GetMethods()[0]
+	eq(t, md, mtd.GetInputType())
+	eq(t, md, mtd.GetOutputType())
+	eq(t, false, mtd.IsClientStreaming())
+	eq(t, true, mtd.IsServerStreaming())
+
+	// building an element builds its file
+	fld, err := msg.GetField("kind").Build()
+	ok(t, err)
+	eq(t, "foo.bar.Foo.kind", fld.GetFullyQualifiedName())
+	ev, err := en.GetValue("BAZ").Build()
+	ok(t, err)
+	eq(t, int32(11), ev.GetNumber())
+}
+
+func TestBuildCrossFileReferences(t *testing.T) {
+	shared := NewMessage("Shared").AddField(NewField("val", FieldTypeString()))
+	NewFile("shared.proto").SetPackageName("shared").AddMessage(shared)
+	user := NewMessage("User").AddField(NewField("s", FieldTypeMessage(shared)))
+	fb2 := NewFile("user.proto").SetPackageName("user").SetProto3(true).AddMessage(user)
+
+	fd, err := fb2.Build()
+	ok(t, err)
+	eq(t, 1, len(fd.GetDependencies()))
+	eq(t, "shared.proto", fd.GetDependencies()[0].GetName())
+	eq(t, "shared.Shared", fd.FindMessage("user.User").GetFields()[0].GetMessageType().GetFullyQualifiedName())
+	eq(t, "proto3", fd.AsFileDescriptorProto().GetSyntax())
+
+	// a reference back from the dependency creates a cycle
+	shared.AddField(NewField("u", FieldTypeMessage(user)))
+	_, err = fb2.Build()
+	eq(t, "import cycle: user.proto -> shared.proto -> user.proto", fmt.Sprint(err))
+}
+
+func TestBuildUnattachedElements(t *testing.T) {
+	// referenced elements that are not in any file all end up in the same
+	// synthesized file, so they can refer to one another
+	foo := NewMessage("Foo")
+	bar := NewMessage("Bar").AddField(NewField("foo", FieldTypeMessage(foo)))
+	foo.AddField(NewField("bar", FieldTypeMessage(bar)))
+	en := NewEnum("Color").AddValue(NewEnumValue("RED"))
+	foo.AddField(NewField("color", FieldTypeEnum(en)))
+
+	md, err := foo.Build()
+	ok(t, err)
+	eq(t, "Foo", md.GetFullyQualifiedName())
+	eq(t, "Bar", md.FindFieldByName("bar").GetMessageType().GetFullyQualifiedName())
+	eq(t, md.GetFile(), md.FindFieldByName("bar").GetMessageType().GetFile())
+	eq(t, md.GetFile(), md.FindFieldByName("color").GetEnumType().GetFile())
+	eq(t, true, strings.HasSuffix(md.GetFile().GetName(), ".proto"))
+
+	// a file that refers to an unattached element depends on the
+	// synthesized file
+	fb := NewFile("test.proto").AddMessage(NewMessage("Baz").AddField(NewField("foo", FieldTypeMessage(foo))))
+	fd, err := fb.Build()
+	ok(t, err)
+	eq(t, 1, len(fd.GetDependencies()))
+	eq(t, "Foo", fd.GetMessageTypes()[0].GetFields()[0].GetMessageType().GetFullyQualifiedName())
+
+	// a oneof must be in a message to be built
+	_, err = NewOneOf("o").AddChoice(NewField("f", FieldTypeBool())).Build()
+	eq(t, "oneof o must be added to a message before it can be built", fmt.Sprint(err))
+}
+
+func TestBuildImportedTypes(t *testing.T) {
+	md, err := desc.LoadMessageDescriptorForMessage((*desc_test.TestMessage)(nil))
+	ok(t, err)
+	ext, err := desc.LoadMessageDescriptorForMessage((*desc_test.AnotherTestMessage)(nil))
+	ok(t, err)
+	opts, err := desc.LoadMessageDescriptorForMessage((*dpb.MessageOptions)(nil))
+	ok(t, err)
+
+	fb := NewFile("imports.proto").SetPackageName("imports").
+		AddMessage(NewMessage("Msg").
+			AddField(NewField("tm", FieldTypeImportedMessage(md))).
+			AddField(NewField("ne", FieldTypeImportedEnum(md.GetNestedEnumTypes()[0])))).
+		AddExtension(NewExtensionImported("ext", 150, FieldTypeString(), ext)).
+		AddExtension(NewExtensionImported("opt", 20000, FieldTypeBool(), opts))
+	fd, err := fb.Build()
+	ok(t, err)
+	var deps []string
+	for _, dep := range fd.GetDependencies() {
+		deps = append(deps, dep.GetName())
+	}
+	eq(t, "[desc_test1.proto google/protobuf/descriptor.proto]", fmt.Sprint(deps))
+	eq(t, md, fd.FindMessage("imports.Msg").FindFieldByName("tm").GetMessageType())
+	eq(t, ext, fd.FindExtensionByName("imports.ext").GetOwner())
+
+	// the extension's tag must be in one of the extendee's ranges
+	_, err = NewExtensionImported("bad", 250, FieldTypeString(), ext).Build()
+	eq(t, "extension bad: tag 250 is not in an extension range of desc_test.AnotherTestMessage", fmt.Sprint(err))
+}
+
+func TestBuildComments(t *testing.T) {
+	fb := NewFile("comments.proto")
+	fb.PackageComments.LeadingComment = " package comment\n"
+	msg := NewMessage("Foo").SetComments(Comments{
+		LeadingDetachedComments: []string{" detached\n"},
+		LeadingComment:          " leading\n",
+	})
+	msg.AddField(NewField("bar", FieldTypeString()).SetComments(Comments{TrailingComment: " trailing\n"}))
+	fb.AddMessage(msg)
+
+	fd, err := fb.Build()
+	ok(t, err)
+	md := fd.GetMessageTypes()[0]
+	eq(t, " detached\n", md.GetSourceInfo().GetLeadingDetachedComments()[0])
+	eq(t, " leading\n", md.GetSourceInfo().GetLeadingComments())
+	eq(t, " trailing\n", md.GetFields()[0].GetSourceInfo().GetTrailingComments())
+	eq(t, 3, len(fd.AsFileDescriptorProto().GetSourceCodeInfo().GetLocation()))
+}
+
+func TestNamesAndRenames(t *testing.T) {
+	msg := NewMessage("Foo").
+		AddField(NewField("a", FieldTypeString())).
+		AddField(NewMapField("m", FieldTypeString(), FieldTypeString())).
+		AddField(NewGroup(NewMessage("Grp"))).
+		AddOneOf(NewOneOf("o").AddChoice(NewField("c", FieldTypeString())))
+
+	// names of fields, oneof fields, and group and map entry messages are
+	// all in the message's namespace
+	for _, name := range []string{"a", "m", "MEntry", "grp", "Grp", "o", "c"} {
+		err := msg.TryAddNestedMessage(NewMessage(name))
+		eq(t, true, err != nil, name)
+	}
+	eq(t, true, msg.GetOneOf("o").TryAddChoice(NewField("a", FieldTypeString())) != nil)
+	eq(t, true, msg.GetField("a").TrySetName("c") != nil)
+	eq(t, true, msg.GetField("a").TrySetName("not valid") != nil)
+	eq(t, true, msg.TryAddField(NewField("b", FieldTypeString()).SetNumber(1)) == nil)
+
+	// renaming a map field renames its entry; renaming a group renames both
+	// the field and its message
+	ok(t, msg.GetField("m").TrySetName("mm"))
+	eq(t, "MmEntry", msg.GetField("mm").GetType().localMsgType.GetName())
+	ok(t, msg.GetField("grp").TrySetName("Group"))
+	eq(t, "group", msg.GetField("group").GetName())
+	eq(t, "Group", msg.GetField("group").GetGroupType().GetName())
+	eq(t, true, msg.GetField("mm").GetType().localMsgType.TrySetName("Other") != nil)
+
+	msg.RemoveField("c")
+	eq(t, 0, len(msg.GetOneOf("o").GetChoices()))
+	msg.RemoveOneOf("o")
+	eq(t, (*OneOfBuilder)(nil), msg.GetOneOf("o"))
+	ok(t, msg.TryAddNestedMessage(NewMessage("o")))
+}
+
+func TestBuildErrors(t *testing.T) {
+	testCases := []struct {
+		builder Builder
+		err     string
+	}{
+		{
+			builder: NewMessage("Foo").
+				AddField(NewField("a", FieldTypeString()).SetNumber(1)).
+				AddField(NewField("b", FieldTypeString()).SetNumber(1)),
+			err: "message Foo: fields a and b both use tag 1",
+		},
+		{
+			builder: NewMessage("Foo").
+				AddReservedRange(1, 10).
+				AddField(NewField("a", FieldTypeString()).SetNumber(5)),
+			err: "message Foo: field a uses tag 5, which is reserved or in an extension range",
+		},
+		{
+			builder: NewFile("test.proto").SetProto3(true).
+				AddMessage(NewMessage("Foo").AddField(NewField("a", FieldTypeString()).SetRequired())),
+			err: "field Foo.a: required fields are not allowed in proto3",
+		},
+		{
+			builder: NewFile("test.proto").SetProto3(true).
+				AddEnum(NewEnum("Foo").AddValue(NewEnumValue("A").SetNumber(1))),
+			err: "enum Foo: first value must be zero in proto3",
+		},
+		{
+			builder: NewEnum("Foo").
+				AddValue(NewEnumValue("A").SetNumber(1)).
+				AddValue(NewEnumValue("B").SetNumber(1)),
+			err: "enum Foo: values A and B both use number 1; set allow_alias to permit this",
+		},
+		{
+			builder: NewFile("test.proto").SetPackageName("pkg").
+				AddEnum(NewEnum("Foo").AddValue(NewEnumValue("A"))).
+				AddEnum(NewEnum("Bar").AddValue(NewEnumValue("A"))),
+			err: "file test.proto: duplicate symbol pkg.A",
+		},
+		{
+			builder: NewEnum("Foo"),
+			err:     "enum Foo: must have at least one value",
+		},
+		{
+			builder: NewMessage("Foo").AddField(NewMapField("m", FieldTypeDouble(), FieldTypeString())),
+			err:     "field Foo.m: invalid map key type",
+		},
+		{
+			builder: NewMessage("Foo").AddField(NewGroup(NewMessage("grp"))),
+			err:     "field Foo.grp: group name grp must start with a capital letter",
+		},
+		{
+			builder: NewMessage("1Foo"),
+			err:     `message 1Foo: name "1Foo" is not a valid identifier`,
+		},
+		{
+			builder: NewExtension("ext", 1, FieldTypeString(), NewMessage("Foo").AddExtensionRange(100, 200)),
+			err:     "extension ext: tag 1 is not in an extension range of Foo",
+		},
+	}
+	for i, tc := range testCases {
+		_, err := tc.builder.BuildDescriptor()
+		eq(t, tc.err, fmt.Sprint(err), "case #%d", i+1)
+	}
+}
@@ -0,0 +1,284 @@
+package builder
+
+import (
+	"fmt"
+
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/jhump/protoreflect/desc"
+)
+
+// EnumBuilder is a builder used to construct a desc.EnumDescriptor.
+//
+// Reserved ranges use the same representation as the descriptor protos: both
+// the start and the end of a range are inclusive.
+type EnumBuilder struct {
+	baseBuilder
+
+	Options        *dpb.EnumOptions
+	ReservedRanges []*dpb.EnumDescriptorProto_EnumReservedRange
+	ReservedNames  []string
+
+	values []*EnumValueBuilder
+}
+
+// NewEnum creates a new EnumBuilder for an enum with the given name. Since the
+// new enum has no parent, it is in the default (unnamed) package until it is
+// added to a file or to a message.
+func NewEnum(name string) *EnumBuilder {
+	return &EnumBuilder{baseBuilder: baseBuilder{name: name}}
+}
+
+// TrySetName changes this enum's name. It returns an error if the given name
+// is not a valid identifier or if it conflicts with another element in the
+// enum's parent.
+func (eb *EnumBuilder) TrySetName(newName string) error {
+	if err := checkRename(eb, newName); err != nil {
+		return err
+	}
+	eb.name = newName
+	return nil
+}
+
+// SetName changes this enum's name. It panics if the name cannot be set. It
+// returns the enum builder, for method chaining.
+func (eb *EnumBuilder) SetName(newName string) *EnumBuilder {
+	if err := eb.TrySetName(newName); err != nil {
+		panic(err)
+	}
+	return eb
+}
+
+// SetComments sets the comments associated with the enum. It returns the enum
+// builder, for method chaining.
+func (eb *EnumBuilder) SetComments(c Comments) *EnumBuilder {
+	eb.comments = c
+	return eb
+}
+
+// GetFile returns the file builder that contains this enum, or nil if there
+// is none.
+func (eb *EnumBuilder) GetFile() *FileBuilder {
+	return fileOf(eb)
+}
+
+// SetOptions sets the enum options. It returns the enum builder, for method
+// chaining.
+func (eb *EnumBuilder) SetOptions(options *dpb.EnumOptions) *EnumBuilder {
+	eb.Options = options
+	return eb
+}
+
+// AddReservedRange adds a reserved range of numbers to this enum. Both the
+// start and the end are inclusive. It returns the enum builder, for method
+// chaining.
+func (eb *EnumBuilder) AddReservedRange(start, end int32) *EnumBuilder {
+	eb.ReservedRanges = append(eb.ReservedRanges, &dpb.EnumDescriptorProto_EnumReservedRange{Start: &start, End: &end})
+	return eb
+}
+
+// AddReservedName adds a reserved value name to this enum. It returns the enum
+// builder, for method chaining.
+func (eb *EnumBuilder) AddReservedName(name string) *EnumBuilder {
+	eb.ReservedNames = append(eb.ReservedNames, name)
+	return eb
+}
+
+func (eb *EnumBuilder) findChild(name string) Builder {
+	for _, evb := range eb.values {
+		if evb.GetName() == name {
+			return evb
+		}
+	}
+	return nil
+}
+
+func (eb *EnumBuilder) removeChild(b Builder) {
+	if b.GetParent() != Builder(eb) {
+		return
+	}
+	for i, evb := range eb.values {
+		if Builder(evb) == b {
+			eb.values = append(eb.values[:i:i], eb.values[i+1:]...)
+			break
+		}
+	}
+	b.setParent(nil)
+}
+
+// GetValues returns the values of this enum.
+func (eb *EnumBuilder) GetValues() []*EnumValueBuilder {
+	return eb.values
+}
+
+// GetValue returns the value with the given name, or nil if there is no such
+// value.
+func (eb *EnumBuilder) GetValue(name string) *EnumValueBuilder {
+	evb, _ := eb.findChild(name).(*EnumValueBuilder)
+	return evb
+}
+
+// TryAddValue adds the given value to this enum. It returns an error if the
+// value already has a parent or if this enum already has a value with the
+// same name.
+func (eb *EnumBuilder) TryAddValue(evb *EnumValueBuilder) error {
+	if err := addChild(eb, evb); err != nil {
+		return err
+	}
+	eb.values = append(eb.values, evb)
+	return nil
+}
+
+// AddValue adds the given value to this enum. It panics if the value cannot
+// be added. It returns the enum builder, for method chaining.
+func (eb *EnumBuilder) AddValue(evb *EnumValueBuilder) *EnumBuilder {
+	if err := eb.TryAddValue(evb); err != nil {
+		panic(err)
+	}
+	return eb
+}
+
+// RemoveValue removes the value with the given name, if present. It returns
+// the enum builder, for method chaining.
+func (eb *EnumBuilder) RemoveValue(name string) *EnumBuilder {
+	if evb := eb.GetValue(name); evb != nil {
+		eb.removeChild(evb)
+	}
+	return eb
+}
+
+// Build constructs an enum descriptor based on the contents of this enum
+// builder. The file that contains the enum is built too (along with any files
+// it depends on). If the enum is not part of a file, a file is synthesized
+// for it.
+func (eb *EnumBuilder) Build() (*desc.EnumDescriptor, error) {
+	d, err := doBuild(eb)
+	if err != nil {
+		return nil, err
+	}
+	return d.(*desc.EnumDescriptor), nil
+}
+
+// BuildDescriptor constructs an enum descriptor based on the contents of this
+// enum builder. Most usages will prefer Build() instead, whose return type is
+// a concrete descriptor type. This method is present to satisfy the Builder
+// interface.
+func (eb *EnumBuilder) BuildDescriptor() (desc.Descriptor, error) {
+	return eb.Build()
+}
+
+// EnumValueBuilder is a builder used to construct a desc.EnumValueDescriptor.
+//
+// A value whose number has not been set is assigned one when it is built: one
+// more than the number of the previous value, or zero for the first value.
+type EnumValueBuilder struct {
+	baseBuilder
+
+	Options *dpb.EnumValueOptions
+
+	number    int32
+	numberSet bool
+}
+
+// NewEnumValue creates a new EnumValueBuilder for a value with the given name.
+// The value has no number and will be assigned one when built.
+func NewEnumValue(name string) *EnumValueBuilder {
+	return &EnumValueBuilder{baseBuilder: baseBuilder{name: name}}
+}
+
+// TrySetName changes this value's name. It returns an error if the given name
+// is not a valid identifier or if the enum already has a value with the same
+// name.
+func (evb *EnumValueBuilder) TrySetName(newName string) error {
+	if err := checkRename(evb, newName); err != nil {
+		return err
+	}
+	evb.name = newName
+	return nil
+}
+
+// SetName changes this value's name. It panics if the name cannot be set. It
+// returns the value builder, for method chaining.
+func (evb *EnumValueBuilder) SetName(newName string) *EnumValueBuilder {
+	if err := evb.TrySetName(newName); err != nil {
+		panic(err)
+	}
+	return evb
+}
+
+// SetComments sets the comments associated with the value. It returns the
+// value builder, for method chaining.
+func (evb *EnumValueBuilder) SetComments(c Comments) *EnumValueBuilder {
+	evb.comments = c
+	return evb
+}
+
+// GetFile returns the file builder that contains this value, or nil if there
+// is none.
+func (evb *EnumValueBuilder) GetFile() *FileBuilder {
+	return fileOf(evb)
+}
+
+func (evb *EnumValueBuilder) findChild(name string) Builder {
+	// enum values do not have children
+	return nil
+}
+
+func (evb *EnumValueBuilder) removeChild(b Builder) {
+	// enum values do not have children
+}
+
+// GetNumber returns the number of this value. If HasNumber returns false,
+// the number has not been set and will be assigned when the value is built.
+func (evb *EnumValueBuilder) GetNumber() int32 {
+	return evb.number
+}
+
+// HasNumber returns true if the number of this value has been set.
+func (evb *EnumValueBuilder) HasNumber() bool {
+	return evb.numberSet
+}
+
+// SetNumber sets the number of this value. It returns the value builder, for
+// method chaining.
+func (evb *EnumValueBuilder) SetNumber(number int32) *EnumValueBuilder {
+	evb.number = number
+	evb.numberSet = true
+	return evb
+}
+
+// ClearNumber clears the number of this value, so that one will be assigned
+// when the value is built. It returns the value builder, for method chaining.
+func (evb *EnumValueBuilder) ClearNumber() *EnumValueBuilder {
+	evb.number = 0
+	evb.numberSet = false
+	return evb
+}
+
+// SetOptions sets the enum value options. It returns the value builder, for
+// method chaining.
+func (evb *EnumValueBuilder) SetOptions(options *dpb.EnumValueOptions) *EnumValueBuilder {
+	evb.Options = options
+	return evb
+}
+
+// Build constructs an enum value descriptor based on the contents of this
+// value builder. The value must first be added to an enum.
+func (evb *EnumValueBuilder) Build() (*desc.EnumValueDescriptor, error) {
+	if evb.parent == nil {
+		return nil, fmt.Errorf("enum value %s must be added to an enum before it can be built", evb.GetName())
+	}
+	d, err := doBuild(evb)
+	if err != nil {
+		return nil, err
+	}
+	return d.(*desc.EnumValueDescriptor), nil
+}
+
+// BuildDescriptor constructs an enum value descriptor based on the contents
+// of this value builder. Most usages will prefer Build() instead, whose
+// return type is a concrete descriptor type. This method is present to
+// satisfy the Builder interface.
+func (evb *EnumValueBuilder) BuildDescriptor() (desc.Descriptor, error) {
+	return evb.Build()
+}
@@ -0,0 +1,489 @@
+package builder
+
+import (
+	"fmt"
+	"strings"
+
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/jhump/protoreflect/desc"
+)
+
+const (
+	maxTag               = 536870911 // 2^29 - 1
+	specialReservedStart = 19000
+	specialReservedEnd   = 19999
+)
+
+// FieldBuilder is a builder used to construct a desc.FieldDescriptor. A field
+// builder is used to create fields and extensions as well as map and group
+// fields (which also define a message type).
+//
+// A field whose tag number is zero has not been assigned a number. When the
+// field is built, it is assigned the lowest number that is not used by another
+// field in the message and that is not in a reserved or extension range.
+// Extensions must be given a number explicitly.
+type FieldBuilder struct {
+	baseBuilder
+	number int32
+
+	Options  *dpb.FieldOptions
+	Label    dpb.FieldDescriptorProto_Label
+	Default  string
+	JsonName string
+
+	fieldType *FieldType
+	// msgType is the message type defined by a group or map field
+	msgType *MessageBuilder
+
+	localExtendee   *MessageBuilder
+	foreignExtendee *desc.MessageDescriptor
+}
+
+// NewField creates a new FieldBuilder for a non-extension field with the
+// given name and type. The field is optional and has no tag number.
+func NewField(name string, typ *FieldType) *FieldBuilder {
+	return &FieldBuilder{
+		baseBuilder: baseBuilder{name: name},
+		Label:       dpb.FieldDescriptorProto_LABEL_OPTIONAL,
+		fieldType:   typ,
+	}
+}
+
+// NewMapField creates a new FieldBuilder for a map field with the given name
+// and the given key and value types. The map entry message is synthesized
+// and is named after the field. The key type must be an integer, bool, or
+// string type; this is verified when the field is built.
+func NewMapField(name string, keyTyp, valTyp *FieldType) *FieldBuilder {
+	entry := NewMessage("")
+	entry.isMapEntry = true
+	entry.AddField(NewField("key", keyTyp).SetNumber(1))
+	entry.AddField(NewField("value", valTyp).SetNumber(2))
+	flb := NewField(name, FieldTypeMessage(entry)).SetRepeated()
+	flb.msgType = entry
+	entry.setParent(flb)
+	return flb
+}
+
+// NewGroup creates a new FieldBuilder for a group field whose type is the
+// given message. The field's name is the lower-case form of the message's
+// name. The given message becomes a child of the returned field, so it must
+// not already have a parent. This function panics if it does.
+func NewGroup(mb *MessageBuilder) *FieldBuilder {
+	if mb.GetParent() != nil {
+		panic(fmt.Sprintf("message %s is already part of %s", mb.GetName(), mb.GetParent().GetName()))
+	}
+	flb := NewField("", &FieldType{fieldType: dpb.FieldDescriptorProto_TYPE_GROUP, localMsgType: mb})
+	flb.msgType = mb
+	mb.setParent(flb)
+	return flb
+}
+
+// NewExtension creates a new FieldBuilder for an extension field with the
+// given name, tag, and type that extends the message defined by the given
+// builder.
+func NewExtension(name string, tag int32, typ *FieldType, extendee *MessageBuilder) *FieldBuilder {
+	flb := NewField(name, typ).SetNumber(tag)
+	flb.localExtendee = extendee
+	return flb
+}
+
+// NewExtensionImported creates a new FieldBuilder for an extension field with
+// the given name, tag, and type that extends the given message. The file that
+// defines the message is imported by the file that defines the extension.
+func NewExtensionImported(name string, tag int32, typ *FieldType, extendee *desc.MessageDescriptor) *FieldBuilder {
+	flb := NewField(name, typ).SetNumber(tag)
+	flb.foreignExtendee = extendee
+	return flb
+}
+
+// GetName returns the name of this field. The name of a group field is the
+// lower-case form of the name of its message.
+func (flb *FieldBuilder) GetName() string {
+	if flb.IsGroup() {
+		return strings.ToLower(flb.msgType.GetName())
+	}
+	return flb.name
+}
+
+// TrySetName changes this field's name. It returns an error if the given name
+// is not a valid identifier or if it conflicts with another element in the
+// field's parent. For a group field, this sets the name of the group's
+// message, and the field's name becomes the lower-case form of the given
+// name. For a map field, the name of the map entry message also changes.
+func (flb *FieldBuilder) TrySetName(newName string) error {
+	switch {
+	case flb.IsGroup():
+		if err := checkRename(flb, strings.ToLower(newName), newName); err != nil {
+			return err
+		}
+		flb.msgType.name = newName
+	case flb.IsMap():
+		if err := checkRename(flb, newName, mapEntryName(newName)); err != nil {
+			return err
+		}
+		flb.name = newName
+	default:
+		if err := checkRename(flb, newName); err != nil {
+			return err
+		}
+		flb.name = newName
+	}
+	return nil
+}
+
+// SetName changes this field's name. It panics if the name cannot be set. It
+// returns the field builder, for method chaining.
+func (flb *FieldBuilder) SetName(newName string) *FieldBuilder {
+	if err := flb.TrySetName(newName); err != nil {
+		panic(err)
+	}
+	return flb
+}
+
+// SetComments sets the comments associated with the field. It returns the
+// field builder, for method chaining.
+func (flb *FieldBuilder) SetComments(c Comments) *FieldBuilder {
+	flb.comments = c
+	return flb
+}
+
+// GetFile returns the file builder that contains this field, or nil if there
+// is none.
+func (flb *FieldBuilder) GetFile() *FileBuilder {
+	return fileOf(flb)
+}
+
+func (flb *FieldBuilder) findChild(name string) Builder {
+	if flb.msgType != nil && flb.msgType.GetName() == name {
+		return flb.msgType
+	}
+	return nil
+}
+
+func (flb *FieldBuilder) removeChild(b Builder) {
+	// the message type of a group or map field cannot be removed
+}
+
+// GetNumber returns the tag number of this field, or zero if the field has
+// no number and will be assigned one when built.
+func (flb *FieldBuilder) GetNumber() int32 {
+	return flb.number
+}
+
+// TrySetNumber sets the tag number of this field. Zero means that a number
+// will be assigned when the field is built. It returns an error if the number
+// is negative, too large, or in the range that is reserved for the protobuf
+// implementation.
+func (flb *FieldBuilder) TrySetNumber(tag int32) error {
+	if tag < 0 || tag > maxTag {
+		return fmt.Errorf("tag number %d must be between 1 and %d", tag, maxTag)
+	}
+	if tag >= specialReservedStart && tag <= specialReservedEnd {
+		return fmt.Errorf("tag number %d is in the disallowed range %d-%d", tag, specialReservedStart, specialReservedEnd)
+	}
+	flb.number = tag
+	return nil
+}
+
+// SetNumber sets the tag number of this field. It panics if the number is not
+// valid. It returns the field builder, for method chaining.
+func (flb *FieldBuilder) SetNumber(tag int32) *FieldBuilder {
+	if err := flb.TrySetNumber(tag); err != nil {
+		panic(err)
+	}
+	return flb
+}
+
+// SetLabel sets the label of this field. It returns the field builder, for
+// method chaining.
+func (flb *FieldBuilder) SetLabel(lbl dpb.FieldDescriptorProto_Label) *FieldBuilder {
+	flb.Label = lbl
+	return flb
+}
+
+// SetOptional makes this field optional. It returns the field builder, for
+// method chaining.
+func (flb *FieldBuilder) SetOptional() *FieldBuilder {
+	return flb.SetLabel(dpb.FieldDescriptorProto_LABEL_OPTIONAL)
+}
+
+// SetRequired makes this field required. It returns the field builder, for
+// method chaining.
+func (flb *FieldBuilder) SetRequired() *FieldBuilder {
+	return flb.SetLabel(dpb.FieldDescriptorProto_LABEL_REQUIRED)
+}
+
+// SetRepeated makes this field repeated. It returns the field builder, for
+// method chaining.
+func (flb *FieldBuilder) SetRepeated() *FieldBuilder {
+	return flb.SetLabel(dpb.FieldDescriptorProto_LABEL_REPEATED)
+}
+
+// IsRepeated returns true if this field is repeated.
+func (flb *FieldBuilder) IsRepeated() bool {
+	return flb.Label == dpb.FieldDescriptorProto_LABEL_REPEATED
+}
+
+// IsRequired returns true if this field is required.
+func (flb *FieldBuilder) IsRequired() bool {
+	return flb.Label == dpb.FieldDescriptorProto_LABEL_REQUIRED
+}
+
+// SetDefaultValue sets the default value of this field, in the same textual
+// form used in descriptor protos. It returns the field builder, for method
+// chaining.
+func (flb *FieldBuilder) SetDefaultValue(defValue string) *FieldBuilder {
+	flb.Default = defValue
+	return flb
+}
+
+// SetJsonName sets the JSON name of this field. If not set, the default JSON
+// name, derived from the field's name, is used. It returns the field builder,
+// for method chaining.
+func (flb *FieldBuilder) SetJsonName(jsonName string) *FieldBuilder {
+	flb.JsonName = jsonName
+	return flb
+}
+
+// SetOptions sets the field options. It returns the field builder, for method
+// chaining.
+func (flb *FieldBuilder) SetOptions(options *dpb.FieldOptions) *FieldBuilder {
+	flb.Options = options
+	return flb
+}
+
+// GetType returns the type of this field.
+func (flb *FieldBuilder) GetType() *FieldType {
+	return flb.fieldType
+}
+
+// TrySetType changes the type of this field. It returns an error if this is a
+// group or map field, whose types cannot be changed.
+func (flb *FieldBuilder) TrySetType(typ *FieldType) error {
+	if flb.msgType != nil {
+		return fmt.Errorf("cannot change the type of group or map field %s", flb.GetName())
+	}
+	flb.fieldType = typ
+	return nil
+}
+
+// SetType changes the type of this field. It panics if the type cannot be
+// changed. It returns the field builder, for method chaining.
+func (flb *FieldBuilder) SetType(typ *FieldType) *FieldBuilder {
+	if err := flb.TrySetType(typ); err != nil {
+		panic(err)
+	}
+	return flb
+}
+
+// IsMap returns true if this is a map field.
+func (flb *FieldBuilder) IsMap() bool {
+	return flb.msgType != nil && flb.msgType.isMapEntry
+}
+
+// IsGroup returns true if this is a group field.
+func (flb *FieldBuilder) IsGroup() bool {
+	return flb.msgType != nil && !flb.msgType.isMapEntry
+}
+
+// GetGroupType returns the message type of this group field, or nil if this
+// is not a group.
+func (flb *FieldBuilder) GetGroupType() *MessageBuilder {
+	if flb.IsGroup() {
+		return flb.msgType
+	}
+	return nil
+}
+
+// IsExtension returns true if this is an extension field.
+func (flb *FieldBuilder) IsExtension() bool {
+	return flb.localExtendee != nil || flb.foreignExtendee != nil
+}
+
+// GetExtendeeTypeName returns the fully-qualified name of the message that
+// this extension extends, or the empty string if this is not an extension.
+func (flb *FieldBuilder) GetExtendeeTypeName() string {
+	switch {
+	case flb.localExtendee != nil:
+		return qualifiedName(flb.localExtendee)
+	case flb.foreignExtendee != nil:
+		return flb.foreignExtendee.GetFullyQualifiedName()
+	default:
+		return ""
+	}
+}
+
+// Build constructs a field descriptor based on the contents of this field
+// builder. The file that contains the field is built too (along with any
+// files it depends on). If the field is not part of a file, a file is
+// synthesized for it. Only extensions can be built without a parent; other
+// fields must first be added to a message.
+func (flb *FieldBuilder) Build() (*desc.FieldDescriptor, error) {
+	if flb.parent == nil && !flb.IsExtension() {
+		return nil, fmt.Errorf("field %s must be added to a message before it can be built", flb.GetName())
+	}
+	d, err := doBuild(flb)
+	if err != nil {
+		return nil, err
+	}
+	return d.(*desc.FieldDescriptor), nil
+}
+
+// BuildDescriptor constructs a field descriptor based on the contents of this
+// field builder. Most usages will prefer Build() instead, whose return type is
+// a concrete descriptor type. This method is present to satisfy the Builder
+// interface.
+func (flb *FieldBuilder) BuildDescriptor() (desc.Descriptor, error) {
+	return flb.Build()
+}
+
+// OneOfBuilder is a builder used to construct a desc.OneOfDescriptor. The
+// fields in a oneof are in the namespace of the enclosing message, so the
+// names of a oneof's fields must not conflict with other elements in the
+// message.
+type OneOfBuilder struct {
+	baseBuilder
+
+	Options *dpb.OneofOptions
+
+	choices []*FieldBuilder
+}
+
+// NewOneOf creates a new OneOfBuilder for a oneof with the given name.
+func NewOneOf(name string) *OneOfBuilder {
+	return &OneOfBuilder{baseBuilder: baseBuilder{name: name}}
+}
+
+// TrySetName changes this oneof's name. It returns an error if the given name
+// is not a valid identifier or if it conflicts with another element in the
+// enclosing message.
+func (oob *OneOfBuilder) TrySetName(newName string) error {
+	if err := checkRename(oob, newName); err != nil {
+		return err
+	}
+	oob.name = newName
+	return nil
+}
+
+// SetName changes this oneof's name. It panics if the name cannot be set. It
+// returns the oneof builder, for method chaining.
+func (oob *OneOfBuilder) SetName(newName string) *OneOfBuilder {
+	if err := oob.TrySetName(newName); err != nil {
+		panic(err)
+	}
+	return oob
+}
+
+// SetComments sets the comments associated with the oneof. It returns the
+// oneof builder, for method chaining.
+func (oob *OneOfBuilder) SetComments(c Comments) *OneOfBuilder {
+	oob.comments = c
+	return oob
+}
+
+// GetFile returns the file builder that contains this oneof, or nil if there
+// is none.
+func (oob *OneOfBuilder) GetFile() *FileBuilder {
+	return fileOf(oob)
+}
+
+// SetOptions sets the oneof options. It returns the oneof builder, for method
+// chaining.
+func (oob *OneOfBuilder) SetOptions(options *dpb.OneofOptions) *OneOfBuilder {
+	oob.Options = options
+	return oob
+}
+
+func (oob *OneOfBuilder) findChild(name string) Builder {
+	for _, flb := range oob.choices {
+		if m := matchChild(flb, name); m != nil {
+			return m
+		}
+	}
+	return nil
+}
+
+func (oob *OneOfBuilder) removeChild(b Builder) {
+	if b.GetParent() != Builder(oob) {
+		return
+	}
+	oob.choices = removeField(oob.choices, b)
+	b.setParent(nil)
+}
+
+// GetChoices returns the fields in this oneof.
+func (oob *OneOfBuilder) GetChoices() []*FieldBuilder {
+	return oob.choices
+}
+
+// GetChoice returns the field in this oneof with the given name, or nil if
+// there is no such field.
+func (oob *OneOfBuilder) GetChoice(name string) *FieldBuilder {
+	for _, flb := range oob.choices {
+		if flb.GetName() == name {
+			return flb
+		}
+	}
+	return nil
+}
+
+// TryAddChoice adds the given field to this oneof. It returns an error if the
+// field is an extension, is repeated, or already has a parent, or if its name
+// conflicts with another element in the enclosing message.
+func (oob *OneOfBuilder) TryAddChoice(flb *FieldBuilder) error {
+	if flb.IsExtension() {
+		return fmt.Errorf("field %s is an extension and cannot be in a oneof", flb.GetName())
+	}
+	if flb.Label != dpb.FieldDescriptorProto_LABEL_OPTIONAL {
+		return fmt.Errorf("field %s is %s; fields in a oneof must be optional", flb.GetName(), labelString(flb.Label))
+	}
+	if err := addChild(oob, flb); err != nil {
+		return err
+	}
+	oob.choices = append(oob.choices, flb)
+	return nil
+}
+
+// AddChoice adds the given field to this oneof. It panics if the field cannot
+// be added. It returns the oneof builder, for method chaining.
+func (oob *OneOfBuilder) AddChoice(flb *FieldBuilder) *OneOfBuilder {
+	if err := oob.TryAddChoice(flb); err != nil {
+		panic(err)
+	}
+	return oob
+}
+
+// RemoveChoice removes the field with the given name from this oneof, if
+// present. It returns the oneof builder, for method chaining.
+func (oob *OneOfBuilder) RemoveChoice(name string) *OneOfBuilder {
+	if flb := oob.GetChoice(name); flb != nil {
+		oob.removeChild(flb)
+	}
+	return oob
+}
+
+// Build constructs a oneof descriptor based on the contents of this oneof
+// builder. The oneof must first be added to a message.
+func (oob *OneOfBuilder) Build() (*desc.OneOfDescriptor, error) {
+	if oob.parent == nil {
+		return nil, fmt.Errorf("oneof %s must be added to a message before it can be built", oob.GetName())
+	}
+	d, err := doBuild(oob)
+	if err != nil {
+		return nil, err
+	}
+	return d.(*desc.OneOfDescriptor), nil
+}
+
+// BuildDescriptor constructs a oneof descriptor based on the contents of this
+// oneof builder. Most usages will prefer Build() instead, whose return type is
+// a concrete descriptor type. This method is present to satisfy the Builder
+// interface.
+func (oob *OneOfBuilder) BuildDescriptor() (desc.Descriptor, error) {
+	return oob.Build()
+}
+
+func labelString(lbl dpb.FieldDescriptorProto_Label) string {
+	return strings.ToLower(strings.TrimPrefix(lbl.String(), "LABEL_"))
+}
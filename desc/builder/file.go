@@ -0,0 +1,351 @@
+package builder
+
+import (
+	"fmt"
+
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/jhump/protoreflect/desc"
+)
+
+// FileBuilder is a builder used to construct a desc.FileDescriptor. This is
+// the root of the hierarchy: all other builders are (directly or indirectly)
+// children of a file builder.
+//
+// Files do not need to be explicitly told about their dependencies. When a
+// file is built, its dependencies are computed from the types that are
+// referenced by its elements. Dependencies that are not referenced by any
+// element, such as files that only define custom options, can be added
+// explicitly via AddDependency.
+type FileBuilder struct {
+	name     string
+	comments Comments
+
+	IsProto3 bool
+	Package  string
+	Options  *dpb.FileOptions
+
+	// comments for the syntax and package statements
+	SyntaxComments  Comments
+	PackageComments Comments
+
+	messages   []*MessageBuilder
+	extensions []*FieldBuilder
+	enums      []*EnumBuilder
+	services   []*ServiceBuilder
+
+	explicitDeps []*desc.FileDescriptor
+}
+
+// NewFile creates a new FileBuilder for a file with the given name.
+func NewFile(name string) *FileBuilder {
+	return &FileBuilder{name: name}
+}
+
+// GetName returns the name of the file.
+func (fb *FileBuilder) GetName() string {
+	return fb.name
+}
+
+// TrySetName sets the name of the file. Unlike other elements, a file name
+// can be any non-empty string, since it is a path and not an identifier.
+func (fb *FileBuilder) TrySetName(newName string) error {
+	if newName == "" {
+		return fmt.Errorf("file name must not be empty")
+	}
+	fb.name = newName
+	return nil
+}
+
+// SetName sets the name of the file. It panics if the given name is empty.
+// It returns the file builder, for method chaining.
+func (fb *FileBuilder) SetName(newName string) *FileBuilder {
+	if err := fb.TrySetName(newName); err != nil {
+		panic(err)
+	}
+	return fb
+}
+
+// GetParent always returns nil since files are the roots of builder
+// hierarchies.
+func (fb *FileBuilder) GetParent() Builder {
+	return nil
+}
+
+func (fb *FileBuilder) setParent(parent Builder) {
+	panic("files cannot have parents")
+}
+
+// GetFile returns this file builder.
+func (fb *FileBuilder) GetFile() *FileBuilder {
+	return fb
+}
+
+// GetComments returns the comments for the file. These are used when the file
+// has no syntax or package statement; otherwise, use SyntaxComments and
+// PackageComments.
+func (fb *FileBuilder) GetComments() *Comments {
+	return &fb.comments
+}
+
+// SetProto3 sets whether the file uses proto3 syntax. It returns the file
+// builder, for method chaining.
+func (fb *FileBuilder) SetProto3(isProto3 bool) *FileBuilder {
+	fb.IsProto3 = isProto3
+	return fb
+}
+
+// SetPackageName sets the package name of the file. It returns the file
+// builder, for method chaining.
+func (fb *FileBuilder) SetPackageName(pkg string) *FileBuilder {
+	fb.Package = pkg
+	return fb
+}
+
+// SetOptions sets the file options. It returns the file builder, for method
+// chaining.
+func (fb *FileBuilder) SetOptions(options *dpb.FileOptions) *FileBuilder {
+	fb.Options = options
+	return fb
+}
+
+// AddDependency adds the given file as an explicit dependency of this file.
+// This is only needed for files that are not otherwise referenced, since
+// files that define referenced types are added automatically. It returns the
+// file builder, for method chaining.
+func (fb *FileBuilder) AddDependency(dep *desc.FileDescriptor) *FileBuilder {
+	for _, d := range fb.explicitDeps {
+		if d == dep {
+			return fb
+		}
+	}
+	fb.explicitDeps = append(fb.explicitDeps, dep)
+	return fb
+}
+
+func (fb *FileBuilder) findChild(name string) Builder {
+	for _, mb := range fb.messages {
+		if mb.GetName() == name {
+			return mb
+		}
+	}
+	for _, exb := range fb.extensions {
+		if m := matchChild(exb, name); m != nil {
+			return m
+		}
+	}
+	for _, eb := range fb.enums {
+		if eb.GetName() == name {
+			return eb
+		}
+	}
+	for _, sb := range fb.services {
+		if sb.GetName() == name {
+			return sb
+		}
+	}
+	return nil
+}
+
+func (fb *FileBuilder) removeChild(b Builder) {
+	if b.GetParent() != Builder(fb) {
+		return
+	}
+	fb.messages = removeMessage(fb.messages, b)
+	fb.enums = removeEnum(fb.enums, b)
+	fb.extensions = removeField(fb.extensions, b)
+	for i, sb := range fb.services {
+		if Builder(sb) == b {
+			fb.services = append(fb.services[:i:i], fb.services[i+1:]...)
+			break
+		}
+	}
+	b.setParent(nil)
+}
+
+// GetMessages returns the messages defined in this file.
+func (fb *FileBuilder) GetMessages() []*MessageBuilder {
+	return fb.messages
+}
+
+// GetMessage returns the message with the given name, or nil if there is no
+// such message in this file.
+func (fb *FileBuilder) GetMessage(name string) *MessageBuilder {
+	mb, _ := fb.findChild(name).(*MessageBuilder)
+	return mb
+}
+
+// TryAddMessage adds the given message to this file. It returns an error if
+// the message already has a parent or if this file already contains an
+// element with the same name.
+func (fb *FileBuilder) TryAddMessage(mb *MessageBuilder) error {
+	if err := addChild(fb, mb); err != nil {
+		return err
+	}
+	fb.messages = append(fb.messages, mb)
+	return nil
+}
+
+// AddMessage adds the given message to this file. It panics if the message
+// cannot be added. It returns the file builder, for method chaining.
+func (fb *FileBuilder) AddMessage(mb *MessageBuilder) *FileBuilder {
+	if err := fb.TryAddMessage(mb); err != nil {
+		panic(err)
+	}
+	return fb
+}
+
+// RemoveMessage removes the message with the given name from this file, if
+// present. It returns the file builder, for method chaining.
+func (fb *FileBuilder) RemoveMessage(name string) *FileBuilder {
+	if mb := fb.GetMessage(name); mb != nil {
+		fb.removeChild(mb)
+	}
+	return fb
+}
+
+// GetExtensions returns the extensions defined at the top level of this file.
+func (fb *FileBuilder) GetExtensions() []*FieldBuilder {
+	return fb.extensions
+}
+
+// GetExtension returns the extension with the given name, or nil if there is
+// no such extension in this file.
+func (fb *FileBuilder) GetExtension(name string) *FieldBuilder {
+	exb, _ := fb.findChild(name).(*FieldBuilder)
+	return exb
+}
+
+// TryAddExtension adds the given extension to this file. It returns an error
+// if the given field is not an extension, if it already has a parent, or if
+// this file already contains an element with the same name.
+func (fb *FileBuilder) TryAddExtension(exb *FieldBuilder) error {
+	if !exb.IsExtension() {
+		return fmt.Errorf("field %s is not an extension", exb.GetName())
+	}
+	if err := addChild(fb, exb); err != nil {
+		return err
+	}
+	fb.extensions = append(fb.extensions, exb)
+	return nil
+}
+
+// AddExtension adds the given extension to this file. It panics if the
+// extension cannot be added. It returns the file builder, for method
+// chaining.
+func (fb *FileBuilder) AddExtension(exb *FieldBuilder) *FileBuilder {
+	if err := fb.TryAddExtension(exb); err != nil {
+		panic(err)
+	}
+	return fb
+}
+
+// RemoveExtension removes the extension with the given name from this file,
+// if present. It returns the file builder, for method chaining.
+func (fb *FileBuilder) RemoveExtension(name string) *FileBuilder {
+	if exb := fb.GetExtension(name); exb != nil {
+		fb.removeChild(exb)
+	}
+	return fb
+}
+
+// GetEnums returns the enums defined in this file.
+func (fb *FileBuilder) GetEnums() []*EnumBuilder {
+	return fb.enums
+}
+
+// GetEnum returns the enum with the given name, or nil if there is no such
+// enum in this file.
+func (fb *FileBuilder) GetEnum(name string) *EnumBuilder {
+	eb, _ := fb.findChild(name).(*EnumBuilder)
+	return eb
+}
+
+// TryAddEnum adds the given enum to this file. It returns an error if the
+// enum already has a parent or if this file already contains an element with
+// the same name.
+func (fb *FileBuilder) TryAddEnum(eb *EnumBuilder) error {
+	if err := addChild(fb, eb); err != nil {
+		return err
+	}
+	fb.enums = append(fb.enums, eb)
+	return nil
+}
+
+// AddEnum adds the given enum to this file. It panics if the enum cannot be
+// added. It returns the file builder, for method chaining.
+func (fb *FileBuilder) AddEnum(eb *EnumBuilder) *FileBuilder {
+	if err := fb.TryAddEnum(eb); err != nil {
+		panic(err)
+	}
+	return fb
+}
+
+// RemoveEnum removes the enum with the given name from this file, if present.
+// It returns the file builder, for method chaining.
+func (fb *FileBuilder) RemoveEnum(name string) *FileBuilder {
+	if eb := fb.GetEnum(name); eb != nil {
+		fb.removeChild(eb)
+	}
+	return fb
+}
+
+// GetServices returns the services defined in this file.
+func (fb *FileBuilder) GetServices() []*ServiceBuilder {
+	return fb.services
+}
+
+// GetService returns the service with the given name, or nil if there is no
+// such service in this file.
+func (fb *FileBuilder) GetService(name string) *ServiceBuilder {
+	sb, _ := fb.findChild(name).(*ServiceBuilder)
+	return sb
+}
+
+// TryAddService adds the given service to this file. It returns an error if
+// the service already has a parent or if this file already contains an
+// element with the same name.
+func (fb *FileBuilder) TryAddService(sb *ServiceBuilder) error {
+	if err := addChild(fb, sb); err != nil {
+		return err
+	}
+	fb.services = append(fb.services, sb)
+	return nil
+}
+
+// AddService adds the given service to this file. It panics if the service
+// cannot be added. It returns the file builder, for method chaining.
+func (fb *FileBuilder) AddService(sb *ServiceBuilder) *FileBuilder {
+	if err := fb.TryAddService(sb); err != nil {
+		panic(err)
+	}
+	return fb
+}
+
+// RemoveService removes the service with the given name from this file, if
+// present. It returns the file builder, for method chaining.
+func (fb *FileBuilder) RemoveService(name string) *FileBuilder {
+	if sb := fb.GetService(name); sb != nil {
+		fb.removeChild(sb)
+	}
+	return fb
+}
+
+// Build constructs a file descriptor based on the contents of this file
+// builder. Any files that this file depends on, including other file
+// builders that define referenced types, are built too.
+func (fb *FileBuilder) Build() (*desc.FileDescriptor, error) {
+	d, err := doBuild(fb)
+	if err != nil {
+		return nil, err
+	}
+	return d.(*desc.FileDescriptor), nil
+}
+
+// BuildDescriptor constructs a file descriptor based on the contents of this
+// file builder. Most usages will prefer Build() instead, whose return type
+// is a concrete descriptor type. This method is present to satisfy the
+// Builder interface.
+func (fb *FileBuilder) BuildDescriptor() (desc.Descriptor, error) {
+	return fb.Build()
+}
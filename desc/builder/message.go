@@ -0,0 +1,461 @@
+package builder
+
+import (
+	"fmt"
+
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/jhump/protoreflect/desc"
+)
+
+// MessageBuilder is a builder used to construct a desc.MessageDescriptor. A
+// message builder can define nested messages, enums, and extensions in
+// addition to defining the message's fields.
+//
+// Extension ranges and reserved ranges use the same representation as the
+// descriptor protos: the start of a range is inclusive and the end is
+// exclusive.
+type MessageBuilder struct {
+	baseBuilder
+
+	Options         *dpb.MessageOptions
+	ExtensionRanges []*dpb.DescriptorProto_ExtensionRange
+	ReservedRanges  []*dpb.DescriptorProto_ReservedRange
+	ReservedNames   []string
+
+	// fields and oneofs, in the order they were added
+	fieldsAndOneOfs  []Builder
+	nestedMessages   []*MessageBuilder
+	nestedExtensions []*FieldBuilder
+	nestedEnums      []*EnumBuilder
+
+	isMapEntry bool
+}
+
+// NewMessage creates a new MessageBuilder for a message with the given name.
+// Since the new message has no parent, it is in the default (unnamed) package
+// until it is added to a file or to another message.
+func NewMessage(name string) *MessageBuilder {
+	return &MessageBuilder{baseBuilder: baseBuilder{name: name}}
+}
+
+// GetName returns the name of this message. The name of a map entry message
+// is derived from the name of its map field.
+func (mb *MessageBuilder) GetName() string {
+	if mb.isMapEntry {
+		if fld, ok := mb.parent.(*FieldBuilder); ok {
+			return mapEntryName(fld.GetName())
+		}
+	}
+	return mb.name
+}
+
+// TrySetName changes this message's name. It returns an error if the given
+// name is not a valid identifier or if it conflicts with another element in
+// the message's parent. It also returns an error if this is a map entry
+// message, whose name cannot be set directly. For a group, this renames both
+// the message and its field, whose name is the lower-case form of the
+// message's name.
+func (mb *MessageBuilder) TrySetName(newName string) error {
+	if mb.isMapEntry {
+		return fmt.Errorf("cannot change the name of map entry %s; change the name of the map field instead", mb.GetName())
+	}
+	if fld, ok := mb.parent.(*FieldBuilder); ok {
+		return fld.TrySetName(newName)
+	}
+	if err := checkRename(mb, newName); err != nil {
+		return err
+	}
+	mb.name = newName
+	return nil
+}
+
+// SetName changes this message's name. It panics if the name cannot be set.
+// It returns the message builder, for method chaining.
+func (mb *MessageBuilder) SetName(newName string) *MessageBuilder {
+	if err := mb.TrySetName(newName); err != nil {
+		panic(err)
+	}
+	return mb
+}
+
+// SetComments sets the comments associated with the message. It returns the
+// message builder, for method chaining.
+func (mb *MessageBuilder) SetComments(c Comments) *MessageBuilder {
+	mb.comments = c
+	return mb
+}
+
+// GetFile returns the file builder that contains this message, or nil if
+// there is none.
+func (mb *MessageBuilder) GetFile() *FileBuilder {
+	return fileOf(mb)
+}
+
+// IsMapEntry returns true if this is a synthetic message that represents the
+// entries of a map field.
+func (mb *MessageBuilder) IsMapEntry() bool {
+	return mb.isMapEntry
+}
+
+// SetOptions sets the message options. It returns the message builder, for
+// method chaining.
+func (mb *MessageBuilder) SetOptions(options *dpb.MessageOptions) *MessageBuilder {
+	mb.Options = options
+	return mb
+}
+
+// AddExtensionRange adds an extension range to this message. The start is
+// inclusive and the end is exclusive. It returns the message builder, for
+// method chaining.
+func (mb *MessageBuilder) AddExtensionRange(start, end int32) *MessageBuilder {
+	mb.ExtensionRanges = append(mb.ExtensionRanges, &dpb.DescriptorProto_ExtensionRange{Start: &start, End: &end})
+	return mb
+}
+
+// AddReservedRange adds a reserved range of tag numbers to this message. The
+// start is inclusive and the end is exclusive. It returns the message
+// builder, for method chaining.
+func (mb *MessageBuilder) AddReservedRange(start, end int32) *MessageBuilder {
+	mb.ReservedRanges = append(mb.ReservedRanges, &dpb.DescriptorProto_ReservedRange{Start: &start, End: &end})
+	return mb
+}
+
+// AddReservedName adds a reserved field name to this message. It returns the
+// message builder, for method chaining.
+func (mb *MessageBuilder) AddReservedName(name string) *MessageBuilder {
+	mb.ReservedNames = append(mb.ReservedNames, name)
+	return mb
+}
+
+func (mb *MessageBuilder) findChild(name string) Builder {
+	for _, b := range mb.fieldsAndOneOfs {
+		if m := matchChild(b, name); m != nil {
+			return m
+		}
+	}
+	for _, nmb := range mb.nestedMessages {
+		if nmb.GetName() == name {
+			return nmb
+		}
+	}
+	for _, exb := range mb.nestedExtensions {
+		if m := matchChild(exb, name); m != nil {
+			return m
+		}
+	}
+	for _, eb := range mb.nestedEnums {
+		if eb.GetName() == name {
+			return eb
+		}
+	}
+	return nil
+}
+
+func (mb *MessageBuilder) removeChild(b Builder) {
+	if b.GetParent() != Builder(mb) {
+		return
+	}
+	for i, e := range mb.fieldsAndOneOfs {
+		if e == b {
+			mb.fieldsAndOneOfs = append(mb.fieldsAndOneOfs[:i:i], mb.fieldsAndOneOfs[i+1:]...)
+			break
+		}
+	}
+	mb.nestedMessages = removeMessage(mb.nestedMessages, b)
+	mb.nestedExtensions = removeField(mb.nestedExtensions, b)
+	mb.nestedEnums = removeEnum(mb.nestedEnums, b)
+	b.setParent(nil)
+}
+
+// GetFields returns the fields of this message, including those that are
+// in oneofs, in the order they were added.
+func (mb *MessageBuilder) GetFields() []*FieldBuilder {
+	var flds []*FieldBuilder
+	for _, b := range mb.fieldsAndOneOfs {
+		switch b := b.(type) {
+		case *FieldBuilder:
+			flds = append(flds, b)
+		case *OneOfBuilder:
+			flds = append(flds, b.choices...)
+		}
+	}
+	return flds
+}
+
+// GetField returns the field with the given name, or nil if there is no such
+// field. Fields in oneofs are included in the search.
+func (mb *MessageBuilder) GetField(name string) *FieldBuilder {
+	for _, fld := range mb.GetFields() {
+		if fld.GetName() == name {
+			return fld
+		}
+	}
+	return nil
+}
+
+// TryAddField adds the given field to this message. It returns an error if
+// the field is an extension, if it already has a parent, or if this message
+// already contains an element with the same name.
+func (mb *MessageBuilder) TryAddField(flb *FieldBuilder) error {
+	if flb.IsExtension() {
+		return fmt.Errorf("field %s is an extension; use AddNestedExtension instead", flb.GetName())
+	}
+	if err := addChild(mb, flb); err != nil {
+		return err
+	}
+	mb.fieldsAndOneOfs = append(mb.fieldsAndOneOfs, flb)
+	return nil
+}
+
+// AddField adds the given field to this message. It panics if the field
+// cannot be added. It returns the message builder, for method chaining.
+func (mb *MessageBuilder) AddField(flb *FieldBuilder) *MessageBuilder {
+	if err := mb.TryAddField(flb); err != nil {
+		panic(err)
+	}
+	return mb
+}
+
+// RemoveField removes the field with the given name, if present. If the
+// field is in a oneof, it is removed from the oneof. It returns the message
+// builder, for method chaining.
+func (mb *MessageBuilder) RemoveField(name string) *MessageBuilder {
+	if flb := mb.GetField(name); flb != nil {
+		flb.GetParent().removeChild(flb)
+	}
+	return mb
+}
+
+// GetOneOfs returns the oneofs of this message.
+func (mb *MessageBuilder) GetOneOfs() []*OneOfBuilder {
+	var oobs []*OneOfBuilder
+	for _, b := range mb.fieldsAndOneOfs {
+		if oob, ok := b.(*OneOfBuilder); ok {
+			oobs = append(oobs, oob)
+		}
+	}
+	return oobs
+}
+
+// GetOneOf returns the oneof with the given name, or nil if there is no such
+// oneof.
+func (mb *MessageBuilder) GetOneOf(name string) *OneOfBuilder {
+	oob, _ := mb.findChild(name).(*OneOfBuilder)
+	return oob
+}
+
+// TryAddOneOf adds the given oneof to this message. It returns an error if
+// the oneof already has a parent or if this message already contains an
+// element with the same name as the oneof or any of its fields.
+func (mb *MessageBuilder) TryAddOneOf(oob *OneOfBuilder) error {
+	if err := addChild(mb, oob); err != nil {
+		return err
+	}
+	mb.fieldsAndOneOfs = append(mb.fieldsAndOneOfs, oob)
+	return nil
+}
+
+// AddOneOf adds the given oneof to this message. It panics if the oneof
+// cannot be added. It returns the message builder, for method chaining.
+func (mb *MessageBuilder) AddOneOf(oob *OneOfBuilder) *MessageBuilder {
+	if err := mb.TryAddOneOf(oob); err != nil {
+		panic(err)
+	}
+	return mb
+}
+
+// RemoveOneOf removes the oneof with the given name, along with its fields,
+// if present. It returns the message builder, for method chaining.
+func (mb *MessageBuilder) RemoveOneOf(name string) *MessageBuilder {
+	if oob := mb.GetOneOf(name); oob != nil {
+		mb.removeChild(oob)
+	}
+	return mb
+}
+
+// GetNestedMessages returns the messages nested in this message. This does
+// not include group and map entry messages, which belong to their fields.
+func (mb *MessageBuilder) GetNestedMessages() []*MessageBuilder {
+	return mb.nestedMessages
+}
+
+// GetNestedMessage returns the nested message with the given name, or nil if
+// there is no such message.
+func (mb *MessageBuilder) GetNestedMessage(name string) *MessageBuilder {
+	for _, nmb := range mb.nestedMessages {
+		if nmb.GetName() == name {
+			return nmb
+		}
+	}
+	return nil
+}
+
+// TryAddNestedMessage adds the given message as a nested message of this
+// message. It returns an error if the nested message already has a parent or
+// if this message already contains an element with the same name.
+func (mb *MessageBuilder) TryAddNestedMessage(nmb *MessageBuilder) error {
+	if err := addChild(mb, nmb); err != nil {
+		return err
+	}
+	mb.nestedMessages = append(mb.nestedMessages, nmb)
+	return nil
+}
+
+// AddNestedMessage adds the given message as a nested message of this
+// message. It panics if the message cannot be added. It returns the message
+// builder, for method chaining.
+func (mb *MessageBuilder) AddNestedMessage(nmb *MessageBuilder) *MessageBuilder {
+	if err := mb.TryAddNestedMessage(nmb); err != nil {
+		panic(err)
+	}
+	return mb
+}
+
+// RemoveNestedMessage removes the nested message with the given name, if
+// present. It returns the message builder, for method chaining.
+func (mb *MessageBuilder) RemoveNestedMessage(name string) *MessageBuilder {
+	if nmb := mb.GetNestedMessage(name); nmb != nil {
+		mb.removeChild(nmb)
+	}
+	return mb
+}
+
+// GetNestedExtensions returns the extensions defined in the scope of this
+// message.
+func (mb *MessageBuilder) GetNestedExtensions() []*FieldBuilder {
+	return mb.nestedExtensions
+}
+
+// GetNestedExtension returns the nested extension with the given name, or nil
+// if there is no such extension.
+func (mb *MessageBuilder) GetNestedExtension(name string) *FieldBuilder {
+	for _, exb := range mb.nestedExtensions {
+		if exb.GetName() == name {
+			return exb
+		}
+	}
+	return nil
+}
+
+// TryAddNestedExtension adds the given extension in the scope of this
+// message. It returns an error if the given field is not an extension, if it
+// already has a parent, or if this message already contains an element with
+// the same name.
+func (mb *MessageBuilder) TryAddNestedExtension(exb *FieldBuilder) error {
+	if !exb.IsExtension() {
+		return fmt.Errorf("field %s is not an extension; use AddField instead", exb.GetName())
+	}
+	if err := addChild(mb, exb); err != nil {
+		return err
+	}
+	mb.nestedExtensions = append(mb.nestedExtensions, exb)
+	return nil
+}
+
+// AddNestedExtension adds the given extension in the scope of this message.
+// It panics if the extension cannot be added. It returns the message builder,
+// for method chaining.
+func (mb *MessageBuilder) AddNestedExtension(exb *FieldBuilder) *MessageBuilder {
+	if err := mb.TryAddNestedExtension(exb); err != nil {
+		panic(err)
+	}
+	return mb
+}
+
+// RemoveNestedExtension removes the nested extension with the given name, if
+// present. It returns the message builder, for method chaining.
+func (mb *MessageBuilder) RemoveNestedExtension(name string) *MessageBuilder {
+	if exb := mb.GetNestedExtension(name); exb != nil {
+		mb.removeChild(exb)
+	}
+	return mb
+}
+
+// GetNestedEnums returns the enums nested in this message.
+func (mb *MessageBuilder) GetNestedEnums() []*EnumBuilder {
+	return mb.nestedEnums
+}
+
+// GetNestedEnum returns the nested enum with the given name, or nil if there
+// is no such enum.
+func (mb *MessageBuilder) GetNestedEnum(name string) *EnumBuilder {
+	for _, eb := range mb.nestedEnums {
+		if eb.GetName() == name {
+			return eb
+		}
+	}
+	return nil
+}
+
+// TryAddNestedEnum adds the given enum as a nested enum of this message. It
+// returns an error if the enum already has a parent or if this message
+// already contains an element with the same name.
+func (mb *MessageBuilder) TryAddNestedEnum(eb *EnumBuilder) error {
+	if err := addChild(mb, eb); err != nil {
+		return err
+	}
+	mb.nestedEnums = append(mb.nestedEnums, eb)
+	return nil
+}
+
+// AddNestedEnum adds the given enum as a nested enum of this message. It
+// panics if the enum cannot be added. It returns the message builder, for
+// method chaining.
+func (mb *MessageBuilder) AddNestedEnum(eb *EnumBuilder) *MessageBuilder {
+	if err := mb.TryAddNestedEnum(eb); err != nil {
+		panic(err)
+	}
+	return mb
+}
+
+// RemoveNestedEnum removes the nested enum with the given name, if present.
+// It returns the message builder, for method chaining.
+func (mb *MessageBuilder) RemoveNestedEnum(name string) *MessageBuilder {
+	if eb := mb.GetNestedEnum(name); eb != nil {
+		mb.removeChild(eb)
+	}
+	return mb
+}
+
+// Build constructs a message descriptor based on the contents of this message
+// builder. The file that contains the message is built too (along with any
+// files it depends on). If the message is not part of a file, a file is
+// synthesized for it.
+func (mb *MessageBuilder) Build() (*desc.MessageDescriptor, error) {
+	d, err := doBuild(mb)
+	if err != nil {
+		return nil, err
+	}
+	return d.(*desc.MessageDescriptor), nil
+}
+
+// BuildDescriptor constructs a message descriptor based on the contents of
+// this message builder. Most usages will prefer Build() instead, whose return
+// type is a concrete descriptor type. This method is present to satisfy the
+// Builder interface.
+func (mb *MessageBuilder) BuildDescriptor() (desc.Descriptor, error) {
+	return mb.Build()
+}
+
+// mapEntryName returns the name of the map entry message for the map field
+// with the given name. This is the camel-case form of the field name followed
+// by "Entry", same as protoc.
+func mapEntryName(fieldName string) string {
+	var name []byte
+	upper := true
+	for i := 0; i < len(fieldName); i++ {
+		c := fieldName[i]
+		switch {
+		case c == '_':
+			upper = true
+		case upper && c >= 'a' && c <= 'z':
+			name = append(name, c-'a'+'A')
+			upper = false
+		default:
+			name = append(name, c)
+			upper = false
+		}
+	}
+	return string(name) + "Entry"
+}
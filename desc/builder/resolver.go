@@ -0,0 +1,794 @@
+package builder
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/jhump/protoreflect/desc"
+)
+
+const (
+	file_packageTag          = 2
+	file_messagesTag         = 4
+	file_enumsTag            = 5
+	file_servicesTag         = 6
+	file_extensionsTag       = 7
+	file_syntaxTag           = 12
+	message_fieldsTag        = 2
+	message_nestedMessageTag = 3
+	message_enumsTag         = 4
+	message_extensionsTag    = 6
+	message_oneOfsTag        = 8
+	enum_valuesTag           = 2
+	service_methodsTag       = 2
+)
+
+var syntheticFileCount int32
+
+// resolver builds files from builders. It resolves references between
+// builders, computes the dependencies of each file, and builds those
+// dependencies first.
+type resolver struct {
+	// synthetic is the file that contains all referenced elements that are
+	// not part of any file
+	synthetic *FileBuilder
+
+	built    map[*FileBuilder]*desc.FileDescriptor
+	building []*FileBuilder
+}
+
+func newResolver() *resolver {
+	return &resolver{built: map[*FileBuilder]*desc.FileDescriptor{}}
+}
+
+// build builds the file that contains the given element.
+func (r *resolver) build(b Builder) (*desc.FileDescriptor, error) {
+	root := rootOf(b)
+	// first find all elements that are not part of a file so that they can
+	// all be put into the synthetic file
+	if err := r.collect(root, map[Builder]struct{}{}); err != nil {
+		return nil, err
+	}
+	return r.buildFile(r.fileFor(root))
+}
+
+func rootOf(b Builder) Builder {
+	for b.GetParent() != nil {
+		b = b.GetParent()
+	}
+	return b
+}
+
+// fileFor returns the file that contains the given root element.
+func (r *resolver) fileFor(root Builder) *FileBuilder {
+	if fb, ok := root.(*FileBuilder); ok {
+		return fb
+	}
+	return r.synthetic
+}
+
+// collect visits the given root element and all elements it references,
+// transitively, adding any that are not part of a file to the synthetic file.
+func (r *resolver) collect(root Builder, seen map[Builder]struct{}) error {
+	if _, ok := seen[root]; ok {
+		return nil
+	}
+	seen[root] = struct{}{}
+	if err := r.addRoot(root); err != nil {
+		return err
+	}
+	var refs []Builder
+	walk(root, func(b Builder) {
+		refs = append(refs, localRefs(b)...)
+	})
+	for _, ref := range refs {
+		if err := r.collect(rootOf(ref), seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *resolver) addRoot(root Builder) error {
+	if _, ok := root.(*FileBuilder); ok {
+		return nil
+	}
+	if r.synthetic == nil {
+		r.synthetic = NewFile(fmt.Sprintf("builder_synthetic_%d.proto", atomic.AddInt32(&syntheticFileCount, 1)))
+	}
+	switch b := root.(type) {
+	case *MessageBuilder:
+		r.synthetic.messages = append(r.synthetic.messages, b)
+	case *EnumBuilder:
+		r.synthetic.enums = append(r.synthetic.enums, b)
+	case *ServiceBuilder:
+		r.synthetic.services = append(r.synthetic.services, b)
+	case *FieldBuilder:
+		if !b.IsExtension() {
+			return fmt.Errorf("field %s is not part of a message", b.GetName())
+		}
+		r.synthetic.extensions = append(r.synthetic.extensions, b)
+	case *OneOfBuilder:
+		return fmt.Errorf("oneof %s is not part of a message", b.GetName())
+	case *EnumValueBuilder:
+		return fmt.Errorf("enum value %s is not part of an enum", b.GetName())
+	case *MethodBuilder:
+		return fmt.Errorf("method %s is not part of a service", b.GetName())
+	}
+	return nil
+}
+
+// walk calls the given function for the given element and all of its
+// descendants, including the message types of group and map fields.
+func walk(b Builder, fn func(Builder)) {
+	fn(b)
+	switch b := b.(type) {
+	case *FileBuilder:
+		for _, mb := range b.messages {
+			walk(mb, fn)
+		}
+		for _, exb := range b.extensions {
+			walk(exb, fn)
+		}
+		for _, eb := range b.enums {
+			walk(eb, fn)
+		}
+		for _, sb := range b.services {
+			walk(sb, fn)
+		}
+	case *MessageBuilder:
+		for _, c := range b.fieldsAndOneOfs {
+			walk(c, fn)
+		}
+		for _, nmb := range b.nestedMessages {
+			walk(nmb, fn)
+		}
+		for _, exb := range b.nestedExtensions {
+			walk(exb, fn)
+		}
+		for _, eb := range b.nestedEnums {
+			walk(eb, fn)
+		}
+	case *FieldBuilder:
+		if b.msgType != nil {
+			walk(b.msgType, fn)
+		}
+	case *OneOfBuilder:
+		for _, flb := range b.choices {
+			walk(flb, fn)
+		}
+	case *EnumBuilder:
+		for _, evb := range b.values {
+			walk(evb, fn)
+		}
+	case *ServiceBuilder:
+		for _, mtb := range b.methods {
+			walk(mtb, fn)
+		}
+	}
+}
+
+// localRefs returns the builders that are referenced by the given element.
+func localRefs(b Builder) []Builder {
+	var refs []Builder
+	switch b := b.(type) {
+	case *FieldBuilder:
+		if b.fieldType != nil {
+			if b.fieldType.localMsgType != nil {
+				refs = append(refs, b.fieldType.localMsgType)
+			}
+			if b.fieldType.localEnumType != nil {
+				refs = append(refs, b.fieldType.localEnumType)
+			}
+		}
+		if b.localExtendee != nil {
+			refs = append(refs, b.localExtendee)
+		}
+	case *MethodBuilder:
+		if b.ReqType != nil && b.ReqType.localType != nil {
+			refs = append(refs, b.ReqType.localType)
+		}
+		if b.RespType != nil && b.RespType.localType != nil {
+			refs = append(refs, b.RespType.localType)
+		}
+	}
+	return refs
+}
+
+// foreignRefs returns the files that define the descriptors referenced by
+// the given element.
+func foreignRefs(b Builder) []*desc.FileDescriptor {
+	var refs []*desc.FileDescriptor
+	switch b := b.(type) {
+	case *FieldBuilder:
+		if b.fieldType != nil {
+			if b.fieldType.foreignMsgType != nil {
+				refs = append(refs, b.fieldType.foreignMsgType.GetFile())
+			}
+			if b.fieldType.foreignEnumType != nil {
+				refs = append(refs, b.fieldType.foreignEnumType.GetFile())
+			}
+		}
+		if b.foreignExtendee != nil {
+			refs = append(refs, b.foreignExtendee.GetFile())
+		}
+	case *MethodBuilder:
+		if b.ReqType != nil && b.ReqType.foreignType != nil {
+			refs = append(refs, b.ReqType.foreignType.GetFile())
+		}
+		if b.RespType != nil && b.RespType.foreignType != nil {
+			refs = append(refs, b.RespType.foreignType.GetFile())
+		}
+	}
+	return refs
+}
+
+// buildFile builds the given file, after first building the files it depends
+// on.
+func (r *resolver) buildFile(fb *FileBuilder) (*desc.FileDescriptor, error) {
+	if fd := r.built[fb]; fd != nil {
+		return fd, nil
+	}
+	for i, f := range r.building {
+		if f == fb {
+			var names []string
+			for _, f := range r.building[i:] {
+				names = append(names, f.name)
+			}
+			names = append(names, fb.name)
+			return nil, fmt.Errorf("import cycle: %s", strings.Join(names, " -> "))
+		}
+	}
+	r.building = append(r.building, fb)
+	defer func() {
+		r.building = r.building[:len(r.building)-1]
+	}()
+
+	var deps []*desc.FileDescriptor
+	depNames := map[string]struct{}{}
+	addDep := func(dep *desc.FileDescriptor) error {
+		if dep.GetName() == fb.name {
+			return fmt.Errorf("file %s cannot depend on another file with the same name", fb.name)
+		}
+		if _, ok := depNames[dep.GetName()]; ok {
+			return nil
+		}
+		depNames[dep.GetName()] = struct{}{}
+		deps = append(deps, dep)
+		return nil
+	}
+	var err error
+	walk(fb, func(b Builder) {
+		if err != nil {
+			return
+		}
+		for _, ref := range localRefs(b) {
+			depFile := r.fileFor(rootOf(ref))
+			if depFile == fb {
+				continue
+			}
+			var dep *desc.FileDescriptor
+			if dep, err = r.buildFile(depFile); err != nil {
+				return
+			}
+			if err = addDep(dep); err != nil {
+				return
+			}
+		}
+		for _, dep := range foreignRefs(b) {
+			if err = addDep(dep); err != nil {
+				return
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, dep := range fb.explicitDeps {
+		if err := addDep(dep); err != nil {
+			return nil, err
+		}
+	}
+
+	g := generator{fb: fb, symbols: map[string]Builder{}}
+	fdp, err := g.genFile(deps)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := desc.CreateFileDescriptor(fdp, deps...)
+	if err != nil {
+		return nil, err
+	}
+	r.built[fb] = fd
+	return fd, nil
+}
+
+// generator produces a descriptor proto from a file builder, validating the
+// builder's contents along the way.
+type generator struct {
+	fb      *FileBuilder
+	locs    []*dpb.SourceCodeInfo_Location
+	symbols map[string]Builder
+}
+
+func (g *generator) addSymbol(fqn string, b Builder) error {
+	if existing, ok := g.symbols[fqn]; ok && existing != b {
+		return fmt.Errorf("file %s: duplicate symbol %s", g.fb.name, fqn)
+	}
+	g.symbols[fqn] = b
+	return nil
+}
+
+func (g *generator) addComments(path []int32, c *Comments) {
+	if c.LeadingComment == "" && c.TrailingComment == "" && len(c.LeadingDetachedComments) == 0 {
+		return
+	}
+	loc := &dpb.SourceCodeInfo_Location{
+		Path:                    append([]int32(nil), path...),
+		LeadingDetachedComments: c.LeadingDetachedComments,
+	}
+	if c.LeadingComment != "" {
+		loc.LeadingComments = proto.String(c.LeadingComment)
+	}
+	if c.TrailingComment != "" {
+		loc.TrailingComments = proto.String(c.TrailingComment)
+	}
+	g.locs = append(g.locs, loc)
+}
+
+func (g *generator) genFile(deps []*desc.FileDescriptor) (*dpb.FileDescriptorProto, error) {
+	fb := g.fb
+	fdp := &dpb.FileDescriptorProto{Name: proto.String(fb.name)}
+	if fb.Package != "" {
+		for _, part := range strings.Split(fb.Package, ".") {
+			if err := checkName(part); err != nil {
+				return nil, fmt.Errorf("file %s: invalid package %q", fb.name, fb.Package)
+			}
+		}
+		fdp.Package = proto.String(fb.Package)
+	}
+	if fb.IsProto3 {
+		fdp.Syntax = proto.String("proto3")
+	}
+	for _, dep := range deps {
+		fdp.Dependency = append(fdp.Dependency, dep.GetName())
+	}
+	if fb.Options != nil {
+		fdp.Options = proto.Clone(fb.Options).(*dpb.FileOptions)
+	}
+	g.addComments([]int32{}, &fb.comments)
+	g.addComments([]int32{file_syntaxTag}, &fb.SyntaxComments)
+	g.addComments([]int32{file_packageTag}, &fb.PackageComments)
+
+	for i, mb := range fb.messages {
+		md, err := g.genMessage(mb, []int32{file_messagesTag, int32(i)})
+		if err != nil {
+			return nil, err
+		}
+		fdp.MessageType = append(fdp.MessageType, md)
+	}
+	for i, eb := range fb.enums {
+		ed, err := g.genEnum(eb, []int32{file_enumsTag, int32(i)})
+		if err != nil {
+			return nil, err
+		}
+		fdp.EnumType = append(fdp.EnumType, ed)
+	}
+	for i, exb := range fb.extensions {
+		exd, err := g.genField(exb, exb.number, nil, []int32{file_extensionsTag, int32(i)})
+		if err != nil {
+			return nil, err
+		}
+		fdp.Extension = append(fdp.Extension, exd)
+	}
+	for i, sb := range fb.services {
+		sd, err := g.genService(sb, []int32{file_servicesTag, int32(i)})
+		if err != nil {
+			return nil, err
+		}
+		fdp.Service = append(fdp.Service, sd)
+	}
+	if len(g.locs) > 0 {
+		fdp.SourceCodeInfo = &dpb.SourceCodeInfo{Location: g.locs}
+	}
+	return fdp, nil
+}
+
+func (g *generator) genMessage(mb *MessageBuilder, path []int32) (*dpb.DescriptorProto, error) {
+	fqn := qualifiedName(mb)
+	if err := checkName(mb.GetName()); err != nil {
+		return nil, fmt.Errorf("message %s: %v", fqn, err)
+	}
+	if err := g.addSymbol(fqn, mb); err != nil {
+		return nil, err
+	}
+	md := &dpb.DescriptorProto{Name: proto.String(mb.GetName())}
+	if mb.Options != nil {
+		md.Options = proto.Clone(mb.Options).(*dpb.MessageOptions)
+	}
+	if mb.isMapEntry {
+		if md.Options == nil {
+			md.Options = &dpb.MessageOptions{}
+		}
+		md.Options.MapEntry = proto.Bool(true)
+	}
+	if g.fb.IsProto3 && len(mb.ExtensionRanges) > 0 {
+		return nil, fmt.Errorf("message %s: extension ranges are not allowed in proto3", fqn)
+	}
+	for _, er := range mb.ExtensionRanges {
+		if er.GetStart() < 1 || er.GetEnd() > maxTag+1 || er.GetStart() >= er.GetEnd() {
+			return nil, fmt.Errorf("message %s: invalid extension range %d-%d", fqn, er.GetStart(), er.GetEnd())
+		}
+		md.ExtensionRange = append(md.ExtensionRange, proto.Clone(er).(*dpb.DescriptorProto_ExtensionRange))
+	}
+	for _, rr := range mb.ReservedRanges {
+		if rr.GetStart() < 1 || rr.GetEnd() > maxTag+1 || rr.GetStart() >= rr.GetEnd() {
+			return nil, fmt.Errorf("message %s: invalid reserved range %d-%d", fqn, rr.GetStart(), rr.GetEnd())
+		}
+		md.ReservedRange = append(md.ReservedRange, proto.Clone(rr).(*dpb.DescriptorProto_ReservedRange))
+	}
+	md.ReservedName = append(md.ReservedName, mb.ReservedNames...)
+	g.addComments(path, &mb.comments)
+
+	tags, err := assignTags(mb)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range mb.fieldsAndOneOfs {
+		switch c := c.(type) {
+		case *FieldBuilder:
+			fld, err := g.genField(c, tags[c], nil, append(path, message_fieldsTag, int32(len(md.Field))))
+			if err != nil {
+				return nil, err
+			}
+			md.Field = append(md.Field, fld)
+		case *OneOfBuilder:
+			ood, err := g.genOneOf(c, append(path, message_oneOfsTag, int32(len(md.OneofDecl))))
+			if err != nil {
+				return nil, err
+			}
+			index := int32(len(md.OneofDecl))
+			md.OneofDecl = append(md.OneofDecl, ood)
+			for _, choice := range c.choices {
+				fld, err := g.genField(choice, tags[choice], &index, append(path, message_fieldsTag, int32(len(md.Field))))
+				if err != nil {
+					return nil, err
+				}
+				md.Field = append(md.Field, fld)
+			}
+		}
+	}
+	for i, exb := range mb.nestedExtensions {
+		exd, err := g.genField(exb, exb.number, nil, append(path, message_extensionsTag, int32(i)))
+		if err != nil {
+			return nil, err
+		}
+		md.Extension = append(md.Extension, exd)
+	}
+
+	for _, nmb := range mb.nestedMessages {
+		nmd, err := g.genMessage(nmb, append(path, message_nestedMessageTag, int32(len(md.NestedType))))
+		if err != nil {
+			return nil, err
+		}
+		md.NestedType = append(md.NestedType, nmd)
+	}
+	// group and map entry messages are nested in the enclosing message
+	for _, flb := range mb.GetFields() {
+		if flb.msgType == nil {
+			continue
+		}
+		nmd, err := g.genMessage(flb.msgType, append(path, message_nestedMessageTag, int32(len(md.NestedType))))
+		if err != nil {
+			return nil, err
+		}
+		md.NestedType = append(md.NestedType, nmd)
+	}
+	for i, eb := range mb.nestedEnums {
+		ed, err := g.genEnum(eb, append(path, message_enumsTag, int32(i)))
+		if err != nil {
+			return nil, err
+		}
+		md.EnumType = append(md.EnumType, ed)
+	}
+	return md, nil
+}
+
+// assignTags computes the tag numbers for all fields in the given message,
+// assigning numbers to fields that do not have them.
+func assignTags(mb *MessageBuilder) (map[*FieldBuilder]int32, error) {
+	fqn := qualifiedName(mb)
+	tags := map[*FieldBuilder]int32{}
+	used := map[int32]*FieldBuilder{}
+	inRange := func(tag int32) (int32, bool) {
+		if tag >= specialReservedStart && tag <= specialReservedEnd {
+			return specialReservedEnd + 1, true
+		}
+		for _, rr := range mb.ReservedRanges {
+			if tag >= rr.GetStart() && tag < rr.GetEnd() {
+				return rr.GetEnd(), true
+			}
+		}
+		for _, er := range mb.ExtensionRanges {
+			if tag >= er.GetStart() && tag < er.GetEnd() {
+				return er.GetEnd(), true
+			}
+		}
+		return 0, false
+	}
+	flds := mb.GetFields()
+	for _, flb := range flds {
+		if flb.number == 0 {
+			continue
+		}
+		if other := used[flb.number]; other != nil {
+			return nil, fmt.Errorf("message %s: fields %s and %s both use tag %d", fqn, other.GetName(), flb.GetName(), flb.number)
+		}
+		if _, ok := inRange(flb.number); ok {
+			return nil, fmt.Errorf("message %s: field %s uses tag %d, which is reserved or in an extension range", fqn, flb.GetName(), flb.number)
+		}
+		used[flb.number] = flb
+		tags[flb] = flb.number
+	}
+	next := int32(1)
+	for _, flb := range flds {
+		if flb.number != 0 {
+			continue
+		}
+		for {
+			if skipTo, ok := inRange(next); ok {
+				next = skipTo
+			} else if used[next] != nil {
+				next++
+			} else {
+				break
+			}
+		}
+		if next > maxTag {
+			return nil, fmt.Errorf("message %s: no tag number available for field %s", fqn, flb.GetName())
+		}
+		used[next] = flb
+		tags[flb] = next
+	}
+	return tags, nil
+}
+
+func (g *generator) genOneOf(oob *OneOfBuilder, path []int32) (*dpb.OneofDescriptorProto, error) {
+	fqn := qualifiedName(oob)
+	if err := checkName(oob.GetName()); err != nil {
+		return nil, fmt.Errorf("oneof %s: %v", fqn, err)
+	}
+	if err := g.addSymbol(fqn, oob); err != nil {
+		return nil, err
+	}
+	if len(oob.choices) == 0 {
+		return nil, fmt.Errorf("oneof %s: must have at least one field", fqn)
+	}
+	ood := &dpb.OneofDescriptorProto{Name: proto.String(oob.GetName())}
+	if oob.Options != nil {
+		ood.Options = proto.Clone(oob.Options).(*dpb.OneofOptions)
+	}
+	g.addComments(path, &oob.comments)
+	return ood, nil
+}
+
+func (g *generator) genField(flb *FieldBuilder, tag int32, oneOfIndex *int32, path []int32) (*dpb.FieldDescriptorProto, error) {
+	fqn := qualifiedName(flb)
+	if err := checkName(flb.GetName()); err != nil {
+		return nil, fmt.Errorf("field %s: %v", fqn, err)
+	}
+	if err := g.addSymbol(fqn, flb); err != nil {
+		return nil, err
+	}
+	if flb.fieldType == nil {
+		return nil, fmt.Errorf("field %s: type must be set", fqn)
+	}
+	if tag == 0 {
+		return nil, fmt.Errorf("extension %s: tag number must be set", fqn)
+	}
+	fld := &dpb.FieldDescriptorProto{
+		Name:   proto.String(flb.GetName()),
+		Number: proto.Int32(tag),
+		Label:  flb.Label.Enum(),
+		Type:   flb.fieldType.fieldType.Enum(),
+	}
+	if name := flb.fieldType.GetTypeName(); name != "" {
+		fld.TypeName = proto.String("." + name)
+	}
+	if flb.JsonName != "" {
+		fld.JsonName = proto.String(flb.JsonName)
+	} else {
+		fld.JsonName = proto.String(desc.DefaultJSONName(flb.GetName()))
+	}
+	if flb.Default != "" {
+		fld.DefaultValue = proto.String(flb.Default)
+	}
+	if flb.Options != nil {
+		fld.Options = proto.Clone(flb.Options).(*dpb.FieldOptions)
+	}
+	fld.OneofIndex = oneOfIndex
+	g.addComments(path, &flb.comments)
+
+	if g.fb.IsProto3 {
+		if flb.IsRequired() {
+			return nil, fmt.Errorf("field %s: required fields are not allowed in proto3", fqn)
+		}
+		if flb.IsGroup() {
+			return nil, fmt.Errorf("field %s: groups are not allowed in proto3", fqn)
+		}
+		if flb.Default != "" {
+			return nil, fmt.Errorf("field %s: default values are not allowed in proto3", fqn)
+		}
+	}
+	if flb.Default != "" {
+		if flb.IsRepeated() {
+			return nil, fmt.Errorf("field %s: repeated fields cannot have a default value", fqn)
+		}
+		if flb.fieldType.fieldType == dpb.FieldDescriptorProto_TYPE_MESSAGE || flb.fieldType.fieldType == dpb.FieldDescriptorProto_TYPE_GROUP {
+			return nil, fmt.Errorf("field %s: message fields cannot have a default value", fqn)
+		}
+	}
+	if flb.IsGroup() {
+		if n := flb.msgType.GetName(); n[0] < 'A' || n[0] > 'Z' {
+			return nil, fmt.Errorf("field %s: group name %s must start with a capital letter", fqn, n)
+		}
+	}
+	if flb.IsMap() {
+		if !flb.IsRepeated() {
+			return nil, fmt.Errorf("field %s: map fields must be repeated", fqn)
+		}
+		switch flb.msgType.GetFields()[0].fieldType.fieldType {
+		case dpb.FieldDescriptorProto_TYPE_FLOAT, dpb.FieldDescriptorProto_TYPE_DOUBLE,
+			dpb.FieldDescriptorProto_TYPE_BYTES, dpb.FieldDescriptorProto_TYPE_ENUM,
+			dpb.FieldDescriptorProto_TYPE_MESSAGE, dpb.FieldDescriptorProto_TYPE_GROUP:
+			return nil, fmt.Errorf("field %s: invalid map key type", fqn)
+		}
+	}
+
+	if flb.IsExtension() {
+		fld.Extendee = proto.String("." + flb.GetExtendeeTypeName())
+		if flb.IsRequired() {
+			return nil, fmt.Errorf("extension %s: extensions cannot be required", fqn)
+		}
+		var isExtension bool
+		if flb.localExtendee != nil {
+			for _, er := range flb.localExtendee.ExtensionRanges {
+				if tag >= er.GetStart() && tag < er.GetEnd() {
+					isExtension = true
+					break
+				}
+			}
+		} else {
+			isExtension = flb.foreignExtendee.IsExtension(tag)
+		}
+		if !isExtension {
+			return nil, fmt.Errorf("extension %s: tag %d is not in an extension range of %s", fqn, tag, flb.GetExtendeeTypeName())
+		}
+		if g.fb.IsProto3 && !isOptionsMessage(flb.GetExtendeeTypeName()) {
+			return nil, fmt.Errorf("extension %s: extensions in proto3 are only allowed for custom options", fqn)
+		}
+	}
+	return fld, nil
+}
+
+func isOptionsMessage(name string) bool {
+	return strings.HasPrefix(name, "google.protobuf.") && strings.HasSuffix(name, "Options")
+}
+
+func (g *generator) genEnum(eb *EnumBuilder, path []int32) (*dpb.EnumDescriptorProto, error) {
+	fqn := qualifiedName(eb)
+	if err := checkName(eb.GetName()); err != nil {
+		return nil, fmt.Errorf("enum %s: %v", fqn, err)
+	}
+	if err := g.addSymbol(fqn, eb); err != nil {
+		return nil, err
+	}
+	if len(eb.values) == 0 {
+		return nil, fmt.Errorf("enum %s: must have at least one value", fqn)
+	}
+	ed := &dpb.EnumDescriptorProto{Name: proto.String(eb.GetName())}
+	if eb.Options != nil {
+		ed.Options = proto.Clone(eb.Options).(*dpb.EnumOptions)
+	}
+	for _, rr := range eb.ReservedRanges {
+		if rr.GetStart() > rr.GetEnd() {
+			return nil, fmt.Errorf("enum %s: invalid reserved range %d-%d", fqn, rr.GetStart(), rr.GetEnd())
+		}
+		ed.ReservedRange = append(ed.ReservedRange, proto.Clone(rr).(*dpb.EnumDescriptorProto_EnumReservedRange))
+	}
+	ed.ReservedName = append(ed.ReservedName, eb.ReservedNames...)
+	g.addComments(path, &eb.comments)
+
+	// enum values are in the same scope as the enum itself (C++ scoping
+	// rules), so they must not conflict with the enum's siblings
+	scope := scopeOf(eb)
+	used := map[int32]*EnumValueBuilder{}
+	var next int32
+	for i, evb := range eb.values {
+		valFqn := qualifiedName(evb)
+		if err := checkName(evb.GetName()); err != nil {
+			return nil, fmt.Errorf("enum value %s: %v", valFqn, err)
+		}
+		scopedName := evb.GetName()
+		if scope != "" {
+			scopedName = scope + "." + scopedName
+		}
+		if err := g.addSymbol(scopedName, evb); err != nil {
+			return nil, err
+		}
+		num := next
+		if evb.numberSet {
+			num = evb.number
+		}
+		next = num + 1
+		if i == 0 && g.fb.IsProto3 && num != 0 {
+			return nil, fmt.Errorf("enum %s: first value must be zero in proto3", fqn)
+		}
+		if other := used[num]; other != nil && !eb.Options.GetAllowAlias() {
+			return nil, fmt.Errorf("enum %s: values %s and %s both use number %d; set allow_alias to permit this", fqn, other.GetName(), evb.GetName(), num)
+		}
+		used[num] = evb
+		for _, rr := range eb.ReservedRanges {
+			if num >= rr.GetStart() && num <= rr.GetEnd() {
+				return nil, fmt.Errorf("enum value %s: number %d is reserved", valFqn, num)
+			}
+		}
+		for _, n := range eb.ReservedNames {
+			if n == evb.GetName() {
+				return nil, fmt.Errorf("enum value %s: name is reserved", valFqn)
+			}
+		}
+		evd := &dpb.EnumValueDescriptorProto{Name: proto.String(evb.GetName()), Number: proto.Int32(num)}
+		if evb.Options != nil {
+			evd.Options = proto.Clone(evb.Options).(*dpb.EnumValueOptions)
+		}
+		g.addComments(append(path, enum_valuesTag, int32(i)), &evb.comments)
+		ed.Value = append(ed.Value, evd)
+	}
+	return ed, nil
+}
+
+func (g *generator) genService(sb *ServiceBuilder, path []int32) (*dpb.ServiceDescriptorProto, error) {
+	fqn := qualifiedName(sb)
+	if err := checkName(sb.GetName()); err != nil {
+		return nil, fmt.Errorf("service %s: %v", fqn, err)
+	}
+	if err := g.addSymbol(fqn, sb); err != nil {
+		return nil, err
+	}
+	sd := &dpb.ServiceDescriptorProto{Name: proto.String(sb.GetName())}
+	if sb.Options != nil {
+		sd.Options = proto.Clone(sb.Options).(*dpb.ServiceOptions)
+	}
+	g.addComments(path, &sb.comments)
+	for i, mtb := range sb.methods {
+		mtdFqn := qualifiedName(mtb)
+		if err := checkName(mtb.GetName()); err != nil {
+			return nil, fmt.Errorf("method %s: %v", mtdFqn, err)
+		}
+		if mtb.ReqType == nil || mtb.RespType == nil {
+			return nil, fmt.Errorf("method %s: request and response types must be set", mtdFqn)
+		}
+		mtd := &dpb.MethodDescriptorProto{
+			Name:       proto.String(mtb.GetName()),
+			InputType:  proto.String("." + mtb.ReqType.GetTypeName()),
+			OutputType: proto.String("." + mtb.RespType.GetTypeName()),
+		}
+		if mtb.ReqType.IsStream {
+			mtd.ClientStreaming = proto.Bool(true)
+		}
+		if mtb.RespType.IsStream {
+			mtd.ServerStreaming = proto.Bool(true)
+		}
+		if mtb.Options != nil {
+			mtd.Options = proto.Clone(mtb.Options).(*dpb.MethodOptions)
+		}
+		g.addComments(append(path, service_methodsTag, int32(i)), &mtb.comments)
+		sd.Method = append(sd.Method, mtd)
+	}
+	return sd, nil
+}
@@ -0,0 +1,251 @@
+package builder
+
+import (
+	"fmt"
+
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/jhump/protoreflect/desc"
+)
+
+// ServiceBuilder is a builder used to construct a desc.ServiceDescriptor.
+type ServiceBuilder struct {
+	baseBuilder
+
+	Options *dpb.ServiceOptions
+
+	methods []*MethodBuilder
+}
+
+// NewService creates a new ServiceBuilder for a service with the given name.
+// Since the new service has no parent, it is in the default (unnamed) package
+// until it is added to a file.
+func NewService(name string) *ServiceBuilder {
+	return &ServiceBuilder{baseBuilder: baseBuilder{name: name}}
+}
+
+// TrySetName changes this service's name. It returns an error if the given
+// name is not a valid identifier or if it conflicts with another element in
+// the service's file.
+func (sb *ServiceBuilder) TrySetName(newName string) error {
+	if err := checkRename(sb, newName); err != nil {
+		return err
+	}
+	sb.name = newName
+	return nil
+}
+
+// SetName changes this service's name. It panics if the name cannot be set.
+// It returns the service builder, for method chaining.
+func (sb *ServiceBuilder) SetName(newName string) *ServiceBuilder {
+	if err := sb.TrySetName(newName); err != nil {
+		panic(err)
+	}
+	return sb
+}
+
+// SetComments sets the comments associated with the service. It returns the
+// service builder, for method chaining.
+func (sb *ServiceBuilder) SetComments(c Comments) *ServiceBuilder {
+	sb.comments = c
+	return sb
+}
+
+// GetFile returns the file builder that contains this service, or nil if
+// there is none.
+func (sb *ServiceBuilder) GetFile() *FileBuilder {
+	return fileOf(sb)
+}
+
+// SetOptions sets the service options. It returns the service builder, for
+// method chaining.
+func (sb *ServiceBuilder) SetOptions(options *dpb.ServiceOptions) *ServiceBuilder {
+	sb.Options = options
+	return sb
+}
+
+func (sb *ServiceBuilder) findChild(name string) Builder {
+	for _, mtb := range sb.methods {
+		if mtb.GetName() == name {
+			return mtb
+		}
+	}
+	return nil
+}
+
+func (sb *ServiceBuilder) removeChild(b Builder) {
+	if b.GetParent() != Builder(sb) {
+		return
+	}
+	for i, mtb := range sb.methods {
+		if Builder(mtb) == b {
+			sb.methods = append(sb.methods[:i:i], sb.methods[i+1:]...)
+			break
+		}
+	}
+	b.setParent(nil)
+}
+
+// GetMethods returns the methods of this service.
+func (sb *ServiceBuilder) GetMethods() []*MethodBuilder {
+	return sb.methods
+}
+
+// GetMethod returns the method with the given name, or nil if there is no
+// such method.
+func (sb *ServiceBuilder) GetMethod(name string) *MethodBuilder {
+	mtb, _ := sb.findChild(name).(*MethodBuilder)
+	return mtb
+}
+
+// TryAddMethod adds the given method to this service. It returns an error if
+// the method already has a parent or if this service already has a method
+// with the same name.
+func (sb *ServiceBuilder) TryAddMethod(mtb *MethodBuilder) error {
+	if err := addChild(sb, mtb); err != nil {
+		return err
+	}
+	sb.methods = append(sb.methods, mtb)
+	return nil
+}
+
+// AddMethod adds the given method to this service. It panics if the method
+// cannot be added. It returns the service builder, for method chaining.
+func (sb *ServiceBuilder) AddMethod(mtb *MethodBuilder) *ServiceBuilder {
+	if err := sb.TryAddMethod(mtb); err != nil {
+		panic(err)
+	}
+	return sb
+}
+
+// RemoveMethod removes the method with the given name, if present. It returns
+// the service builder, for method chaining.
+func (sb *ServiceBuilder) RemoveMethod(name string) *ServiceBuilder {
+	if mtb := sb.GetMethod(name); mtb != nil {
+		sb.removeChild(mtb)
+	}
+	return sb
+}
+
+// Build constructs a service descriptor based on the contents of this service
+// builder. The file that contains the service is built too (along with any
+// files it depends on). If the service is not part of a file, a file is
+// synthesized for it.
+func (sb *ServiceBuilder) Build() (*desc.ServiceDescriptor, error) {
+	d, err := doBuild(sb)
+	if err != nil {
+		return nil, err
+	}
+	return d.(*desc.ServiceDescriptor), nil
+}
+
+// BuildDescriptor constructs a service descriptor based on the contents of
+// this service builder. Most usages will prefer Build() instead, whose return
+// type is a concrete descriptor type. This method is present to satisfy the
+// Builder interface.
+func (sb *ServiceBuilder) BuildDescriptor() (desc.Descriptor, error) {
+	return sb.Build()
+}
+
+// MethodBuilder is a builder used to construct a desc.MethodDescriptor.
+type MethodBuilder struct {
+	baseBuilder
+
+	Options  *dpb.MethodOptions
+	ReqType  *RpcType
+	RespType *RpcType
+}
+
+// NewMethod creates a new MethodBuilder for a method with the given name and
+// request and response types.
+func NewMethod(name string, req, resp *RpcType) *MethodBuilder {
+	return &MethodBuilder{
+		baseBuilder: baseBuilder{name: name},
+		ReqType:     req,
+		RespType:    resp,
+	}
+}
+
+// TrySetName changes this method's name. It returns an error if the given
+// name is not a valid identifier or if the service already has a method with
+// the same name.
+func (mtb *MethodBuilder) TrySetName(newName string) error {
+	if err := checkRename(mtb, newName); err != nil {
+		return err
+	}
+	mtb.name = newName
+	return nil
+}
+
+// SetName changes this method's name. It panics if the name cannot be set. It
+// returns the method builder, for method chaining.
+func (mtb *MethodBuilder) SetName(newName string) *MethodBuilder {
+	if err := mtb.TrySetName(newName); err != nil {
+		panic(err)
+	}
+	return mtb
+}
+
+// SetComments sets the comments associated with the method. It returns the
+// method builder, for method chaining.
+func (mtb *MethodBuilder) SetComments(c Comments) *MethodBuilder {
+	mtb.comments = c
+	return mtb
+}
+
+// GetFile returns the file builder that contains this method, or nil if there
+// is none.
+func (mtb *MethodBuilder) GetFile() *FileBuilder {
+	return fileOf(mtb)
+}
+
+// SetOptions sets the method options. It returns the method builder, for
+// method chaining.
+func (mtb *MethodBuilder) SetOptions(options *dpb.MethodOptions) *MethodBuilder {
+	mtb.Options = options
+	return mtb
+}
+
+// SetRequestType sets the request type of the method. It returns the method
+// builder, for method chaining.
+func (mtb *MethodBuilder) SetRequestType(t *RpcType) *MethodBuilder {
+	mtb.ReqType = t
+	return mtb
+}
+
+// SetResponseType sets the response type of the method. It returns the method
+// builder, for method chaining.
+func (mtb *MethodBuilder) SetResponseType(t *RpcType) *MethodBuilder {
+	mtb.RespType = t
+	return mtb
+}
+
+func (mtb *MethodBuilder) findChild(name string) Builder {
+	// methods do not have children
+	return nil
+}
+
+func (mtb *MethodBuilder) removeChild(b Builder) {
+	// methods do not have children
+}
+
+// Build constructs a method descriptor based on the contents of this method
+// builder. The method must first be added to a service.
+func (mtb *MethodBuilder) Build() (*desc.MethodDescriptor, error) {
+	if mtb.parent == nil {
+		return nil, fmt.Errorf("method %s must be added to a service before it can be built", mtb.GetName())
+	}
+	d, err := doBuild(mtb)
+	if err != nil {
+		return nil, err
+	}
+	return d.(*desc.MethodDescriptor), nil
+}
+
+// BuildDescriptor constructs a method descriptor based on the contents of
+// this method builder. Most usages will prefer Build() instead, whose return
+// type is a concrete descriptor type. This method is present to satisfy the
+// Builder interface.
+func (mtb *MethodBuilder) BuildDescriptor() (desc.Descriptor, error) {
+	return mtb.Build()
+}
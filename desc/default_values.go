@@ -0,0 +1,199 @@
+package desc
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// computeDefaultValue determines the default value for the given field, which
+// is what GetDefaultValue returns. It is called when the field is resolved, so
+// the field's enum type, if any, is already known.
+func computeDefaultValue(fd *FieldDescriptor) (interface{}, error) {
+	if fd.IsRepeated() || fd.GetType() == dpb.FieldDescriptorProto_TYPE_MESSAGE || fd.GetType() == dpb.FieldDescriptorProto_TYPE_GROUP {
+		if fd.proto.DefaultValue != nil {
+			return nil, fmt.Errorf("field %s cannot have a default value", fd.GetFullyQualifiedName())
+		}
+		return nil, nil
+	}
+	if fd.proto.DefaultValue == nil {
+		return zeroValue(fd), nil
+	}
+	v, err := parseDefaultValue(fd)
+	if err != nil {
+		return nil, fmt.Errorf("field %s has invalid default value %q: %v", fd.GetFullyQualifiedName(), fd.proto.GetDefaultValue(), err)
+	}
+	return v, nil
+}
+
+// zeroValue returns the zero value for the type of the given field. This is
+// the default for fields that do not declare one.
+func zeroValue(fd *FieldDescriptor) interface{} {
+	switch fd.GetType() {
+	case dpb.FieldDescriptorProto_TYPE_FIXED32,
+		dpb.FieldDescriptorProto_TYPE_UINT32:
+		return uint32(0)
+	case dpb.FieldDescriptorProto_TYPE_SFIXED32,
+		dpb.FieldDescriptorProto_TYPE_INT32,
+		dpb.FieldDescriptorProto_TYPE_SINT32:
+		return int32(0)
+	case dpb.FieldDescriptorProto_TYPE_FIXED64,
+		dpb.FieldDescriptorProto_TYPE_UINT64:
+		return uint64(0)
+	case dpb.FieldDescriptorProto_TYPE_SFIXED64,
+		dpb.FieldDescriptorProto_TYPE_INT64,
+		dpb.FieldDescriptorProto_TYPE_SINT64:
+		return int64(0)
+	case dpb.FieldDescriptorProto_TYPE_FLOAT:
+		return float32(0)
+	case dpb.FieldDescriptorProto_TYPE_DOUBLE:
+		return float64(0)
+	case dpb.FieldDescriptorProto_TYPE_BOOL:
+		return false
+	case dpb.FieldDescriptorProto_TYPE_BYTES:
+		return []byte(nil)
+	case dpb.FieldDescriptorProto_TYPE_STRING:
+		return ""
+	case dpb.FieldDescriptorProto_TYPE_ENUM:
+		// proto3 enums must have zero as their first value; proto2 enums use
+		// their first value as the default
+		if vals := fd.GetEnumType().GetValues(); len(vals) > 0 {
+			return vals[0]
+		}
+		return nil
+	default:
+		return nil
+	}
+}
+
+// parseDefaultValue interprets the default value in the given field's
+// descriptor proto, which is a string. The string is in the format emitted by
+// protoc: numbers are in decimal, special float values are "inf", "-inf", and
+// "nan", enum values are indicated by name, and bytes use C-style escapes.
+func parseDefaultValue(fd *FieldDescriptor) (interface{}, error) {
+	def := fd.proto.GetDefaultValue()
+	switch fd.GetType() {
+	case dpb.FieldDescriptorProto_TYPE_FIXED32,
+		dpb.FieldDescriptorProto_TYPE_UINT32:
+		v, err := strconv.ParseUint(def, 10, 32)
+		return uint32(v), err
+	case dpb.FieldDescriptorProto_TYPE_SFIXED32,
+		dpb.FieldDescriptorProto_TYPE_INT32,
+		dpb.FieldDescriptorProto_TYPE_SINT32:
+		v, err := strconv.ParseInt(def, 10, 32)
+		return int32(v), err
+	case dpb.FieldDescriptorProto_TYPE_FIXED64,
+		dpb.FieldDescriptorProto_TYPE_UINT64:
+		return strconv.ParseUint(def, 10, 64)
+	case dpb.FieldDescriptorProto_TYPE_SFIXED64,
+		dpb.FieldDescriptorProto_TYPE_INT64,
+		dpb.FieldDescriptorProto_TYPE_SINT64:
+		return strconv.ParseInt(def, 10, 64)
+	case dpb.FieldDescriptorProto_TYPE_FLOAT:
+		v, err := parseFloat(def, 32)
+		return float32(v), err
+	case dpb.FieldDescriptorProto_TYPE_DOUBLE:
+		return parseFloat(def, 64)
+	case dpb.FieldDescriptorProto_TYPE_BOOL:
+		return strconv.ParseBool(def)
+	case dpb.FieldDescriptorProto_TYPE_STRING:
+		return def, nil
+	case dpb.FieldDescriptorProto_TYPE_BYTES:
+		return UnescapeBytes(def)
+	case dpb.FieldDescriptorProto_TYPE_ENUM:
+		if vd := fd.GetEnumType().FindValueByName(def); vd != nil {
+			return vd, nil
+		}
+		return nil, fmt.Errorf("not a value of enum %s", fd.GetEnumType().GetFullyQualifiedName())
+	default:
+		return nil, fmt.Errorf("type %v cannot have a default value", fd.GetType())
+	}
+}
+
+func parseFloat(s string, bitSize int) (float64, error) {
+	switch s {
+	case "inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	case "nan":
+		return math.NaN(), nil
+	default:
+		return strconv.ParseFloat(s, bitSize)
+	}
+}
+
+// UnescapeBytes decodes a string that uses C-style escapes. This is the
+// escaping that protoc uses for the default values of bytes fields (see
+// FieldDescriptor.GetDefaultValue), and that the protobuf text format uses for
+// string and bytes values. Supported escapes are \a, \b, \f, \n, \r, \t,
+// \v, \\, \', \", \?, hex escapes of up to two digits (\x7f), and octal
+// escapes of up to three digits (\177).
+func UnescapeBytes(s string) ([]byte, error) {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' {
+			b = append(b, c)
+			continue
+		}
+		i++
+		if i >= len(s) {
+			return nil, fmt.Errorf("invalid escape sequence at end of %q", s)
+		}
+		c = s[i]
+		switch c {
+		case 'a':
+			b = append(b, '\a')
+		case 'b':
+			b = append(b, '\b')
+		case 'f':
+			b = append(b, '\f')
+		case 'n':
+			b = append(b, '\n')
+		case 'r':
+			b = append(b, '\r')
+		case 't':
+			b = append(b, '\t')
+		case 'v':
+			b = append(b, '\v')
+		case '\\', '\'', '"', '?':
+			b = append(b, c)
+		case 'x', 'X':
+			// up to two hex digits
+			start := i + 1
+			end := start
+			for end < len(s) && end-start < 2 && isHexDigit(s[end]) {
+				end++
+			}
+			if end == start {
+				return nil, fmt.Errorf("invalid hex escape in %q", s)
+			}
+			v, _ := strconv.ParseUint(s[start:end], 16, 8)
+			b = append(b, byte(v))
+			i = end - 1
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			// up to three octal digits
+			start := i
+			end := start
+			for end < len(s) && end-start < 3 && s[end] >= '0' && s[end] <= '7' {
+				end++
+			}
+			v, err := strconv.ParseUint(s[start:end], 8, 16)
+			if err != nil || v > 255 {
+				return nil, fmt.Errorf("invalid octal escape in %q", s)
+			}
+			b = append(b, byte(v))
+			i = end - 1
+		default:
+			return nil, fmt.Errorf("invalid escape sequence '\\%c' in %q", c, s)
+		}
+	}
+	return b, nil
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
@@ -31,6 +31,8 @@
 // FileDescriptorSet file (which can be generated by protoc) or loaded from a
 // server. This enables interesting things like dynamic clients: where a Go
 // program can be an RPC client of a service it wasn't compiled to know about.
+// The DescriptorSource interface abstracts over these different places from
+// which descriptors can be loaded; see LoadFileDescriptorFromSource.
 package desc
 
 import (
@@ -52,10 +54,13 @@ const (
 	// NB: It would be nice to use constants from generated code instead of hard-coding these here.
 	// But code-gen does not emit these as constants anywhere. The only places they appear in generated
 	// code are struct tags on fields of the generated descriptor protos.
+	file_packageTag = 2
+	file_dependencyTag = 3
 	file_messagesTag = 4
 	file_enumsTag = 5
 	file_servicesTag = 6
 	file_extensionsTag = 7
+	file_syntaxTag = 12
 	message_fieldsTag = 2
 	message_nestedMessagesTag = 3
 	message_enumsTag = 4
@@ -90,10 +95,104 @@ type Descriptor interface {
 	// the element (including if there is none at all in the file descriptor) then this
 	// returns nil
 	GetSourceInfo() *dpb.SourceCodeInfo_Location
+	// GetLeadingComments returns the comment that immediately precedes the element in
+	// its source file, or the empty string if there is none or the file descriptor has no
+	// source code info. Comment markers ("//" and "/*") are not included. For file
+	// descriptors, this is the comment that precedes the syntax declaration (or the package
+	// declaration if the file has no syntax declaration).
+	GetLeadingComments() string
+	// GetTrailingComments returns the comment that immediately follows the element in its
+	// source file, or the empty string if there is none or the file descriptor has no source
+	// code info.
+	GetTrailingComments() string
+	// GetLeadingDetachedComments returns comments that precede the element in its source
+	// file but are separated from it (and from each other) by blank lines. Such comments
+	// are typically not documentation for the element. For file descriptors, this will
+	// include comments at the top of the file, such as copyright headers.
+	GetLeadingDetachedComments() []string
+	// GetSourceSpan returns the location of the element in its source file. If there is
+	// no source code info for the element, the zero value is returned.
+	GetSourceSpan() SourceSpan
+	// GetSourcePath returns the path that identifies the element in its file's source code
+	// info (see SourceCodeInfo.Location in descriptor.proto). The path is computed from the
+	// element's position in the file, so it is available even if the file descriptor has no
+	// source code info. The returned slice must not be modified. For file descriptors, this
+	// returns nil.
+	GetSourcePath() []int32
 	// AsProto returns the underlying descriptor proto for this descriptor.
 	AsProto() proto.Message
 }
 
+// ScopeOf returns the fully-qualified name of the scope in which the given element is
+// declared. This is the scope in which the element's fully-qualified name is defined and
+// from which relative type names used by the element are resolved. For top-level
+// elements, it is the file's package. For elements declared inside of a message or
+// service, it is that message or service. Enum values follow C++ scoping rules: they are
+// in the same scope as their enum, not inside of it. The scope of a file is its package.
+func ScopeOf(d Descriptor) string {
+	switch p := d.GetParent().(type) {
+	case nil:
+		return d.GetFile().GetPackage()
+	case *FileDescriptor:
+		return p.GetPackage()
+	case *EnumDescriptor:
+		return ScopeOf(p)
+	case *OneOfDescriptor:
+		return p.GetOwner().GetFullyQualifiedName()
+	default:
+		return p.GetFullyQualifiedName()
+	}
+}
+
+// SourceSpan describes the location of an element in a proto source file. Line and
+// column numbers are one-based, and tab characters advance the column to the next
+// multiple of eight (the same as protoc). The end line and column are the position
+// just after the end of the element. All values are zero if the location is unknown.
+type SourceSpan struct {
+	StartLine, StartColumn int
+	EndLine, EndColumn int
+}
+
+// IsValid returns true if the span refers to an actual location in a source file and
+// false if it is the zero value (the location is unknown).
+func (s SourceSpan) IsValid() bool {
+	return s.StartLine > 0
+}
+
+// Contains returns true if the given position is within the span. Line and column
+// numbers are one-based. An invalid span contains no positions.
+func (s SourceSpan) Contains(line, col int) bool {
+	if !s.IsValid() {
+		return false
+	}
+	if line < s.StartLine || (line == s.StartLine && col < s.StartColumn) {
+		return false
+	}
+	return line < s.EndLine || (line == s.EndLine && col < s.EndColumn)
+}
+
+// String returns a string representation of the span, in the form
+// "startLine:startCol-endLine:endCol", or "?" if the location is unknown.
+func (s SourceSpan) String() string {
+	if !s.IsValid() {
+		return "?"
+	}
+	return fmt.Sprintf("%d:%d-%d:%d", s.StartLine, s.StartColumn, s.EndLine, s.EndColumn)
+}
+
+func sourceSpan(loc *dpb.SourceCodeInfo_Location) SourceSpan {
+	span := loc.GetSpan()
+	switch len(span) {
+	case 3:
+		// when the element starts and ends on the same line, the end line is omitted
+		return SourceSpan{ StartLine: int(span[0]) + 1, StartColumn: int(span[1]) + 1, EndLine: int(span[0]) + 1, EndColumn: int(span[2]) + 1 }
+	case 4:
+		return SourceSpan{ StartLine: int(span[0]) + 1, StartColumn: int(span[1]) + 1, EndLine: int(span[2]) + 1, EndColumn: int(span[3]) + 1 }
+	default:
+		return SourceSpan{}
+	}
+}
+
 // FileDescriptor describes a proto source file.
 type FileDescriptor struct {
 	proto      *dpb.FileDescriptorProto
@@ -106,6 +205,8 @@ type FileDescriptor struct {
 	extensions []*FieldDescriptor
 	services   []*ServiceDescriptor
 	fieldIndex map[string]map[int32]*FieldDescriptor
+	sourceInfo *dpb.SourceCodeInfo_Location
+	headerInfo *dpb.SourceCodeInfo_Location
 }
 
 // CreateFileDescriptor instantiates a new file descriptor for the given descriptor proto.
@@ -162,6 +263,10 @@ func CreateFileDescriptor(fd *dpb.FileDescriptorProto, deps ...*FileDescriptor)
 	for _, scl := range fd.GetSourceCodeInfo().GetLocation() {
 		sourceCodeInfo[pathAsKey(scl.GetPath())] = scl
 	}
+	ret.sourceInfo = sourceCodeInfo[pathAsKey(nil)]
+	if ret.headerInfo = sourceCodeInfo[pathAsKey([]int32{ file_syntaxTag })]; ret.headerInfo == nil {
+		ret.headerInfo = sourceCodeInfo[pathAsKey([]int32{ file_packageTag })]
+	}
 
 	// now we can resolve all type references and source code info
 	scopes := []scope{fileScope(ret)}
@@ -199,37 +304,115 @@ func CreateFileDescriptorFromSet(fds *dpb.FileDescriptorSet) (*FileDescriptor, e
 	if len(fds.GetFile()) == 0 {
 		return nil, errors.New("file descriptor set is empty")
 	}
-	files := map[string]*dpb.FileDescriptorProto{}
-	resolved := map[string]*FileDescriptor{}
-	var name string
-	for i, fd := range fds.GetFile() {
-		if i == 0 {
-			name = fd.GetName()
+	s := newSetLinker(fds, false)
+	return s.link(fds.GetFile()[0].GetName(), nil)
+}
+
+// CreateFileDescriptorFromSetStrict creates a descriptor from the given file descriptor
+// set, like CreateFileDescriptorFromSet, but also validates every file in the set, like
+// CreateFileDescriptorStrict.
+func CreateFileDescriptorFromSetStrict(fds *dpb.FileDescriptorSet) (*FileDescriptor, error) {
+	if len(fds.GetFile()) == 0 {
+		return nil, errors.New("file descriptor set is empty")
+	}
+	s := newSetLinker(fds, true)
+	return s.link(fds.GetFile()[0].GetName(), nil)
+}
+
+// CreateFileDescriptorsFromSet creates descriptors for all of the files in the given file
+// descriptor set. The returned map is keyed by file name. The set must include all of the
+// transitive dependencies of the files it contains. Each file is only created once, even
+// if it is imported by many other files in the set. An error is returned if any of the
+// files cannot be created or if the files' imports contain a cycle.
+func CreateFileDescriptorsFromSet(fds *dpb.FileDescriptorSet) (map[string]*FileDescriptor, error) {
+	return createFilesFromSet(fds, false)
+}
+
+// CreateFileDescriptorsFromSetStrict creates descriptors for all of the files in the given
+// file descriptor set, like CreateFileDescriptorsFromSet, but also validates every file in
+// the set, like CreateFileDescriptorStrict.
+func CreateFileDescriptorsFromSetStrict(fds *dpb.FileDescriptorSet) (map[string]*FileDescriptor, error) {
+	return createFilesFromSet(fds, true)
+}
+
+func createFilesFromSet(fds *dpb.FileDescriptorSet, strict bool) (map[string]*FileDescriptor, error) {
+	s := newSetLinker(fds, strict)
+	for _, fd := range fds.GetFile() {
+		if _, err := s.link(fd.GetName(), nil); err != nil {
+			return nil, err
 		}
+	}
+	return s.resolved, nil
+}
+
+// setLinker creates descriptors for the files in a file descriptor set. Each file
+// is created once and memoized, so files imported by many others are shared.
+type setLinker struct {
+	files map[string]*dpb.FileDescriptorProto
+	resolved map[string]*FileDescriptor
+	strict bool
+}
+
+func newSetLinker(fds *dpb.FileDescriptorSet, strict bool) *setLinker {
+	files := map[string]*dpb.FileDescriptorProto{}
+	for _, fd := range fds.GetFile() {
 		files[fd.GetName()] = fd
 	}
-	return createFromSet(name, files, resolved)
+	return &setLinker{files: files, resolved: map[string]*FileDescriptor{}, strict: strict}
 }
 
-// createFromSet creates a descriptor for the given filename. It recursively
-// creates descriptors for the given file's dependencies.
-func createFromSet(filename string, files map[string]*dpb.FileDescriptorProto, resolved map[string]*FileDescriptor) (*FileDescriptor, error) {
-	if d, ok := resolved[filename]; ok {
+// link creates a descriptor for the given filename. It recursively creates
+// descriptors for the given file's dependencies. The importedBy parameter is
+// the chain of files that led to this one, for detecting import cycles. If
+// the linker is strict, each file is also validated.
+func (s *setLinker) link(filename string, importedBy []string) (*FileDescriptor, error) {
+	if d, ok := s.resolved[filename]; ok {
 		return d, nil
 	}
-	fdp := files[filename]
+	if err := checkImportCycle(filename, importedBy); err != nil {
+		return nil, err
+	}
+	fdp := s.files[filename]
 	if fdp == nil {
 		return nil, fmt.Errorf("file descriptor set missing a dependency: %s", filename)
 	}
+	importedBy = append(importedBy, filename)
 	deps := make([]*FileDescriptor, len(fdp.GetDependency()))
 	for i, depName := range fdp.GetDependency() {
-		if dep, err := createFromSet(depName, files, resolved); err != nil {
+		if dep, err := s.link(depName, importedBy); err != nil {
 			return nil, err
 		} else {
 			deps[i] = dep
 		}
 	}
-	return CreateFileDescriptor(fdp, deps...)
+	var d *FileDescriptor
+	var err error
+	if s.strict {
+		d, err = CreateFileDescriptorStrict(fdp, deps...)
+	} else {
+		d, err = CreateFileDescriptor(fdp, deps...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.resolved[filename] = d
+	return d, nil
+}
+
+// checkImportCycle returns an error if the given file is already in the given
+// chain of importing files.
+func checkImportCycle(filename string, importedBy []string) error {
+	for i, n := range importedBy {
+		if n == filename {
+			chain := make([]string, 0, len(importedBy) - i + 1)
+			for _, c := range importedBy[i:] {
+				chain = append(chain, fmt.Sprintf("%q", c))
+			}
+			chain = append(chain, fmt.Sprintf("%q", filename))
+			return fmt.Errorf("cycle found in imports: %s", strings.Join(chain, " -> "))
+		}
+	}
+	return nil
 }
 
 func (fd *FileDescriptor) registerField(field *FieldDescriptor) {
@@ -253,6 +436,11 @@ func (fd *FileDescriptor) GetPackage() string {
 	return fd.proto.GetPackage()
 }
 
+// IsProto3 returns true if the file declares a syntax of "proto3".
+func (fd *FileDescriptor) IsProto3() bool {
+	return fd.proto.GetSyntax() == "proto3"
+}
+
 func (fd *FileDescriptor) GetParent() Descriptor {
 	return nil
 }
@@ -273,6 +461,26 @@ func (fd *FileDescriptor) GetSourceInfo() *dpb.SourceCodeInfo_Location {
 	return nil
 }
 
+func (fd *FileDescriptor) GetLeadingComments() string {
+	return fd.headerInfo.GetLeadingComments()
+}
+
+func (fd *FileDescriptor) GetTrailingComments() string {
+	return fd.headerInfo.GetTrailingComments()
+}
+
+func (fd *FileDescriptor) GetLeadingDetachedComments() []string {
+	return fd.headerInfo.GetLeadingDetachedComments()
+}
+
+func (fd *FileDescriptor) GetSourceSpan() SourceSpan {
+	return sourceSpan(fd.sourceInfo)
+}
+
+func (fd *FileDescriptor) GetSourcePath() []int32 {
+	return nil
+}
+
 func (fd *FileDescriptor) AsProto() proto.Message {
 	return fd.proto
 }
@@ -380,6 +588,56 @@ func (fd *FileDescriptor) FindExtensionByName(extName string) *FieldDescriptor {
 	}
 }
 
+// FindDescriptorByPath finds the innermost element in this file whose source path
+// (see Descriptor.GetSourcePath) is a prefix of the given path. So the path can
+// identify an element or any part of one, like the name or type of a field, which is
+// the kind of path used for locations in source code info. If the path does not refer
+// to any element in this file, such as if it is empty or refers to the file's package
+// or options, then nil is returned.
+func (fd *FileDescriptor) FindDescriptorByPath(path []int32) Descriptor {
+	var found Descriptor
+	Walk(fd, func(d Descriptor, p []int32) error {
+		if !isPathPrefix(p, path) {
+			return SkipChildren
+		}
+		found = d
+		return nil
+	})
+	return found
+}
+
+func isPathPrefix(prefix, path []int32) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i, p := range prefix {
+		if path[i] != p {
+			return false
+		}
+	}
+	return true
+}
+
+// FindDescriptorAtPosition finds the innermost element in this file whose source
+// span (see Descriptor.GetSourceSpan) contains the given position. Line and column
+// numbers are one-based. If no element contains the position, including if the file
+// descriptor has no source code info, then nil is returned.
+func (fd *FileDescriptor) FindDescriptorAtPosition(line, col int) Descriptor {
+	var found Descriptor
+	Walk(fd, func(d Descriptor, _ []int32) error {
+		span := d.GetSourceSpan()
+		if !span.IsValid() {
+			return nil
+		}
+		if !span.Contains(line, col) {
+			return SkipChildren
+		}
+		found = d
+		return nil
+	})
+	return found
+}
+
 // MessageDescriptor describes a protocol buffer message.
 type MessageDescriptor struct {
 	proto      *dpb.DescriptorProto
@@ -391,8 +649,11 @@ type MessageDescriptor struct {
 	extensions []*FieldDescriptor
 	oneOfs     []*OneOfDescriptor
 	extRanges  extRanges
+	resRanges  reservedRanges
+	resNames   map[string]struct{}
 	fqn        string
 	sourceInfo *dpb.SourceCodeInfo_Location
+	path       []int32
 }
 
 func createMessageDescriptor(fd *FileDescriptor, parent Descriptor, enclosing string, md *dpb.DescriptorProto, symbols map[string]Descriptor) (*MessageDescriptor, string) {
@@ -424,15 +685,26 @@ func createMessageDescriptor(fd *FileDescriptor, parent Descriptor, enclosing st
 		ret.oneOfs = append(ret.oneOfs, od)
 	}
 	for _, r := range md.GetExtensionRange() {
-		ret.extRanges = append(ret.extRanges, proto.ExtensionRange{r.GetStart(), r.GetEnd()})
+		// proto.ExtensionRange has an inclusive end, but the end in the descriptor proto is exclusive
+		end := r.GetEnd() - 1
+		ret.extRanges = append(ret.extRanges, proto.ExtensionRange{
+			Start: r.GetStart(),
+			End:   end})
 	}
 	sort.Sort(ret.extRanges)
+	for _, r := range md.GetReservedRange() {
+		// like extension ranges, the end of a message's reserved range is exclusive in the descriptor proto
+		ret.resRanges = append(ret.resRanges, ReservedRange{ Start: r.GetStart(), End: r.GetEnd() - 1 })
+	}
+	sort.Sort(ret.resRanges)
+	ret.resNames = reservedNames(md.GetReservedName())
 
 	return ret, msgName
 }
 
 func (md *MessageDescriptor) resolve(path []int32, sourceCodeInfo map[string]*dpb.SourceCodeInfo_Location, scopes []scope) error {
 	md.sourceInfo = sourceCodeInfo[pathAsKey(path)]
+	md.path = copyPath(path)
 	path = append(path, message_nestedMessagesTag)
 	scopes = append(scopes, messageScope(md))
 	for i, nmd := range md.nested {
@@ -491,6 +763,26 @@ func (md *MessageDescriptor) GetSourceInfo() *dpb.SourceCodeInfo_Location {
 	return md.sourceInfo
 }
 
+func (md *MessageDescriptor) GetLeadingComments() string {
+	return md.sourceInfo.GetLeadingComments()
+}
+
+func (md *MessageDescriptor) GetTrailingComments() string {
+	return md.sourceInfo.GetTrailingComments()
+}
+
+func (md *MessageDescriptor) GetLeadingDetachedComments() []string {
+	return md.sourceInfo.GetLeadingDetachedComments()
+}
+
+func (md *MessageDescriptor) GetSourceSpan() SourceSpan {
+	return sourceSpan(md.sourceInfo)
+}
+
+func (md *MessageDescriptor) GetSourcePath() []int32 {
+	return md.path
+}
+
 func (md *MessageDescriptor) AsProto() proto.Message {
 	return md.proto
 }
@@ -567,8 +859,8 @@ func (er extRanges) String() string {
 }
 
 func (er extRanges) IsExtension(tagNumber int32) bool {
-	i := sort.Search(len(er), func(i int) bool { return er[i].Start >= tagNumber })
-	return i < len(er) && tagNumber <= er[i].End
+	i := sort.Search(len(er), func(i int) bool { return er[i].End >= tagNumber })
+	return i < len(er) && tagNumber >= er[i].Start
 }
 
 func (er extRanges) Len() int {
@@ -588,6 +880,82 @@ func (er extRanges) Swap(i, j int) {
 	er[i], er[j] = er[j], er[i]
 }
 
+// GetReservedRanges returns the ranges of field numbers that are reserved in this
+// message. Fields may not use these numbers.
+func (md *MessageDescriptor) GetReservedRanges() []ReservedRange {
+	return md.resRanges
+}
+
+// IsReservedNumber returns true if the given tag number is within any of this message's
+// reserved ranges.
+func (md *MessageDescriptor) IsReservedNumber(tagNumber int32) bool {
+	return md.resRanges.IsReserved(tagNumber)
+}
+
+// GetReservedNames returns the field names that are reserved in this message. Fields
+// may not use these names.
+func (md *MessageDescriptor) GetReservedNames() []string {
+	return md.proto.GetReservedName()
+}
+
+// IsReservedName returns true if the given field name is reserved in this message.
+func (md *MessageDescriptor) IsReservedName(fieldName string) bool {
+	_, ok := md.resNames[fieldName]
+	return ok
+}
+
+// ReservedRange is a range of reserved field numbers (for messages) or enum value numbers
+// (for enums). Like proto.ExtensionRange, both the start and end of the range are inclusive.
+type ReservedRange struct {
+	Start, End int32
+}
+
+type reservedRanges []ReservedRange
+
+func (rr reservedRanges) String() string {
+	var buf bytes.Buffer
+	first := true
+	for _, r := range rr {
+		if first {
+			first = false
+		} else {
+			buf.WriteString(",")
+		}
+		fmt.Fprintf(&buf, "%d..%d", r.Start, r.End)
+	}
+	return buf.String()
+}
+
+func (rr reservedRanges) IsReserved(num int32) bool {
+	i := sort.Search(len(rr), func(i int) bool { return rr[i].End >= num })
+	return i < len(rr) && num >= rr[i].Start
+}
+
+func (rr reservedRanges) Len() int {
+	return len(rr)
+}
+
+func (rr reservedRanges) Less(i, j int) bool {
+	if rr[i].Start < rr[j].Start {
+		return true
+	} else if rr[i].Start == rr[j].Start && rr[i].End < rr[j].End {
+		return true
+	}
+	return false
+}
+
+func (rr reservedRanges) Swap(i, j int) {
+	rr[i], rr[j] = rr[j], rr[i]
+}
+
+func reservedNames(names []string) map[string]struct{} {
+	ret := make(map[string]struct{}, len(names))
+	for _, n := range names {
+		ret[n] = struct{}{}
+	}
+	return ret
+}
+
 // FindFieldByName finds the field with the given name. If no such field exists
 // then nil is returned. Only regular fields are returned, not extensions.
 func (md *MessageDescriptor) FindFieldByName(fieldName string) *FieldDescriptor {
@@ -620,6 +988,8 @@ type FieldDescriptor struct {
 	enumType   *EnumDescriptor
 	fqn        string
 	sourceInfo *dpb.SourceCodeInfo_Location
+	path       []int32
+	def        interface{}
 }
 
 func createFieldDescriptor(fd *FileDescriptor, parent Descriptor, enclosing string, fld *dpb.FieldDescriptorProto) (*FieldDescriptor, string) {
@@ -634,27 +1004,33 @@ func createFieldDescriptor(fd *FileDescriptor, parent Descriptor, enclosing stri
 
 func (fd *FieldDescriptor) resolve(path []int32, sourceCodeInfo map[string]*dpb.SourceCodeInfo_Location, scopes []scope) error {
 	fd.sourceInfo = sourceCodeInfo[pathAsKey(path)]
+	fd.path = copyPath(path)
 	if fd.proto.GetType() == dpb.FieldDescriptorProto_TYPE_ENUM {
-		if desc, err := resolve(fd.file, fd.proto.GetTypeName(), scopes); err != nil {
+		if desc, err := resolveEnum(fd.file, fd.proto.GetTypeName(), scopes, "field " + fd.fqn); err != nil {
 			return err
 		} else {
-			fd.enumType = desc.(*EnumDescriptor)
+			fd.enumType = desc
 		}
 	}
 	if fd.proto.GetType() == dpb.FieldDescriptorProto_TYPE_MESSAGE || fd.proto.GetType() == dpb.FieldDescriptorProto_TYPE_GROUP {
-		if desc, err := resolve(fd.file, fd.proto.GetTypeName(), scopes); err != nil {
+		if desc, err := resolveMessage(fd.file, fd.proto.GetTypeName(), scopes, "field " + fd.fqn); err != nil {
 			return err
 		} else {
-			fd.msgType = desc.(*MessageDescriptor)
+			fd.msgType = desc
 		}
 	}
 	if fd.proto.GetExtendee() != "" {
-		if desc, err := resolve(fd.file, fd.proto.GetExtendee(), scopes); err != nil {
+		if desc, err := resolveMessage(fd.file, fd.proto.GetExtendee(), scopes, "extendee of " + fd.fqn); err != nil {
 			return err
 		} else {
-			fd.owner = desc.(*MessageDescriptor)
+			fd.owner = desc
 		}
 	}
+	if def, err := computeDefaultValue(fd); err != nil {
+		return fmt.Errorf("File %q: %v", fd.file.proto.GetName(), err)
+	} else {
+		fd.def = def
+	}
 	fd.file.registerField(fd)
 	return nil
 }
@@ -668,6 +1044,38 @@ func (fd *FieldDescriptor) GetNumber() int32 {
 	return fd.proto.GetNumber()
 }
 
+// GetJSONName returns the name used to represent this field in JSON. This is
+// the json_name in the field's descriptor if present. Otherwise, it is the
+// field's name converted to lowerCamelCase, the same way that protoc computes
+// the default JSON name.
+func (fd *FieldDescriptor) GetJSONName() string {
+	if fd.proto.JsonName != nil {
+		return fd.proto.GetJsonName()
+	}
+	return DefaultJSONName(fd.proto.GetName())
+}
+
+// DefaultJSONName returns the default JSON name for a field with the given
+// name, computed the same way protoc computes it: underscores are removed and
+// any lower-case ASCII letter that follows an underscore is converted to upper
+// case. Other characters, including non-ASCII letters, are left as is.
+func DefaultJSONName(fieldName string) string {
+	var buf bytes.Buffer
+	capitalizeNext := false
+	for _, r := range fieldName {
+		if r == '_' {
+			capitalizeNext = true
+			continue
+		}
+		if capitalizeNext && r >= 'a' && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		capitalizeNext = false
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
 func (fd *FieldDescriptor) GetFullyQualifiedName() string {
 	return fd.fqn
 }
@@ -692,6 +1100,26 @@ func (fd *FieldDescriptor) GetSourceInfo() *dpb.SourceCodeInfo_Location {
 	return fd.sourceInfo
 }
 
+func (fd *FieldDescriptor) GetLeadingComments() string {
+	return fd.sourceInfo.GetLeadingComments()
+}
+
+func (fd *FieldDescriptor) GetTrailingComments() string {
+	return fd.sourceInfo.GetTrailingComments()
+}
+
+func (fd *FieldDescriptor) GetLeadingDetachedComments() []string {
+	return fd.sourceInfo.GetLeadingDetachedComments()
+}
+
+func (fd *FieldDescriptor) GetSourceSpan() SourceSpan {
+	return sourceSpan(fd.sourceInfo)
+}
+
+func (fd *FieldDescriptor) GetSourcePath() []int32 {
+	return fd.path
+}
+
 func (fd *FieldDescriptor) AsProto() proto.Message {
 	return fd.proto
 }
@@ -769,29 +1197,55 @@ func (fd *FieldDescriptor) GetEnumType() *EnumDescriptor {
 	return fd.enumType
 }
 
+// GetDefaultValue returns the default value for this field. This is the value
+// declared in the field's default option, or the zero value of the field's type
+// if it does not declare one (which is always the case in proto3). The returned
+// value will be an int32, int64, uint32, uint64, float32, float64, bool,
+// string, or []byte, depending on the field's type. For enum fields, the value
+// is an *EnumValueDescriptor; the zero value is the enum's first value. Repeated
+// fields and fields whose type is a message have no default value, so this
+// returns nil for them. The returned value must not be modified.
+func (fd *FieldDescriptor) GetDefaultValue() interface{} {
+	return fd.def
+}
+
 // EnumDescriptor describes an enum declared in a proto file.
 type EnumDescriptor struct {
 	proto      *dpb.EnumDescriptorProto
 	parent     Descriptor
 	file       *FileDescriptor
 	values     []*EnumValueDescriptor
+	valsByName map[string]*EnumValueDescriptor
+	valsByNum  map[int32][]*EnumValueDescriptor
+	resRanges  reservedRanges
+	resNames   map[string]struct{}
 	fqn        string
 	sourceInfo *dpb.SourceCodeInfo_Location
+	path       []int32
 }
 
 func createEnumDescriptor(fd *FileDescriptor, parent Descriptor, enclosing string, ed *dpb.EnumDescriptorProto, symbols map[string]Descriptor) (*EnumDescriptor, string) {
 	enumName := merge(enclosing, ed.GetName())
-	ret := &EnumDescriptor{ proto: ed, parent: parent, file: fd, fqn: enumName }
+	ret := &EnumDescriptor{ proto: ed, parent: parent, file: fd, fqn: enumName, valsByName: map[string]*EnumValueDescriptor{}, valsByNum: map[int32][]*EnumValueDescriptor{} }
 	for _, ev := range ed.GetValue() {
 		evd, n := createEnumValueDescriptor(fd, ret, enumName, ev)
 		symbols[n] = evd
 		ret.values = append(ret.values, evd)
+		ret.valsByName[ev.GetName()] = evd
+		ret.valsByNum[ev.GetNumber()] = append(ret.valsByNum[ev.GetNumber()], evd)
+	}
+	for _, r := range ed.GetReservedRange() {
+		// unlike message reserved ranges, the end of an enum's reserved range is inclusive
+		ret.resRanges = append(ret.resRanges, ReservedRange{ Start: r.GetStart(), End: r.GetEnd() })
 	}
+	sort.Sort(ret.resRanges)
+	ret.resNames = reservedNames(ed.GetReservedName())
 	return ret, enumName
 }
 
 func (ed *EnumDescriptor) resolve(path []int32, sourceCodeInfo map[string]*dpb.SourceCodeInfo_Location) {
 	ed.sourceInfo = sourceCodeInfo[pathAsKey(path)]
+	ed.path = copyPath(path)
 	path = append(path, enum_valuesTag)
 	for i, evd := range ed.values {
 		evd.resolve(append(path, int32(i)), sourceCodeInfo)
@@ -826,6 +1280,26 @@ func (ed *EnumDescriptor) GetSourceInfo() *dpb.SourceCodeInfo_Location {
 	return ed.sourceInfo
 }
 
+func (ed *EnumDescriptor) GetLeadingComments() string {
+	return ed.sourceInfo.GetLeadingComments()
+}
+
+func (ed *EnumDescriptor) GetTrailingComments() string {
+	return ed.sourceInfo.GetTrailingComments()
+}
+
+func (ed *EnumDescriptor) GetLeadingDetachedComments() []string {
+	return ed.sourceInfo.GetLeadingDetachedComments()
+}
+
+func (ed *EnumDescriptor) GetSourceSpan() SourceSpan {
+	return sourceSpan(ed.sourceInfo)
+}
+
+func (ed *EnumDescriptor) GetSourcePath() []int32 {
+	return ed.path
+}
+
 func (ed *EnumDescriptor) AsProto() proto.Message {
 	return ed.proto
 }
@@ -843,6 +1317,69 @@ func (ed *EnumDescriptor) GetValues() []*EnumValueDescriptor {
 	return ed.values
 }
 
+// FindValueByName finds the enum value with the given name. The name is the value's
+// simple name, not its fully-qualified name. If no such value exists then nil is returned.
+func (ed *EnumDescriptor) FindValueByName(name string) *EnumValueDescriptor {
+	return ed.valsByName[name]
+}
+
+// FindValueByNumber finds the enum value with the given number. If the enum allows
+// aliases and more than one value has the given number, the first one declared is
+// returned; this is the value that protoc uses when formatting the number as a name.
+// If no value has the given number then nil is returned.
+func (ed *EnumDescriptor) FindValueByNumber(num int32) *EnumValueDescriptor {
+	if vals := ed.valsByNum[num]; len(vals) > 0 {
+		return vals[0]
+	}
+	return nil
+}
+
+// FindValuesByNumber finds all enum values with the given number, in the order in
+// which they were declared. There will be more than one only if the enum allows
+// aliases. If no value has the given number then nil is returned.
+func (ed *EnumDescriptor) FindValuesByNumber(num int32) []*EnumValueDescriptor {
+	return ed.valsByNum[num]
+}
+
+// AllowsAlias returns true if the enum's allow_alias option is set, which allows more
+// than one value to have the same number.
+func (ed *EnumDescriptor) AllowsAlias() bool {
+	return ed.proto.GetOptions().GetAllowAlias()
+}
+
+// IsOpen returns true if the enum is open, which means that fields of the enum type
+// may have any int32 value, including numbers that have no corresponding enum value.
+// Enums declared in proto3 files are open. Enums declared in proto2 files are closed:
+// when a decoder encounters a number with no corresponding enum value, it should treat
+// the field as an unknown field instead of recording the number as the field's value.
+func (ed *EnumDescriptor) IsOpen() bool {
+	return ed.file.IsProto3()
+}
+
+// GetReservedRanges returns the ranges of numbers that are reserved in this enum. Enum
+// values may not use these numbers.
+func (ed *EnumDescriptor) GetReservedRanges() []ReservedRange {
+	return ed.resRanges
+}
+
+// IsReservedNumber returns true if the given number is within any of this enum's reserved
+// ranges.
+func (ed *EnumDescriptor) IsReservedNumber(num int32) bool {
+	return ed.resRanges.IsReserved(num)
+}
+
+// GetReservedNames returns the value names that are reserved in this enum. Enum values
+// may not use these names.
+func (ed *EnumDescriptor) GetReservedNames() []string {
+	return ed.proto.GetReservedName()
+}
+
+// IsReservedName returns true if the given value name is reserved in this enum.
+func (ed *EnumDescriptor) IsReservedName(valueName string) bool {
+	_, ok := ed.resNames[valueName]
+	return ok
+}
+
 // EnumValueDescriptor describes an allowed value of an enum declared in a proto file.
 type EnumValueDescriptor struct {
 	proto      *dpb.EnumValueDescriptorProto
@@ -850,6 +1387,7 @@ type EnumValueDescriptor struct {
 	file       *FileDescriptor
 	fqn        string
 	sourceInfo *dpb.SourceCodeInfo_Location
+	path       []int32
 }
 
 func createEnumValueDescriptor(fd *FileDescriptor, parent *EnumDescriptor, enclosing string, evd *dpb.EnumValueDescriptorProto) (*EnumValueDescriptor, string) {
@@ -859,6 +1397,7 @@ func createEnumValueDescriptor(fd *FileDescriptor, parent *EnumDescriptor, enclo
 
 func (vd *EnumValueDescriptor) resolve(path []int32, sourceCodeInfo map[string]*dpb.SourceCodeInfo_Location) {
 	vd.sourceInfo = sourceCodeInfo[pathAsKey(path)]
+	vd.path = copyPath(path)
 }
 
 func (vd *EnumValueDescriptor) GetName() string {
@@ -899,6 +1438,26 @@ func (vd *EnumValueDescriptor) GetSourceInfo() *dpb.SourceCodeInfo_Location {
 	return vd.sourceInfo
 }
 
+func (vd *EnumValueDescriptor) GetLeadingComments() string {
+	return vd.sourceInfo.GetLeadingComments()
+}
+
+func (vd *EnumValueDescriptor) GetTrailingComments() string {
+	return vd.sourceInfo.GetTrailingComments()
+}
+
+func (vd *EnumValueDescriptor) GetLeadingDetachedComments() []string {
+	return vd.sourceInfo.GetLeadingDetachedComments()
+}
+
+func (vd *EnumValueDescriptor) GetSourceSpan() SourceSpan {
+	return sourceSpan(vd.sourceInfo)
+}
+
+func (vd *EnumValueDescriptor) GetSourcePath() []int32 {
+	return vd.path
+}
+
 func (vd *EnumValueDescriptor) AsProto() proto.Message {
 	return vd.proto
 }
@@ -918,6 +1477,7 @@ type ServiceDescriptor struct {
 	methods    []*MethodDescriptor
 	fqn        string
 	sourceInfo *dpb.SourceCodeInfo_Location
+	path       []int32
 }
 
 func createServiceDescriptor(fd *FileDescriptor, enclosing string, sd *dpb.ServiceDescriptorProto, symbols map[string]Descriptor) (*ServiceDescriptor, string) {
@@ -933,6 +1493,7 @@ func createServiceDescriptor(fd *FileDescriptor, enclosing string, sd *dpb.Servi
 
 func (sd *ServiceDescriptor) resolve(path []int32, sourceCodeInfo map[string]*dpb.SourceCodeInfo_Location, scopes []scope) error {
 	sd.sourceInfo = sourceCodeInfo[pathAsKey(path)]
+	sd.path = copyPath(path)
 	path = append(path, service_methodsTag)
 	for i, md := range sd.methods {
 		if err := md.resolve(append(path, int32(i)), sourceCodeInfo, scopes); err != nil {
@@ -970,6 +1531,26 @@ func (sd *ServiceDescriptor) GetSourceInfo() *dpb.SourceCodeInfo_Location {
 	return sd.sourceInfo
 }
 
+func (sd *ServiceDescriptor) GetLeadingComments() string {
+	return sd.sourceInfo.GetLeadingComments()
+}
+
+func (sd *ServiceDescriptor) GetTrailingComments() string {
+	return sd.sourceInfo.GetTrailingComments()
+}
+
+func (sd *ServiceDescriptor) GetLeadingDetachedComments() []string {
+	return sd.sourceInfo.GetLeadingDetachedComments()
+}
+
+func (sd *ServiceDescriptor) GetSourceSpan() SourceSpan {
+	return sourceSpan(sd.sourceInfo)
+}
+
+func (sd *ServiceDescriptor) GetSourcePath() []int32 {
+	return sd.path
+}
+
 func (sd *ServiceDescriptor) AsProto() proto.Message {
 	return sd.proto
 }
@@ -996,6 +1577,7 @@ type MethodDescriptor struct {
 	outType    *MessageDescriptor
 	fqn        string
 	sourceInfo *dpb.SourceCodeInfo_Location
+	path       []int32
 }
 
 func createMethodDescriptor(fd *FileDescriptor, parent *ServiceDescriptor, enclosing string, md *dpb.MethodDescriptorProto) (*MethodDescriptor, string) {
@@ -1006,15 +1588,16 @@ func createMethodDescriptor(fd *FileDescriptor, parent *ServiceDescriptor, enclo
 
 func (md *MethodDescriptor) resolve(path []int32, sourceCodeInfo map[string]*dpb.SourceCodeInfo_Location, scopes []scope) error {
 	md.sourceInfo = sourceCodeInfo[pathAsKey(path)]
-	if desc, err := resolve(md.file, md.proto.GetInputType(), scopes); err != nil {
+	md.path = copyPath(path)
+	if desc, err := resolveMessage(md.file, md.proto.GetInputType(), scopes, "input type of " + md.fqn); err != nil {
 		return err
 	} else {
-		md.inType = desc.(*MessageDescriptor)
+		md.inType = desc
 	}
-	if desc, err := resolve(md.file, md.proto.GetOutputType(), scopes); err != nil {
+	if desc, err := resolveMessage(md.file, md.proto.GetOutputType(), scopes, "output type of " + md.fqn); err != nil {
 		return err
 	} else {
-		md.outType = desc.(*MessageDescriptor)
+		md.outType = desc
 	}
 	return nil
 }
@@ -1052,6 +1635,26 @@ func (md *MethodDescriptor) GetSourceInfo() *dpb.SourceCodeInfo_Location {
 	return md.sourceInfo
 }
 
+func (md *MethodDescriptor) GetLeadingComments() string {
+	return md.sourceInfo.GetLeadingComments()
+}
+
+func (md *MethodDescriptor) GetTrailingComments() string {
+	return md.sourceInfo.GetTrailingComments()
+}
+
+func (md *MethodDescriptor) GetLeadingDetachedComments() []string {
+	return md.sourceInfo.GetLeadingDetachedComments()
+}
+
+func (md *MethodDescriptor) GetSourceSpan() SourceSpan {
+	return sourceSpan(md.sourceInfo)
+}
+
+func (md *MethodDescriptor) GetSourcePath() []int32 {
+	return md.path
+}
+
 func (md *MethodDescriptor) AsProto() proto.Message {
 	return md.proto
 }
@@ -1092,6 +1695,7 @@ type OneOfDescriptor struct {
 	choices    []*FieldDescriptor
 	fqn        string
 	sourceInfo *dpb.SourceCodeInfo_Location
+	path       []int32
 }
 
 func createOneOfDescriptor(fd *FileDescriptor, parent *MessageDescriptor, index int, enclosing string, od *dpb.OneofDescriptorProto) (*OneOfDescriptor, string) {
@@ -1109,6 +1713,7 @@ func createOneOfDescriptor(fd *FileDescriptor, parent *MessageDescriptor, index
 
 func (od *OneOfDescriptor) resolve(path []int32, sourceCodeInfo map[string]*dpb.SourceCodeInfo_Location) {
 	od.sourceInfo = sourceCodeInfo[pathAsKey(path)]
+	od.path = copyPath(path)
 }
 
 func (od *OneOfDescriptor) GetName() string {
@@ -1144,6 +1749,26 @@ func (od *OneOfDescriptor) GetSourceInfo() *dpb.SourceCodeInfo_Location {
 	return od.sourceInfo
 }
 
+func (od *OneOfDescriptor) GetLeadingComments() string {
+	return od.sourceInfo.GetLeadingComments()
+}
+
+func (od *OneOfDescriptor) GetTrailingComments() string {
+	return od.sourceInfo.GetTrailingComments()
+}
+
+func (od *OneOfDescriptor) GetLeadingDetachedComments() []string {
+	return od.sourceInfo.GetLeadingDetachedComments()
+}
+
+func (od *OneOfDescriptor) GetSourceSpan() SourceSpan {
+	return sourceSpan(od.sourceInfo)
+}
+
+func (od *OneOfDescriptor) GetSourcePath() []int32 {
+	return od.path
+}
+
 func (od *OneOfDescriptor) AsProto() proto.Message {
 	return od.proto
 }
@@ -1162,6 +1787,14 @@ func (od *OneOfDescriptor) GetChoices() []*FieldDescriptor {
 	return od.choices
 }
 
+// copyPath returns a copy of the given path, which is needed to retain a path
+// since resolve methods re-use the underlying storage.
+func copyPath(path []int32) []int32 {
+	ret := make([]int32, len(path))
+	copy(ret, path)
+	return ret
+}
+
 func pathAsKey(path []int32) string {
 	var b bytes.Buffer
 	first := true
@@ -1236,6 +1869,34 @@ func resolve(fd *FileDescriptor, name string, scopes []scope) (Descriptor, error
 	return nil, fmt.Errorf("File %q included an unresolvable reference to %q", fd.proto.GetName(), name)
 }
 
+// resolveMessage resolves the given name, like resolve, but returns an error if
+// the name does not refer to a message. The given description of the reference
+// is included in the error message.
+func resolveMessage(fd *FileDescriptor, name string, scopes []scope, ref string) (*MessageDescriptor, error) {
+	d, err := resolve(fd, name, scopes)
+	if err != nil {
+		return nil, err
+	}
+	if md, ok := d.(*MessageDescriptor); ok {
+		return md, nil
+	}
+	return nil, fmt.Errorf("File %q: %s refers to %q, which is not a message", fd.proto.GetName(), ref, name)
+}
+
+// resolveEnum resolves the given name, like resolve, but returns an error if
+// the name does not refer to an enum. The given description of the reference
+// is included in the error message.
+func resolveEnum(fd *FileDescriptor, name string, scopes []scope, ref string) (*EnumDescriptor, error) {
+	d, err := resolve(fd, name, scopes)
+	if err != nil {
+		return nil, err
+	}
+	if ed, ok := d.(*EnumDescriptor); ok {
+		return ed, nil
+	}
+	return nil, fmt.Errorf("File %q: %s refers to %q, which is not an enum", fd.proto.GetName(), ref, name)
+}
+
 func findSymbol(fd *FileDescriptor, name string, public bool) Descriptor {
 	d := fd.symbols[name]
 	if d != nil {
@@ -1276,7 +1937,8 @@ var (
 
 // LoadFileDescriptor creates a file descriptor using the bytes returned by
 // proto.FileDescriptor. Descriptors are cached so that they do not need to be
-// re-processed if the same file is fetched again later.
+// re-processed if the same file is fetched again later. To load descriptors
+// from somewhere other than the linked Go code, use LoadFileDescriptorFromSource.
 func LoadFileDescriptor(file string) (*FileDescriptor, error) {
 	f := getFileFromCache(file)
 	if f != nil {
@@ -1288,39 +1950,30 @@ func LoadFileDescriptor(file string) (*FileDescriptor, error) {
 }
 
 func loadFileDescriptorLocked(file string) (*FileDescriptor, error) {
-	f := filesCache[file]
-	if f != nil {
-		return f, nil
-	}
+	return linkedLoaderLocked().load(file)
+}
 
-	fdb := proto.FileDescriptor(file)
-	if fdb == nil {
-		return nil, fmt.Errorf("No such file: %q", file)
-	}
+func toFileDescriptorLocked(fd *dpb.FileDescriptorProto) (*FileDescriptor, error) {
+	return linkedLoaderLocked().create(fd)
+}
 
-	fd, err := decodeFileDescriptor(file, fdb)
-	if err != nil {
-		return nil, err
-	}
+// linkedLoaderLocked returns a loader for files linked into the program that
+// memoizes them in the global cache.
+func linkedLoaderLocked() *loader {
+	return &loader{src: linkedSource{}, cache: linkedCacheLocked{}}
+}
 
-	f, err = toFileDescriptorLocked(fd)
-	if err != nil {
-		return nil, err
-	}
-	putCacheLocked(file, f)
-	return f, nil
+// linkedCacheLocked is the global cache of files linked into the program. It
+// must only be used while holding cacheMu.
+type linkedCacheLocked struct{}
+
+func (linkedCacheLocked) GetFileDescriptor(filename string) *FileDescriptor {
+	return filesCache[filename]
 }
 
-func toFileDescriptorLocked(fd *dpb.FileDescriptorProto) (*FileDescriptor, error) {
-	deps := make([]*FileDescriptor, len(fd.GetDependency()))
-	for i, dep := range fd.GetDependency() {
-		var err error
-		deps[i], err = loadFileDescriptorLocked(dep)
-		if err != nil {
-			return nil, err
-		}
-	}
-	return CreateFileDescriptor(fd, deps...)
+func (linkedCacheLocked) PutFileDescriptor(fd *FileDescriptor) *FileDescriptor {
+	putCacheLocked(fd.GetName(), fd)
+	return fd
 }
 
 func decodeFileDescriptor(file string, fdb []byte) (*dpb.FileDescriptorProto, error) {
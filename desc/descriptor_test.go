@@ -2,6 +2,7 @@ package desc
 
 import (
 	"fmt"
+	"math"
 	"reflect"
 	"strings"
 	"testing"
@@ -726,6 +727,7 @@ func checkDescriptor(t *testing.T, caseName string, num int32, d Descriptor, par
 			}
 		}
 		eq(t, expectedComment, strings.TrimSpace(d.GetSourceInfo().GetLeadingComments()), caseName)
+		eq(t, expectedComment, strings.TrimSpace(d.GetLeadingComments()), caseName)
 	}
 
 	// references
@@ -892,4 +894,605 @@ func formatContext(context []interface{}) string {
 		format := context[0].(string)
 		return fmt.Sprintf(format, context[1:]...)
 	}
-}
\ No newline at end of file
+}
+
+// fieldProto returns the proto for an optional field with the given name,
+// number, and type. The type name is only set if it is not empty.
+func fieldProto(name string, num int32, typ dpb.FieldDescriptorProto_Type, typeName string) *dpb.FieldDescriptorProto {
+	fld := &dpb.FieldDescriptorProto{
+		Name:   proto.String(name),
+		Number: proto.Int32(num),
+		Label:  dpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:   typ.Enum(),
+	}
+	if typeName != "" {
+		fld.TypeName = proto.String(typeName)
+	}
+	return fld
+}
+
+// messageFieldProto returns the proto for an optional field with the given
+// name and number whose type is the named message.
+func messageFieldProto(name string, num int32, typeName string) *dpb.FieldDescriptorProto {
+	return fieldProto(name, num, dpb.FieldDescriptorProto_TYPE_MESSAGE, typeName)
+}
+
+func TestMessageExtensionRanges(t *testing.T) {
+	md, err := LoadMessageDescriptor("desc_test.AnotherTestMessage")
+	ok(t, err)
+	// declared as "extensions 100 to 200"
+	eq(t, 1, len(md.GetExtensionRanges()))
+	eq(t, int32(100), md.GetExtensionRanges()[0].Start)
+	eq(t, int32(200), md.GetExtensionRanges()[0].End)
+	eq(t, false, md.IsExtension(99))
+	eq(t, true, md.IsExtension(100))
+	eq(t, true, md.IsExtension(150))
+	eq(t, true, md.IsExtension(200))
+	eq(t, false, md.IsExtension(201))
+}
+
+func TestFieldJSONName(t *testing.T) {
+	md, err := LoadMessageDescriptor("desc_test.AnotherTestMessage")
+	ok(t, err)
+	eq(t, "dne", md.FindFieldByName("dne").GetJSONName())
+	eq(t, "mapField1", md.FindFieldByName("map_field1").GetJSONName())
+	eq(t, "fooBarBaz", DefaultJSONName("foo_bar__baz"))
+	eq(t, "FooBar", DefaultJSONName("_foo_bar_"))
+	// only ASCII letters are capitalized, like protoc
+	eq(t, "fooéBar", DefaultJSONName("foo_é_bar"))
+	eq(t, "foo1bar", DefaultJSONName("foo_1bar"))
+}
+
+func TestScopeOf(t *testing.T) {
+	fd, err := LoadFileDescriptor("desc_test1.proto")
+	ok(t, err)
+	cases := []struct{ name, scope string }{
+		{"desc_test.TestMessage", "desc_test"},
+		{"desc_test.TestMessage.nm", "desc_test.TestMessage"},
+		{"desc_test.TestMessage.NestedMessage", "desc_test.TestMessage"},
+		{"desc_test.TestMessage.NestedEnum", "desc_test.TestMessage"},
+		// enum values are in the same scope as their enum
+		{"desc_test.TestMessage.NestedEnum.VALUE1", "desc_test.TestMessage"},
+		{"desc_test.TestMessage.NestedMessage.AnotherNestedMessage.flags", "desc_test.TestMessage.NestedMessage.AnotherNestedMessage"},
+		{"desc_test.xtm", "desc_test"},
+	}
+	for _, c := range cases {
+		d := fd.FindSymbol(c.name)
+		if d == nil {
+			t.Errorf("symbol %s not found", c.name)
+			continue
+		}
+		eq(t, c.scope, ScopeOf(d), c.name)
+	}
+	eq(t, "desc_test", ScopeOf(fd))
+}
+
+func TestSourceSpan(t *testing.T) {
+	fd, err := CreateFileDescriptorFromSet(desc_test.GetDescriptorSet())
+	ok(t, err)
+	eq(t, SourceSpan{ StartLine: 1, StartColumn: 1, EndLine: 100, EndColumn: 2 }, fd.GetSourceSpan())
+
+	md := fd.FindMessage("desc_test.TestMessage")
+	eq(t, SourceSpan{ StartLine: 8, StartColumn: 1, EndLine: 64, EndColumn: 2 }, md.GetSourceSpan())
+	eq(t, "8:1-64:2", md.GetSourceSpan().String())
+
+	// span on a single line, indented with a tab
+	fld := fd.FindMessage("desc_test.AnotherTestMessage").FindFieldByName("dne")
+	eq(t, SourceSpan{ StartLine: 69, StartColumn: 9, EndLine: 69, EndColumn: 114 }, fld.GetSourceSpan())
+
+	// no source info
+	md, err = LoadMessageDescriptor("desc_test.TestMessage")
+	ok(t, err)
+	eq(t, false, md.GetSourceSpan().IsValid())
+	eq(t, "?", md.GetSourceSpan().String())
+	eq(t, "", md.GetLeadingComments())
+	eq(t, 0, len(md.GetLeadingDetachedComments()))
+}
+
+func TestSourcePaths(t *testing.T) {
+	fd, err := CreateFileDescriptorFromSet(desc_test.GetDescriptorSet())
+	ok(t, err)
+	eq(t, 0, len(fd.GetSourcePath()))
+	locs := map[string]*dpb.SourceCodeInfo_Location{}
+	for _, loc := range fd.AsFileDescriptorProto().GetSourceCodeInfo().GetLocation() {
+		locs[pathAsKey(loc.Path)] = loc
+	}
+	for sym, d := range fd.symbols {
+		// synthesized map entry messages have no location, so loc may be nil
+		loc := locs[pathAsKey(d.GetSourcePath())]
+		eq(t, d.GetSourceInfo(), loc, "%s: wrong location for path %v", sym, d.GetSourcePath())
+		eq(t, d, fd.FindDescriptorByPath(d.GetSourcePath()), "%s", sym)
+	}
+
+	eq(t, "[4 0 2 0]", fmt.Sprint(fd.FindMessage("desc_test.TestMessage").GetFields()[0].GetSourcePath()))
+	// paths to parts of an element find the element
+	eq(t, "desc_test.TestMessage.nm", fd.FindDescriptorByPath([]int32{ 4, 0, 2, 0, 1 }).GetFullyQualifiedName())
+	eq(t, nil, fd.FindDescriptorByPath([]int32{ file_packageTag }))
+	eq(t, nil, fd.FindDescriptorByPath(nil))
+	eq(t, nil, fd.FindDescriptorByPath([]int32{ 4, 100 }))
+
+	// paths are available without source info
+	md, err := LoadMessageDescriptor("desc_test.TestMessage.NestedMessage")
+	ok(t, err)
+	eq(t, "[4 0 3 0]", fmt.Sprint(md.GetSourcePath()))
+	eq(t, md, md.GetFile().FindDescriptorByPath(md.GetSourcePath()))
+}
+
+func TestFindDescriptorAtPosition(t *testing.T) {
+	fd, err := CreateFileDescriptorFromSet(desc_test.GetDescriptorSet())
+	ok(t, err)
+	eq(t, "desc_test.AnotherTestMessage.dne", fd.FindDescriptorAtPosition(69, 20).GetFullyQualifiedName())
+	eq(t, "desc_test.AnotherTestMessage.dne", fd.FindDescriptorAtPosition(69, 9).GetFullyQualifiedName())
+	// the end of the span is exclusive
+	eq(t, "desc_test.AnotherTestMessage", fd.FindDescriptorAtPosition(69, 114).GetFullyQualifiedName())
+	eq(t, "desc_test.TestMessage", fd.FindDescriptorAtPosition(8, 1).GetFullyQualifiedName())
+	eq(t, nil, fd.FindDescriptorAtPosition(1, 1))
+
+	// no source info
+	fd, err = LoadFileDescriptor("desc_test1.proto")
+	ok(t, err)
+	eq(t, nil, fd.FindDescriptorAtPosition(69, 20))
+}
+
+func TestFieldDefaultValue(t *testing.T) {
+	fld := func(name string, num int32, typ dpb.FieldDescriptorProto_Type, def string) *dpb.FieldDescriptorProto {
+		f := &dpb.FieldDescriptorProto{
+			Name: proto.String(name),
+			Number: proto.Int32(num),
+			Label: dpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type: typ.Enum(),
+		}
+		if def != "" {
+			f.DefaultValue = proto.String(def)
+		}
+		return f
+	}
+	enumFld := fld("e", 12, dpb.FieldDescriptorProto_TYPE_ENUM, "BAR")
+	enumFld.TypeName = proto.String(".foo.Kind")
+	zeroEnumFld := fld("ze", 13, dpb.FieldDescriptorProto_TYPE_ENUM, "")
+	zeroEnumFld.TypeName = proto.String(".foo.Kind")
+	msgFld := fld("m", 14, dpb.FieldDescriptorProto_TYPE_MESSAGE, "")
+	msgFld.TypeName = proto.String(".foo.Msg")
+	repFld := fld("r", 15, dpb.FieldDescriptorProto_TYPE_INT32, "")
+	repFld.Label = dpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	fd := createDesc(t, &dpb.FileDescriptorProto{
+		Name: proto.String("foo.proto"),
+		Package: proto.String("foo"),
+		EnumType: []*dpb.EnumDescriptorProto{
+			{
+				Name: proto.String("Kind"),
+				Value: []*dpb.EnumValueDescriptorProto{
+					{ Name: proto.String("FOO"), Number: proto.Int32(1) },
+					{ Name: proto.String("BAR"), Number: proto.Int32(2) },
+				},
+			},
+		},
+		MessageType: []*dpb.DescriptorProto{
+			{
+				Name: proto.String("Msg"),
+				Field: []*dpb.FieldDescriptorProto{
+					fld("i32", 1, dpb.FieldDescriptorProto_TYPE_SINT32, "-123"),
+					fld("i64", 2, dpb.FieldDescriptorProto_TYPE_INT64, "-9000000000"),
+					fld("u32", 3, dpb.FieldDescriptorProto_TYPE_FIXED32, "4000000000"),
+					fld("u64", 4, dpb.FieldDescriptorProto_TYPE_UINT64, "18000000000000000000"),
+					fld("f", 5, dpb.FieldDescriptorProto_TYPE_FLOAT, "-inf"),
+					fld("d", 6, dpb.FieldDescriptorProto_TYPE_DOUBLE, "nan"),
+					fld("d2", 7, dpb.FieldDescriptorProto_TYPE_DOUBLE, "1.5e10"),
+					fld("b", 8, dpb.FieldDescriptorProto_TYPE_BOOL, "true"),
+					fld("s", 9, dpb.FieldDescriptorProto_TYPE_STRING, "a\\nb"),
+					fld("by", 10, dpb.FieldDescriptorProto_TYPE_BYTES, "\\000\\x01\\377\\\"q\\n"),
+					fld("zs", 11, dpb.FieldDescriptorProto_TYPE_STRING, ""),
+					enumFld,
+					zeroEnumFld,
+					msgFld,
+					repFld,
+				},
+			},
+		},
+	})
+	md := fd.FindMessage("foo.Msg")
+	eq(t, int32(-123), md.FindFieldByName("i32").GetDefaultValue())
+	eq(t, int64(-9000000000), md.FindFieldByName("i64").GetDefaultValue())
+	eq(t, uint32(4000000000), md.FindFieldByName("u32").GetDefaultValue())
+	eq(t, uint64(18000000000000000000), md.FindFieldByName("u64").GetDefaultValue())
+	eq(t, float32(math.Inf(-1)), md.FindFieldByName("f").GetDefaultValue())
+	eq(t, true, math.IsNaN(md.FindFieldByName("d").GetDefaultValue().(float64)))
+	eq(t, 1.5e10, md.FindFieldByName("d2").GetDefaultValue())
+	eq(t, true, md.FindFieldByName("b").GetDefaultValue())
+	// string defaults are not escaped
+	eq(t, "a\\nb", md.FindFieldByName("s").GetDefaultValue())
+	eq(t, true, reflect.DeepEqual([]byte{0, 1, 255, '"', 'q', '\n'}, md.FindFieldByName("by").GetDefaultValue()))
+	eq(t, "", md.FindFieldByName("zs").GetDefaultValue())
+	eq(t, fd.FindEnum("foo.Kind").GetValues()[1], md.FindFieldByName("e").GetDefaultValue())
+	eq(t, fd.FindEnum("foo.Kind").GetValues()[0], md.FindFieldByName("ze").GetDefaultValue())
+	eq(t, nil, md.FindFieldByName("m").GetDefaultValue())
+	eq(t, nil, md.FindFieldByName("r").GetDefaultValue())
+
+	// invalid defaults are reported when the descriptor is created
+	for _, f := range []*dpb.FieldDescriptorProto{
+		fld("x", 1, dpb.FieldDescriptorProto_TYPE_INT32, "abc"),
+		fld("x", 1, dpb.FieldDescriptorProto_TYPE_UINT32, "-1"),
+		fld("x", 1, dpb.FieldDescriptorProto_TYPE_BYTES, "\\q"),
+		fld("x", 1, dpb.FieldDescriptorProto_TYPE_MESSAGE, "abc"),
+	} {
+		if f.GetType() == dpb.FieldDescriptorProto_TYPE_MESSAGE {
+			f.TypeName = proto.String(".Bar")
+		}
+		_, err := CreateFileDescriptor(&dpb.FileDescriptorProto{
+			Name: proto.String("bar.proto"),
+			MessageType: []*dpb.DescriptorProto{{ Name: proto.String("Bar"), Field: []*dpb.FieldDescriptorProto{ f } }},
+		})
+		eq(t, true, err != nil, "field of type %v with default %q", f.GetType(), f.GetDefaultValue())
+	}
+}
+
+func TestUnescapeBytes(t *testing.T) {
+	b, err := UnescapeBytes(`a\tb\x7Fc\0\101\'\"\?\\`)
+	ok(t, err)
+	eq(t, "a\tb\x7fc\x00A'\"?\\", string(b))
+	for _, s := range []string{`\`, `\q`, `\x`, `\400`} {
+		_, err := UnescapeBytes(s)
+		eq(t, true, err != nil, s)
+	}
+}
+
+func TestEnumValueLookups(t *testing.T) {
+	fd := createDesc(t, &dpb.FileDescriptorProto{
+		Name: proto.String("foo.proto"),
+		Package: proto.String("foo"),
+		EnumType: []*dpb.EnumDescriptorProto{
+			{
+				Name: proto.String("Kind"),
+				Options: &dpb.EnumOptions{ AllowAlias: proto.Bool(true) },
+				Value: []*dpb.EnumValueDescriptorProto{
+					{ Name: proto.String("FOO"), Number: proto.Int32(1) },
+					{ Name: proto.String("BAR"), Number: proto.Int32(2) },
+					{ Name: proto.String("BAZ"), Number: proto.Int32(1) },
+				},
+			},
+		},
+	})
+	ed := fd.FindEnum("foo.Kind")
+	vals := ed.GetValues()
+	eq(t, true, ed.AllowsAlias())
+	eq(t, false, ed.IsOpen())
+	eq(t, vals[0], ed.FindValueByName("FOO"))
+	eq(t, vals[2], ed.FindValueByName("BAZ"))
+	eq(t, (*EnumValueDescriptor)(nil), ed.FindValueByName("foo.FOO"))
+	eq(t, (*EnumValueDescriptor)(nil), ed.FindValueByName("QUUX"))
+	// first declared value wins
+	eq(t, vals[0], ed.FindValueByNumber(1))
+	eq(t, vals[1], ed.FindValueByNumber(2))
+	eq(t, (*EnumValueDescriptor)(nil), ed.FindValueByNumber(3))
+	aliases := ed.FindValuesByNumber(1)
+	eq(t, 2, len(aliases))
+	eq(t, vals[0], aliases[0])
+	eq(t, vals[2], aliases[1])
+	eq(t, 0, len(ed.FindValuesByNumber(3)))
+
+	fd, err := LoadFileDescriptor("desc_test_proto3.proto")
+	ok(t, err)
+	ed = fd.FindEnum("desc_test.Proto3Enum")
+	eq(t, true, ed.IsOpen())
+	eq(t, false, ed.AllowsAlias())
+	eq(t, "UNKNOWN", ed.FindValueByNumber(0).GetName())
+}
+
+func TestCreateFileDescriptorStrict(t *testing.T) {
+	// descriptors produced by protoc are valid
+	_, err := CreateFileDescriptorFromSetStrict(desc_test.GetDescriptorSet())
+	ok(t, err)
+
+	fld := func(name string, num int32) *dpb.FieldDescriptorProto {
+		return &dpb.FieldDescriptorProto{
+			Name: proto.String(name),
+			Number: proto.Int32(num),
+			Label: dpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type: dpb.FieldDescriptorProto_TYPE_INT32.Enum(),
+		}
+	}
+	msg := func(flds ...*dpb.FieldDescriptorProto) *dpb.DescriptorProto {
+		return &dpb.DescriptorProto{ Name: proto.String("Foo"), Field: flds }
+	}
+	testCases := []struct{
+		name     string
+		syntax   string
+		msg      *dpb.DescriptorProto
+		enum     *dpb.EnumDescriptorProto
+		ext      *dpb.FieldDescriptorProto
+		problem  string
+	}{
+		{ name: "duplicate number", msg: msg(fld("a", 1), fld("b", 1)), problem: "foo.Foo.b: field number 1 is already used by a" },
+		{ name: "number out of range", msg: msg(fld("a", 0)), problem: "foo.Foo.a: field number 0 is out of range" },
+		{ name: "implementation reserved", msg: msg(fld("a", 19001)), problem: "foo.Foo.a: field number 19001 is in the range 19000 to 19999" },
+		{
+			name: "reserved range",
+			msg: &dpb.DescriptorProto{
+				Name: proto.String("Foo"),
+				Field: []*dpb.FieldDescriptorProto{ fld("a", 10) },
+				ReservedRange: []*dpb.DescriptorProto_ReservedRange{ { Start: proto.Int32(5), End: proto.Int32(11) } },
+			},
+			problem: "foo.Foo.a: field number 10 is reserved",
+		},
+		{
+			name: "reserved name",
+			msg: &dpb.DescriptorProto{
+				Name: proto.String("Foo"),
+				Field: []*dpb.FieldDescriptorProto{ fld("a", 10) },
+				ReservedName: []string{ "a" },
+			},
+			problem: "foo.Foo.a: field name a is reserved",
+		},
+		{
+			name: "extension range",
+			msg: &dpb.DescriptorProto{
+				Name: proto.String("Foo"),
+				Field: []*dpb.FieldDescriptorProto{ fld("a", 100) },
+				ExtensionRange: []*dpb.DescriptorProto_ExtensionRange{ { Start: proto.Int32(100), End: proto.Int32(200) } },
+			},
+			problem: "foo.Foo.a: field number 100 is in an extension range",
+		},
+		{
+			name: "extension outside ranges",
+			msg: &dpb.DescriptorProto{
+				Name: proto.String("Foo"),
+				ExtensionRange: []*dpb.DescriptorProto_ExtensionRange{ { Start: proto.Int32(100), End: proto.Int32(200) } },
+			},
+			ext: &dpb.FieldDescriptorProto{
+				Name: proto.String("x"),
+				Number: proto.Int32(200),
+				Label: dpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type: dpb.FieldDescriptorProto_TYPE_INT32.Enum(),
+				Extendee: proto.String(".foo.Foo"),
+			},
+			problem: "foo.x: field number 200 is not in an extension range of foo.Foo",
+		},
+		{ name: "json name conflict", msg: msg(fld("foo_bar", 1), fld("fooBar", 2)), problem: `foo.Foo.fooBar: JSON name "fooBar" conflicts with field foo_bar` },
+		{
+			name: "proto3 enum",
+			syntax: "proto3",
+			enum: &dpb.EnumDescriptorProto{
+				Name: proto.String("Kind"),
+				Value: []*dpb.EnumValueDescriptorProto{ { Name: proto.String("FOO"), Number: proto.Int32(1) } },
+			},
+			problem: "foo.Kind: first value of an enum in proto3 must be zero",
+		},
+		{
+			name: "enum alias",
+			enum: &dpb.EnumDescriptorProto{
+				Name: proto.String("Kind"),
+				Value: []*dpb.EnumValueDescriptorProto{
+					{ Name: proto.String("FOO"), Number: proto.Int32(1) },
+					{ Name: proto.String("BAR"), Number: proto.Int32(1) },
+				},
+			},
+			problem: "foo.Kind.BAR: number 1 is already used by FOO",
+		},
+		{
+			name: "proto3 required",
+			syntax: "proto3",
+			msg: msg(&dpb.FieldDescriptorProto{
+				Name: proto.String("a"),
+				Number: proto.Int32(1),
+				Label: dpb.FieldDescriptorProto_LABEL_REQUIRED.Enum(),
+				Type: dpb.FieldDescriptorProto_TYPE_INT32.Enum(),
+			}),
+			problem: "foo.Foo.a: required fields are not allowed in proto3",
+		},
+		{
+			name: "map entry key type",
+			msg: &dpb.DescriptorProto{
+				Name: proto.String("Foo"),
+				Field: []*dpb.FieldDescriptorProto{
+					{
+						Name: proto.String("things"),
+						Number: proto.Int32(1),
+						Label: dpb.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+						Type: dpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+						TypeName: proto.String(".foo.Foo.ThingsEntry"),
+					},
+				},
+				NestedType: []*dpb.DescriptorProto{
+					{
+						Name: proto.String("ThingsEntry"),
+						Options: &dpb.MessageOptions{ MapEntry: proto.Bool(true) },
+						Field: []*dpb.FieldDescriptorProto{
+							{
+								Name: proto.String("key"),
+								Number: proto.Int32(1),
+								Label: dpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+								Type: dpb.FieldDescriptorProto_TYPE_DOUBLE.Enum(),
+							},
+							fld("value", 2),
+						},
+					},
+				},
+			},
+			problem: "foo.Foo.ThingsEntry.key: map key cannot have type TYPE_DOUBLE",
+		},
+		{
+			name: "map entry fields",
+			msg: &dpb.DescriptorProto{
+				Name: proto.String("Foo"),
+				Field: []*dpb.FieldDescriptorProto{
+					{
+						Name: proto.String("stuff"),
+						Number: proto.Int32(1),
+						Label: dpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+						Type: dpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+						TypeName: proto.String(".foo.Foo.ThingsEntry"),
+					},
+				},
+				NestedType: []*dpb.DescriptorProto{
+					{
+						Name: proto.String("ThingsEntry"),
+						Options: &dpb.MessageOptions{ MapEntry: proto.Bool(true) },
+						Field: []*dpb.FieldDescriptorProto{ fld("key", 1) },
+					},
+				},
+			},
+			problem: "foo.Foo.ThingsEntry: map entry must have exactly two fields",
+		},
+	}
+	for _, tc := range testCases {
+		fdp := &dpb.FileDescriptorProto{
+			Name: proto.String("foo.proto"),
+			Package: proto.String("foo"),
+		}
+		if tc.syntax != "" {
+			fdp.Syntax = proto.String(tc.syntax)
+		}
+		if tc.msg != nil {
+			fdp.MessageType = []*dpb.DescriptorProto{ tc.msg }
+		}
+		if tc.enum != nil {
+			fdp.EnumType = []*dpb.EnumDescriptorProto{ tc.enum }
+		}
+		if tc.ext != nil {
+			fdp.Extension = []*dpb.FieldDescriptorProto{ tc.ext }
+		}
+		// without strict validation, the descriptor can be created
+		_, err := CreateFileDescriptor(fdp)
+		ok(t, err, tc.name)
+		_, err = CreateFileDescriptorStrict(fdp)
+		if verr, isValidation := err.(*ValidationError); eq(t, true, isValidation, tc.name) {
+			eq(t, "foo.proto", verr.File, tc.name)
+			found := false
+			for _, p := range verr.Problems {
+				if strings.HasPrefix(p, tc.problem) {
+					found = true
+				}
+			}
+			eq(t, true, found, "%s: expecting problem %q, got %v", tc.name, tc.problem, verr.Problems)
+		}
+	}
+}
+
+func TestCreateFileDescriptorWrongKindOfType(t *testing.T) {
+	_, err := CreateFileDescriptor(&dpb.FileDescriptorProto{
+		Name: proto.String("foo.proto"),
+		Package: proto.String("foo"),
+		MessageType: []*dpb.DescriptorProto{ { Name: proto.String("Foo") } },
+		EnumType: []*dpb.EnumDescriptorProto{
+			{ Name: proto.String("Kind"), Value: []*dpb.EnumValueDescriptorProto{ { Name: proto.String("FOO"), Number: proto.Int32(0) } } },
+		},
+		Service: []*dpb.ServiceDescriptorProto{
+			{
+				Name: proto.String("Svc"),
+				Method: []*dpb.MethodDescriptorProto{
+					{ Name: proto.String("Do"), InputType: proto.String(".foo.Kind"), OutputType: proto.String(".foo.Foo") },
+				},
+			},
+		},
+	})
+	eq(t, `File "foo.proto": input type of foo.Svc.Do refers to ".foo.Kind", which is not a message`, fmt.Sprintf("%v", err))
+}
+
+func TestReservedRangesAndNames(t *testing.T) {
+	fd := createDesc(t, &dpb.FileDescriptorProto{
+		Name: proto.String("foo.proto"),
+		Package: proto.String("foo"),
+		MessageType: []*dpb.DescriptorProto{
+			{
+				Name: proto.String("Foo"),
+				// declared out of order; end is exclusive
+				ReservedRange: []*dpb.DescriptorProto_ReservedRange{
+					{ Start: proto.Int32(100), End: proto.Int32(201) },
+					{ Start: proto.Int32(5), End: proto.Int32(6) },
+				},
+				ReservedName: []string{"foo", "bar"},
+			},
+		},
+		EnumType: []*dpb.EnumDescriptorProto{
+			{
+				Name: proto.String("Kind"),
+				Value: []*dpb.EnumValueDescriptorProto{ { Name: proto.String("FOO"), Number: proto.Int32(0) } },
+				// end is inclusive
+				ReservedRange: []*dpb.EnumDescriptorProto_EnumReservedRange{
+					{ Start: proto.Int32(-10), End: proto.Int32(-1) },
+					{ Start: proto.Int32(3), End: proto.Int32(3) },
+				},
+				ReservedName: []string{"BAR"},
+			},
+		},
+	})
+
+	md := fd.FindMessage("foo.Foo")
+	eq(t, 2, len(md.GetReservedRanges()))
+	eq(t, ReservedRange{ Start: 5, End: 5 }, md.GetReservedRanges()[0])
+	eq(t, ReservedRange{ Start: 100, End: 200 }, md.GetReservedRanges()[1])
+	eq(t, false, md.IsReservedNumber(4))
+	eq(t, true, md.IsReservedNumber(5))
+	eq(t, false, md.IsReservedNumber(6))
+	eq(t, false, md.IsReservedNumber(99))
+	eq(t, true, md.IsReservedNumber(100))
+	eq(t, true, md.IsReservedNumber(200))
+	eq(t, false, md.IsReservedNumber(201))
+	eq(t, 2, len(md.GetReservedNames()))
+	eq(t, true, md.IsReservedName("foo"))
+	eq(t, true, md.IsReservedName("bar"))
+	eq(t, false, md.IsReservedName("baz"))
+
+	ed := fd.FindEnum("foo.Kind")
+	eq(t, 2, len(ed.GetReservedRanges()))
+	eq(t, ReservedRange{ Start: -10, End: -1 }, ed.GetReservedRanges()[0])
+	eq(t, ReservedRange{ Start: 3, End: 3 }, ed.GetReservedRanges()[1])
+	eq(t, true, ed.IsReservedNumber(-10))
+	eq(t, true, ed.IsReservedNumber(-1))
+	eq(t, false, ed.IsReservedNumber(0))
+	eq(t, true, ed.IsReservedNumber(3))
+	eq(t, false, ed.IsReservedNumber(4))
+	eq(t, true, ed.IsReservedName("BAR"))
+	eq(t, false, ed.IsReservedName("FOO"))
+
+	// no reserved ranges or names
+	md, err := LoadMessageDescriptor("desc_test.TestMessage")
+	ok(t, err)
+	eq(t, 0, len(md.GetReservedRanges()))
+	eq(t, false, md.IsReservedNumber(1))
+	eq(t, false, md.IsReservedName("nm"))
+}
+
+func TestCreateFileDescriptorsFromSet(t *testing.T) {
+	file := func(name string, deps ...string) *dpb.FileDescriptorProto {
+		return &dpb.FileDescriptorProto{ Name: proto.String(name), Dependency: deps }
+	}
+	// d is imported by both b and c
+	fds := &dpb.FileDescriptorSet{
+		File: []*dpb.FileDescriptorProto{
+			file("a.proto", "b.proto", "c.proto"),
+			file("b.proto", "d.proto"),
+			file("c.proto", "d.proto"),
+			file("d.proto"),
+		},
+	}
+	files, err := CreateFileDescriptorsFromSet(fds)
+	ok(t, err)
+	eq(t, 4, len(files))
+	for name, fd := range files {
+		eq(t, name, fd.GetName())
+	}
+	a := files["a.proto"]
+	eq(t, files["b.proto"], a.GetDependencies()[0])
+	eq(t, files["c.proto"], a.GetDependencies()[1])
+	// shared dependencies are only created once
+	eq(t, files["d.proto"], files["b.proto"].GetDependencies()[0])
+	eq(t, files["d.proto"], files["c.proto"].GetDependencies()[0])
+
+	files, err = CreateFileDescriptorsFromSetStrict(desc_test.GetDescriptorSet())
+	ok(t, err)
+	eq(t, len(desc_test.GetDescriptorSet().GetFile()), len(files))
+	eq(t, true, files["desc_test1.proto"].FindMessage("desc_test.TestMessage") != nil)
+
+	// missing dependency
+	fds.File = fds.File[:3]
+	_, err = CreateFileDescriptorsFromSet(fds)
+	eq(t, "file descriptor set missing a dependency: d.proto", err.Error())
+
+	// import cycle
+	fds.File = append(fds.File, file("d.proto", "a.proto"))
+	_, err = CreateFileDescriptorsFromSet(fds)
+	eq(t, `cycle found in imports: "a.proto" -> "b.proto" -> "d.proto" -> "a.proto"`, err.Error())
+	_, err = CreateFileDescriptorFromSet(fds)
+	eq(t, `cycle found in imports: "a.proto" -> "b.proto" -> "d.proto" -> "a.proto"`, err.Error())
+}
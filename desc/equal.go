@@ -0,0 +1,279 @@
+package desc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"reflect"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// Equal returns true if the two given descriptors describe the same element,
+// including all of its children and its source code info. The two must be the
+// same kind of element, with the same fully-qualified name, and their
+// underlying descriptor protos must be equal, except for the order in which
+// things are declared when that order has no effect on the schema. So Equal
+// ignores order in the same way as Fingerprint: two elements that differ only
+// in that order are equal.
+//
+// Source code info is compared per element, relative to the element, so it is
+// also unaffected by declaration order. Like the rest of the element, the
+// source code info of an element's children is included.
+//
+// Only the elements themselves are compared; the types they refer to are
+// compared by name. So two files are equal if their protos are equal, even if
+// the files they import differ.
+func Equal(a, b Descriptor) bool {
+	return equal(a, b, true)
+}
+
+// EqualIgnoringSourceInfo is like Equal, except that source code info, which
+// includes comments and the positions of elements in the source file, is not
+// compared. Two elements are equal, ignoring source info, if and only if they
+// have the same fingerprint (see Fingerprint).
+func EqualIgnoringSourceInfo(a, b Descriptor) bool {
+	return equal(a, b, false)
+}
+
+func equal(a, b Descriptor, includeSourceInfo bool) bool {
+	if a == b {
+		return true
+	}
+	if a == nil || b == nil || reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return false
+	}
+	if !bytes.Equal(canonical(a), canonical(b)) {
+		return false
+	}
+	if !includeSourceInfo {
+		return true
+	}
+	return bytes.Equal(canonicalSourceInfo(a), canonicalSourceInfo(b))
+}
+
+// canonicalSourceInfo returns the source locations for the given element and
+// all of its children in a form that does not depend on the order in which
+// elements are declared. Each location is attributed to the innermost element
+// that contains it and identified by that element's name and the location's
+// path relative to it. The results are sorted.
+func canonicalSourceInfo(d Descriptor) []byte {
+	prefix := d.GetSourcePath()
+	elements := map[string]string{pathKey(prefix): d.GetFullyQualifiedName()}
+	Walk(d.GetFile(), func(el Descriptor, path []int32) error {
+		if !isPathPrefix(prefix, path) {
+			return SkipChildren
+		}
+		elements[pathKey(path)] = el.GetFullyQualifiedName()
+		return nil
+	})
+	fd, _ := d.(*FileDescriptor)
+
+	var locs [][]byte
+	for _, loc := range sourceLocationsWithin(d) {
+		// find the innermost element that contains the location
+		n := len(loc.Path)
+		for ; n > len(prefix); n-- {
+			if _, ok := elements[pathKey(loc.Path[:n])]; ok {
+				break
+			}
+		}
+		var buf bytes.Buffer
+		writeCanonicalBytes(&buf, []byte(elements[pathKey(loc.Path[:n])]))
+		rel := loc.Path[n:]
+		if fd != nil && len(rel) >= 2 && rel[0] == file_dependencyTag && int(rel[1]) < len(fd.proto.Dependency) {
+			// imports are identified by name, not by index
+			writeCanonicalBytes(&buf, []byte(fd.proto.Dependency[rel[1]]))
+			rel = rel[2:]
+		}
+		binary.Write(&buf, binary.BigEndian, uint32(len(rel)))
+		binary.Write(&buf, binary.BigEndian, rel)
+		loc = proto.Clone(loc).(*dpb.SourceCodeInfo_Location)
+		loc.Path = nil
+		writeCanonicalProto(&buf, loc)
+		locs = append(locs, buf.Bytes())
+	}
+	var buf bytes.Buffer
+	writeCanonicalGroup(&buf, locs)
+	return buf.Bytes()
+}
+
+// sourceLocationsWithin returns the source locations for the given element and
+// all of its children.
+func sourceLocationsWithin(d Descriptor) []*dpb.SourceCodeInfo_Location {
+	path := d.GetSourcePath()
+	var locs []*dpb.SourceCodeInfo_Location
+	for _, loc := range d.GetFile().proto.GetSourceCodeInfo().GetLocation() {
+		if isPathPrefix(path, loc.Path) {
+			locs = append(locs, loc)
+		}
+	}
+	return locs
+}
+
+func pathKey(path []int32) string {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, path)
+	return buf.String()
+}
+
+// Fingerprint computes a hash of the given element's contents, including all
+// of its children, as a hexadecimal string. Two elements with the same
+// fingerprint describe the same schema, so fingerprints can be used to detect
+// when a schema has changed without comparing the descriptors themselves.
+//
+// Source code info is not included. Neither is the order in which things are
+// declared when that order has no effect on the schema: the order of the
+// elements in a file, of the fields, one-ofs, and nested elements in a message,
+// of the methods in a service, and of a file's imports. The order of the values
+// in an enum is included only to the extent that the first value is the
+// enum's default. EqualIgnoringSourceInfo ignores the same things, so two
+// elements have the same fingerprint if and only if they are equal, ignoring
+// source info.
+//
+// Like Equal, types that the element refers to are included only by name.
+func Fingerprint(d Descriptor) string {
+	sum := sha256.Sum256(canonical(d))
+	return hex.EncodeToString(sum[:])
+}
+
+// canonical returns a serialized form of the given element and all of its
+// children that does not depend on the order in which things are declared
+// when that order has no effect on the schema. Source code info is not
+// included.
+func canonical(d Descriptor) []byte {
+	switch d := d.(type) {
+	case *FileDescriptor:
+		fdp := proto.Clone(d.proto).(*dpb.FileDescriptorProto)
+		fdp.MessageType, fdp.EnumType, fdp.Extension, fdp.Service = nil, nil, nil, nil
+		fdp.Dependency, fdp.PublicDependency, fdp.WeakDependency = nil, nil, nil
+		fdp.SourceCodeInfo = nil
+		// imports are identified by name, not by index, so they can be sorted
+		var imports [][]byte
+		for i, dep := range d.proto.Dependency {
+			kind := "import"
+			if isDepIndex(d.proto.PublicDependency, i) {
+				kind = "public import"
+			} else if isDepIndex(d.proto.WeakDependency, i) {
+				kind = "weak import"
+			}
+			imports = append(imports, []byte(kind+" "+dep))
+		}
+		return canonicalOf("file", d, fdp, imports,
+			canonicalAll(d.messages), canonicalAll(d.enums), canonicalAll(d.extensions), canonicalAll(d.services))
+	case *MessageDescriptor:
+		mdp := proto.Clone(d.proto).(*dpb.DescriptorProto)
+		mdp.Field, mdp.NestedType, mdp.EnumType, mdp.Extension, mdp.OneofDecl = nil, nil, nil, nil, nil
+		return canonicalOf("message", d, mdp,
+			canonicalAll(d.fields), canonicalAll(d.oneOfs), canonicalAll(d.nested), canonicalAll(d.enums), canonicalAll(d.extensions))
+	case *FieldDescriptor:
+		fldp := proto.Clone(d.proto).(*dpb.FieldDescriptorProto)
+		// one-ofs are identified by name, not by index, since their order does
+		// not matter
+		fldp.OneofIndex = nil
+		var oneOf [][]byte
+		if d.oneOf != nil {
+			oneOf = [][]byte{[]byte(d.oneOf.GetName())}
+		}
+		return canonicalOf("field", d, fldp, oneOf)
+	case *OneOfDescriptor:
+		return canonicalOf("oneof", d, d.proto)
+	case *EnumDescriptor:
+		edp := proto.Clone(d.proto).(*dpb.EnumDescriptorProto)
+		edp.Value = nil
+		var first [][]byte
+		if len(d.values) > 0 {
+			first = [][]byte{[]byte(d.values[0].GetName())}
+		}
+		return canonicalOf("enum", d, edp, first, canonicalAll(d.values))
+	case *EnumValueDescriptor:
+		return canonicalOf("value", d, d.proto)
+	case *ServiceDescriptor:
+		sdp := proto.Clone(d.proto).(*dpb.ServiceDescriptorProto)
+		sdp.Method = nil
+		return canonicalOf("service", d, sdp, canonicalAll(d.methods))
+	case *MethodDescriptor:
+		return canonicalOf("method", d, d.proto)
+	default:
+		return canonicalOf(reflect.TypeOf(d).String(), d, d.AsProto())
+	}
+}
+
+func isDepIndex(indexes []int32, index int) bool {
+	for _, i := range indexes {
+		if int(i) == index {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalAll computes the canonical forms of all of the given descriptors,
+// which must be a slice of some type that implements Descriptor.
+func canonicalAll(slice interface{}) [][]byte {
+	rv := reflect.ValueOf(slice)
+	all := make([][]byte, rv.Len())
+	for i := range all {
+		all[i] = canonical(rv.Index(i).Interface().(Descriptor))
+	}
+	return all
+}
+
+// canonicalOf computes the canonical form for an element given its kind, its
+// descriptor proto with all children removed, and groups of data about its
+// children. The data in each group is sorted, so the order of the children
+// does not affect the result.
+func canonicalOf(kind string, d Descriptor, self proto.Message, groups ...[][]byte) []byte {
+	var buf bytes.Buffer
+	writeCanonicalBytes(&buf, []byte(kind))
+	writeCanonicalBytes(&buf, []byte(d.GetFullyQualifiedName()))
+	writeCanonicalProto(&buf, self)
+	for _, group := range groups {
+		writeCanonicalGroup(&buf, group)
+	}
+	return buf.Bytes()
+}
+
+func writeCanonicalProto(buf *bytes.Buffer, msg proto.Message) {
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		// shouldn't happen for a descriptor that has been successfully created,
+		// but the text format is a fine deterministic substitute
+		b = []byte(proto.CompactTextString(msg))
+	}
+	writeCanonicalBytes(buf, b)
+}
+
+// writeCanonicalGroup sorts the given data and then writes it, prefixed with
+// the number of items.
+func writeCanonicalGroup(buf *bytes.Buffer, group [][]byte) {
+	sort.Sort(byteSlices(group))
+	binary.Write(buf, binary.BigEndian, uint32(len(group)))
+	for _, data := range group {
+		writeCanonicalBytes(buf, data)
+	}
+}
+
+// writeCanonicalBytes writes the given data, prefixed with its length so that
+// adjacent data cannot be confused.
+func writeCanonicalBytes(buf *bytes.Buffer, data []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	buf.Write(data)
+}
+
+type byteSlices [][]byte
+
+func (s byteSlices) Len() int {
+	return len(s)
+}
+
+func (s byteSlices) Less(i, j int) bool {
+	return bytes.Compare(s[i], s[j]) < 0
+}
+
+func (s byteSlices) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
@@ -0,0 +1,206 @@
+package desc
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+func createEqualTestProto() *dpb.FileDescriptorProto {
+	inOneOf := func(fld *dpb.FieldDescriptorProto, index int32) *dpb.FieldDescriptorProto {
+		fld.OneofIndex = proto.Int32(index)
+		return fld
+	}
+	value := func(name string, num int32) *dpb.EnumValueDescriptorProto {
+		return &dpb.EnumValueDescriptorProto{Name: proto.String(name), Number: proto.Int32(num)}
+	}
+	method := func(name, typ string) *dpb.MethodDescriptorProto {
+		return &dpb.MethodDescriptorProto{Name: proto.String(name), InputType: proto.String(typ), OutputType: proto.String(typ)}
+	}
+	return &dpb.FileDescriptorProto{
+		Name:    proto.String("equal.proto"),
+		Package: proto.String("equal"),
+		MessageType: []*dpb.DescriptorProto{
+			{
+				Name: proto.String("Foo"),
+				Field: []*dpb.FieldDescriptorProto{
+					fieldProto("a", 1, dpb.FieldDescriptorProto_TYPE_INT32, ""),
+					fieldProto("b", 2, dpb.FieldDescriptorProto_TYPE_STRING, ""),
+					inOneOf(fieldProto("c", 3, dpb.FieldDescriptorProto_TYPE_BOOL, ""), 0),
+					inOneOf(fieldProto("d", 4, dpb.FieldDescriptorProto_TYPE_BOOL, ""), 1),
+				},
+				OneofDecl: []*dpb.OneofDescriptorProto{{Name: proto.String("x")}, {Name: proto.String("y")}},
+			},
+			{Name: proto.String("Bar")},
+		},
+		EnumType: []*dpb.EnumDescriptorProto{
+			{Name: proto.String("E"), Value: []*dpb.EnumValueDescriptorProto{value("ZERO", 0), value("ONE", 1), value("TWO", 2)}},
+		},
+		Service: []*dpb.ServiceDescriptorProto{
+			{Name: proto.String("S"), Method: []*dpb.MethodDescriptorProto{method("M1", ".equal.Foo"), method("M2", ".equal.Bar")}},
+		},
+		SourceCodeInfo: &dpb.SourceCodeInfo{
+			Location: []*dpb.SourceCodeInfo_Location{
+				{Path: []int32{4, 0}, Span: []int32{1, 0, 5, 1}, LeadingComments: proto.String(" Foo\n")},
+				{Path: []int32{4, 0, 2, 0}, Span: []int32{2, 2, 12}, LeadingComments: proto.String(" a\n")},
+				{Path: []int32{4, 1}, Span: []int32{6, 0, 14}},
+			},
+		},
+	}
+}
+
+func TestEqual(t *testing.T) {
+	fdp := createEqualTestProto()
+	fd1, err := CreateFileDescriptor(fdp)
+	ok(t, err)
+	fd2, err := CreateFileDescriptor(proto.Clone(fdp).(*dpb.FileDescriptorProto))
+	ok(t, err)
+
+	eq(t, true, Equal(fd1, fd2))
+	eq(t, true, Equal(fd1.FindMessage("equal.Foo"), fd2.FindMessage("equal.Foo")))
+	eq(t, false, Equal(fd1.FindMessage("equal.Foo"), fd2.FindMessage("equal.Bar")))
+	eq(t, false, Equal(fd1, fd2.FindMessage("equal.Foo")))
+	eq(t, false, Equal(fd1.FindMessage("equal.Foo"), nil))
+
+	// only comments differ
+	changed := proto.Clone(fdp).(*dpb.FileDescriptorProto)
+	changed.SourceCodeInfo.Location[1].LeadingComments = proto.String(" A\n")
+	fd2, err = CreateFileDescriptor(changed)
+	ok(t, err)
+	eq(t, false, Equal(fd1, fd2))
+	eq(t, true, EqualIgnoringSourceInfo(fd1, fd2))
+	eq(t, false, Equal(fd1.FindMessage("equal.Foo"), fd2.FindMessage("equal.Foo")))
+	eq(t, true, EqualIgnoringSourceInfo(fd1.FindMessage("equal.Foo"), fd2.FindMessage("equal.Foo")))
+	eq(t, true, Equal(fd1.FindMessage("equal.Bar"), fd2.FindMessage("equal.Bar")))
+	eq(t, true, Equal(fd1.FindSymbol("equal.Foo.b"), fd2.FindSymbol("equal.Foo.b")))
+
+	// an element's source info is compared relative to the element, so it can
+	// be at a different position in its file
+	moved := proto.Clone(fdp).(*dpb.FileDescriptorProto)
+	moved.MessageType = append([]*dpb.DescriptorProto{{Name: proto.String("Baz")}}, moved.MessageType...)
+	for _, loc := range moved.SourceCodeInfo.Location {
+		loc.Path[1]++
+	}
+	fd2, err = CreateFileDescriptor(moved)
+	ok(t, err)
+	eq(t, false, EqualIgnoringSourceInfo(fd1, fd2))
+	eq(t, true, Equal(fd1.FindMessage("equal.Foo"), fd2.FindMessage("equal.Foo")))
+	eq(t, true, Equal(fd1.FindMessage("equal.Bar"), fd2.FindMessage("equal.Bar")))
+
+	// declaration order that doesn't matter, with source info that moves along
+	// with the elements
+	reordered := proto.Clone(fdp).(*dpb.FileDescriptorProto)
+	msgs := reordered.MessageType
+	msgs[0], msgs[1] = msgs[1], msgs[0]
+	flds := msgs[1].Field
+	flds[0], flds[1] = flds[1], flds[0]
+	reordered.SourceCodeInfo.Location = []*dpb.SourceCodeInfo_Location{
+		{Path: []int32{4, 1}, Span: []int32{1, 0, 5, 1}, LeadingComments: proto.String(" Foo\n")},
+		{Path: []int32{4, 1, 2, 1}, Span: []int32{2, 2, 12}, LeadingComments: proto.String(" a\n")},
+		{Path: []int32{4, 0}, Span: []int32{6, 0, 14}},
+	}
+	fd2, err = CreateFileDescriptor(reordered)
+	ok(t, err)
+	eq(t, true, Equal(fd1, fd2))
+	eq(t, true, Equal(fd1.FindMessage("equal.Foo"), fd2.FindMessage("equal.Foo")))
+	// but the source info must still belong to the same elements
+	reordered.SourceCodeInfo.Location[1].Path = []int32{4, 1, 2, 0}
+	fd2, err = CreateFileDescriptor(reordered)
+	ok(t, err)
+	eq(t, false, Equal(fd1, fd2))
+	eq(t, true, EqualIgnoringSourceInfo(fd1, fd2))
+
+	// the definition differs
+	changed = proto.Clone(fdp).(*dpb.FileDescriptorProto)
+	changed.MessageType[0].Field[0].Number = proto.Int32(10)
+	fd2, err = CreateFileDescriptor(changed)
+	ok(t, err)
+	eq(t, false, EqualIgnoringSourceInfo(fd1, fd2))
+	eq(t, false, EqualIgnoringSourceInfo(fd1.FindMessage("equal.Foo"), fd2.FindMessage("equal.Foo")))
+	eq(t, true, EqualIgnoringSourceInfo(fd1.FindEnum("equal.E"), fd2.FindEnum("equal.E")))
+}
+
+func TestFingerprint(t *testing.T) {
+	fdp := createEqualTestProto()
+	fd, err := CreateFileDescriptor(fdp)
+	ok(t, err)
+	fp := Fingerprint(fd)
+	eq(t, 64, len(fp))
+
+	// same contents, without source info
+	same := proto.Clone(fdp).(*dpb.FileDescriptorProto)
+	same.SourceCodeInfo = nil
+	fd2, err := CreateFileDescriptor(same)
+	ok(t, err)
+	eq(t, fp, Fingerprint(fd2))
+
+	// declaration order that doesn't matter
+	reordered := proto.Clone(fdp).(*dpb.FileDescriptorProto)
+	reordered.SourceCodeInfo = nil
+	msgs := reordered.MessageType
+	msgs[0], msgs[1] = msgs[1], msgs[0]
+	foo := msgs[1]
+	foo.Field[0], foo.Field[3] = foo.Field[3], foo.Field[0]
+	foo.OneofDecl[0], foo.OneofDecl[1] = foo.OneofDecl[1], foo.OneofDecl[0]
+	for _, fld := range foo.Field {
+		if fld.OneofIndex != nil {
+			fld.OneofIndex = proto.Int32(1 - fld.GetOneofIndex())
+		}
+	}
+	vals := reordered.EnumType[0].Value
+	vals[1], vals[2] = vals[2], vals[1]
+	mtds := reordered.Service[0].Method
+	mtds[0], mtds[1] = mtds[1], mtds[0]
+	fd2, err = CreateFileDescriptor(reordered)
+	ok(t, err)
+	eq(t, true, EqualIgnoringSourceInfo(fd, fd2))
+	eq(t, fp, Fingerprint(fd2))
+	for _, name := range []string{"equal.Foo", "equal.Bar", "equal.E", "equal.S"} {
+		eq(t, Fingerprint(fd.FindSymbol(name)), Fingerprint(fd2.FindSymbol(name)), name)
+	}
+
+	// changes that do matter
+	changes := map[string]func(*dpb.FileDescriptorProto){
+		"field number": func(f *dpb.FileDescriptorProto) {
+			f.MessageType[0].Field[0].Number = proto.Int32(10)
+		},
+		"field in one-of": func(f *dpb.FileDescriptorProto) {
+			f.MessageType[0].Field[2].OneofIndex = proto.Int32(1)
+		},
+		"message name": func(f *dpb.FileDescriptorProto) {
+			f.MessageType[1].Name = proto.String("Baz")
+			f.Service[0].Method[1].InputType = proto.String(".equal.Baz")
+			f.Service[0].Method[1].OutputType = proto.String(".equal.Baz")
+		},
+		"enum default": func(f *dpb.FileDescriptorProto) {
+			vals := f.EnumType[0].Value
+			vals[0], vals[1] = vals[1], vals[0]
+		},
+		"method type": func(f *dpb.FileDescriptorProto) {
+			f.Service[0].Method[1].InputType = proto.String(".equal.Foo")
+		},
+		"package": func(f *dpb.FileDescriptorProto) {
+			f.Package = proto.String("other")
+			for _, mtd := range f.Service[0].Method {
+				mtd.InputType = proto.String(".other" + mtd.GetInputType()[len(".equal"):])
+				mtd.OutputType = proto.String(".other" + mtd.GetOutputType()[len(".equal"):])
+			}
+		},
+	}
+	for name, change := range changes {
+		changed := proto.Clone(fdp).(*dpb.FileDescriptorProto)
+		change(changed)
+		fd2, err := CreateFileDescriptor(changed)
+		ok(t, err, name)
+		if fp == Fingerprint(fd2) {
+			t.Errorf("%s: fingerprint should have changed", name)
+		}
+	}
+
+	// different elements have different fingerprints, even if their protos are
+	// the same
+	if Fingerprint(fd.FindSymbol("equal.Foo.c")) == Fingerprint(fd.FindSymbol("equal.Foo.d")) {
+		t.Errorf("fields should have different fingerprints")
+	}
+}
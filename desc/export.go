@@ -0,0 +1,55 @@
+package desc
+
+import (
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// ToFileDescriptorSet creates a file descriptor set that contains the given
+// files and all of their transitive dependencies. The files are in topological
+// order: each file appears after all of the files it imports. So the result is
+// self-contained and can be used with CreateFileDescriptorsFromSet or written
+// to a protoset file. Each file appears only once, even if it is given more
+// than once or is imported by more than one file. Files are identified by
+// name, so if two different descriptors have the same name, only the first one
+// encountered is included.
+//
+// The returned set shares the underlying descriptor protos with the given
+// files, so it should not be modified.
+func ToFileDescriptorSet(fds ...*FileDescriptor) *dpb.FileDescriptorSet {
+	return toFileDescriptorSet(fds, false)
+}
+
+// ToFileDescriptorSetWithoutSourceInfo creates a file descriptor set that
+// contains the given files and all of their transitive dependencies, like
+// ToFileDescriptorSet. But the files in the returned set do not include source
+// code info, which can substantially reduce its size. The returned set's
+// descriptor protos are copies, so it can be modified freely.
+func ToFileDescriptorSetWithoutSourceInfo(fds ...*FileDescriptor) *dpb.FileDescriptorSet {
+	return toFileDescriptorSet(fds, true)
+}
+
+func toFileDescriptorSet(fds []*FileDescriptor, stripSourceInfo bool) *dpb.FileDescriptorSet {
+	var files []*dpb.FileDescriptorProto
+	seen := map[string]bool{}
+	var add func(fd *FileDescriptor)
+	add = func(fd *FileDescriptor) {
+		if seen[fd.GetName()] {
+			return
+		}
+		seen[fd.GetName()] = true
+		for _, dep := range fd.deps {
+			add(dep)
+		}
+		fdp := fd.proto
+		if stripSourceInfo {
+			fdp = proto.Clone(fdp).(*dpb.FileDescriptorProto)
+			fdp.SourceCodeInfo = nil
+		}
+		files = append(files, fdp)
+	}
+	for _, fd := range fds {
+		add(fd)
+	}
+	return &dpb.FileDescriptorSet{File: files}
+}
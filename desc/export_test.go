@@ -0,0 +1,50 @@
+package desc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/jhump/protoreflect/desc/desc_test"
+)
+
+func TestToFileDescriptorSet(t *testing.T) {
+	fd2, err := LoadFileDescriptor("desc_test2.proto")
+	ok(t, err)
+	fd3, err := LoadFileDescriptor("desc_test_proto3.proto")
+	ok(t, err)
+	// desc_test1.proto is given explicitly and is also a dependency of both others
+	fd1, err := LoadFileDescriptor("desc_test1.proto")
+	ok(t, err)
+	fds := ToFileDescriptorSet(fd2, fd3, fd1, fd2)
+	var names []string
+	for _, f := range fds.GetFile() {
+		names = append(names, f.GetName())
+	}
+	eq(t, "desc_test1.proto,pkg/desc_test_pkg.proto,nopkg/desc_test_nopkg_new.proto,nopkg/desc_test_nopkg.proto,desc_test2.proto,desc_test_proto3.proto", strings.Join(names, ","))
+	eq(t, fd2.AsFileDescriptorProto(), fds.GetFile()[4])
+
+	// the set can be linked back into descriptors
+	files, err := CreateFileDescriptorsFromSet(fds)
+	ok(t, err)
+	eq(t, 6, len(files))
+	eq(t, true, proto.Equal(fd3.AsProto(), files["desc_test_proto3.proto"].AsProto()))
+}
+
+func TestToFileDescriptorSetWithoutSourceInfo(t *testing.T) {
+	fd, err := CreateFileDescriptorFromSet(desc_test.GetDescriptorSet())
+	ok(t, err)
+	eq(t, true, fd.AsFileDescriptorProto().GetSourceCodeInfo() != nil)
+
+	fds := ToFileDescriptorSet(fd)
+	eq(t, 1, len(fds.GetFile()))
+	eq(t, true, fds.GetFile()[0].GetSourceCodeInfo() != nil)
+
+	fds = ToFileDescriptorSetWithoutSourceInfo(fd)
+	eq(t, 1, len(fds.GetFile()))
+	eq(t, true, fds.GetFile()[0].GetSourceCodeInfo() == nil)
+	// the original is not modified
+	eq(t, true, fd.AsFileDescriptorProto().GetSourceCodeInfo() != nil)
+	eq(t, fd.GetMessageTypes()[0].GetName(), fds.GetFile()[0].GetMessageType()[0].GetName())
+}
@@ -0,0 +1,287 @@
+package desc
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// firstOptionExtensionTag is the smallest tag number that custom options can
+// use. All of the options messages in descriptor.proto declare the extension
+// range 1000 to max.
+const firstOptionExtensionTag = 1000
+
+var errBadEncoding = errors.New("bad encoding")
+
+// GetTransitiveDependencies returns all of the files that this file imports,
+// directly or indirectly. The returned files are in topological order: each
+// file appears after all of the files it imports. The file itself is not
+// included.
+func (fd *FileDescriptor) GetTransitiveDependencies() []*FileDescriptor {
+	var deps []*FileDescriptor
+	seen := map[*FileDescriptor]bool{fd: true}
+	var add func(f *FileDescriptor)
+	add = func(f *FileDescriptor) {
+		for _, dep := range f.deps {
+			if !seen[dep] {
+				seen[dep] = true
+				add(dep)
+				deps = append(deps, dep)
+			}
+		}
+	}
+	add(fd)
+	return deps
+}
+
+// GetUnusedDependencies returns the files imported by this file that it does
+// not use. A file is used if the file refers to an element that it declares:
+// a message or enum that is the type of a field, extension, or method; a
+// message that is extended; or an extension that is used as a custom option
+// on any element in the file.
+//
+// A file is also used if it is needed to see a used element through a chain of
+// public imports. So if this file imports "a.proto", which publicly imports
+// "b.proto", and this file refers to an element declared in "b.proto", then
+// "a.proto" is used. Public imports of this file are never reported as unused,
+// since they are for the benefit of files that import this one.
+func (fd *FileDescriptor) GetUnusedDependencies() []*FileDescriptor {
+	used := fd.usedFiles()
+	var unused []*FileDescriptor
+	for _, dep := range fd.deps {
+		if isPublicDep(fd, dep) {
+			continue
+		}
+		if !providesAny(dep, used, map[*FileDescriptor]bool{}) {
+			unused = append(unused, dep)
+		}
+	}
+	return unused
+}
+
+func isPublicDep(fd, dep *FileDescriptor) bool {
+	for _, pub := range fd.publicDeps {
+		if pub == dep {
+			return true
+		}
+	}
+	return false
+}
+
+// providesAny returns true if any of the given files is the given dependency
+// or is made visible by it via public imports.
+func providesAny(dep *FileDescriptor, files map[*FileDescriptor]bool, seen map[*FileDescriptor]bool) bool {
+	if seen[dep] {
+		return false
+	}
+	seen[dep] = true
+	if files[dep] {
+		return true
+	}
+	for _, pub := range dep.publicDeps {
+		if providesAny(pub, files, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// usedFiles returns the set of other files that declare elements to which this
+// file refers.
+func (fd *FileDescriptor) usedFiles() map[*FileDescriptor]bool {
+	used := map[*FileDescriptor]bool{}
+	use := func(d Descriptor) {
+		if d != nil && d.GetFile() != fd {
+			used[d.GetFile()] = true
+		}
+	}
+	exts := fd.visibleExtensions()
+	useOptions := func(d Descriptor) {
+		for _, exd := range optionExtensions(d, exts) {
+			use(exd)
+		}
+	}
+
+	useOptions(fd)
+	Walk(fd, func(d Descriptor, _ []int32) error {
+		useOptions(d)
+		switch d := d.(type) {
+		case *FieldDescriptor:
+			if d.msgType != nil {
+				use(d.msgType)
+			}
+			if d.enumType != nil {
+				use(d.enumType)
+			}
+			if d.IsExtension() {
+				use(d.owner)
+			}
+		case *MethodDescriptor:
+			use(d.inType)
+			use(d.outType)
+		}
+		return nil
+	})
+	return used
+}
+
+// visibleExtensions returns all extensions declared in this file and its
+// transitive dependencies, keyed by fully-qualified name.
+func (fd *FileDescriptor) visibleExtensions() map[string]*FieldDescriptor {
+	exts := map[string]*FieldDescriptor{}
+	for _, f := range append(fd.GetTransitiveDependencies(), fd) {
+		for sym, d := range f.symbols {
+			if exd, ok := d.(*FieldDescriptor); ok && exd.IsExtension() {
+				exts[sym] = exd
+			}
+		}
+	}
+	return exts
+}
+
+// optionExtensions returns the extensions, among the given ones, that are used
+// as custom options on the given element.
+func optionExtensions(d Descriptor, exts map[string]*FieldDescriptor) []*FieldDescriptor {
+	opts := d.GetOptions()
+	if rv := reflect.ValueOf(opts); rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return nil
+	}
+	extendee := proto.MessageName(opts)
+	var ret []*FieldDescriptor
+	b, err := proto.Marshal(opts)
+	if err != nil {
+		return nil
+	}
+	tags, err := topLevelTags(b)
+	if err != nil {
+		return nil
+	}
+	for _, tag := range tags {
+		if tag < firstOptionExtensionTag {
+			continue
+		}
+		for _, exd := range exts {
+			if exd.GetNumber() == tag && exd.owner.GetFullyQualifiedName() == extendee {
+				ret = append(ret, exd)
+				break
+			}
+		}
+	}
+
+	// also check options that have not been interpreted
+	uninterp, ok := opts.(interface {
+		GetUninterpretedOption() []*dpb.UninterpretedOption
+	})
+	if !ok {
+		return ret
+	}
+	for _, uo := range uninterp.GetUninterpretedOption() {
+		names := uo.GetName()
+		if len(names) == 0 || !names[0].GetIsExtension() {
+			continue
+		}
+		if exd := resolveOptionName(d, names[0].GetNamePart(), exts); exd != nil {
+			ret = append(ret, exd)
+		}
+	}
+	return ret
+}
+
+// resolveOptionName resolves the given extension name, which may be relative
+// to the scope that encloses the given element.
+func resolveOptionName(d Descriptor, name string, exts map[string]*FieldDescriptor) *FieldDescriptor {
+	if strings.HasPrefix(name, ".") {
+		return exts[name[1:]]
+	}
+	scope := d.GetFullyQualifiedName()
+	if _, ok := d.(*FileDescriptor); ok {
+		scope = d.GetFile().GetPackage()
+	} else if pos := strings.LastIndex(scope, "."); pos >= 0 {
+		scope = scope[:pos]
+	} else {
+		scope = ""
+	}
+	for {
+		if exd := exts[merge(scope, name)]; exd != nil {
+			return exd
+		}
+		if scope == "" {
+			return nil
+		}
+		if pos := strings.LastIndex(scope, "."); pos >= 0 {
+			scope = scope[:pos]
+		} else {
+			scope = ""
+		}
+	}
+}
+
+// topLevelTags returns the tag numbers of all fields present in the given
+// encoded message.
+func topLevelTags(b []byte) ([]int32, error) {
+	var tags []int32
+	for len(b) > 0 {
+		tag, n, err := skipField(b)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+		b = b[n:]
+	}
+	return tags, nil
+}
+
+// skipField reads a field from the start of the given bytes, returning its tag
+// number and the length of its encoding. If the field is the end of a group,
+// the returned tag number is negative.
+func skipField(b []byte) (int32, int, error) {
+	key, n := proto.DecodeVarint(b)
+	if n == 0 {
+		return 0, 0, errBadEncoding
+	}
+	tag, wireType := int32(key>>3), key&7
+	var l int
+	switch wireType {
+	case proto.WireVarint:
+		_, l = proto.DecodeVarint(b[n:])
+		if l == 0 {
+			return 0, 0, errBadEncoding
+		}
+	case proto.WireFixed64:
+		l = 8
+	case proto.WireFixed32:
+		l = 4
+	case proto.WireBytes:
+		sz, ln := proto.DecodeVarint(b[n:])
+		if ln == 0 {
+			return 0, 0, errBadEncoding
+		}
+		l = ln + int(sz)
+	case proto.WireStartGroup:
+		for {
+			if n+l > len(b) {
+				return 0, 0, errBadEncoding
+			}
+			nested, ln, err := skipField(b[n+l:])
+			if err != nil {
+				return 0, 0, err
+			}
+			l += ln
+			if nested == -tag {
+				break
+			}
+		}
+	case proto.WireEndGroup:
+		tag = -tag
+	default:
+		return 0, 0, fmt.Errorf("bad wire type: %d", wireType)
+	}
+	if n+l > len(b) {
+		return 0, 0, errBadEncoding
+	}
+	return tag, n + l, nil
+}
@@ -0,0 +1,137 @@
+package desc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+func fileNames(fds []*FileDescriptor) string {
+	names := make([]string, len(fds))
+	for i, fd := range fds {
+		names[i] = fd.GetName()
+	}
+	return strings.Join(names, ",")
+}
+
+func TestGetTransitiveDependencies(t *testing.T) {
+	fd, err := LoadFileDescriptor("desc_test2.proto")
+	ok(t, err)
+	eq(t, "desc_test1.proto,pkg/desc_test_pkg.proto,nopkg/desc_test_nopkg_new.proto,nopkg/desc_test_nopkg.proto", fileNames(fd.GetTransitiveDependencies()))
+
+	fd, err = LoadFileDescriptor("nopkg/desc_test_nopkg_new.proto")
+	ok(t, err)
+	eq(t, 0, len(fd.GetTransitiveDependencies()))
+}
+
+func TestGetUnusedDependencies(t *testing.T) {
+	descFd, err := LoadFileDescriptor("google/protobuf/descriptor.proto")
+	ok(t, err)
+	base, err := CreateFileDescriptor(&dpb.FileDescriptorProto{
+		Name:       proto.String("base.proto"),
+		Package:    proto.String("base"),
+		Dependency: []string{"google/protobuf/descriptor.proto"},
+		Extension: []*dpb.FieldDescriptorProto{
+			{
+				Name:     proto.String("label"),
+				Number:   proto.Int32(50000),
+				Label:    dpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:     dpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+				Extendee: proto.String(".google.protobuf.FieldOptions"),
+			},
+		},
+	}, descFd)
+	ok(t, err)
+	file := func(name string, deps ...*FileDescriptor) *FileDescriptor {
+		var depNames []string
+		for _, dep := range deps {
+			depNames = append(depNames, dep.GetName())
+		}
+		fd, err := CreateFileDescriptor(&dpb.FileDescriptorProto{
+			Name:        proto.String(name),
+			Package:     proto.String(strings.TrimSuffix(name, ".proto")),
+			Dependency:  depNames,
+			MessageType: []*dpb.DescriptorProto{{Name: proto.String("T")}},
+		}, deps...)
+		ok(t, err)
+		return fd
+	}
+	types := file("types.proto")
+	unused := file("unused.proto")
+	reexported := file("reexported.proto")
+	reexport, err := CreateFileDescriptor(&dpb.FileDescriptorProto{
+		Name:             proto.String("reexport.proto"),
+		Dependency:       []string{"types.proto"},
+		PublicDependency: []int32{0},
+	}, types)
+	ok(t, err)
+
+	// the custom option is only present as unrecognized bytes in the options
+	var opts dpb.FieldOptions
+	b := proto.EncodeVarint(50000<<3 | proto.WireBytes)
+	b = append(b, 1, 'x')
+	ok(t, proto.Unmarshal(b, &opts))
+	main, err := CreateFileDescriptor(&dpb.FileDescriptorProto{
+		Name:             proto.String("main.proto"),
+		Package:          proto.String("main"),
+		Dependency:       []string{"google/protobuf/descriptor.proto", "base.proto", "unused.proto", "reexport.proto", "reexported.proto"},
+		PublicDependency: []int32{4},
+		MessageType: []*dpb.DescriptorProto{
+			{
+				Name: proto.String("M"),
+				Field: []*dpb.FieldDescriptorProto{
+					{
+						Name:     proto.String("t"),
+						Number:   proto.Int32(1),
+						Label:    dpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+						Type:     dpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+						TypeName: proto.String(".types.T"),
+						Options:  &opts,
+					},
+				},
+			},
+		},
+	}, descFd, base, unused, reexport, reexported)
+	ok(t, err)
+	// base.proto is used only by the custom option, and reexport.proto only
+	// because it publicly imports types.proto; public imports are never unused
+	eq(t, "google/protobuf/descriptor.proto,unused.proto", fileNames(main.GetUnusedDependencies()))
+
+	// options that have not been interpreted, with relative names
+	other, err := CreateFileDescriptor(&dpb.FileDescriptorProto{
+		Name:       proto.String("base/other.proto"),
+		Package:    proto.String("base.other"),
+		Dependency: []string{"base.proto", "unused.proto"},
+		MessageType: []*dpb.DescriptorProto{
+			{
+				Name: proto.String("M"),
+				Field: []*dpb.FieldDescriptorProto{
+					{
+						Name:   proto.String("s"),
+						Number: proto.Int32(1),
+						Label:  dpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+						Type:   dpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+						Options: &dpb.FieldOptions{
+							UninterpretedOption: []*dpb.UninterpretedOption{
+								{
+									Name:        []*dpb.UninterpretedOption_NamePart{{NamePart: proto.String("label"), IsExtension: proto.Bool(true)}},
+									StringValue: []byte("x"),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}, base, unused)
+	ok(t, err)
+	eq(t, "unused.proto", fileNames(other.GetUnusedDependencies()))
+
+	// files that use all of their imports
+	eq(t, 0, len(base.GetUnusedDependencies()))
+	fd, err := LoadFileDescriptor("desc_test_proto3.proto")
+	ok(t, err)
+	eq(t, 0, len(fd.GetUnusedDependencies()))
+}
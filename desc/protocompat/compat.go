@@ -0,0 +1,442 @@
+// Package protocompat compares two versions of a schema and reports changes
+// that are not backwards-compatible.
+//
+// Each reported change is categorized by the kinds of clients it can break.
+// A wire-breaking change means that data in the binary format written with
+// one version may be misinterpreted (or rejected) by the other. A JSON-breaking
+// change means the same, but for the JSON format. A source-breaking change is
+// one that can break code that was generated from the old version, such as
+// a renamed field or a removed message.
+//
+// The two versions are each given as a set of file descriptors. Elements are
+// matched by fully-qualified name (and fields and enum values by number), so
+// elements may move between files without being reported as removed. The
+// descriptors may come from anywhere: compiled-in descriptors, protoset files,
+// or a server via the grpcreflect package.
+package protocompat
+
+import (
+	"fmt"
+	"strings"
+
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/jhump/protoreflect/desc"
+)
+
+// Category describes the kinds of clients that are broken by a change. It is
+// a bit set, since a change can break more than one kind of client.
+type Category int
+
+const (
+	// Wire indicates that a change breaks compatibility of the binary format.
+	Wire Category = 1 << iota
+	// JSON indicates that a change breaks compatibility of the JSON format.
+	JSON
+	// Source indicates that a change breaks code generated from the schema.
+	Source
+)
+
+// String returns a string representation of the category, such as
+// "wire|json".
+func (c Category) String() string {
+	var parts []string
+	if c&Wire != 0 {
+		parts = append(parts, "wire")
+	}
+	if c&JSON != 0 {
+		parts = append(parts, "json")
+	}
+	if c&Source != 0 {
+		parts = append(parts, "source")
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, "|")
+}
+
+// Change describes an incompatible change between two versions of a schema.
+type Change struct {
+	// Category indicates the kinds of clients broken by this change.
+	Category Category
+	// Symbol is the fully-qualified name of the element that changed, as
+	// named in the old version of the schema.
+	Symbol string
+	// Description is a human-readable description of the change.
+	Description string
+}
+
+// String returns a string representation of the change, including its
+// symbol, description, and category.
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s [%v]", c.Symbol, c.Description, c.Category)
+}
+
+// Compare compares the elements defined in the given old files with those
+// defined in the given new files and returns any incompatible changes. Only
+// elements defined in the given files are compared, not those defined in
+// their dependencies. Changes are returned in the order that the elements
+// are defined in the old files.
+//
+// Additions are not reported, since they are compatible, with the exception
+// of new required fields.
+func Compare(oldFiles, newFiles []*desc.FileDescriptor) []Change {
+	c := comparer{newIndex: newIndex(newFiles)}
+	oldIdx := newIndex(oldFiles)
+	for _, d := range oldIdx.order {
+		switch d := d.(type) {
+		case *desc.MessageDescriptor:
+			c.compareMessage(d, c.newIndex.messages[d.GetFullyQualifiedName()])
+		case *desc.EnumDescriptor:
+			c.compareEnum(d, c.newIndex.enums[d.GetFullyQualifiedName()])
+		case *desc.FieldDescriptor:
+			c.compareExtension(d, c.newIndex.extensions[d.GetFullyQualifiedName()])
+		case *desc.ServiceDescriptor:
+			c.compareService(d, c.newIndex.services[d.GetFullyQualifiedName()])
+		}
+	}
+	return c.changes
+}
+
+// index contains the elements of a set of files, keyed by fully-qualified
+// name.
+type index struct {
+	messages   map[string]*desc.MessageDescriptor
+	enums      map[string]*desc.EnumDescriptor
+	extensions map[string]*desc.FieldDescriptor
+	services   map[string]*desc.ServiceDescriptor
+	// all of the above, in the order they are defined
+	order []desc.Descriptor
+}
+
+func newIndex(files []*desc.FileDescriptor) *index {
+	idx := &index{
+		messages:   map[string]*desc.MessageDescriptor{},
+		enums:      map[string]*desc.EnumDescriptor{},
+		extensions: map[string]*desc.FieldDescriptor{},
+		services:   map[string]*desc.ServiceDescriptor{},
+	}
+	for _, fd := range files {
+		for _, md := range fd.GetMessageTypes() {
+			idx.addMessage(md)
+		}
+		for _, ed := range fd.GetEnumTypes() {
+			idx.addEnum(ed)
+		}
+		for _, exd := range fd.GetExtensions() {
+			idx.addExtension(exd)
+		}
+		for _, sd := range fd.GetServices() {
+			idx.services[sd.GetFullyQualifiedName()] = sd
+			idx.order = append(idx.order, sd)
+		}
+	}
+	return idx
+}
+
+func (idx *index) addMessage(md *desc.MessageDescriptor) {
+	if md.IsMapEntry() {
+		// map entries are compared as part of their map fields
+		return
+	}
+	idx.messages[md.GetFullyQualifiedName()] = md
+	idx.order = append(idx.order, md)
+	for _, nmd := range md.GetNestedMessageTypes() {
+		idx.addMessage(nmd)
+	}
+	for _, ed := range md.GetNestedEnumTypes() {
+		idx.addEnum(ed)
+	}
+	for _, exd := range md.GetNestedExtensions() {
+		idx.addExtension(exd)
+	}
+}
+
+func (idx *index) addEnum(ed *desc.EnumDescriptor) {
+	idx.enums[ed.GetFullyQualifiedName()] = ed
+	idx.order = append(idx.order, ed)
+}
+
+func (idx *index) addExtension(exd *desc.FieldDescriptor) {
+	idx.extensions[exd.GetFullyQualifiedName()] = exd
+	idx.order = append(idx.order, exd)
+}
+
+type comparer struct {
+	newIndex *index
+	changes  []Change
+}
+
+func (c *comparer) add(cat Category, symbol, format string, args ...interface{}) {
+	c.changes = append(c.changes, Change{Category: cat, Symbol: symbol, Description: fmt.Sprintf(format, args...)})
+}
+
+// checkMoved reports a source-breaking change if the given top-level element
+// has moved to a different file, since code that uses it may need to import
+// a different file.
+func (c *comparer) checkMoved(oldD, newD desc.Descriptor) {
+	if _, ok := oldD.GetParent().(*desc.FileDescriptor); !ok {
+		// nested elements move with their enclosing message
+		return
+	}
+	if oldD.GetFile().GetName() != newD.GetFile().GetName() {
+		c.add(Source, oldD.GetFullyQualifiedName(), "moved from file %s to %s", oldD.GetFile().GetName(), newD.GetFile().GetName())
+	}
+}
+
+func (c *comparer) compareMessage(oldMd, newMd *desc.MessageDescriptor) {
+	name := oldMd.GetFullyQualifiedName()
+	if newMd == nil {
+		c.add(Source, name, "message removed")
+		return
+	}
+	c.checkMoved(oldMd, newMd)
+
+	for _, oldFld := range oldMd.GetFields() {
+		newFld := newMd.FindFieldByNumber(oldFld.GetNumber())
+		if newFld == nil {
+			if newMd.IsReservedNumber(oldFld.GetNumber()) {
+				c.add(JSON|Source, oldFld.GetFullyQualifiedName(), "field %d removed", oldFld.GetNumber())
+			} else {
+				c.add(Wire|JSON|Source, oldFld.GetFullyQualifiedName(), "field %d removed without reserving its number", oldFld.GetNumber())
+			}
+			continue
+		}
+		c.compareField(oldFld, newFld)
+	}
+	for _, newFld := range newMd.GetFields() {
+		if newFld.IsRequired() && oldMd.FindFieldByNumber(newFld.GetNumber()) == nil {
+			c.add(Wire|JSON, name, "required field %s (%d) added", newFld.GetName(), newFld.GetNumber())
+		}
+	}
+}
+
+func (c *comparer) compareField(oldFld, newFld *desc.FieldDescriptor) {
+	name := oldFld.GetFullyQualifiedName()
+	if oldFld.GetName() != newFld.GetName() {
+		cat := Source
+		if oldFld.GetJSONName() != newFld.GetJSONName() {
+			cat |= JSON
+		}
+		c.add(cat, name, "renamed to %s", newFld.GetName())
+	} else if oldFld.GetJSONName() != newFld.GetJSONName() {
+		c.add(JSON, name, "JSON name changed from %s to %s", oldFld.GetJSONName(), newFld.GetJSONName())
+	}
+
+	if oldFld.GetLabel() != newFld.GetLabel() {
+		c.add(Wire|JSON|Source, name, "label changed from %s to %s", labelString(oldFld.GetLabel()), labelString(newFld.GetLabel()))
+	}
+
+	if oldFld.IsMap() && newFld.IsMap() {
+		oldEntry, newEntry := oldFld.GetMessageType(), newFld.GetMessageType()
+		c.compareFieldType(name, "map key", oldEntry.FindFieldByNumber(1), newEntry.FindFieldByNumber(1))
+		c.compareFieldType(name, "map value", oldEntry.FindFieldByNumber(2), newEntry.FindFieldByNumber(2))
+	} else {
+		c.compareFieldType(name, "type", oldFld, newFld)
+	}
+
+	oldOneOf, newOneOf := oneOfName(oldFld), oneOfName(newFld)
+	if oldOneOf != newOneOf {
+		switch {
+		case oldOneOf == "":
+			c.add(Wire|Source, name, "moved into oneof %s", newOneOf)
+		case newOneOf == "":
+			c.add(Wire|Source, name, "moved out of oneof %s", oldOneOf)
+		default:
+			c.add(Wire|Source, name, "moved from oneof %s to %s", oldOneOf, newOneOf)
+		}
+	}
+}
+
+func oneOfName(fld *desc.FieldDescriptor) string {
+	if fld.GetOneOf() == nil {
+		return ""
+	}
+	return fld.GetOneOf().GetName()
+}
+
+// compareFieldType compares the types of the given fields. The given what
+// describes which type is compared (e.g. "type" or "map value") for use in
+// the change description.
+func (c *comparer) compareFieldType(name, what string, oldFld, newFld *desc.FieldDescriptor) {
+	oldType, newType := typeName(oldFld), typeName(newFld)
+	if oldType == newType {
+		return
+	}
+	cat := Source
+	if wireGroup(oldFld) != wireGroup(newFld) {
+		cat |= Wire
+	}
+	if jsonGroup(oldFld) != jsonGroup(newFld) {
+		cat |= JSON
+	}
+	c.add(cat, name, "%s changed from %s to %s", what, oldType, newType)
+}
+
+// typeName returns a description of the given field's type. For message and
+// enum fields, this includes the name of the message or enum.
+func typeName(fld *desc.FieldDescriptor) string {
+	switch fld.GetType() {
+	case dpb.FieldDescriptorProto_TYPE_MESSAGE:
+		if fld.IsMap() {
+			return "map"
+		}
+		return "message " + fld.GetMessageType().GetFullyQualifiedName()
+	case dpb.FieldDescriptorProto_TYPE_GROUP:
+		return "group " + fld.GetMessageType().GetFullyQualifiedName()
+	case dpb.FieldDescriptorProto_TYPE_ENUM:
+		return "enum " + fld.GetEnumType().GetFullyQualifiedName()
+	default:
+		return strings.ToLower(strings.TrimPrefix(fld.GetType().String(), "TYPE_"))
+	}
+}
+
+// wireGroup returns a string that is the same for two fields if their types
+// are compatible in the binary format.
+func wireGroup(fld *desc.FieldDescriptor) string {
+	switch fld.GetType() {
+	case dpb.FieldDescriptorProto_TYPE_INT32, dpb.FieldDescriptorProto_TYPE_INT64,
+		dpb.FieldDescriptorProto_TYPE_UINT32, dpb.FieldDescriptorProto_TYPE_UINT64,
+		dpb.FieldDescriptorProto_TYPE_BOOL, dpb.FieldDescriptorProto_TYPE_ENUM:
+		return "varint"
+	case dpb.FieldDescriptorProto_TYPE_SINT32, dpb.FieldDescriptorProto_TYPE_SINT64:
+		return "zigzag"
+	case dpb.FieldDescriptorProto_TYPE_FIXED32, dpb.FieldDescriptorProto_TYPE_SFIXED32:
+		return "fixed32"
+	case dpb.FieldDescriptorProto_TYPE_FIXED64, dpb.FieldDescriptorProto_TYPE_SFIXED64:
+		return "fixed64"
+	case dpb.FieldDescriptorProto_TYPE_STRING, dpb.FieldDescriptorProto_TYPE_BYTES:
+		return "bytes"
+	default:
+		// messages and groups are only compatible with the same type and
+		// float and double are not compatible with anything else
+		return typeName(fld)
+	}
+}
+
+// jsonGroup returns a string that is the same for two fields if their types
+// are compatible in the JSON format.
+func jsonGroup(fld *desc.FieldDescriptor) string {
+	switch fld.GetType() {
+	case dpb.FieldDescriptorProto_TYPE_INT32, dpb.FieldDescriptorProto_TYPE_UINT32,
+		dpb.FieldDescriptorProto_TYPE_SINT32, dpb.FieldDescriptorProto_TYPE_FIXED32,
+		dpb.FieldDescriptorProto_TYPE_SFIXED32:
+		return "int32"
+	case dpb.FieldDescriptorProto_TYPE_INT64, dpb.FieldDescriptorProto_TYPE_UINT64,
+		dpb.FieldDescriptorProto_TYPE_SINT64, dpb.FieldDescriptorProto_TYPE_FIXED64,
+		dpb.FieldDescriptorProto_TYPE_SFIXED64:
+		// 64-bit integers are represented as strings in JSON
+		return "int64"
+	case dpb.FieldDescriptorProto_TYPE_FLOAT, dpb.FieldDescriptorProto_TYPE_DOUBLE:
+		return "float"
+	case dpb.FieldDescriptorProto_TYPE_MESSAGE, dpb.FieldDescriptorProto_TYPE_GROUP:
+		// groups and messages look the same in JSON
+		return "message " + fld.GetMessageType().GetFullyQualifiedName()
+	default:
+		return typeName(fld)
+	}
+}
+
+func labelString(lbl dpb.FieldDescriptorProto_Label) string {
+	return strings.ToLower(strings.TrimPrefix(lbl.String(), "LABEL_"))
+}
+
+func (c *comparer) compareExtension(oldExt, newExt *desc.FieldDescriptor) {
+	name := oldExt.GetFullyQualifiedName()
+	if newExt == nil {
+		c.add(Source, name, "extension removed")
+		return
+	}
+	c.checkMoved(oldExt, newExt)
+	if oldExt.GetOwner().GetFullyQualifiedName() != newExt.GetOwner().GetFullyQualifiedName() {
+		c.add(Wire|JSON|Source, name, "extendee changed from %s to %s", oldExt.GetOwner().GetFullyQualifiedName(), newExt.GetOwner().GetFullyQualifiedName())
+	}
+	if oldExt.GetNumber() != newExt.GetNumber() {
+		c.add(Wire, name, "number changed from %d to %d", oldExt.GetNumber(), newExt.GetNumber())
+	}
+	if oldExt.GetLabel() != newExt.GetLabel() {
+		c.add(Wire|JSON|Source, name, "label changed from %s to %s", labelString(oldExt.GetLabel()), labelString(newExt.GetLabel()))
+	}
+	c.compareFieldType(name, "type", oldExt, newExt)
+}
+
+func (c *comparer) compareEnum(oldEd, newEd *desc.EnumDescriptor) {
+	name := oldEd.GetFullyQualifiedName()
+	if newEd == nil {
+		c.add(Source, name, "enum removed")
+		return
+	}
+	c.checkMoved(oldEd, newEd)
+
+	newByName := map[string]*desc.EnumValueDescriptor{}
+	newByNumber := map[int32]*desc.EnumValueDescriptor{}
+	for _, vd := range newEd.GetValues() {
+		newByName[vd.GetName()] = vd
+		if _, ok := newByNumber[vd.GetNumber()]; !ok {
+			newByNumber[vd.GetNumber()] = vd
+		}
+	}
+	for _, oldVal := range oldEd.GetValues() {
+		valName := oldVal.GetFullyQualifiedName()
+		if newVal := newByName[oldVal.GetName()]; newVal != nil {
+			if newVal.GetNumber() != oldVal.GetNumber() {
+				c.add(Wire|Source, valName, "number changed from %d to %d", oldVal.GetNumber(), newVal.GetNumber())
+			}
+			continue
+		}
+		// values are represented by name in JSON, so a renamed value breaks
+		// JSON but not the binary format
+		if newVal := newByNumber[oldVal.GetNumber()]; newVal != nil {
+			c.add(JSON|Source, valName, "renamed to %s", newVal.GetName())
+		} else if newEd.IsReservedNumber(oldVal.GetNumber()) {
+			c.add(JSON|Source, valName, "value %d removed", oldVal.GetNumber())
+		} else {
+			c.add(Wire|JSON|Source, valName, "value %d removed without reserving its number", oldVal.GetNumber())
+		}
+	}
+}
+
+func (c *comparer) compareService(oldSd, newSd *desc.ServiceDescriptor) {
+	name := oldSd.GetFullyQualifiedName()
+	if newSd == nil {
+		c.add(Wire|Source, name, "service removed")
+		return
+	}
+	c.checkMoved(oldSd, newSd)
+
+	newMethods := map[string]*desc.MethodDescriptor{}
+	for _, mtd := range newSd.GetMethods() {
+		newMethods[mtd.GetName()] = mtd
+	}
+	for _, oldMtd := range oldSd.GetMethods() {
+		mtdName := oldMtd.GetFullyQualifiedName()
+		newMtd := newMethods[oldMtd.GetName()]
+		if newMtd == nil {
+			c.add(Wire|Source, mtdName, "method removed")
+			continue
+		}
+		if oldIn, newIn := oldMtd.GetInputType().GetFullyQualifiedName(), newMtd.GetInputType().GetFullyQualifiedName(); oldIn != newIn {
+			c.add(Wire|JSON|Source, mtdName, "request type changed from %s to %s", oldIn, newIn)
+		}
+		if oldOut, newOut := oldMtd.GetOutputType().GetFullyQualifiedName(), newMtd.GetOutputType().GetFullyQualifiedName(); oldOut != newOut {
+			c.add(Wire|JSON|Source, mtdName, "response type changed from %s to %s", oldOut, newOut)
+		}
+		if oldMtd.IsClientStreaming() != newMtd.IsClientStreaming() || oldMtd.IsServerStreaming() != newMtd.IsServerStreaming() {
+			c.add(Wire|Source, mtdName, "streaming mode changed from %s to %s", streamingMode(oldMtd), streamingMode(newMtd))
+		}
+	}
+}
+
+func streamingMode(mtd *desc.MethodDescriptor) string {
+	switch {
+	case mtd.IsClientStreaming() && mtd.IsServerStreaming():
+		return "bidi-streaming"
+	case mtd.IsClientStreaming():
+		return "client-streaming"
+	case mtd.IsServerStreaming():
+		return "server-streaming"
+	default:
+		return "unary"
+	}
+}
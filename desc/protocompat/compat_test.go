@@ -0,0 +1,158 @@
+package protocompat
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/desc_test"
+	"github.com/jhump/protoreflect/desc/protoparse"
+)
+
+const oldSource = `
+	syntax = "proto2";
+	package test;
+	message Foo {
+		optional string name = 1;
+		optional int32 count = 2;
+		optional int32 size = 3;
+		repeated string tags = 4;
+		optional Bar bar = 5;
+		optional string old_name = 6;
+		map<string, int32> attrs = 7;
+		optional uint32 flags = 8;
+		optional string a = 9;
+		oneof choice {
+			string b = 10;
+		}
+		optional int32 gone = 11;
+		optional int32 gone_but_reserved = 12;
+		optional int32 x = 13 [json_name = "x"];
+		message Nested {}
+	}
+	message Bar {}
+	message Removed {}
+	enum Color {
+		RED = 0;
+		GREEN = 1;
+		BLUE = 2;
+		PURPLE = 3;
+		ORANGE = 4;
+	}
+	service Svc {
+		rpc Get(Foo) returns (Bar);
+		rpc Watch(Foo) returns (stream Bar);
+		rpc Delete(Foo) returns (Bar);
+	}`
+
+const newSource = `
+	syntax = "proto2";
+	package test;
+	message Foo {
+		optional string name = 1;
+		optional int64 count = 2;
+		optional sint32 size = 3;
+		optional string tags = 4;
+		optional Baz bar = 5;
+		optional string new_name = 6;
+		map<string, string> attrs = 7;
+		optional int64 flags = 8;
+		oneof choice {
+			string a = 9;
+			string b = 10;
+		}
+		reserved 12;
+		optional int32 x = 13 [json_name = "ex"];
+		required int32 id = 14;
+		message Nested {}
+	}
+	message Bar {}
+	message Baz {}
+	enum Color {
+		RED = 0;
+		VERDE = 1;
+		BLUE = 5;
+		reserved 3;
+	}
+	service Svc {
+		rpc Get(Baz) returns (Bar);
+		rpc Watch(Foo) returns (Bar);
+	}`
+
+func TestCompare(t *testing.T) {
+	oldFd := parse(t, "test.proto", oldSource)
+	newFd := parse(t, "test.proto", newSource)
+
+	changes := Compare([]*desc.FileDescriptor{oldFd}, []*desc.FileDescriptor{newFd})
+	var actual []string
+	for _, c := range changes {
+		actual = append(actual, c.String())
+	}
+	expected := []string{
+		"test.Foo.count: type changed from int32 to int64 [json|source]",
+		"test.Foo.size: type changed from int32 to sint32 [wire|source]",
+		"test.Foo.tags: label changed from repeated to optional [wire|json|source]",
+		"test.Foo.bar: type changed from message test.Bar to message test.Baz [wire|json|source]",
+		"test.Foo.old_name: renamed to new_name [json|source]",
+		"test.Foo.attrs: map value changed from int32 to string [wire|json|source]",
+		"test.Foo.flags: type changed from uint32 to int64 [json|source]",
+		"test.Foo.a: moved into oneof choice [wire|source]",
+		"test.Foo.gone: field 11 removed without reserving its number [wire|json|source]",
+		"test.Foo.gone_but_reserved: field 12 removed [json|source]",
+		"test.Foo.x: JSON name changed from x to ex [json]",
+		"test.Foo: required field id (14) added [wire|json]",
+		"test.Removed: message removed [source]",
+		"test.Color.GREEN: renamed to VERDE [json|source]",
+		"test.Color.BLUE: number changed from 2 to 5 [wire|source]",
+		"test.Color.PURPLE: value 3 removed [json|source]",
+		"test.Color.ORANGE: value 4 removed without reserving its number [wire|json|source]",
+		"test.Svc.Get: request type changed from test.Foo to test.Baz [wire|json|source]",
+		"test.Svc.Watch: streaming mode changed from server-streaming to unary [wire|source]",
+		"test.Svc.Delete: method removed [wire|source]",
+	}
+	eq(t, strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+
+	// comparing a file with itself yields no changes
+	eq(t, 0, len(Compare([]*desc.FileDescriptor{oldFd}, []*desc.FileDescriptor{oldFd})))
+}
+
+func TestCompareMovedElements(t *testing.T) {
+	oldFd := parse(t, "a.proto", `syntax = "proto3"; package test; message Foo { int32 a = 1; } enum E { V = 0; }`)
+	newA := parse(t, "a.proto", `syntax = "proto3"; package test; enum E { V = 0; }`)
+	newB := parse(t, "b.proto", `syntax = "proto3"; package test; message Foo { int32 a = 1; }`)
+
+	changes := Compare([]*desc.FileDescriptor{oldFd}, []*desc.FileDescriptor{newA, newB})
+	eq(t, 1, len(changes))
+	eq(t, Change{Category: Source, Symbol: "test.Foo", Description: "moved from file a.proto to b.proto"}, changes[0])
+}
+
+func TestCompareCompiledDescriptors(t *testing.T) {
+	fd, err := desc.LoadFileDescriptor("desc_test1.proto")
+	ok(t, err)
+	fromSet, err := desc.CreateFileDescriptorFromSet(desc_test.GetDescriptorSet())
+	ok(t, err)
+	eq(t, 0, len(Compare([]*desc.FileDescriptor{fd}, []*desc.FileDescriptor{fromSet})))
+}
+
+func TestCategoryString(t *testing.T) {
+	eq(t, "none", Category(0).String())
+	eq(t, "wire", Wire.String())
+	eq(t, "json|source", (JSON | Source).String())
+}
+
+func parse(t *testing.T, filename, source string) *desc.FileDescriptor {
+	p := protoparse.Parser{
+		Accessor: func(name string) (io.ReadCloser, error) {
+			if name != filename {
+				return nil, os.ErrNotExist
+			}
+			return ioutil.NopCloser(strings.NewReader(source)), nil
+		},
+	}
+	fds, err := p.ParseFiles(filename)
+	ok(t, err)
+	return fds[0]
+}
@@ -0,0 +1,206 @@
+// Package protolint provides a linter that checks descriptors for violations
+// of style and correctness rules.
+//
+// The linter works on rich descriptors (desc.FileDescriptor), so it can check
+// schemas from any source: files compiled by protoc into protosets, files
+// parsed with the protoparse package, or files fetched from a server using
+// the grpcreflect package. When descriptors include source code info, the
+// problems reported include source positions.
+//
+// Rules are pluggable: a rule is anything that implements the Rule interface.
+// The built-in rules, returned by DefaultRules, are exported types whose
+// fields configure their behavior.
+package protolint
+
+import (
+	"fmt"
+	"sort"
+
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/jhump/protoreflect/desc"
+)
+
+// Rule is a single lint check. The linter calls a rule's Check method for
+// every element in the files being linted: the file itself and all of its
+// messages, fields, oneofs, enums, enum values, extensions, services, and
+// methods. Rules can ignore elements that they do not care about.
+type Rule interface {
+	// Name returns the name of the rule. The name is used to disable rules
+	// and is included in the problems the rule reports.
+	Name() string
+	// Check checks the given element and reports any problems found to the
+	// given reporter.
+	Check(d desc.Descriptor, r *Reporter)
+}
+
+// CheckFunc is the signature of a function that implements a rule's checks.
+type CheckFunc func(d desc.Descriptor, r *Reporter)
+
+// NewRule returns a rule with the given name that uses the given function to
+// check elements.
+func NewRule(name string, check CheckFunc) Rule {
+	return funcRule{name: name, check: check}
+}
+
+type funcRule struct {
+	name  string
+	check CheckFunc
+}
+
+func (r funcRule) Name() string {
+	return r.name
+}
+
+func (r funcRule) Check(d desc.Descriptor, rep *Reporter) {
+	r.check(d, rep)
+}
+
+// Position describes a location in a source file. Line and column numbers
+// start at one. They are zero if the position is not known, which is the case
+// when the descriptors have no source code info.
+type Position struct {
+	Filename  string
+	Line, Col int
+}
+
+// String returns a string representation of the position, in the form
+// "filename:line:col", or just the filename if the line is unknown.
+func (p Position) String() string {
+	if p.Line == 0 {
+		return p.Filename
+	}
+	return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Col)
+}
+
+// Problem is a rule violation found by the linter.
+type Problem struct {
+	// Rule is the name of the rule that was violated.
+	Rule string
+	// Element is the element that violates the rule.
+	Element desc.Descriptor
+	// Pos is the location of the element in its source file.
+	Pos Position
+	// Message describes the problem.
+	Message string
+}
+
+// String returns a string representation of the problem, including its
+// position, message, and rule name.
+func (p Problem) String() string {
+	return fmt.Sprintf("%v: %s (%s)", p.Pos, p.Message, p.Rule)
+}
+
+// Reporter is used by rules to report problems.
+type Reporter struct {
+	rule     string
+	problems []Problem
+}
+
+// Report reports a problem with the given element. The problem's message is
+// formatted from the given format and arguments, same as fmt.Sprintf.
+func (r *Reporter) Report(d desc.Descriptor, format string, args ...interface{}) {
+	r.report(d, d.GetSourceInfo(), format, args...)
+}
+
+// ReportPath reports a problem at the given path in the given file. This can
+// be used to report problems with parts of a file that are not elements with
+// their own descriptors, such as imports. The path is the same as used in
+// source code info.
+func (r *Reporter) ReportPath(fd *desc.FileDescriptor, path []int32, format string, args ...interface{}) {
+	r.report(fd, findLocation(fd, path), format, args...)
+}
+
+func (r *Reporter) report(d desc.Descriptor, loc *dpb.SourceCodeInfo_Location, format string, args ...interface{}) {
+	pos := Position{Filename: d.GetFile().GetName()}
+	if span := loc.GetSpan(); len(span) >= 3 {
+		pos.Line = int(span[0]) + 1
+		pos.Col = int(span[1]) + 1
+	}
+	r.problems = append(r.problems, Problem{
+		Rule:    r.rule,
+		Element: d,
+		Pos:     pos,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func findLocation(fd *desc.FileDescriptor, path []int32) *dpb.SourceCodeInfo_Location {
+	for _, loc := range fd.AsFileDescriptorProto().GetSourceCodeInfo().GetLocation() {
+		if len(loc.Path) != len(path) {
+			continue
+		}
+		match := true
+		for i := range path {
+			if loc.Path[i] != path[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return loc
+		}
+	}
+	return nil
+}
+
+// Linter checks files for rule violations.
+type Linter struct {
+	// Rules are the rules to check. If empty, DefaultRules() is used.
+	Rules []Rule
+	// Disabled contains the names of rules that should not be checked.
+	Disabled map[string]bool
+}
+
+// Lint checks the given files and returns the problems found. Problems are
+// grouped by file, in the order the files are given, and sorted by position
+// within each file. Problems at the same position are in the order they were
+// reported. Only the given files are checked, not their dependencies.
+func (l *Linter) Lint(files ...*desc.FileDescriptor) []Problem {
+	rules := l.Rules
+	if len(rules) == 0 {
+		rules = DefaultRules()
+	}
+	var problems []Problem
+	for _, fd := range files {
+		var r Reporter
+		check := func(d desc.Descriptor) {
+			for _, rule := range rules {
+				if l.Disabled[rule.Name()] {
+					continue
+				}
+				r.rule = rule.Name()
+				rule.Check(d, &r)
+			}
+		}
+		check(fd)
+		desc.Walk(fd, func(d desc.Descriptor, _ []int32) error {
+			// map entry messages are synthesized, not declared in source
+			if md, ok := d.(*desc.MessageDescriptor); ok && md.IsMapEntry() {
+				return desc.SkipChildren
+			}
+			check(d)
+			return nil
+		})
+		sort.Stable(problemsByPos(r.problems))
+		problems = append(problems, r.problems...)
+	}
+	return problems
+}
+
+type problemsByPos []Problem
+
+func (p problemsByPos) Len() int {
+	return len(p)
+}
+
+func (p problemsByPos) Less(i, j int) bool {
+	if p[i].Pos.Line != p[j].Pos.Line {
+		return p[i].Pos.Line < p[j].Pos.Line
+	}
+	return p[i].Pos.Col < p[j].Pos.Col
+}
+
+func (p problemsByPos) Swap(i, j int) {
+	p[i], p[j] = p[j], p[i]
+}
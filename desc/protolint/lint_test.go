@@ -0,0 +1,152 @@
+package protolint
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+)
+
+var sources = map[string]string{
+	"test.proto": `syntax = "proto3";
+package test;
+
+import "google/protobuf/descriptor.proto";
+import "other.proto";
+import "opts.proto";
+import public "pub.proto";
+
+// A message.
+message GoodMessage {
+  string good_field = 1;
+  int32 BadField = 2;
+  map<string, string> attrs = 3;
+  oneof theChoice {
+    string a = 4;
+  }
+}
+
+message bad_message {
+  option (opts.custom) = true;
+}
+
+// An enum.
+enum Color {
+  COLOR_UNSPECIFIED = 0;
+  red = 1;
+}
+
+// Another enum.
+enum Size {
+  SMALL = 0;
+}
+
+// A service.
+service Svc {
+  // A method.
+  rpc Get(GoodMessage) returns (GoodMessage);
+  rpc list(GoodMessage) returns (bad_message);
+}
+`,
+	"other.proto": `syntax = "proto3"; package other; message Other {}`,
+	"opts.proto": `syntax = "proto3"; package opts; import "google/protobuf/descriptor.proto";
+		extend google.protobuf.MessageOptions { bool custom = 10101; }`,
+	"pub.proto": `syntax = "proto3"; package pub; message Pub {}`,
+}
+
+func TestLint(t *testing.T) {
+	fd := parse(t, "test.proto")
+	var l Linter
+	problems := l.Lint(fd)
+	expected := []string{
+		`test.proto:4:1: import "google/protobuf/descriptor.proto" is not used (unused-imports)`,
+		`test.proto:5:1: import "other.proto" is not used (unused-imports)`,
+		`test.proto:12:3: field name BadField should be lower_snake_case (field-names)`,
+		`test.proto:14:3: oneof name theChoice should be lower_snake_case (oneof-names)`,
+		`test.proto:19:1: message name bad_message should be UpperCamelCase (message-names)`,
+		`test.proto:19:1: message bad_message should have a comment (comments)`,
+		`test.proto:26:3: enum value name red should be UPPER_SNAKE_CASE (enum-value-names)`,
+		`test.proto:31:3: zero value SMALL of enum Size should end with _UNSPECIFIED (enum-zero-value)`,
+		`test.proto:38:3: method list uses request type test.GoodMessage, which is also used by test.Svc.Get (unique-rpc-types)`,
+		`test.proto:38:3: method name list should be UpperCamelCase (method-names)`,
+		`test.proto:38:3: method list should have a comment (comments)`,
+	}
+	eq(t, strings.Join(expected, "\n"), problemsString(problems))
+	eq(t, "test.bad_message", problems[4].Element.GetFullyQualifiedName())
+
+	// rules can be disabled and configured
+	l.Rules = DefaultRules()
+	l.Disabled = map[string]bool{"unused-imports": true, "comments": true, "unique-rpc-types": true}
+	for _, r := range l.Rules {
+		switch r := r.(type) {
+		case *EnumZeroValueRule:
+			r.Suffix = ""
+		case *NamingRule:
+			if r.Kind == KindField {
+				r.Pattern = regexp.MustCompile(`^[a-zA-Z_]+$`)
+			}
+		}
+	}
+	expected = []string{
+		`test.proto:14:3: oneof name theChoice should be lower_snake_case (oneof-names)`,
+		`test.proto:19:1: message name bad_message should be UpperCamelCase (message-names)`,
+		`test.proto:26:3: enum value name red should be UPPER_SNAKE_CASE (enum-value-names)`,
+		`test.proto:38:3: method name list should be UpperCamelCase (method-names)`,
+	}
+	eq(t, strings.Join(expected, "\n"), problemsString(l.Lint(fd)))
+}
+
+func TestLintCustomRule(t *testing.T) {
+	fd := parse(t, "test.proto")
+	l := Linter{Rules: []Rule{
+		NewRule("no-maps", func(d desc.Descriptor, r *Reporter) {
+			if fld, ok := d.(*desc.FieldDescriptor); ok && fld.IsMap() {
+				r.Report(d, "field %s is a map", fld.GetName())
+			}
+		}),
+	}}
+	eq(t, "test.proto:13:3: field attrs is a map (no-maps)", problemsString(l.Lint(fd)))
+}
+
+func TestLintWithoutSourceInfo(t *testing.T) {
+	fd := parse(t, "test.proto")
+	fdp := fd.AsFileDescriptorProto()
+	fdp.SourceCodeInfo = nil
+	fd, err := desc.CreateFileDescriptor(fdp, fd.GetDependencies()...)
+	ok(t, err)
+
+	// without source info, there are no positions and no comment checks
+	l := Linter{Disabled: map[string]bool{"unused-imports": true}}
+	problems := l.Lint(fd)
+	eq(t, 7, len(problems))
+	eq(t, "test.proto: field name BadField should be lower_snake_case (field-names)", problems[1].String())
+}
+
+func problemsString(problems []Problem) string {
+	strs := make([]string, len(problems))
+	for i, p := range problems {
+		strs[i] = fmt.Sprint(p)
+	}
+	return strings.Join(strs, "\n")
+}
+
+func parse(t *testing.T, filename string) *desc.FileDescriptor {
+	p := protoparse.Parser{
+		Accessor: func(name string) (io.ReadCloser, error) {
+			src, ok := sources[name]
+			if !ok {
+				return nil, os.ErrNotExist
+			}
+			return ioutil.NopCloser(strings.NewReader(src)), nil
+		},
+	}
+	fds, err := p.ParseFiles(filename)
+	ok(t, err)
+	return fds[0]
+}
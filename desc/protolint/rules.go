@@ -0,0 +1,257 @@
+package protolint
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/jhump/protoreflect/desc"
+)
+
+// DefaultRules returns the built-in rules, with their default configurations.
+// The returned rules are new values, so they can be modified without
+// affecting the results of other calls.
+func DefaultRules() []Rule {
+	return []Rule{
+		&NamingRule{RuleName: "message-names", Kind: KindMessage, Pattern: upperCamelCase, Style: "UpperCamelCase"},
+		&NamingRule{RuleName: "field-names", Kind: KindField, Pattern: lowerSnakeCase, Style: "lower_snake_case"},
+		&NamingRule{RuleName: "oneof-names", Kind: KindOneOf, Pattern: lowerSnakeCase, Style: "lower_snake_case"},
+		&NamingRule{RuleName: "enum-names", Kind: KindEnum, Pattern: upperCamelCase, Style: "UpperCamelCase"},
+		&NamingRule{RuleName: "enum-value-names", Kind: KindEnumValue, Pattern: upperSnakeCase, Style: "UPPER_SNAKE_CASE"},
+		&NamingRule{RuleName: "service-names", Kind: KindService, Pattern: upperCamelCase, Style: "UpperCamelCase"},
+		&NamingRule{RuleName: "method-names", Kind: KindMethod, Pattern: upperCamelCase, Style: "UpperCamelCase"},
+		&CommentsRule{Kinds: []ElementKind{KindMessage, KindEnum, KindService, KindMethod}},
+		&EnumZeroValueRule{Suffix: "_UNSPECIFIED"},
+		&UniqueRpcTypesRule{},
+		&UnusedImportsRule{},
+	}
+}
+
+var (
+	upperCamelCase = regexp.MustCompile(`^[A-Z][a-zA-Z0-9]*$`)
+	lowerSnakeCase = regexp.MustCompile(`^[a-z][a-z0-9]*(_[a-z0-9]+)*$`)
+	upperSnakeCase = regexp.MustCompile(`^[A-Z][A-Z0-9]*(_[A-Z0-9]+)*$`)
+)
+
+// ElementKind identifies a kind of element, for rules that apply only to some
+// kinds of elements.
+type ElementKind int
+
+const (
+	KindFile ElementKind = iota
+	KindMessage
+	KindField
+	KindOneOf
+	KindEnum
+	KindEnumValue
+	KindExtension
+	KindService
+	KindMethod
+)
+
+// String returns a description of the kind, for use in messages.
+func (k ElementKind) String() string {
+	switch k {
+	case KindFile:
+		return "file"
+	case KindMessage:
+		return "message"
+	case KindField:
+		return "field"
+	case KindOneOf:
+		return "oneof"
+	case KindEnum:
+		return "enum"
+	case KindEnumValue:
+		return "enum value"
+	case KindExtension:
+		return "extension"
+	case KindService:
+		return "service"
+	case KindMethod:
+		return "method"
+	default:
+		return "unknown"
+	}
+}
+
+// KindOf returns the kind of the given element.
+func KindOf(d desc.Descriptor) ElementKind {
+	switch d := d.(type) {
+	case *desc.FileDescriptor:
+		return KindFile
+	case *desc.MessageDescriptor:
+		return KindMessage
+	case *desc.FieldDescriptor:
+		if d.IsExtension() {
+			return KindExtension
+		}
+		return KindField
+	case *desc.OneOfDescriptor:
+		return KindOneOf
+	case *desc.EnumDescriptor:
+		return KindEnum
+	case *desc.EnumValueDescriptor:
+		return KindEnumValue
+	case *desc.ServiceDescriptor:
+		return KindService
+	case *desc.MethodDescriptor:
+		return KindMethod
+	default:
+		return -1
+	}
+}
+
+// NamingRule checks that the names of one kind of element match a pattern.
+type NamingRule struct {
+	RuleName string
+	Kind     ElementKind
+	Pattern  *regexp.Regexp
+	// Style describes the pattern, for use in problem messages.
+	Style string
+}
+
+// Name returns the rule's name.
+func (r *NamingRule) Name() string {
+	return r.RuleName
+}
+
+// Check reports a problem if the given element is of the rule's kind and its
+// name does not match the rule's pattern.
+func (r *NamingRule) Check(d desc.Descriptor, rep *Reporter) {
+	if KindOf(d) != r.Kind || r.Pattern.MatchString(d.GetName()) {
+		return
+	}
+	rep.Report(d, "%v name %s should be %s", r.Kind, d.GetName(), r.Style)
+}
+
+// CommentsRule checks that elements have comments. Files without source code
+// info (such as those from compiled-in descriptors or from server reflection)
+// are not checked, since they have no comments at all.
+type CommentsRule struct {
+	// Kinds are the kinds of elements that must have comments.
+	Kinds []ElementKind
+}
+
+// Name returns the rule's name: "comments".
+func (r *CommentsRule) Name() string {
+	return "comments"
+}
+
+// Check reports a problem if the given element is one of the rule's kinds
+// and has neither a leading nor a trailing comment.
+func (r *CommentsRule) Check(d desc.Descriptor, rep *Reporter) {
+	if d.GetFile().AsFileDescriptorProto().GetSourceCodeInfo() == nil {
+		return
+	}
+	kind := KindOf(d)
+	for _, k := range r.Kinds {
+		if k != kind {
+			continue
+		}
+		if strings.TrimSpace(d.GetLeadingComments()) == "" && strings.TrimSpace(d.GetTrailingComments()) == "" {
+			rep.Report(d, "%v %s should have a comment", kind, d.GetName())
+		}
+		return
+	}
+}
+
+// EnumZeroValueRule checks that the first value of every enum is zero, since
+// that is the default value for enum fields. (This is required in proto3 but
+// not in proto2.)
+type EnumZeroValueRule struct {
+	// Suffix, if not empty, is a suffix that the name of the zero value must
+	// have, such as "_UNSPECIFIED".
+	Suffix string
+}
+
+// Name returns the rule's name: "enum-zero-value".
+func (r *EnumZeroValueRule) Name() string {
+	return "enum-zero-value"
+}
+
+// Check reports a problem if the given element is an enum whose first value
+// is not zero or, if the rule has a suffix, whose zero value's name does not
+// have the suffix.
+func (r *EnumZeroValueRule) Check(d desc.Descriptor, rep *Reporter) {
+	ed, ok := d.(*desc.EnumDescriptor)
+	if !ok {
+		return
+	}
+	first := ed.GetValues()[0]
+	if first.GetNumber() != 0 {
+		rep.Report(first, "first value of enum %s should be zero", ed.GetName())
+	} else if r.Suffix != "" && !strings.HasSuffix(first.GetName(), r.Suffix) {
+		rep.Report(first, "zero value %s of enum %s should end with %s", first.GetName(), ed.GetName(), r.Suffix)
+	}
+}
+
+// UniqueRpcTypesRule checks that each message in a file is used as the
+// request type of at most one RPC method. Sharing request types makes it
+// hard to evolve one method without affecting the others.
+type UniqueRpcTypesRule struct {
+	// CheckResponses indicates whether response types should also be checked.
+	CheckResponses bool
+}
+
+// Name returns the rule's name: "unique-rpc-types".
+func (r *UniqueRpcTypesRule) Name() string {
+	return "unique-rpc-types"
+}
+
+// Check reports a problem for each method in the given file whose request
+// (or response, if so configured) type is also used by an earlier method.
+func (r *UniqueRpcTypesRule) Check(d desc.Descriptor, rep *Reporter) {
+	fd, ok := d.(*desc.FileDescriptor)
+	if !ok {
+		return
+	}
+	requests := map[*desc.MessageDescriptor]*desc.MethodDescriptor{}
+	responses := map[*desc.MessageDescriptor]*desc.MethodDescriptor{}
+	for _, sd := range fd.GetServices() {
+		for _, mtd := range sd.GetMethods() {
+			if other := requests[mtd.GetInputType()]; other != nil {
+				rep.Report(mtd, "method %s uses request type %s, which is also used by %s", mtd.GetName(), mtd.GetInputType().GetFullyQualifiedName(), other.GetFullyQualifiedName())
+			} else {
+				requests[mtd.GetInputType()] = mtd
+			}
+			if !r.CheckResponses {
+				continue
+			}
+			if other := responses[mtd.GetOutputType()]; other != nil {
+				rep.Report(mtd, "method %s uses response type %s, which is also used by %s", mtd.GetName(), mtd.GetOutputType().GetFullyQualifiedName(), other.GetFullyQualifiedName())
+			} else {
+				responses[mtd.GetOutputType()] = mtd
+			}
+		}
+	}
+}
+
+// UnusedImportsRule checks that every file imported by a file is used. A
+// file is used if it defines a type or extension referenced by the importing
+// file, including custom options. Public imports are never reported, since
+// they are used by files that import the importing file.
+type UnusedImportsRule struct{}
+
+// Name returns the rule's name: "unused-imports".
+func (r *UnusedImportsRule) Name() string {
+	return "unused-imports"
+}
+
+const file_dependencyTag = 3
+
+// Check reports a problem for each unused import of the given file.
+func (r *UnusedImportsRule) Check(d desc.Descriptor, rep *Reporter) {
+	fd, ok := d.(*desc.FileDescriptor)
+	if !ok {
+		return
+	}
+	unused := map[*desc.FileDescriptor]bool{}
+	for _, dep := range fd.GetUnusedDependencies() {
+		unused[dep] = true
+	}
+	for i, dep := range fd.GetDependencies() {
+		if unused[dep] {
+			rep.ReportPath(fd, []int32{file_dependencyTag, int32(i)}, "import %q is not used", dep.GetName())
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package protolint
+
+import (
+	"fmt"
+	"testing"
+)
+
+func eq(t *testing.T, expected, actual interface{}, context ...interface{}) bool {
+	if expected != actual {
+		ctxString := formatContext(context)
+		if ctxString == "" {
+			t.Errorf("Expecting %v, got %v", expected, actual)
+		} else {
+			t.Errorf("%s: Expecting %v, got %v", ctxString, expected, actual)
+		}
+		return false
+	}
+	return true
+}
+
+func ok(t *testing.T, err error, context ...interface{}) {
+	if err != nil {
+		ctxString := formatContext(context)
+		if ctxString == "" {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		} else {
+			t.Fatalf("%s: Unexpected error: %s", ctxString, err.Error())
+		}
+	}
+}
+
+func formatContext(context []interface{}) string {
+	if len(context) == 0 {
+		return ""
+	} else if len(context) == 1 {
+		return context[0].(string)
+	} else {
+		format := context[0].(string)
+		return fmt.Sprintf(format, context[1:]...)
+	}
+}
@@ -0,0 +1,1830 @@
+package protoparse
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/jhump/protoreflect/desc"
+)
+
+const (
+	// NB: These are the field numbers of the various descriptor protos, used to
+	// construct paths for source code info locations.
+	file_packageTag           = 2
+	file_dependencyTag        = 3
+	file_messagesTag          = 4
+	file_enumsTag             = 5
+	file_servicesTag          = 6
+	file_extensionsTag        = 7
+	file_optionsTag           = 8
+	file_publicDependencyTag  = 10
+	file_weakDependencyTag    = 11
+	file_syntaxTag            = 12
+	message_nameTag           = 1
+	message_fieldsTag         = 2
+	message_nestedMessagesTag = 3
+	message_enumsTag          = 4
+	message_extensionRangeTag = 5
+	message_extensionsTag     = 6
+	message_optionsTag        = 7
+	message_oneOfsTag         = 8
+	message_reservedRangeTag  = 9
+	message_reservedNameTag   = 10
+	extensionRange_startTag   = 1
+	extensionRange_endTag     = 2
+	extensionRange_optionsTag = 3
+	reservedRange_startTag    = 1
+	reservedRange_endTag      = 2
+	field_nameTag             = 1
+	field_extendeeTag         = 2
+	field_numberTag           = 3
+	field_labelTag            = 4
+	field_typeTag             = 5
+	field_typeNameTag         = 6
+	field_defaultTag          = 7
+	field_optionsTag          = 8
+	field_jsonNameTag         = 10
+	oneOf_nameTag             = 1
+	oneOf_optionsTag          = 2
+	enum_nameTag              = 1
+	enum_valuesTag            = 2
+	enum_optionsTag           = 3
+	enum_reservedRangeTag     = 4
+	enum_reservedNameTag      = 5
+	enumVal_nameTag           = 1
+	enumVal_numberTag         = 2
+	enumVal_optionsTag        = 3
+	service_nameTag           = 1
+	service_methodsTag        = 2
+	service_optionsTag        = 3
+	method_nameTag            = 1
+	method_inputTag           = 2
+	method_outputTag          = 3
+	method_optionsTag         = 4
+	method_clientStreamingTag = 5
+	method_serverStreamingTag = 6
+	// options messages all use this field number for uninterpreted options
+	uninterpretedOptionsTag = 999
+)
+
+const (
+	maxTag               = 536870911 // 2^29 - 1
+	specialReservedStart = 19000
+	specialReservedEnd   = 19999
+)
+
+// node records the positions of the interesting parts of an element in a
+// source file, for reporting errors found when linking.
+type node struct {
+	start    *token
+	name     *token
+	number   *token
+	typ      *token
+	extendee *token
+	input    *token
+	output   *token
+	deflt    *token
+	// for extension and reserved ranges whose end was "max"
+	isMax bool
+}
+
+// optionDecl is an option declaration whose name refers to a field of an
+// options message (or an extension of it) and whose value must be
+// interpreted into that field.
+type optionDecl struct {
+	// the element (a descriptor proto) that the option is for
+	elem proto.Message
+	// the element's options message
+	opts proto.Message
+	// the name from which relative references to extensions are resolved
+	relativeTo string
+	names      []*optionName
+	val        *optionValue
+	// the source location for the option, whose path is updated once the
+	// option is interpreted
+	loc   *dpb.SourceCodeInfo_Location
+	start *token
+}
+
+type optionName struct {
+	text        string
+	isExtension bool
+	tok         *token
+}
+
+type optionValueKind int
+
+const (
+	identValue optionValueKind = iota
+	intValue
+	floatValue
+	stringValue
+	aggregateValue
+)
+
+type optionValue struct {
+	kind optionValueKind
+	tok  *token
+	neg  bool
+	// ident or string value, or text format for an aggregate value
+	str string
+	// magnitude of an integer value
+	u uint64
+	f float64
+}
+
+type parser struct {
+	filename string
+	toks     []*token
+	pos      int
+	proto3   bool
+	res      *parseResult
+	sci      *dpb.SourceCodeInfo
+}
+
+// parseProto parses the given contents of a proto source file.
+func parseProto(filename string, contents []byte) (*parseResult, error) {
+	toks, err := newLexer(filename, contents).tokenize()
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{
+		filename: filename,
+		toks:     toks,
+		res:      &parseResult{nodes: map[interface{}]*node{}},
+		sci:      &dpb.SourceCodeInfo{},
+	}
+	if err := p.parseFile(); err != nil {
+		return nil, err
+	}
+	return p.res, nil
+}
+
+func (p *parser) cur() *token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) peekAt(offset int) *token {
+	if p.pos+offset >= len(p.toks) {
+		return p.toks[len(p.toks)-1]
+	}
+	return p.toks[p.pos+offset]
+}
+
+func (p *parser) prev() *token {
+	return p.toks[p.pos-1]
+}
+
+func (p *parser) next() *token {
+	t := p.toks[p.pos]
+	if t.typ != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) tryConsume(text string) bool {
+	if p.cur().is(text) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) (*token, error) {
+	if !p.cur().is(text) {
+		return nil, p.errorf(p.cur(), "syntax error: expecting %q, got %s", text, p.cur().describe())
+	}
+	return p.next(), nil
+}
+
+func (p *parser) expectIdent() (*token, error) {
+	if p.cur().typ != tokenIdent {
+		return nil, p.errorf(p.cur(), "syntax error: expecting identifier, got %s", p.cur().describe())
+	}
+	return p.next(), nil
+}
+
+// expectString consumes one or more adjacent string literals, which are
+// concatenated.
+func (p *parser) expectString() (string, *token, error) {
+	if p.cur().typ != tokenString {
+		return "", nil, p.errorf(p.cur(), "syntax error: expecting string literal, got %s", p.cur().describe())
+	}
+	var s string
+	for p.cur().typ == tokenString {
+		s += p.next().str
+	}
+	return s, p.prev(), nil
+}
+
+func (p *parser) expectInt(max uint64) (uint64, *token, error) {
+	t := p.cur()
+	if t.typ != tokenInt {
+		return 0, nil, p.errorf(t, "syntax error: expecting integer, got %s", t.describe())
+	}
+	v, err := strconv.ParseUint(t.text, 0, 64)
+	if err != nil || v > max {
+		return 0, nil, p.errorf(t, "integer %s is out of range", t.text)
+	}
+	return v, p.next(), nil
+}
+
+func (p *parser) errorf(t *token, format string, args ...interface{}) error {
+	return errorAt(p.filename, t, format, args...)
+}
+
+func errorAt(filename string, t *token, format string, args ...interface{}) error {
+	return ErrorWithSourcePos{
+		Pos:        &SourcePos{Filename: filename, Line: t.line + 1, Col: t.col + 1},
+		Underlying: fmt.Errorf(format, args...),
+	}
+}
+
+func appendPath(path []int32, elements ...int) []int32 {
+	ret := make([]int32, len(path), len(path)+len(elements))
+	copy(ret, path)
+	for _, e := range elements {
+		ret = append(ret, int32(e))
+	}
+	return ret
+}
+
+// newLoc adds a new source location with the given path. Its span is set
+// later, once the end of the element is known, but the location is created
+// first so that locations are ordered the same way protoc orders them.
+func (p *parser) newLoc(path []int32) *dpb.SourceCodeInfo_Location {
+	loc := &dpb.SourceCodeInfo_Location{Path: appendPath(path)}
+	p.sci.Location = append(p.sci.Location, loc)
+	return loc
+}
+
+func (p *parser) addLoc(path []int32, start, end *token) *dpb.SourceCodeInfo_Location {
+	loc := p.newLoc(path)
+	setSpan(loc, start, end)
+	return loc
+}
+
+func setSpan(loc *dpb.SourceCodeInfo_Location, start, end *token) {
+	if start.line == end.endLine {
+		loc.Span = []int32{int32(start.line), int32(start.col), int32(end.endCol)}
+	} else {
+		loc.Span = []int32{int32(start.line), int32(start.col), int32(end.endLine), int32(end.endCol)}
+	}
+}
+
+// attachComments sets the comments for the location of a declaration, which
+// starts with the given start token and whose end is the given token. The end
+// is the semicolon that terminates a declaration or the open brace that
+// starts the body of a declaration.
+func attachComments(loc *dpb.SourceCodeInfo_Location, start, end *token) {
+	loc.LeadingComments = start.leading
+	loc.LeadingDetachedComments = start.detached
+	loc.TrailingComments = end.trailing
+}
+
+func merge(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "." + b
+}
+
+func (p *parser) parseFile() error {
+	fd := &dpb.FileDescriptorProto{Name: proto.String(p.filename)}
+	p.res.fd = fd
+	root := p.newLoc(nil)
+	first := p.cur()
+
+	if first.is("syntax") {
+		loc := p.newLoc([]int32{file_syntaxTag})
+		p.next()
+		if _, err := p.expect("="); err != nil {
+			return err
+		}
+		strTok := p.cur()
+		syntax, _, err := p.expectString()
+		if err != nil {
+			return err
+		}
+		switch syntax {
+		case "proto2":
+		case "proto3":
+			p.proto3 = true
+			fd.Syntax = proto.String(syntax)
+		default:
+			return p.errorf(strTok, "syntax value must be \"proto2\" or \"proto3\"")
+		}
+		semi, err := p.expect(";")
+		if err != nil {
+			return err
+		}
+		setSpan(loc, first, semi)
+		attachComments(loc, first, semi)
+	}
+
+	for p.cur().typ != tokenEOF {
+		if err := p.parseTopLevel(fd); err != nil {
+			return err
+		}
+	}
+
+	if p.pos > 0 {
+		setSpan(root, first, p.prev())
+	} else {
+		setSpan(root, first, first)
+	}
+	fd.SourceCodeInfo = p.sci
+	return nil
+}
+
+func (p *parser) parseTopLevel(fd *dpb.FileDescriptorProto) error {
+	t := p.cur()
+	switch {
+	case t.is(";"):
+		p.next()
+		return nil
+	case t.is("package"):
+		return p.parsePackage(fd)
+	case t.is("import"):
+		return p.parseImport(fd)
+	case t.is("option"):
+		return p.parseOptionStatement(fd, []int32{file_optionsTag}, merge(fd.GetPackage(), "dummy"))
+	case t.is("message"):
+		msg, err := p.parseMessage([]int32{file_messagesTag, int32(len(fd.MessageType))}, fd.GetPackage())
+		if err != nil {
+			return err
+		}
+		fd.MessageType = append(fd.MessageType, msg)
+		return nil
+	case t.is("enum"):
+		en, err := p.parseEnum([]int32{file_enumsTag, int32(len(fd.EnumType))}, fd.GetPackage())
+		if err != nil {
+			return err
+		}
+		fd.EnumType = append(fd.EnumType, en)
+		return nil
+	case t.is("service"):
+		svc, err := p.parseService([]int32{file_servicesTag, int32(len(fd.Service))}, fd.GetPackage())
+		if err != nil {
+			return err
+		}
+		fd.Service = append(fd.Service, svc)
+		return nil
+	case t.is("extend"):
+		return p.parseExtend(&fd.Extension, []int32{file_extensionsTag}, &fd.MessageType, []int32{file_messagesTag}, fd.GetPackage())
+	default:
+		return p.errorf(t, "syntax error: unexpected %s", t.describe())
+	}
+}
+
+func (p *parser) parsePackage(fd *dpb.FileDescriptorProto) error {
+	loc := p.newLoc([]int32{file_packageTag})
+	start := p.next()
+	if fd.Package != nil {
+		return p.errorf(start, "multiple package declarations")
+	}
+	// like protoc, the span covers just the package name
+	name, nameStart, nameEnd, err := p.parseTypeName(false)
+	if err != nil {
+		return err
+	}
+	semi, err := p.expect(";")
+	if err != nil {
+		return err
+	}
+	fd.Package = proto.String(name)
+	setSpan(loc, nameStart, nameEnd)
+	attachComments(loc, start, semi)
+	return nil
+}
+
+func (p *parser) parseImport(fd *dpb.FileDescriptorProto) error {
+	loc := p.newLoc([]int32{file_dependencyTag, int32(len(fd.Dependency))})
+	start := p.next()
+	if p.cur().is("public") {
+		t := p.next()
+		p.addLoc([]int32{file_publicDependencyTag, int32(len(fd.PublicDependency))}, t, t)
+		fd.PublicDependency = append(fd.PublicDependency, int32(len(fd.Dependency)))
+	} else if p.cur().is("weak") {
+		t := p.next()
+		p.addLoc([]int32{file_weakDependencyTag, int32(len(fd.WeakDependency))}, t, t)
+		fd.WeakDependency = append(fd.WeakDependency, int32(len(fd.Dependency)))
+	}
+	name, _, err := p.expectString()
+	if err != nil {
+		return err
+	}
+	semi, err := p.expect(";")
+	if err != nil {
+		return err
+	}
+	for _, dep := range fd.Dependency {
+		if dep == name {
+			return p.errorf(start, "%q was listed twice", name)
+		}
+	}
+	fd.Dependency = append(fd.Dependency, name)
+	setSpan(loc, start, semi)
+	attachComments(loc, start, semi)
+	return nil
+}
+
+// parseTypeName parses a possibly-qualified name. If allowLeadingDot is true,
+// the name may be fully-qualified, with a leading dot.
+func (p *parser) parseTypeName(allowLeadingDot bool) (string, *token, *token, error) {
+	start := p.cur()
+	var name string
+	if allowLeadingDot && start.is(".") {
+		p.next()
+		name = "."
+	}
+	t, err := p.expectIdent()
+	if err != nil {
+		return "", nil, nil, err
+	}
+	name += t.text
+	for p.cur().is(".") {
+		p.next()
+		t, err := p.expectIdent()
+		if err != nil {
+			return "", nil, nil, err
+		}
+		name += "." + t.text
+	}
+	return name, start, p.prev(), nil
+}
+
+var scalarTypes = map[string]dpb.FieldDescriptorProto_Type{
+	"double":   dpb.FieldDescriptorProto_TYPE_DOUBLE,
+	"float":    dpb.FieldDescriptorProto_TYPE_FLOAT,
+	"int64":    dpb.FieldDescriptorProto_TYPE_INT64,
+	"uint64":   dpb.FieldDescriptorProto_TYPE_UINT64,
+	"int32":    dpb.FieldDescriptorProto_TYPE_INT32,
+	"fixed64":  dpb.FieldDescriptorProto_TYPE_FIXED64,
+	"fixed32":  dpb.FieldDescriptorProto_TYPE_FIXED32,
+	"bool":     dpb.FieldDescriptorProto_TYPE_BOOL,
+	"string":   dpb.FieldDescriptorProto_TYPE_STRING,
+	"group":    dpb.FieldDescriptorProto_TYPE_GROUP,
+	"bytes":    dpb.FieldDescriptorProto_TYPE_BYTES,
+	"uint32":   dpb.FieldDescriptorProto_TYPE_UINT32,
+	"sfixed32": dpb.FieldDescriptorProto_TYPE_SFIXED32,
+	"sfixed64": dpb.FieldDescriptorProto_TYPE_SFIXED64,
+	"sint32":   dpb.FieldDescriptorProto_TYPE_SINT32,
+	"sint64":   dpb.FieldDescriptorProto_TYPE_SINT64,
+}
+
+// parseType parses a field type, which is either the name of a scalar type
+// (or "group") or the name of a message or enum type. In the latter case, the
+// returned type is zero and the type name is non-empty.
+func (p *parser) parseType() (dpb.FieldDescriptorProto_Type, string, *token, *token, error) {
+	if t := p.cur(); t.typ == tokenIdent {
+		if typ, ok := scalarTypes[t.text]; ok {
+			p.next()
+			return typ, "", t, t, nil
+		}
+	}
+	name, start, end, err := p.parseTypeName(true)
+	return 0, name, start, end, err
+}
+
+func (p *parser) parseMessage(path []int32, scope string) (*dpb.DescriptorProto, error) {
+	loc := p.newLoc(path)
+	start := p.next()
+	nameTok, err := p.expectIdent()
+	if err != nil {
+		return nil, err
+	}
+	p.addLoc(appendPath(path, message_nameTag), nameTok, nameTok)
+	open, err := p.expect("{")
+	if err != nil {
+		return nil, err
+	}
+	attachComments(loc, start, open)
+
+	msg := &dpb.DescriptorProto{Name: proto.String(nameTok.text)}
+	p.res.nodes[msg] = &node{start: start, name: nameTok}
+	end, err := p.parseMessageBody(msg, path, merge(scope, nameTok.text))
+	if err != nil {
+		return nil, err
+	}
+	setSpan(loc, start, end)
+	return msg, nil
+}
+
+// parseMessageBody parses the declarations inside a message (or group) body,
+// up to and including the closing brace, which is returned.
+func (p *parser) parseMessageBody(msg *dpb.DescriptorProto, path []int32, fqn string) (*token, error) {
+	for {
+		t := p.cur()
+		var err error
+		switch {
+		case t.typ == tokenEOF:
+			return nil, p.errorf(t, "syntax error: unexpected end of file, expecting \"}\"")
+		case t.is("}"):
+			p.next()
+			p.addSyntheticOneOfs(msg)
+			return t, nil
+		case t.is(";"):
+			p.next()
+		case t.is("message"):
+			var nested *dpb.DescriptorProto
+			nested, err = p.parseMessage(appendPath(path, message_nestedMessagesTag, len(msg.NestedType)), fqn)
+			if err == nil {
+				msg.NestedType = append(msg.NestedType, nested)
+			}
+		case t.is("enum"):
+			var en *dpb.EnumDescriptorProto
+			en, err = p.parseEnum(appendPath(path, message_enumsTag, len(msg.EnumType)), fqn)
+			if err == nil {
+				msg.EnumType = append(msg.EnumType, en)
+			}
+		case t.is("extensions"):
+			err = p.parseExtensionRanges(msg, path, fqn)
+		case t.is("reserved"):
+			err = p.parseReserved(msg, path, false)
+		case t.is("extend"):
+			err = p.parseExtend(&msg.Extension, appendPath(path, message_extensionsTag), &msg.NestedType, appendPath(path, message_nestedMessagesTag), fqn)
+		case t.is("option"):
+			err = p.parseOptionStatement(msg, appendPath(path, message_optionsTag), fqn)
+		case t.is("oneof"):
+			err = p.parseOneOf(msg, path, fqn)
+		default:
+			var fld *dpb.FieldDescriptorProto
+			fld, err = p.parseField(appendPath(path, message_fieldsTag, len(msg.Field)), &fieldContext{
+				scope:    fqn,
+				msgs:     &msg.NestedType,
+				msgsPath: appendPath(path, message_nestedMessagesTag),
+			})
+			if err == nil {
+				msg.Field = append(msg.Field, fld)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// addSyntheticOneOfs adds a oneof for each proto3 optional field in the given
+// message. These synthetic oneofs always come after all other oneofs.
+func (p *parser) addSyntheticOneOfs(msg *dpb.DescriptorProto) {
+	names := map[string]bool{}
+	for _, fld := range msg.Field {
+		names[fld.GetName()] = true
+	}
+	for _, od := range msg.OneofDecl {
+		names[od.GetName()] = true
+	}
+	for _, fld := range msg.Field {
+		if !fld.GetProto3Optional() {
+			continue
+		}
+		name := fld.GetName()
+		if !strings.HasPrefix(name, "_") {
+			name = "_" + name
+		}
+		for names[name] {
+			name = "X" + name
+		}
+		names[name] = true
+		fld.OneofIndex = proto.Int32(int32(len(msg.OneofDecl)))
+		msg.OneofDecl = append(msg.OneofDecl, &dpb.OneofDescriptorProto{Name: proto.String(name)})
+	}
+}
+
+// fieldContext describes where a field is declared.
+type fieldContext struct {
+	// the fully-qualified name of the enclosing message, or the package for
+	// extensions that are declared at the top-level of the file
+	scope string
+	// where group and map entry messages are added
+	msgs     *[]*dpb.DescriptorProto
+	msgsPath []int32
+	// for extensions, the extended message
+	extendee      string
+	extendeeStart *token
+	extendeeEnd   *token
+	// for fields in a oneof, the index of the oneof
+	oneOfIndex *int32
+}
+
+func (p *parser) parseField(path []int32, ctx *fieldContext) (*dpb.FieldDescriptorProto, error) {
+	loc := p.newLoc(path)
+	start := p.cur()
+	fld := &dpb.FieldDescriptorProto{}
+	n := &node{start: start}
+	p.res.nodes[fld] = n
+
+	if ctx.extendeeStart != nil {
+		p.addLoc(appendPath(path, field_extendeeTag), ctx.extendeeStart, ctx.extendeeEnd)
+		fld.Extendee = proto.String(ctx.extendee)
+		n.extendee = ctx.extendeeStart
+	}
+
+	if t := p.cur(); t.is("optional") || t.is("repeated") || t.is("required") {
+		if ctx.oneOfIndex != nil {
+			return nil, p.errorf(t, "fields in oneofs must not have labels (required / optional / repeated)")
+		}
+		p.next()
+		p.addLoc(appendPath(path, field_labelTag), t, t)
+		switch t.text {
+		case "optional":
+			fld.Label = dpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+			if p.proto3 && ctx.extendeeStart == nil {
+				fld.Proto3Optional = proto.Bool(true)
+			}
+		case "repeated":
+			fld.Label = dpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		case "required":
+			if p.proto3 {
+				return nil, p.errorf(t, "required fields are not allowed in proto3")
+			}
+			fld.Label = dpb.FieldDescriptorProto_LABEL_REQUIRED.Enum()
+		}
+	}
+
+	// type
+	var isMap bool
+	var keyType, valType dpb.FieldDescriptorProto_Type
+	var keyTypeName, valTypeName string
+	if t := p.cur(); t.is("map") && p.peekAt(1).is("<") {
+		isMap = true
+		switch {
+		case fld.Label != nil:
+			return nil, p.errorf(start, "field labels (required/optional/repeated) are not allowed on map fields")
+		case ctx.oneOfIndex != nil:
+			return nil, p.errorf(t, "map fields are not allowed in oneofs")
+		case ctx.extendeeStart != nil:
+			return nil, p.errorf(t, "map fields are not allowed to be extensions")
+		}
+		fld.Label = dpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		p.next()
+		p.next()
+		var keyStart *token
+		var err error
+		if keyType, keyTypeName, keyStart, _, err = p.parseType(); err != nil {
+			return nil, err
+		}
+		switch keyType {
+		case dpb.FieldDescriptorProto_TYPE_DOUBLE, dpb.FieldDescriptorProto_TYPE_FLOAT,
+			dpb.FieldDescriptorProto_TYPE_BYTES, dpb.FieldDescriptorProto_TYPE_GROUP, 0:
+			return nil, p.errorf(keyStart, "key in map fields cannot be float/double, bytes, or message/enum types")
+		}
+		if _, err := p.expect(","); err != nil {
+			return nil, err
+		}
+		var valStart *token
+		if valType, valTypeName, valStart, _, err = p.parseType(); err != nil {
+			return nil, err
+		}
+		if valType == dpb.FieldDescriptorProto_TYPE_GROUP {
+			return nil, p.errorf(valStart, "map values cannot be groups")
+		}
+		end, err := p.expect(">")
+		if err != nil {
+			return nil, err
+		}
+		p.addLoc(appendPath(path, field_typeNameTag), t, end)
+		n.typ = t
+	} else {
+		if fld.Label == nil {
+			if !p.proto3 && ctx.oneOfIndex == nil {
+				return nil, p.errorf(t, "syntax error: expecting \"required\", \"optional\", or \"repeated\", got %s", t.describe())
+			}
+			fld.Label = dpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+		}
+		typ, typeName, typeStart, typeEnd, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		n.typ = typeStart
+		if typeName == "" {
+			if typ == dpb.FieldDescriptorProto_TYPE_GROUP {
+				if p.proto3 {
+					return nil, p.errorf(typeStart, "groups are not allowed in proto3")
+				}
+			}
+			p.addLoc(appendPath(path, field_typeTag), typeStart, typeEnd)
+			fld.Type = typ.Enum()
+		} else {
+			p.addLoc(appendPath(path, field_typeNameTag), typeStart, typeEnd)
+			fld.TypeName = proto.String(typeName)
+		}
+	}
+
+	nameTok, err := p.expectIdent()
+	if err != nil {
+		return nil, err
+	}
+	n.name = nameTok
+	p.addLoc(appendPath(path, field_nameTag), nameTok, nameTok)
+	fld.Name = proto.String(nameTok.text)
+
+	if _, err := p.expect("="); err != nil {
+		return nil, err
+	}
+	num, numTok, err := p.expectInt(math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+	n.number = numTok
+	p.addLoc(appendPath(path, field_numberTag), numTok, numTok)
+	if err := checkTag(p.filename, numTok, num); err != nil {
+		return nil, err
+	}
+	fld.Number = proto.Int32(int32(num))
+	if ctx.oneOfIndex != nil {
+		fld.OneofIndex = proto.Int32(*ctx.oneOfIndex)
+	}
+
+	if p.cur().is("[") {
+		if err := p.parseFieldOptions(fld, path, merge(ctx.scope, fld.GetName())); err != nil {
+			return nil, err
+		}
+	}
+
+	if fld.GetType() == dpb.FieldDescriptorProto_TYPE_GROUP {
+		name := nameTok.text
+		if name[0] < 'A' || name[0] > 'Z' {
+			return nil, p.errorf(nameTok, "group names must start with a capital letter")
+		}
+		groupPath := appendPath(ctx.msgsPath, len(*ctx.msgs))
+		groupLoc := p.newLoc(groupPath)
+		p.addLoc(appendPath(groupPath, message_nameTag), nameTok, nameTok)
+		p.addLoc(appendPath(path, field_typeNameTag), nameTok, nameTok)
+		fld.Name = proto.String(strings.ToLower(name))
+		fld.TypeName = proto.String(name)
+		open, err := p.expect("{")
+		if err != nil {
+			return nil, err
+		}
+		attachComments(groupLoc, start, open)
+		group := &dpb.DescriptorProto{Name: proto.String(name)}
+		p.res.nodes[group] = &node{start: start, name: nameTok}
+		*ctx.msgs = append(*ctx.msgs, group)
+		end, err := p.parseMessageBody(group, groupPath, merge(ctx.scope, name))
+		if err != nil {
+			return nil, err
+		}
+		setSpan(groupLoc, start, end)
+		setSpan(loc, start, end)
+	} else {
+		semi, err := p.expect(";")
+		if err != nil {
+			return nil, err
+		}
+		setSpan(loc, start, semi)
+		attachComments(loc, start, semi)
+	}
+
+	if isMap {
+		entryName := mapEntryName(fld.GetName())
+		fld.TypeName = proto.String(entryName)
+		entry := &dpb.DescriptorProto{
+			Name:    proto.String(entryName),
+			Options: &dpb.MessageOptions{MapEntry: proto.Bool(true)},
+			Field: []*dpb.FieldDescriptorProto{
+				mapEntryField("key", 1, keyType, keyTypeName),
+				mapEntryField("value", 2, valType, valTypeName),
+			},
+		}
+		p.res.nodes[entry] = n
+		p.res.nodes[entry.Field[0]] = n
+		p.res.nodes[entry.Field[1]] = n
+		*ctx.msgs = append(*ctx.msgs, entry)
+	}
+
+	if fld.JsonName == nil {
+		fld.JsonName = proto.String(desc.DefaultJSONName(fld.GetName()))
+	}
+	return fld, nil
+}
+
+func checkTag(filename string, t *token, tag uint64) error {
+	switch {
+	case tag < 1:
+		return errorAt(filename, t, "tag number %d must be greater than zero", tag)
+	case tag > maxTag:
+		return errorAt(filename, t, "tag number %d is higher than max allowed tag number (%d)", tag, maxTag)
+	case tag >= specialReservedStart && tag <= specialReservedEnd:
+		return errorAt(filename, t, "tag number %d is in disallowed reserved range %d-%d", tag, specialReservedStart, specialReservedEnd)
+	}
+	return nil
+}
+
+func mapEntryField(name string, number int32, typ dpb.FieldDescriptorProto_Type, typeName string) *dpb.FieldDescriptorProto {
+	fld := &dpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		Number:   proto.Int32(number),
+		Label:    dpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		JsonName: proto.String(name),
+	}
+	if typeName == "" {
+		fld.Type = typ.Enum()
+	} else {
+		fld.TypeName = proto.String(typeName)
+	}
+	return fld
+}
+
+// mapEntryName computes the name of the synthesized message for a map field,
+// e.g. "FooBarEntry" for a field named "foo_bar".
+func mapEntryName(fieldName string) string {
+	var b strings.Builder
+	capNext := true
+	for _, r := range fieldName {
+		if r == '_' {
+			capNext = true
+		} else if capNext {
+			if r >= 'a' && r <= 'z' {
+				r -= 'a' - 'A'
+			}
+			b.WriteRune(r)
+			capNext = false
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	b.WriteString("Entry")
+	return b.String()
+}
+
+func (p *parser) parseFieldOptions(fld *dpb.FieldDescriptorProto, path []int32, relativeTo string) error {
+	optsPath := appendPath(path, field_optionsTag)
+	loc := p.newLoc(optsPath)
+	open := p.next()
+	for {
+		t := p.cur()
+		var err error
+		switch {
+		case t.is("default"):
+			err = p.parseDefault(fld, path)
+		case t.is("json_name"):
+			err = p.parseJSONName(fld, path)
+		default:
+			err = p.parseOption(fld, optsPath, relativeTo, false)
+		}
+		if err != nil {
+			return err
+		}
+		if !p.tryConsume(",") {
+			break
+		}
+	}
+	end, err := p.expect("]")
+	if err != nil {
+		return err
+	}
+	setSpan(loc, open, end)
+	return nil
+}
+
+func (p *parser) parseJSONName(fld *dpb.FieldDescriptorProto, path []int32) error {
+	loc := p.newLoc(appendPath(path, field_jsonNameTag))
+	start := p.next()
+	if fld.Extendee != nil {
+		return p.errorf(start, "option json_name is not allowed on extensions")
+	}
+	if fld.JsonName != nil {
+		return p.errorf(start, "option json_name was already set")
+	}
+	if _, err := p.expect("="); err != nil {
+		return err
+	}
+	name, end, err := p.expectString()
+	if err != nil {
+		return err
+	}
+	fld.JsonName = proto.String(name)
+	setSpan(loc, start, end)
+	return nil
+}
+
+func (p *parser) parseDefault(fld *dpb.FieldDescriptorProto, path []int32) error {
+	loc := p.newLoc(appendPath(path, field_defaultTag))
+	start := p.next()
+	n := p.res.nodes[fld]
+	switch {
+	case fld.DefaultValue != nil:
+		return p.errorf(start, "default value already set")
+	case p.proto3:
+		return p.errorf(start, "default values are not allowed in proto3")
+	case fld.GetLabel() == dpb.FieldDescriptorProto_LABEL_REPEATED:
+		return p.errorf(start, "repeated fields cannot have default values")
+	case fld.GetType() == dpb.FieldDescriptorProto_TYPE_GROUP:
+		return p.errorf(start, "groups cannot have default values")
+	}
+	if _, err := p.expect("="); err != nil {
+		return err
+	}
+	n.deflt = p.cur()
+
+	var val string
+	var typ dpb.FieldDescriptorProto_Type
+	if fld.Type != nil {
+		typ = fld.GetType()
+	}
+	switch typ {
+	case 0:
+		// message or enum, which we can't know until the type is resolved;
+		// the linker will verify that it is a valid enum value name
+		t := p.next()
+		if t.typ == tokenEOF {
+			return p.errorf(t, "syntax error: expecting default value, got %s", t.describe())
+		}
+		val = t.text
+
+	case dpb.FieldDescriptorProto_TYPE_FLOAT, dpb.FieldDescriptorProto_TYPE_DOUBLE:
+		if p.tryConsume("-") {
+			val = "-"
+		}
+		t := p.next()
+		var f float64
+		switch {
+		case t.typ == tokenInt || t.typ == tokenFloat:
+			if t.typ == tokenInt {
+				u, err := strconv.ParseUint(t.text, 0, 64)
+				if err != nil {
+					return p.errorf(t, "integer %s is out of range", t.text)
+				}
+				f = float64(u)
+			} else {
+				var err error
+				if f, err = strconv.ParseFloat(t.text, 64); err != nil {
+					return p.errorf(t, "invalid number %s", t.text)
+				}
+			}
+		case t.is("inf"):
+			f = math.Inf(1)
+		case t.is("nan"):
+			f = math.NaN()
+		default:
+			return p.errorf(t, "syntax error: expecting number, got %s", t.describe())
+		}
+		val += formatFloat(f)
+
+	case dpb.FieldDescriptorProto_TYPE_INT32, dpb.FieldDescriptorProto_TYPE_SINT32, dpb.FieldDescriptorProto_TYPE_SFIXED32,
+		dpb.FieldDescriptorProto_TYPE_INT64, dpb.FieldDescriptorProto_TYPE_SINT64, dpb.FieldDescriptorProto_TYPE_SFIXED64:
+		max := uint64(math.MaxInt64)
+		if is32Bit(typ) {
+			max = math.MaxInt32
+		}
+		if p.tryConsume("-") {
+			val = "-"
+			max++
+		}
+		u, _, err := p.expectInt(max)
+		if err != nil {
+			return err
+		}
+		val += strconv.FormatUint(u, 10)
+
+	case dpb.FieldDescriptorProto_TYPE_UINT32, dpb.FieldDescriptorProto_TYPE_FIXED32,
+		dpb.FieldDescriptorProto_TYPE_UINT64, dpb.FieldDescriptorProto_TYPE_FIXED64:
+		max := uint64(math.MaxUint64)
+		if is32Bit(typ) {
+			max = math.MaxUint32
+		}
+		u, _, err := p.expectInt(max)
+		if err != nil {
+			return err
+		}
+		val = strconv.FormatUint(u, 10)
+
+	case dpb.FieldDescriptorProto_TYPE_BOOL:
+		t := p.next()
+		if !t.is("true") && !t.is("false") {
+			return p.errorf(t, "syntax error: expecting \"true\" or \"false\", got %s", t.describe())
+		}
+		val = t.text
+
+	case dpb.FieldDescriptorProto_TYPE_STRING:
+		s, _, err := p.expectString()
+		if err != nil {
+			return err
+		}
+		val = s
+
+	case dpb.FieldDescriptorProto_TYPE_BYTES:
+		s, _, err := p.expectString()
+		if err != nil {
+			return err
+		}
+		val = cEscape(s)
+	}
+
+	fld.DefaultValue = proto.String(val)
+	setSpan(loc, start, p.prev())
+	return nil
+}
+
+func is32Bit(t dpb.FieldDescriptorProto_Type) bool {
+	switch t {
+	case dpb.FieldDescriptorProto_TYPE_INT32, dpb.FieldDescriptorProto_TYPE_SINT32, dpb.FieldDescriptorProto_TYPE_SFIXED32,
+		dpb.FieldDescriptorProto_TYPE_UINT32, dpb.FieldDescriptorProto_TYPE_FIXED32:
+		return true
+	}
+	return false
+}
+
+// formatFloat formats the given value the same way as protoc when it stores
+// a default value in a field descriptor.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 0):
+		return "inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	s := strconv.FormatFloat(f, 'g', 15, 64)
+	if v, err := strconv.ParseFloat(s, 64); err != nil || v != f {
+		s = strconv.FormatFloat(f, 'g', 17, 64)
+	}
+	return s
+}
+
+// cEscape escapes the given bytes the same way as protoc when it stores the
+// default value for a bytes field in a field descriptor.
+func cEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '\n':
+			b.WriteString("\\n")
+		case '\r':
+			b.WriteString("\\r")
+		case '\t':
+			b.WriteString("\\t")
+		case '"':
+			b.WriteString("\\\"")
+		case '\'':
+			b.WriteString("\\'")
+		case '\\':
+			b.WriteString("\\\\")
+		default:
+			if c < ' ' || c >= 0x7f {
+				fmt.Fprintf(&b, "\\%03o", c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	return b.String()
+}
+
+// parseOptionStatement parses an option declaration that is its own
+// statement (as opposed to one in a bracketed list after a field or enum
+// value).
+func (p *parser) parseOptionStatement(elem proto.Message, optsPath []int32, relativeTo string) error {
+	loc := p.newLoc(optsPath)
+	start := p.cur()
+	if err := p.parseOption(elem, optsPath, relativeTo, true); err != nil {
+		return err
+	}
+	setSpan(loc, start, p.prev())
+	return nil
+}
+
+// parseCompactOptions parses a bracketed list of options, like those that
+// can follow an enum value or extension range.
+func (p *parser) parseCompactOptions(elem proto.Message, optsPath []int32, relativeTo string) error {
+	loc := p.newLoc(optsPath)
+	open := p.next()
+	for {
+		if err := p.parseOption(elem, optsPath, relativeTo, false); err != nil {
+			return err
+		}
+		if !p.tryConsume(",") {
+			break
+		}
+	}
+	end, err := p.expect("]")
+	if err != nil {
+		return err
+	}
+	setSpan(loc, open, end)
+	return nil
+}
+
+func (p *parser) parseOption(elem proto.Message, optsPath []int32, relativeTo string, statement bool) error {
+	opts := optionsFor(elem)
+	index := 0
+	for _, o := range p.res.options {
+		if o.opts == opts {
+			index++
+		}
+	}
+	loc := p.newLoc(appendPath(optsPath, uninterpretedOptionsTag, index))
+	start := p.cur()
+	if statement {
+		p.next()
+	}
+
+	var names []*optionName
+	for {
+		if t := p.cur(); t.is("(") {
+			p.next()
+			name, _, _, err := p.parseTypeName(true)
+			if err != nil {
+				return err
+			}
+			if _, err := p.expect(")"); err != nil {
+				return err
+			}
+			names = append(names, &optionName{text: name, isExtension: true, tok: t})
+		} else {
+			t, err := p.expectIdent()
+			if err != nil {
+				return err
+			}
+			names = append(names, &optionName{text: t.text, tok: t})
+		}
+		if !p.tryConsume(".") {
+			break
+		}
+	}
+	if _, err := p.expect("="); err != nil {
+		return err
+	}
+	val, err := p.parseOptionValue()
+	if err != nil {
+		return err
+	}
+	if statement {
+		semi, err := p.expect(";")
+		if err != nil {
+			return err
+		}
+		attachComments(loc, start, semi)
+	}
+	setSpan(loc, start, p.prev())
+
+	p.res.options = append(p.res.options, &optionDecl{
+		elem:       elem,
+		opts:       opts,
+		relativeTo: relativeTo,
+		names:      names,
+		val:        val,
+		loc:        loc,
+		start:      start,
+	})
+	return nil
+}
+
+func (p *parser) parseOptionValue() (*optionValue, error) {
+	val := &optionValue{tok: p.cur()}
+	if p.tryConsume("-") {
+		val.neg = true
+	}
+	t := p.cur()
+	switch t.typ {
+	case tokenIdent:
+		if val.neg && !t.is("inf") && !t.is("nan") {
+			return nil, p.errorf(t, "syntax error: invalid '-' symbol before identifier")
+		}
+		p.next()
+		val.kind = identValue
+		val.str = t.text
+	case tokenInt:
+		p.next()
+		u, err := strconv.ParseUint(t.text, 0, 64)
+		if err != nil || (val.neg && u > math.MaxInt64+1) {
+			return nil, p.errorf(t, "integer %s is out of range", t.text)
+		}
+		val.kind = intValue
+		val.u = u
+	case tokenFloat:
+		p.next()
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf(t, "invalid number %s", t.text)
+		}
+		val.kind = floatValue
+		val.f = f
+	case tokenString:
+		if val.neg {
+			return nil, p.errorf(t, "syntax error: invalid '-' symbol before string")
+		}
+		s, _, _ := p.expectString()
+		val.kind = stringValue
+		val.str = s
+	default:
+		if val.neg || !t.is("{") {
+			return nil, p.errorf(t, "syntax error: expecting option value, got %s", t.describe())
+		}
+		// aggregate values are in the text format; we collect the text
+		// here and parse it once we know the type of the option
+		p.next()
+		var parts []string
+		depth := 1
+		for {
+			t := p.next()
+			switch {
+			case t.typ == tokenEOF:
+				return nil, p.errorf(t, "syntax error: unexpected end of file in aggregate value")
+			case t.is("{"):
+				depth++
+			case t.is("}"):
+				depth--
+			}
+			if depth == 0 {
+				break
+			}
+			parts = append(parts, t.text)
+		}
+		val.kind = aggregateValue
+		val.str = strings.Join(parts, " ")
+	}
+	return val, nil
+}
+
+// optionsFor returns the options message for the given element, allocating it
+// if necessary.
+func optionsFor(elem proto.Message) proto.Message {
+	switch e := elem.(type) {
+	case *dpb.FileDescriptorProto:
+		if e.Options == nil {
+			e.Options = &dpb.FileOptions{}
+		}
+		return e.Options
+	case *dpb.DescriptorProto:
+		if e.Options == nil {
+			e.Options = &dpb.MessageOptions{}
+		}
+		return e.Options
+	case *dpb.FieldDescriptorProto:
+		if e.Options == nil {
+			e.Options = &dpb.FieldOptions{}
+		}
+		return e.Options
+	case *dpb.OneofDescriptorProto:
+		if e.Options == nil {
+			e.Options = &dpb.OneofOptions{}
+		}
+		return e.Options
+	case *dpb.DescriptorProto_ExtensionRange:
+		if e.Options == nil {
+			e.Options = &dpb.ExtensionRangeOptions{}
+		}
+		return e.Options
+	case *dpb.EnumDescriptorProto:
+		if e.Options == nil {
+			e.Options = &dpb.EnumOptions{}
+		}
+		return e.Options
+	case *dpb.EnumValueDescriptorProto:
+		if e.Options == nil {
+			e.Options = &dpb.EnumValueOptions{}
+		}
+		return e.Options
+	case *dpb.ServiceDescriptorProto:
+		if e.Options == nil {
+			e.Options = &dpb.ServiceOptions{}
+		}
+		return e.Options
+	case *dpb.MethodDescriptorProto:
+		if e.Options == nil {
+			e.Options = &dpb.MethodOptions{}
+		}
+		return e.Options
+	default:
+		panic(fmt.Sprintf("unexpected element type %T", elem))
+	}
+}
+
+func (p *parser) parseOneOf(msg *dpb.DescriptorProto, msgPath []int32, scope string) error {
+	index := int32(len(msg.OneofDecl))
+	path := appendPath(msgPath, message_oneOfsTag, int(index))
+	loc := p.newLoc(path)
+	start := p.next()
+	nameTok, err := p.expectIdent()
+	if err != nil {
+		return err
+	}
+	p.addLoc(appendPath(path, oneOf_nameTag), nameTok, nameTok)
+	open, err := p.expect("{")
+	if err != nil {
+		return err
+	}
+	attachComments(loc, start, open)
+
+	od := &dpb.OneofDescriptorProto{Name: proto.String(nameTok.text)}
+	p.res.nodes[od] = &node{start: start, name: nameTok}
+	msg.OneofDecl = append(msg.OneofDecl, od)
+	fqn := merge(scope, nameTok.text)
+	var count int
+	for {
+		t := p.cur()
+		switch {
+		case t.typ == tokenEOF:
+			return p.errorf(t, "syntax error: unexpected end of file, expecting \"}\"")
+		case t.is("}"):
+			p.next()
+			if count == 0 {
+				return p.errorf(start, "oneof must contain at least one field")
+			}
+			setSpan(loc, start, t)
+			return nil
+		case t.is(";"):
+			p.next()
+		case t.is("option"):
+			if err := p.parseOptionStatement(od, appendPath(path, oneOf_optionsTag), fqn); err != nil {
+				return err
+			}
+		default:
+			fld, err := p.parseField(appendPath(msgPath, message_fieldsTag, len(msg.Field)), &fieldContext{
+				scope:      scope,
+				msgs:       &msg.NestedType,
+				msgsPath:   appendPath(msgPath, message_nestedMessagesTag),
+				oneOfIndex: &index,
+			})
+			if err != nil {
+				return err
+			}
+			msg.Field = append(msg.Field, fld)
+			count++
+		}
+	}
+}
+
+func (p *parser) parseExtend(exts *[]*dpb.FieldDescriptorProto, extsPath []int32, msgs *[]*dpb.DescriptorProto, msgsPath []int32, scope string) error {
+	loc := p.newLoc(extsPath)
+	start := p.next()
+	extendee, extStart, extEnd, err := p.parseTypeName(true)
+	if err != nil {
+		return err
+	}
+	open, err := p.expect("{")
+	if err != nil {
+		return err
+	}
+	attachComments(loc, start, open)
+
+	var count int
+	for {
+		t := p.cur()
+		switch {
+		case t.typ == tokenEOF:
+			return p.errorf(t, "syntax error: unexpected end of file, expecting \"}\"")
+		case t.is("}"):
+			p.next()
+			if count == 0 {
+				return p.errorf(start, "extend sections must define at least one extension")
+			}
+			setSpan(loc, start, t)
+			return nil
+		case t.is(";"):
+			p.next()
+		default:
+			fld, err := p.parseField(appendPath(extsPath, len(*exts)), &fieldContext{
+				scope:         scope,
+				msgs:          msgs,
+				msgsPath:      msgsPath,
+				extendee:      extendee,
+				extendeeStart: extStart,
+				extendeeEnd:   extEnd,
+			})
+			if err != nil {
+				return err
+			}
+			*exts = append(*exts, fld)
+			count++
+		}
+	}
+}
+
+// parseRange parses a single range of tag numbers in an extensions or
+// reserved statement. The returned end is inclusive. If allowNegative is
+// true (for enum reserved ranges), the values may be negative and "max"
+// refers to the max int32 value.
+func (p *parser) parseRange(path []int32, startTag, endTag int, allowNegative bool) (start, end int64, isMax bool, err error) {
+	parseNum := func() (int64, *token, error) {
+		t := p.cur()
+		neg := allowNegative && p.tryConsume("-")
+		max := uint64(maxTag)
+		if allowNegative {
+			max = math.MaxInt32
+			if neg {
+				max++
+			}
+		}
+		u, end, err := p.expectInt(max)
+		if err != nil {
+			return 0, nil, err
+		}
+		v := int64(u)
+		if neg {
+			v = -v
+		}
+		p.addLoc(appendPath(path, startTag), t, end)
+		return v, t, nil
+	}
+
+	start, startTok, err := parseNum()
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if !p.tryConsume("to") {
+		p.addLoc(appendPath(path, endTag), startTok, p.prev())
+		return start, start, false, nil
+	}
+	if t := p.cur(); t.is("max") {
+		p.next()
+		p.addLoc(appendPath(path, endTag), t, t)
+		if allowNegative {
+			return start, math.MaxInt32, true, nil
+		}
+		return start, maxTag, true, nil
+	}
+	// the end location is created before the end value is parsed
+	t := p.cur()
+	neg := allowNegative && p.tryConsume("-")
+	max := uint64(maxTag)
+	if allowNegative {
+		max = math.MaxInt32
+		if neg {
+			max++
+		}
+	}
+	u, endTok, err := p.expectInt(max)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	p.addLoc(appendPath(path, endTag), t, endTok)
+	end = int64(u)
+	if neg {
+		end = -end
+	}
+	if end < start {
+		return 0, 0, false, p.errorf(startTok, "range, %d to %d, is invalid: start must be <= end", start, end)
+	}
+	return start, end, false, nil
+}
+
+func (p *parser) parseExtensionRanges(msg *dpb.DescriptorProto, msgPath []int32, fqn string) error {
+	path := appendPath(msgPath, message_extensionRangeTag)
+	loc := p.newLoc(path)
+	start := p.next()
+	first := len(msg.ExtensionRange)
+	for {
+		rangePath := appendPath(path, len(msg.ExtensionRange))
+		rangeLoc := p.newLoc(rangePath)
+		rangeStart := p.cur()
+		s, e, isMax, err := p.parseRange(rangePath, extensionRange_startTag, extensionRange_endTag, false)
+		if err != nil {
+			return err
+		}
+		if err := checkTag(p.filename, rangeStart, uint64(s)); err != nil {
+			return err
+		}
+		setSpan(rangeLoc, rangeStart, p.prev())
+		er := &dpb.DescriptorProto_ExtensionRange{Start: proto.Int32(int32(s)), End: proto.Int32(int32(e + 1))}
+		p.res.nodes[er] = &node{start: rangeStart, isMax: isMax}
+		msg.ExtensionRange = append(msg.ExtensionRange, er)
+		if !p.tryConsume(",") {
+			break
+		}
+	}
+	if p.cur().is("[") {
+		// options apply to all ranges in the statement
+		numOpts := len(p.res.options)
+		firstRange := msg.ExtensionRange[first]
+		if err := p.parseCompactOptions(firstRange, appendPath(path, first, extensionRange_optionsTag), fqn); err != nil {
+			return err
+		}
+		decls := p.res.options[numOpts:]
+		for i := first + 1; i < len(msg.ExtensionRange); i++ {
+			er := msg.ExtensionRange[i]
+			opts := optionsFor(er)
+			for j, o := range decls {
+				loc := p.newLoc(appendPath(path, i, extensionRange_optionsTag, uninterpretedOptionsTag, j))
+				loc.Span = o.loc.Span
+				clone := *o
+				clone.elem, clone.opts, clone.loc = er, opts, loc
+				p.res.options = append(p.res.options, &clone)
+			}
+		}
+	}
+	semi, err := p.expect(";")
+	if err != nil {
+		return err
+	}
+	setSpan(loc, start, semi)
+	attachComments(loc, start, semi)
+	return nil
+}
+
+func (p *parser) parseReserved(elem proto.Message, elemPath []int32, isEnum bool) error {
+	start := p.next()
+	rangesTag, namesTag := message_reservedRangeTag, message_reservedNameTag
+	if isEnum {
+		rangesTag, namesTag = enum_reservedRangeTag, enum_reservedNameTag
+	}
+
+	if p.cur().typ == tokenString {
+		path := appendPath(elemPath, namesTag)
+		loc := p.newLoc(path)
+		for {
+			var names *[]string
+			if isEnum {
+				names = &elem.(*dpb.EnumDescriptorProto).ReservedName
+			} else {
+				names = &elem.(*dpb.DescriptorProto).ReservedName
+			}
+			t := p.cur()
+			name, end, err := p.expectString()
+			if err != nil {
+				return err
+			}
+			p.addLoc(appendPath(path, len(*names)), t, end)
+			*names = append(*names, name)
+			if !p.tryConsume(",") {
+				break
+			}
+		}
+		semi, err := p.expect(";")
+		if err != nil {
+			return err
+		}
+		setSpan(loc, start, semi)
+		attachComments(loc, start, semi)
+		return nil
+	}
+
+	path := appendPath(elemPath, rangesTag)
+	loc := p.newLoc(path)
+	for {
+		var index int
+		if isEnum {
+			index = len(elem.(*dpb.EnumDescriptorProto).ReservedRange)
+		} else {
+			index = len(elem.(*dpb.DescriptorProto).ReservedRange)
+		}
+		rangePath := appendPath(path, index)
+		rangeLoc := p.newLoc(rangePath)
+		rangeStart := p.cur()
+		s, e, isMax, err := p.parseRange(rangePath, reservedRange_startTag, reservedRange_endTag, isEnum)
+		if err != nil {
+			return err
+		}
+		setSpan(rangeLoc, rangeStart, p.prev())
+		n := &node{start: rangeStart, isMax: isMax}
+		if isEnum {
+			en := elem.(*dpb.EnumDescriptorProto)
+			rr := &dpb.EnumDescriptorProto_EnumReservedRange{Start: proto.Int32(int32(s)), End: proto.Int32(int32(e))}
+			p.res.nodes[rr] = n
+			en.ReservedRange = append(en.ReservedRange, rr)
+		} else {
+			if err := checkTag(p.filename, rangeStart, uint64(s)); err != nil {
+				return err
+			}
+			msg := elem.(*dpb.DescriptorProto)
+			rr := &dpb.DescriptorProto_ReservedRange{Start: proto.Int32(int32(s)), End: proto.Int32(int32(e + 1))}
+			p.res.nodes[rr] = n
+			msg.ReservedRange = append(msg.ReservedRange, rr)
+		}
+		if !p.tryConsume(",") {
+			break
+		}
+	}
+	semi, err := p.expect(";")
+	if err != nil {
+		return err
+	}
+	setSpan(loc, start, semi)
+	attachComments(loc, start, semi)
+	return nil
+}
+
+func (p *parser) parseEnum(path []int32, scope string) (*dpb.EnumDescriptorProto, error) {
+	loc := p.newLoc(path)
+	start := p.next()
+	nameTok, err := p.expectIdent()
+	if err != nil {
+		return nil, err
+	}
+	p.addLoc(appendPath(path, enum_nameTag), nameTok, nameTok)
+	open, err := p.expect("{")
+	if err != nil {
+		return nil, err
+	}
+	attachComments(loc, start, open)
+
+	en := &dpb.EnumDescriptorProto{Name: proto.String(nameTok.text)}
+	p.res.nodes[en] = &node{start: start, name: nameTok}
+	fqn := merge(scope, nameTok.text)
+	for {
+		t := p.cur()
+		var err error
+		switch {
+		case t.typ == tokenEOF:
+			return nil, p.errorf(t, "syntax error: unexpected end of file, expecting \"}\"")
+		case t.is("}"):
+			p.next()
+			if len(en.Value) == 0 {
+				return nil, p.errorf(start, "enums must define at least one value")
+			}
+			if p.proto3 && en.Value[0].GetNumber() != 0 {
+				return nil, p.errorf(p.res.nodes[en.Value[0]].number, "the first value in a proto3 enum must be zero")
+			}
+			setSpan(loc, start, t)
+			return en, nil
+		case t.is(";"):
+			p.next()
+		case t.is("option"):
+			err = p.parseOptionStatement(en, appendPath(path, enum_optionsTag), fqn)
+		case t.is("reserved"):
+			err = p.parseReserved(en, path, true)
+		default:
+			var val *dpb.EnumValueDescriptorProto
+			val, err = p.parseEnumValue(appendPath(path, enum_valuesTag, len(en.Value)), scope)
+			if err == nil {
+				en.Value = append(en.Value, val)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseEnumValue(path []int32, scope string) (*dpb.EnumValueDescriptorProto, error) {
+	loc := p.newLoc(path)
+	nameTok, err := p.expectIdent()
+	if err != nil {
+		return nil, err
+	}
+	p.addLoc(appendPath(path, enumVal_nameTag), nameTok, nameTok)
+	if _, err := p.expect("="); err != nil {
+		return nil, err
+	}
+	numStart := p.cur()
+	neg := p.tryConsume("-")
+	max := uint64(math.MaxInt32)
+	if neg {
+		max++
+	}
+	u, numEnd, err := p.expectInt(max)
+	if err != nil {
+		return nil, err
+	}
+	p.addLoc(appendPath(path, enumVal_numberTag), numStart, numEnd)
+	num := int64(u)
+	if neg {
+		num = -num
+	}
+	val := &dpb.EnumValueDescriptorProto{Name: proto.String(nameTok.text), Number: proto.Int32(int32(num))}
+	p.res.nodes[val] = &node{start: nameTok, name: nameTok, number: numStart}
+
+	if p.cur().is("[") {
+		// enum values are siblings of their enum, not children
+		if err := p.parseCompactOptions(val, appendPath(path, enumVal_optionsTag), merge(scope, nameTok.text)); err != nil {
+			return nil, err
+		}
+	}
+	semi, err := p.expect(";")
+	if err != nil {
+		return nil, err
+	}
+	setSpan(loc, nameTok, semi)
+	attachComments(loc, nameTok, semi)
+	return val, nil
+}
+
+func (p *parser) parseService(path []int32, scope string) (*dpb.ServiceDescriptorProto, error) {
+	loc := p.newLoc(path)
+	start := p.next()
+	nameTok, err := p.expectIdent()
+	if err != nil {
+		return nil, err
+	}
+	p.addLoc(appendPath(path, service_nameTag), nameTok, nameTok)
+	open, err := p.expect("{")
+	if err != nil {
+		return nil, err
+	}
+	attachComments(loc, start, open)
+
+	svc := &dpb.ServiceDescriptorProto{Name: proto.String(nameTok.text)}
+	p.res.nodes[svc] = &node{start: start, name: nameTok}
+	fqn := merge(scope, nameTok.text)
+	for {
+		t := p.cur()
+		var err error
+		switch {
+		case t.typ == tokenEOF:
+			return nil, p.errorf(t, "syntax error: unexpected end of file, expecting \"}\"")
+		case t.is("}"):
+			p.next()
+			setSpan(loc, start, t)
+			return svc, nil
+		case t.is(";"):
+			p.next()
+		case t.is("option"):
+			err = p.parseOptionStatement(svc, appendPath(path, service_optionsTag), fqn)
+		case t.is("rpc"):
+			var mtd *dpb.MethodDescriptorProto
+			mtd, err = p.parseMethod(appendPath(path, service_methodsTag, len(svc.Method)), fqn)
+			if err == nil {
+				svc.Method = append(svc.Method, mtd)
+			}
+		default:
+			err = p.errorf(t, "syntax error: unexpected %s, expecting \"rpc\" or \"option\"", t.describe())
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseMethod(path []int32, scope string) (*dpb.MethodDescriptorProto, error) {
+	loc := p.newLoc(path)
+	start := p.next()
+	nameTok, err := p.expectIdent()
+	if err != nil {
+		return nil, err
+	}
+	p.addLoc(appendPath(path, method_nameTag), nameTok, nameTok)
+	mtd := &dpb.MethodDescriptorProto{Name: proto.String(nameTok.text)}
+	n := &node{start: start, name: nameTok}
+	p.res.nodes[mtd] = n
+
+	parseMessageType := func(streamTag, typeTag int) (string, bool, *token, error) {
+		if _, err := p.expect("("); err != nil {
+			return "", false, nil, err
+		}
+		var stream bool
+		if t := p.cur(); t.is("stream") && p.peekAt(1).typ == tokenIdent {
+			p.next()
+			p.addLoc(appendPath(path, streamTag), t, t)
+			stream = true
+		}
+		name, typeStart, typeEnd, err := p.parseTypeName(true)
+		if err != nil {
+			return "", false, nil, err
+		}
+		p.addLoc(appendPath(path, typeTag), typeStart, typeEnd)
+		if _, err := p.expect(")"); err != nil {
+			return "", false, nil, err
+		}
+		return name, stream, typeStart, nil
+	}
+
+	input, clientStream, inTok, err := parseMessageType(method_clientStreamingTag, method_inputTag)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect("returns"); err != nil {
+		return nil, err
+	}
+	output, serverStream, outTok, err := parseMessageType(method_serverStreamingTag, method_outputTag)
+	if err != nil {
+		return nil, err
+	}
+	mtd.InputType = proto.String(input)
+	mtd.OutputType = proto.String(output)
+	if clientStream {
+		mtd.ClientStreaming = proto.Bool(true)
+	}
+	if serverStream {
+		mtd.ServerStreaming = proto.Bool(true)
+	}
+	n.input, n.output = inTok, outTok
+
+	if t := p.cur(); t.is(";") {
+		p.next()
+		setSpan(loc, start, t)
+		attachComments(loc, start, t)
+		return mtd, nil
+	}
+	open, err := p.expect("{")
+	if err != nil {
+		return nil, err
+	}
+	attachComments(loc, start, open)
+	fqn := merge(scope, nameTok.text)
+	for {
+		t := p.cur()
+		switch {
+		case t.is("}"):
+			p.next()
+			setSpan(loc, start, t)
+			return mtd, nil
+		case t.is(";"):
+			p.next()
+		case t.is("option"):
+			if err := p.parseOptionStatement(mtd, appendPath(path, method_optionsTag), fqn); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, p.errorf(t, "syntax error: unexpected %s, expecting \"option\" or \"}\"", t.describe())
+		}
+	}
+}
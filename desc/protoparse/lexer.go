@@ -0,0 +1,559 @@
+package protoparse
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type tokenType int
+
+const (
+	tokenEOF tokenType = iota
+	tokenIdent
+	tokenInt
+	tokenFloat
+	tokenString
+	tokenSymbol
+)
+
+func (t tokenType) String() string {
+	switch t {
+	case tokenEOF:
+		return "end of file"
+	case tokenIdent:
+		return "identifier"
+	case tokenInt:
+		return "integer"
+	case tokenFloat:
+		return "number"
+	case tokenString:
+		return "string literal"
+	default:
+		return "symbol"
+	}
+}
+
+// tabWidth is the number of columns a tab character advances to. This matches
+// protoc so that source code info spans agree with those it computes.
+const tabWidth = 8
+
+// token is a single lexical element of a proto source file. Line and column
+// values are zero-based, the same as spans in source code info.
+type token struct {
+	typ  tokenType
+	text string
+	// for string literals, the un-escaped value
+	str string
+
+	line, col       int
+	endLine, endCol int
+
+	// comments that precede the token
+	leading  *string
+	detached []string
+	// comments that follow the token
+	trailing *string
+}
+
+func (t *token) is(text string) bool {
+	return (t.typ == tokenSymbol || t.typ == tokenIdent) && t.text == text
+}
+
+func (t *token) describe() string {
+	if t.typ == tokenEOF {
+		return t.typ.String()
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// lexer turns the contents of a proto source file into tokens. Comments are
+// attributed to tokens using the same rules as protoc's tokenizer: a comment
+// on the same line as a token (or a block of comments on the lines following
+// it, if separated from the next token by a blank line) is a trailing comment
+// for that token; the block of comments immediately preceding a token is its
+// leading comment; and other comment blocks in between are detached.
+type lexer struct {
+	filename  string
+	input     []byte
+	pos       int
+	line, col int
+	prev      *token
+}
+
+func newLexer(filename string, input []byte) *lexer {
+	// ignore a UTF-8 byte order mark
+	input = bytes.TrimPrefix(input, []byte{0xef, 0xbb, 0xbf})
+	return &lexer{filename: filename, input: input}
+}
+
+// tokenize reads all tokens in the input. The last token is always of type
+// tokenEOF.
+func (l *lexer) tokenize() ([]*token, error) {
+	var toks []*token
+	for {
+		t, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		toks = append(toks, t)
+		if t.typ == tokenEOF {
+			return toks, nil
+		}
+	}
+}
+
+func (l *lexer) errorf(line, col int, format string, args ...interface{}) error {
+	return ErrorWithSourcePos{
+		Pos:        &SourcePos{Filename: l.filename, Line: line + 1, Col: col + 1},
+		Underlying: fmt.Errorf(format, args...),
+	}
+}
+
+func (l *lexer) peek() byte {
+	if l.pos >= len(l.input) {
+		return 0
+	}
+	return l.input[l.pos]
+}
+
+func (l *lexer) peekAt(offset int) byte {
+	if l.pos+offset >= len(l.input) {
+		return 0
+	}
+	return l.input[l.pos+offset]
+}
+
+func (l *lexer) advance() {
+	if l.pos >= len(l.input) {
+		return
+	}
+	switch l.input[l.pos] {
+	case '\n':
+		l.line++
+		l.col = 0
+	case '\t':
+		l.col += tabWidth - l.col%tabWidth
+	default:
+		l.col++
+	}
+	l.pos++
+}
+
+func (l *lexer) tryConsume(c byte) bool {
+	if l.pos < len(l.input) && l.input[l.pos] == c {
+		l.advance()
+		return true
+	}
+	return false
+}
+
+func isWhitespaceNoNewline(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func (l *lexer) skipWhitespaceNoNewline() {
+	for l.pos < len(l.input) && isWhitespaceNoNewline(l.input[l.pos]) {
+		l.advance()
+	}
+}
+
+type commentStart int
+
+const (
+	noComment commentStart = iota
+	lineComment
+	blockComment
+)
+
+func (l *lexer) tryCommentStart() commentStart {
+	if l.peek() == '/' && l.peekAt(1) == '/' {
+		l.advance()
+		l.advance()
+		return lineComment
+	}
+	if l.peek() == '/' && l.peekAt(1) == '*' {
+		l.advance()
+		l.advance()
+		return blockComment
+	}
+	return noComment
+}
+
+func (l *lexer) readLineComment() string {
+	start := l.pos
+	for l.pos < len(l.input) && l.input[l.pos] != '\n' {
+		l.advance()
+	}
+	l.tryConsume('\n')
+	return string(l.input[start:l.pos])
+}
+
+func (l *lexer) readBlockComment() (string, error) {
+	startLine, startCol := l.line, l.col-2
+	var buf bytes.Buffer
+	start := l.pos
+	for {
+		if l.pos >= len(l.input) {
+			return "", l.errorf(startLine, startCol, "block comment never terminates, unexpected end of file")
+		}
+		c := l.input[l.pos]
+		if c == '\n' {
+			l.advance()
+			buf.Write(l.input[start:l.pos])
+			// leading whitespace and asterisk of subsequent lines are not
+			// part of the comment text
+			l.skipWhitespaceNoNewline()
+			if l.peek() == '*' {
+				l.advance()
+				if l.tryConsume('/') {
+					return buf.String(), nil
+				}
+			}
+			start = l.pos
+		} else if c == '*' && l.peekAt(1) == '/' {
+			buf.Write(l.input[start:l.pos])
+			l.advance()
+			l.advance()
+			return buf.String(), nil
+		} else {
+			l.advance()
+		}
+	}
+}
+
+// commentCollector accumulates comments between two tokens and decides which
+// are trailing comments for the previous token, which are detached, and which
+// is the leading comment for the next one.
+type commentCollector struct {
+	prev            *token
+	canAttachToPrev bool
+	detached        []string
+	buf             string
+	hasComment      bool
+	isLineComment   bool
+}
+
+func (c *commentCollector) addLineComment(text string) {
+	// consecutive line comments are combined, but not with block comments
+	if c.hasComment && !c.isLineComment {
+		c.flush()
+	}
+	c.buf += text
+	c.hasComment = true
+	c.isLineComment = true
+}
+
+func (c *commentCollector) addBlockComment(text string) {
+	if c.hasComment {
+		c.flush()
+	}
+	c.buf = text
+	c.hasComment = true
+	c.isLineComment = false
+}
+
+func (c *commentCollector) clear() {
+	c.buf = ""
+	c.hasComment = false
+}
+
+// flush is called when the buffered comment is known to not be connected to
+// the next token.
+func (c *commentCollector) flush() {
+	if !c.hasComment {
+		return
+	}
+	if c.canAttachToPrev {
+		s := c.buf
+		c.prev.trailing = &s
+		c.canAttachToPrev = false
+	} else {
+		c.detached = append(c.detached, c.buf)
+	}
+	c.clear()
+}
+
+func (l *lexer) next() (*token, error) {
+	cc := commentCollector{prev: l.prev, canAttachToPrev: l.prev != nil}
+	if l.prev != nil {
+		// a comment on the same line belongs to the previous token
+		l.skipWhitespaceNoNewline()
+		switch l.tryCommentStart() {
+		case lineComment:
+			cc.addLineComment(l.readLineComment())
+			cc.flush()
+		case blockComment:
+			text, err := l.readBlockComment()
+			if err != nil {
+				return nil, err
+			}
+			cc.addBlockComment(text)
+			l.skipWhitespaceNoNewline()
+			if !l.tryConsume('\n') {
+				// the next token is on the same line, so we can't tell to
+				// which token the comment belongs
+				cc.clear()
+				return l.readToken(&cc)
+			}
+			cc.flush()
+		default:
+			if !l.tryConsume('\n') {
+				return l.readToken(&cc)
+			}
+		}
+	}
+
+	// now we are on the line after the previous token
+	for {
+		l.skipWhitespaceNoNewline()
+		switch l.tryCommentStart() {
+		case lineComment:
+			cc.addLineComment(l.readLineComment())
+		case blockComment:
+			text, err := l.readBlockComment()
+			if err != nil {
+				return nil, err
+			}
+			cc.addBlockComment(text)
+			l.skipWhitespaceNoNewline()
+			l.tryConsume('\n')
+		default:
+			if l.tryConsume('\n') {
+				// blank line
+				cc.flush()
+				cc.canAttachToPrev = false
+			} else {
+				return l.readToken(&cc)
+			}
+		}
+	}
+}
+
+func (l *lexer) readToken(cc *commentCollector) (*token, error) {
+	t, err := l.scan()
+	if err != nil {
+		return nil, err
+	}
+	if t.typ == tokenEOF || t.is("}") || t.is("]") || t.is(")") {
+		// at the end of a scope, so it makes no sense to attach
+		// a comment to the following token
+		cc.flush()
+	}
+	if cc.hasComment {
+		s := cc.buf
+		t.leading = &s
+	}
+	t.detached = cc.detached
+	l.prev = t
+	return t, nil
+}
+
+func isLetter(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// scan reads the next token, which must begin at the current position (or
+// the input is exhausted).
+func (l *lexer) scan() (*token, error) {
+	t := &token{line: l.line, col: l.col}
+	start := l.pos
+	if l.pos >= len(l.input) {
+		t.typ = tokenEOF
+		t.endLine, t.endCol = l.line, l.col
+		return t, nil
+	}
+
+	c := l.input[l.pos]
+	switch {
+	case isLetter(c):
+		t.typ = tokenIdent
+		for isLetter(l.peek()) || isDigit(l.peek()) {
+			l.advance()
+		}
+
+	case isDigit(c) || (c == '.' && isDigit(l.peekAt(1))):
+		var err error
+		if t.typ, err = l.scanNumber(); err != nil {
+			return nil, err
+		}
+
+	case c == '"' || c == '\'':
+		t.typ = tokenString
+		var err error
+		if t.str, err = l.scanString(); err != nil {
+			return nil, err
+		}
+
+	case c < ' ' || c >= utf8.RuneSelf:
+		return nil, l.errorf(l.line, l.col, "invalid character %q", c)
+
+	default:
+		t.typ = tokenSymbol
+		l.advance()
+	}
+
+	t.text = string(l.input[start:l.pos])
+	t.endLine, t.endCol = l.line, l.col
+	return t, nil
+}
+
+func (l *lexer) scanNumber() (tokenType, error) {
+	line, col := l.line, l.col
+	typ := tokenInt
+	if l.peek() == '0' && (l.peekAt(1) == 'x' || l.peekAt(1) == 'X') {
+		l.advance()
+		l.advance()
+		if !isHexDigit(l.peek()) {
+			return 0, l.errorf(line, col, "\"0x\" must be followed by hex digits")
+		}
+		for isHexDigit(l.peek()) {
+			l.advance()
+		}
+	} else if l.peek() == '0' && isDigit(l.peekAt(1)) {
+		for isDigit(l.peek()) {
+			if l.peek() > '7' {
+				return 0, l.errorf(line, col, "numbers starting with leading zero must be in octal")
+			}
+			l.advance()
+		}
+	} else {
+		if l.peek() == '.' {
+			typ = tokenFloat
+			l.advance()
+		}
+		for isDigit(l.peek()) {
+			l.advance()
+		}
+		if typ == tokenInt && l.tryConsume('.') {
+			typ = tokenFloat
+			for isDigit(l.peek()) {
+				l.advance()
+			}
+		}
+		if l.peek() == 'e' || l.peek() == 'E' {
+			typ = tokenFloat
+			l.advance()
+			if !l.tryConsume('-') {
+				l.tryConsume('+')
+			}
+			if !isDigit(l.peek()) {
+				return 0, l.errorf(line, col, "\"e\" must be followed by exponent")
+			}
+			for isDigit(l.peek()) {
+				l.advance()
+			}
+		}
+	}
+	if isLetter(l.peek()) {
+		return 0, l.errorf(l.line, l.col, "need space between number and identifier")
+	} else if l.peek() == '.' {
+		if typ == tokenFloat {
+			return 0, l.errorf(l.line, l.col, "already saw decimal point or exponent; can't have another one")
+		}
+		return 0, l.errorf(l.line, l.col, "hex and octal numbers must be integers")
+	}
+	return typ, nil
+}
+
+func (l *lexer) scanString() (string, error) {
+	line, col := l.line, l.col
+	delim := l.input[l.pos]
+	l.advance()
+	var buf bytes.Buffer
+	for {
+		if l.pos >= len(l.input) || l.peek() == '\n' {
+			return "", l.errorf(line, col, "string literal not terminated")
+		}
+		c := l.input[l.pos]
+		if c == delim {
+			l.advance()
+			return buf.String(), nil
+		}
+		if c != '\\' {
+			buf.WriteByte(c)
+			l.advance()
+			continue
+		}
+		escLine, escCol := l.line, l.col
+		l.advance()
+		c = l.peek()
+		switch c {
+		case 'a':
+			buf.WriteByte('\a')
+		case 'b':
+			buf.WriteByte('\b')
+		case 'f':
+			buf.WriteByte('\f')
+		case 'n':
+			buf.WriteByte('\n')
+		case 'r':
+			buf.WriteByte('\r')
+		case 't':
+			buf.WriteByte('\t')
+		case 'v':
+			buf.WriteByte('\v')
+		case '\\', '?', '\'', '"':
+			buf.WriteByte(c)
+		case 'x', 'X':
+			l.advance()
+			var v int
+			n := 0
+			for ; n < 2 && isHexDigit(l.peek()); n++ {
+				d, _ := strconv.ParseInt(string(l.peek()), 16, 8)
+				v = v*16 + int(d)
+				l.advance()
+			}
+			if n == 0 {
+				return "", l.errorf(escLine, escCol, "expecting hex digits after \\x escape")
+			}
+			buf.WriteByte(byte(v))
+			continue
+		case 'u', 'U':
+			digits := 4
+			if c == 'U' {
+				digits = 8
+			}
+			l.advance()
+			var v rune
+			for i := 0; i < digits; i++ {
+				if !isHexDigit(l.peek()) {
+					return "", l.errorf(escLine, escCol, "expecting %d hex digits after \\%c escape", digits, c)
+				}
+				d, _ := strconv.ParseInt(string(l.peek()), 16, 8)
+				v = v*16 + rune(d)
+				l.advance()
+			}
+			if !utf8.ValidRune(v) {
+				return "", l.errorf(escLine, escCol, "invalid unicode code point in \\%c escape", c)
+			}
+			buf.WriteRune(v)
+			continue
+		default:
+			if c >= '0' && c <= '7' {
+				var v int
+				for n := 0; n < 3 && l.peek() >= '0' && l.peek() <= '7'; n++ {
+					v = v*8 + int(l.peek()-'0')
+					l.advance()
+				}
+				if v > 0xff {
+					return "", l.errorf(escLine, escCol, "octal escape is out of range")
+				}
+				buf.WriteByte(byte(v))
+				continue
+			}
+			return "", l.errorf(escLine, escCol, "invalid escape sequence: \\%s", strings.TrimSpace(string(c)))
+		}
+		l.advance()
+	}
+}
@@ -0,0 +1,694 @@
+package protoparse
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/jhump/protoreflect/desc"
+)
+
+type symbolKind int
+
+const (
+	symbolPackage symbolKind = iota
+	symbolMessage
+	symbolEnum
+	symbolEnumValue
+	symbolField
+	symbolOneOf
+	symbolService
+	symbolMethod
+)
+
+func (k symbolKind) String() string {
+	switch k {
+	case symbolPackage:
+		return "a package"
+	case symbolMessage:
+		return "a message"
+	case symbolEnum:
+		return "an enum"
+	case symbolEnumValue:
+		return "an enum value"
+	case symbolField:
+		return "a field"
+	case symbolOneOf:
+		return "a oneof"
+	case symbolService:
+		return "a service"
+	default:
+		return "a method"
+	}
+}
+
+// symbol is an element that can be referenced by name.
+type symbol struct {
+	kind symbolKind
+	file *dpb.FileDescriptorProto
+	// the element's descriptor proto; nil for packages
+	elem proto.Message
+}
+
+// linker resolves references between elements in parsed files and then
+// creates rich descriptors for them.
+type linker struct {
+	files   map[string]*parseResult
+	protos  map[string]*dpb.FileDescriptorProto
+	linked  map[string]*desc.FileDescriptor
+	symbols map[string]map[string]*symbol
+}
+
+func newLinker(files map[string]*parseResult) *linker {
+	return &linker{
+		files:   files,
+		protos:  map[string]*dpb.FileDescriptorProto{},
+		linked:  map[string]*desc.FileDescriptor{},
+		symbols: map[string]map[string]*symbol{},
+	}
+}
+
+func (l *linker) linkFile(filename string) (*desc.FileDescriptor, error) {
+	if fd, ok := l.linked[filename]; ok {
+		return fd, nil
+	}
+	res := l.files[filename]
+	if res == nil {
+		// not parsed from source, so it must be a standard import
+		fd, err := desc.LoadFileDescriptor(filename)
+		if err != nil {
+			return nil, err
+		}
+		l.protos[filename] = fd.AsFileDescriptorProto()
+		if _, err := l.fileSymbols(filename, nil); err != nil {
+			return nil, err
+		}
+		l.linked[filename] = fd
+		return fd, nil
+	}
+
+	deps := make([]*desc.FileDescriptor, len(res.fd.GetDependency()))
+	for i, dep := range res.fd.GetDependency() {
+		fd, err := l.linkFile(dep)
+		if err != nil {
+			return nil, err
+		}
+		deps[i] = fd
+	}
+
+	l.protos[filename] = res.fd
+	r := &resolver{l: l, res: res, filename: filename}
+	if err := r.init(); err != nil {
+		return nil, err
+	}
+	if err := r.resolveReferences(); err != nil {
+		return nil, err
+	}
+	fd, err := desc.CreateFileDescriptor(res.fd, deps...)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.interpretOptions(fd); err != nil {
+		return nil, err
+	}
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+	l.linked[filename] = fd
+	return fd, nil
+}
+
+// fileSymbols computes the table of symbols defined in the given file. If
+// the file was parsed from source, its parse result is given, for reporting
+// the positions of duplicate symbols.
+func (l *linker) fileSymbols(filename string, res *parseResult) (map[string]*symbol, error) {
+	if syms, ok := l.symbols[filename]; ok {
+		return syms, nil
+	}
+	fd := l.protos[filename]
+	b := symbolTableBuilder{syms: map[string]*symbol{}, file: fd, res: res}
+	if err := b.addFile(); err != nil {
+		return nil, err
+	}
+	l.symbols[filename] = b.syms
+	return b.syms, nil
+}
+
+type symbolTableBuilder struct {
+	syms map[string]*symbol
+	file *dpb.FileDescriptorProto
+	res  *parseResult
+}
+
+func (b *symbolTableBuilder) add(fqn string, kind symbolKind, elem proto.Message) error {
+	if existing, ok := b.syms[fqn]; ok {
+		if existing.kind == symbolPackage && kind == symbolPackage {
+			return nil
+		}
+		return b.errorf(elem, "symbol %q already defined", fqn)
+	}
+	b.syms[fqn] = &symbol{kind: kind, file: b.file, elem: elem}
+	return nil
+}
+
+func (b *symbolTableBuilder) errorf(elem proto.Message, format string, args ...interface{}) error {
+	if b.res != nil {
+		if n := b.res.nodes[elem]; n != nil {
+			t := n.name
+			if t == nil {
+				t = n.start
+			}
+			return errorAt(b.file.GetName(), t, format, args...)
+		}
+	}
+	return fmt.Errorf("%s: %s", b.file.GetName(), fmt.Sprintf(format, args...))
+}
+
+func (b *symbolTableBuilder) addFile() error {
+	if pkg := b.file.GetPackage(); pkg != "" {
+		parts := strings.Split(pkg, ".")
+		for i := range parts {
+			if err := b.add(strings.Join(parts[:i+1], "."), symbolPackage, nil); err != nil {
+				return err
+			}
+		}
+	}
+	scope := b.file.GetPackage()
+	for _, msg := range b.file.GetMessageType() {
+		if err := b.addMessage(scope, msg); err != nil {
+			return err
+		}
+	}
+	for _, en := range b.file.GetEnumType() {
+		if err := b.addEnum(scope, en); err != nil {
+			return err
+		}
+	}
+	for _, ext := range b.file.GetExtension() {
+		if err := b.add(merge(scope, ext.GetName()), symbolField, ext); err != nil {
+			return err
+		}
+	}
+	for _, svc := range b.file.GetService() {
+		svcName := merge(scope, svc.GetName())
+		if err := b.add(svcName, symbolService, svc); err != nil {
+			return err
+		}
+		for _, mtd := range svc.GetMethod() {
+			if err := b.add(merge(svcName, mtd.GetName()), symbolMethod, mtd); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *symbolTableBuilder) addMessage(scope string, msg *dpb.DescriptorProto) error {
+	fqn := merge(scope, msg.GetName())
+	if err := b.add(fqn, symbolMessage, msg); err != nil {
+		return err
+	}
+	for _, fld := range msg.GetField() {
+		if err := b.add(merge(fqn, fld.GetName()), symbolField, fld); err != nil {
+			return err
+		}
+	}
+	for _, ext := range msg.GetExtension() {
+		if err := b.add(merge(fqn, ext.GetName()), symbolField, ext); err != nil {
+			return err
+		}
+	}
+	for _, od := range msg.GetOneofDecl() {
+		if err := b.add(merge(fqn, od.GetName()), symbolOneOf, od); err != nil {
+			return err
+		}
+	}
+	for _, nested := range msg.GetNestedType() {
+		if err := b.addMessage(fqn, nested); err != nil {
+			return err
+		}
+	}
+	for _, en := range msg.GetEnumType() {
+		if err := b.addEnum(fqn, en); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *symbolTableBuilder) addEnum(scope string, en *dpb.EnumDescriptorProto) error {
+	if err := b.add(merge(scope, en.GetName()), symbolEnum, en); err != nil {
+		return err
+	}
+	// enum values follow C++ scoping rules: they are siblings of their enum,
+	// not children of it
+	for _, val := range en.GetValue() {
+		if existing, ok := b.syms[merge(scope, val.GetName())]; ok && existing.kind == symbolEnumValue {
+			return b.errorf(val, "symbol %q already defined; note that enum values use C++ scoping rules, so they must be unique within %q, not just within the enum", merge(scope, val.GetName()), scope)
+		}
+		if err := b.add(merge(scope, val.GetName()), symbolEnumValue, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolver resolves references in a single file.
+type resolver struct {
+	l        *linker
+	res      *parseResult
+	filename string
+	// symbol tables for this file and all files visible to it (its direct
+	// dependencies and any files they publicly import)
+	visible []map[string]*symbol
+	// names of the files visible to this one, not including itself
+	visibleFiles []string
+}
+
+func (r *resolver) init() error {
+	syms, err := r.l.fileSymbols(r.filename, r.res)
+	if err != nil {
+		return err
+	}
+	r.visible = append(r.visible, syms)
+	seen := map[string]bool{r.filename: true}
+	for _, dep := range r.res.fd.GetDependency() {
+		if err := r.addVisible(dep, seen); err != nil {
+			return err
+		}
+	}
+
+	// symbols in this file must not collide with those in dependencies
+	for _, fqn := range sortedSymbolNames(syms) {
+		sym := syms[fqn]
+		if sym.kind == symbolPackage {
+			continue
+		}
+		for _, other := range r.visible[1:] {
+			if o, ok := other[fqn]; ok && o.kind != symbolPackage {
+				b := symbolTableBuilder{file: r.res.fd, res: r.res}
+				return b.errorf(sym.elem, "symbol %q already defined in %q", fqn, o.file.GetName())
+			}
+		}
+	}
+	return nil
+}
+
+func (r *resolver) addVisible(filename string, seen map[string]bool) error {
+	if seen[filename] {
+		return nil
+	}
+	seen[filename] = true
+	syms, err := r.l.fileSymbols(filename, r.l.files[filename])
+	if err != nil {
+		return err
+	}
+	r.visible = append(r.visible, syms)
+	r.visibleFiles = append(r.visibleFiles, filename)
+	fd := r.l.protos[filename]
+	for _, i := range fd.GetPublicDependency() {
+		if err := r.addVisible(fd.GetDependency()[i], seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedSymbolNames(syms map[string]*symbol) []string {
+	names := make([]string, 0, len(syms))
+	for n := range syms {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *resolver) find(fqn string) *symbol {
+	for _, syms := range r.visible {
+		if sym, ok := syms[fqn]; ok {
+			return sym
+		}
+	}
+	return nil
+}
+
+// lookup resolves a possibly-relative name using the same rules as protoc.
+// The name is resolved relative to the given fully-qualified name of an
+// element. The first component of the name is searched for in each enclosing
+// scope, from innermost to outermost. Once found, the rest of the name must
+// be resolvable from there. If typesOnly is true, then an unqualified name
+// only matches messages and enums.
+func (r *resolver) lookup(relativeTo, name string, typesOnly bool) (string, *symbol) {
+	if strings.HasPrefix(name, ".") {
+		return name[1:], r.find(name[1:])
+	}
+	firstPart := name
+	if dot := strings.IndexByte(name, '.'); dot >= 0 {
+		firstPart = name[:dot]
+	}
+	scope := relativeTo
+	for {
+		dot := strings.LastIndexByte(scope, '.')
+		if dot < 0 {
+			return name, r.find(name)
+		}
+		scope = scope[:dot]
+		candidate := scope + "." + firstPart
+		if sym := r.find(candidate); sym != nil {
+			if firstPart != name {
+				if sym.kind == symbolPackage || sym.kind == symbolMessage || sym.kind == symbolEnum || sym.kind == symbolService {
+					fqn := scope + "." + name
+					return fqn, r.find(fqn)
+				}
+			} else if !typesOnly || sym.kind == symbolMessage || sym.kind == symbolEnum {
+				return candidate, sym
+			}
+		}
+	}
+}
+
+func (r *resolver) errorf(elem interface{}, tok func(n *node) *token, format string, args ...interface{}) error {
+	if n := r.res.nodes[elem]; n != nil {
+		t := n.start
+		if tok != nil {
+			if tt := tok(n); tt != nil {
+				t = tt
+			}
+		}
+		return errorAt(r.filename, t, format, args...)
+	}
+	return fmt.Errorf("%s: %s", r.filename, fmt.Sprintf(format, args...))
+}
+
+func nameTok(n *node) *token     { return n.name }
+func numberTok(n *node) *token   { return n.number }
+func typeTok(n *node) *token     { return n.typ }
+func extendeeTok(n *node) *token { return n.extendee }
+func defaultTok(n *node) *token  { return n.deflt }
+
+func (r *resolver) resolveReferences() error {
+	fd := r.res.fd
+	scope := fd.GetPackage()
+	for _, msg := range fd.GetMessageType() {
+		if err := r.resolveMessage(scope, msg); err != nil {
+			return err
+		}
+	}
+	for _, ext := range fd.GetExtension() {
+		if err := r.resolveField(scope, ext); err != nil {
+			return err
+		}
+	}
+	for _, svc := range fd.GetService() {
+		svcName := merge(scope, svc.GetName())
+		for _, mtd := range svc.GetMethod() {
+			mtdName := merge(svcName, mtd.GetName())
+			in, err := r.resolveMessageType(mtd, mtdName, mtd.GetInputType(), func(n *node) *token { return n.input })
+			if err != nil {
+				return err
+			}
+			out, err := r.resolveMessageType(mtd, mtdName, mtd.GetOutputType(), func(n *node) *token { return n.output })
+			if err != nil {
+				return err
+			}
+			mtd.InputType = proto.String(in)
+			mtd.OutputType = proto.String(out)
+		}
+	}
+	return nil
+}
+
+func (r *resolver) resolveMessageType(elem proto.Message, relativeTo, name string, tok func(n *node) *token) (string, error) {
+	fqn, sym := r.lookup(relativeTo, name, true)
+	if sym == nil {
+		return "", r.errorf(elem, tok, "%s: unknown type %s", relativeTo, name)
+	}
+	if sym.kind != symbolMessage {
+		return "", r.errorf(elem, tok, "%s: invalid type: %s is %v, not a message", relativeTo, fqn, sym.kind)
+	}
+	return "." + fqn, nil
+}
+
+func (r *resolver) resolveMessage(scope string, msg *dpb.DescriptorProto) error {
+	fqn := merge(scope, msg.GetName())
+	for _, fld := range msg.GetField() {
+		if err := r.resolveField(fqn, fld); err != nil {
+			return err
+		}
+	}
+	for _, ext := range msg.GetExtension() {
+		if err := r.resolveField(fqn, ext); err != nil {
+			return err
+		}
+	}
+	for _, nested := range msg.GetNestedType() {
+		if err := r.resolveMessage(fqn, nested); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *resolver) resolveField(scope string, fld *dpb.FieldDescriptorProto) error {
+	fqn := merge(scope, fld.GetName())
+	if fld.Extendee != nil {
+		extendee, err := r.resolveMessageType(fld, fqn, fld.GetExtendee(), extendeeTok)
+		if err != nil {
+			return err
+		}
+		fld.Extendee = proto.String(extendee)
+		if r.res.fd.GetSyntax() == "proto3" && !isOptionsMessage(extendee[1:]) {
+			return r.errorf(fld, extendeeTok, "%s: extensions in proto3 are only allowed for defining options", fqn)
+		}
+		// the tag must be in one of the extendee's extension ranges
+		target := r.find(extendee[1:]).elem.(*dpb.DescriptorProto)
+		var found bool
+		for _, er := range target.GetExtensionRange() {
+			if fld.GetNumber() >= er.GetStart() && fld.GetNumber() < er.GetEnd() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return r.errorf(fld, numberTok, "%s: tag %d is not in valid range for extended type %s", fqn, fld.GetNumber(), extendee[1:])
+		}
+	}
+
+	if fld.TypeName == nil {
+		return nil
+	}
+	typeName, sym := r.lookup(fqn, fld.GetTypeName(), true)
+	if sym == nil {
+		return r.errorf(fld, typeTok, "%s: unknown type %s", fqn, fld.GetTypeName())
+	}
+	switch sym.kind {
+	case symbolMessage:
+		if fld.Type == nil {
+			fld.Type = dpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+		}
+		if fld.DefaultValue != nil {
+			return r.errorf(fld, defaultTok, "%s: default values are not allowed for message fields", fqn)
+		}
+	case symbolEnum:
+		if fld.Type != nil {
+			// groups must refer to messages
+			return r.errorf(fld, typeTok, "%s: invalid type: %s is an enum, not a message", fqn, typeName)
+		}
+		fld.Type = dpb.FieldDescriptorProto_TYPE_ENUM.Enum()
+		if r.res.fd.GetSyntax() == "proto3" && sym.file.GetSyntax() != "proto3" {
+			return r.errorf(fld, typeTok, "%s: cannot use proto2 enum %s in a proto3 message", fqn, typeName)
+		}
+		if fld.DefaultValue != nil {
+			en := sym.elem.(*dpb.EnumDescriptorProto)
+			var found bool
+			for _, val := range en.GetValue() {
+				if val.GetName() == fld.GetDefaultValue() {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return r.errorf(fld, defaultTok, "%s: enum %s has no value named %s", fqn, typeName, fld.GetDefaultValue())
+			}
+		}
+	default:
+		return r.errorf(fld, typeTok, "%s: invalid type: %s is %v, not a message or enum", fqn, typeName, sym.kind)
+	}
+	fld.TypeName = proto.String("." + typeName)
+	return nil
+}
+
+func isOptionsMessage(fqn string) bool {
+	switch fqn {
+	case "google.protobuf.FileOptions", "google.protobuf.MessageOptions", "google.protobuf.FieldOptions",
+		"google.protobuf.OneofOptions", "google.protobuf.ExtensionRangeOptions", "google.protobuf.EnumOptions",
+		"google.protobuf.EnumValueOptions", "google.protobuf.ServiceOptions", "google.protobuf.MethodOptions":
+		return true
+	}
+	return false
+}
+
+// validate checks the constraints that can only be verified once all
+// references are resolved and all options interpreted.
+func (r *resolver) validate() error {
+	fd := r.res.fd
+	scope := fd.GetPackage()
+	for _, msg := range fd.GetMessageType() {
+		if err := r.validateMessage(scope, msg); err != nil {
+			return err
+		}
+	}
+	for _, en := range fd.GetEnumType() {
+		if err := r.validateEnum(scope, en); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *resolver) validateMessage(scope string, msg *dpb.DescriptorProto) error {
+	fqn := merge(scope, msg.GetName())
+	proto3 := r.res.fd.GetSyntax() == "proto3"
+
+	if msg.GetOptions().GetMessageSetWireFormat() {
+		// message sets can use extension numbers all the way up to max int32
+		for _, er := range msg.GetExtensionRange() {
+			if n := r.res.nodes[er]; n != nil && n.isMax {
+				er.End = proto.Int32(1<<31 - 1)
+			}
+		}
+	}
+
+	if proto3 && len(msg.GetExtensionRange()) > 0 {
+		return r.errorf(msg.GetExtensionRange()[0], nil, "%s: extension ranges are not allowed in proto3", fqn)
+	}
+
+	// ranges must not overlap
+	for i, er := range msg.GetExtensionRange() {
+		for _, other := range msg.GetExtensionRange()[:i] {
+			if er.GetStart() < other.GetEnd() && other.GetStart() < er.GetEnd() {
+				return r.errorf(er, nil, "%s: extension range %s overlaps with range %s", fqn, rangeString(er.GetStart(), er.GetEnd()), rangeString(other.GetStart(), other.GetEnd()))
+			}
+		}
+		for _, rr := range msg.GetReservedRange() {
+			if er.GetStart() < rr.GetEnd() && rr.GetStart() < er.GetEnd() {
+				return r.errorf(er, nil, "%s: extension range %s overlaps with reserved range %s", fqn, rangeString(er.GetStart(), er.GetEnd()), rangeString(rr.GetStart(), rr.GetEnd()))
+			}
+		}
+	}
+	for i, rr := range msg.GetReservedRange() {
+		for _, other := range msg.GetReservedRange()[:i] {
+			if rr.GetStart() < other.GetEnd() && other.GetStart() < rr.GetEnd() {
+				return r.errorf(rr, nil, "%s: reserved range %s overlaps with reserved range %s", fqn, rangeString(rr.GetStart(), rr.GetEnd()), rangeString(other.GetStart(), other.GetEnd()))
+			}
+		}
+	}
+
+	tags := map[int32]*dpb.FieldDescriptorProto{}
+	for _, fld := range msg.GetField() {
+		fldName := merge(fqn, fld.GetName())
+		if existing, ok := tags[fld.GetNumber()]; ok {
+			return r.errorf(fld, numberTok, "%s: tag %d is already used by field %s", fldName, fld.GetNumber(), existing.GetName())
+		}
+		tags[fld.GetNumber()] = fld
+		for _, rr := range msg.GetReservedRange() {
+			if fld.GetNumber() >= rr.GetStart() && fld.GetNumber() < rr.GetEnd() {
+				return r.errorf(fld, numberTok, "%s: tag %d is in reserved range %s", fldName, fld.GetNumber(), rangeString(rr.GetStart(), rr.GetEnd()))
+			}
+		}
+		for _, er := range msg.GetExtensionRange() {
+			if fld.GetNumber() >= er.GetStart() && fld.GetNumber() < er.GetEnd() {
+				return r.errorf(fld, numberTok, "%s: tag %d is in extension range %s", fldName, fld.GetNumber(), rangeString(er.GetStart(), er.GetEnd()))
+			}
+		}
+		for _, name := range msg.GetReservedName() {
+			if fld.GetName() == name {
+				return r.errorf(fld, nameTok, "%s: field name %q is reserved", fldName, name)
+			}
+		}
+		if fld.GetOptions().GetPacked() && !isPackable(fld) {
+			return r.errorf(fld, nil, "%s: packed option is only allowed on repeated fields of scalar numeric types", fldName)
+		}
+	}
+	for _, ext := range msg.GetExtension() {
+		fldName := merge(fqn, ext.GetName())
+		if ext.GetOptions().GetPacked() && !isPackable(ext) {
+			return r.errorf(ext, nil, "%s: packed option is only allowed on repeated fields of scalar numeric types", fldName)
+		}
+	}
+
+	for _, nested := range msg.GetNestedType() {
+		if err := r.validateMessage(fqn, nested); err != nil {
+			return err
+		}
+	}
+	for _, en := range msg.GetEnumType() {
+		if err := r.validateEnum(fqn, en); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isPackable(fld *dpb.FieldDescriptorProto) bool {
+	if fld.GetLabel() != dpb.FieldDescriptorProto_LABEL_REPEATED {
+		return false
+	}
+	switch fld.GetType() {
+	case dpb.FieldDescriptorProto_TYPE_STRING, dpb.FieldDescriptorProto_TYPE_BYTES,
+		dpb.FieldDescriptorProto_TYPE_MESSAGE, dpb.FieldDescriptorProto_TYPE_GROUP:
+		return false
+	}
+	return true
+}
+
+// rangeString formats the given range, whose end is exclusive, the way it
+// would appear in source.
+func rangeString(start, end int32) string {
+	if end-1 == start {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d to %d", start, end-1)
+}
+
+func (r *resolver) validateEnum(scope string, en *dpb.EnumDescriptorProto) error {
+	fqn := merge(scope, en.GetName())
+	for i, rr := range en.GetReservedRange() {
+		for _, other := range en.GetReservedRange()[:i] {
+			if rr.GetStart() <= other.GetEnd() && other.GetStart() <= rr.GetEnd() {
+				return r.errorf(rr, nil, "%s: reserved range %s overlaps with reserved range %s", fqn, rangeString(rr.GetStart(), rr.GetEnd()+1), rangeString(other.GetStart(), other.GetEnd()+1))
+			}
+		}
+	}
+	allowAlias := en.GetOptions().GetAllowAlias()
+	nums := map[int32]*dpb.EnumValueDescriptorProto{}
+	var hasAlias bool
+	for _, val := range en.GetValue() {
+		valName := merge(fqn, val.GetName())
+		if existing, ok := nums[val.GetNumber()]; ok {
+			if !allowAlias {
+				return r.errorf(val, numberTok, "%s: value %d is already used by %s; set option allow_alias = true to allow aliases", valName, val.GetNumber(), existing.GetName())
+			}
+			hasAlias = true
+		} else {
+			nums[val.GetNumber()] = val
+		}
+		for _, rr := range en.GetReservedRange() {
+			if val.GetNumber() >= rr.GetStart() && val.GetNumber() <= rr.GetEnd() {
+				return r.errorf(val, numberTok, "%s: value %d is in reserved range %s", valName, val.GetNumber(), rangeString(rr.GetStart(), rr.GetEnd()+1))
+			}
+		}
+		for _, name := range en.GetReservedName() {
+			if val.GetName() == name {
+				return r.errorf(val, nameTok, "%s: value name %q is reserved", valName, name)
+			}
+		}
+	}
+	if allowAlias && !hasAlias {
+		return r.errorf(en, nil, "%s: allow_alias is set but no values are aliases", fqn)
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+package protoparse
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+)
+
+// interpretOptions interprets all option declarations in the file, setting
+// the corresponding fields (and extensions) of the elements' options
+// messages. This happens after the file descriptor is created so that custom
+// options defined in the file itself can be used.
+func (r *resolver) interpretOptions(fd *desc.FileDescriptor) error {
+	files := []*desc.FileDescriptor{fd}
+	for _, name := range r.visibleFiles {
+		files = append(files, r.l.linked[name])
+	}
+	er := &dynamic.ExtensionRegistry{}
+	for _, f := range files {
+		er.AddExtensionsFromFile(f)
+	}
+
+	// group declarations by the options message they populate
+	var order []proto.Message
+	decls := map[proto.Message][]*optionDecl{}
+	for _, decl := range r.res.options {
+		if _, ok := decls[decl.opts]; !ok {
+			order = append(order, decl.opts)
+		}
+		decls[decl.opts] = append(decls[decl.opts], decl)
+	}
+
+	for _, opts := range order {
+		md, err := desc.LoadMessageDescriptorForMessage(opts)
+		if err != nil {
+			return err
+		}
+		dm := dynamic.NewMessageWithExtensionRegistry(md, er)
+		// some options, like map_entry, are set by the parser directly
+		if err := dm.ConvertFrom(opts); err != nil {
+			return err
+		}
+		for _, decl := range decls[opts] {
+			if err := r.interpretOption(files, er, dm, decl); err != nil {
+				return err
+			}
+		}
+		if err := dm.ConvertTo(opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *resolver) interpretOption(files []*desc.FileDescriptor, er *dynamic.ExtensionRegistry, dm *dynamic.Message, decl *optionDecl) error {
+	optName := optionNameString(decl.names)
+	// the parser recorded the location using the path to an uninterpreted
+	// option; we replace that with the path to the interpreted field
+	path := decl.loc.Path[:len(decl.loc.Path)-2]
+	cur := dm
+	for i, n := range decl.names {
+		md := cur.GetMessageDescriptor()
+		var fld *desc.FieldDescriptor
+		if n.isExtension {
+			fqn, sym := r.lookup(decl.relativeTo, n.text, false)
+			if sym == nil {
+				return errorAt(r.filename, n.tok, "unknown extension %s", n.text)
+			}
+			if sym.kind != symbolField || sym.elem.(*dpb.FieldDescriptorProto).Extendee == nil {
+				return errorAt(r.filename, n.tok, "invalid extension: %s is %v, not an extension", fqn, sym.kind)
+			}
+			for _, f := range files {
+				if fld = f.FindExtensionByName(fqn); fld != nil {
+					break
+				}
+			}
+			if fld == nil {
+				return errorAt(r.filename, n.tok, "unknown extension %s", n.text)
+			}
+			if fld.GetOwner().GetFullyQualifiedName() != md.GetFullyQualifiedName() {
+				return errorAt(r.filename, n.tok, "option %s: extension %s should extend %s but instead extends %s", optName, fqn, md.GetFullyQualifiedName(), fld.GetOwner().GetFullyQualifiedName())
+			}
+		} else {
+			fld = md.FindFieldByName(n.text)
+			if fld == nil {
+				return errorAt(r.filename, n.tok, "option %s: field %s of %s does not exist", optName, n.text, md.GetFullyQualifiedName())
+			}
+		}
+		path = append(path, fld.GetNumber())
+
+		if i < len(decl.names)-1 {
+			if fld.GetMessageType() == nil {
+				return errorAt(r.filename, n.tok, "option %s: %s is a non-message field and so cannot have sub-fields", optName, fld.GetName())
+			}
+			if fld.IsRepeated() {
+				return errorAt(r.filename, n.tok, "option %s: %s is a repeated message field; repeated message options must be set using an aggregate value", optName, fld.GetName())
+			}
+			if cur.HasField(fld) {
+				nested, ok := cur.GetField(fld).(*dynamic.Message)
+				if !ok {
+					return errorAt(r.filename, n.tok, "option %s: %s already set", optName, fld.GetName())
+				}
+				cur = nested
+			} else {
+				nested := dynamic.NewMessageWithExtensionRegistry(fld.GetMessageType(), er)
+				if err := cur.TrySetField(fld, nested); err != nil {
+					return errorAt(r.filename, n.tok, "option %s: %v", optName, err)
+				}
+				cur = nested
+			}
+			continue
+		}
+
+		v, err := r.optionFieldValue(optName, fld, decl.val, er)
+		if err != nil {
+			return err
+		}
+		if fld.IsRepeated() {
+			path = append(path, int32(cur.FieldLength(fld)))
+			err = cur.TryAddRepeatedField(fld, v)
+		} else {
+			if cur.HasField(fld) {
+				return errorAt(r.filename, decl.start, "option %s: option already set", optName)
+			}
+			err = cur.TrySetField(fld, v)
+		}
+		if err != nil {
+			return errorAt(r.filename, decl.val.tok, "option %s: %v", optName, err)
+		}
+	}
+	decl.loc.Path = path
+	return nil
+}
+
+func optionNameString(names []*optionName) string {
+	parts := make([]string, len(names))
+	for i, n := range names {
+		if n.isExtension {
+			parts[i] = "(" + n.text + ")"
+		} else {
+			parts[i] = n.text
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// optionFieldValue converts the given option value into a value of the
+// appropriate type for the given field.
+func (r *resolver) optionFieldValue(optName string, fld *desc.FieldDescriptor, val *optionValue, er *dynamic.ExtensionRegistry) (interface{}, error) {
+	mismatch := func(expected string) error {
+		return errorAt(r.filename, val.tok, "option %s: expecting %s, got %s", optName, expected, val.describe())
+	}
+	switch fld.GetType() {
+	case dpb.FieldDescriptorProto_TYPE_ENUM:
+		if val.kind != identValue || val.neg {
+			return nil, mismatch("enum")
+		}
+		if ev := fld.GetEnumType().FindValueByName(val.str); ev != nil {
+			return ev.GetNumber(), nil
+		}
+		return nil, errorAt(r.filename, val.tok, "option %s: enum %s has no value named %s", optName, fld.GetEnumType().GetFullyQualifiedName(), val.str)
+
+	case dpb.FieldDescriptorProto_TYPE_MESSAGE, dpb.FieldDescriptorProto_TYPE_GROUP:
+		if val.kind != aggregateValue {
+			return nil, mismatch("message")
+		}
+		dm := dynamic.NewMessageWithExtensionRegistry(fld.GetMessageType(), er)
+		if err := dm.UnmarshalText([]byte(val.str)); err != nil {
+			return nil, errorAt(r.filename, val.tok, "option %s: %v", optName, err)
+		}
+		return dm, nil
+
+	case dpb.FieldDescriptorProto_TYPE_BOOL:
+		if val.kind == identValue && !val.neg {
+			switch val.str {
+			case "true":
+				return true, nil
+			case "false":
+				return false, nil
+			}
+		}
+		return nil, mismatch("bool")
+
+	case dpb.FieldDescriptorProto_TYPE_STRING:
+		if val.kind != stringValue {
+			return nil, mismatch("string")
+		}
+		return val.str, nil
+
+	case dpb.FieldDescriptorProto_TYPE_BYTES:
+		if val.kind != stringValue {
+			return nil, mismatch("bytes")
+		}
+		return []byte(val.str), nil
+
+	case dpb.FieldDescriptorProto_TYPE_INT32, dpb.FieldDescriptorProto_TYPE_SINT32, dpb.FieldDescriptorProto_TYPE_SFIXED32:
+		i, err := r.signedValue(optName, val, math.MinInt32, math.MaxInt32, "int32")
+		return int32(i), err
+
+	case dpb.FieldDescriptorProto_TYPE_INT64, dpb.FieldDescriptorProto_TYPE_SINT64, dpb.FieldDescriptorProto_TYPE_SFIXED64:
+		return r.signedValue(optName, val, math.MinInt64, math.MaxInt64, "int64")
+
+	case dpb.FieldDescriptorProto_TYPE_UINT32, dpb.FieldDescriptorProto_TYPE_FIXED32:
+		u, err := r.unsignedValue(optName, val, math.MaxUint32, "uint32")
+		return uint32(u), err
+
+	case dpb.FieldDescriptorProto_TYPE_UINT64, dpb.FieldDescriptorProto_TYPE_FIXED64:
+		return r.unsignedValue(optName, val, math.MaxUint64, "uint64")
+
+	case dpb.FieldDescriptorProto_TYPE_FLOAT:
+		f, err := r.floatValue(optName, val)
+		return float32(f), err
+
+	case dpb.FieldDescriptorProto_TYPE_DOUBLE:
+		return r.floatValue(optName, val)
+
+	default:
+		return nil, errorAt(r.filename, val.tok, "option %s: unrecognized field type %v", optName, fld.GetType())
+	}
+}
+
+func (r *resolver) signedValue(optName string, val *optionValue, min, max int64, typeName string) (int64, error) {
+	if val.kind != intValue {
+		return 0, errorAt(r.filename, val.tok, "option %s: expecting %s, got %s", optName, typeName, val.describe())
+	}
+	if val.neg {
+		if val.u > uint64(-(min+1))+1 {
+			return 0, errorAt(r.filename, val.tok, "option %s: value -%d is out of range for %s", optName, val.u, typeName)
+		}
+		return -int64(val.u-1) - 1, nil
+	}
+	if val.u > uint64(max) {
+		return 0, errorAt(r.filename, val.tok, "option %s: value %d is out of range for %s", optName, val.u, typeName)
+	}
+	return int64(val.u), nil
+}
+
+func (r *resolver) unsignedValue(optName string, val *optionValue, max uint64, typeName string) (uint64, error) {
+	if val.kind != intValue {
+		return 0, errorAt(r.filename, val.tok, "option %s: expecting %s, got %s", optName, typeName, val.describe())
+	}
+	if val.neg || val.u > max {
+		return 0, errorAt(r.filename, val.tok, "option %s: value %s is out of range for %s", optName, val.describe(), typeName)
+	}
+	return val.u, nil
+}
+
+func (r *resolver) floatValue(optName string, val *optionValue) (float64, error) {
+	var f float64
+	switch val.kind {
+	case intValue:
+		f = float64(val.u)
+	case floatValue:
+		f = val.f
+	case identValue:
+		switch val.str {
+		case "inf":
+			f = math.Inf(1)
+		case "nan":
+			f = math.NaN()
+		default:
+			return 0, errorAt(r.filename, val.tok, "option %s: expecting float, got %s", optName, val.describe())
+		}
+	default:
+		return 0, errorAt(r.filename, val.tok, "option %s: expecting float, got %s", optName, val.describe())
+	}
+	if val.neg {
+		f = -f
+	}
+	return f, nil
+}
+
+// describe returns a description of the value for use in error messages.
+func (v *optionValue) describe() string {
+	var s string
+	switch v.kind {
+	case identValue:
+		s = "identifier " + v.str
+	case intValue:
+		s = fmt.Sprintf("integer %d", v.u)
+	case floatValue:
+		s = "float " + v.tok.text
+	case stringValue:
+		return fmt.Sprintf("string %q", v.str)
+	default:
+		return "aggregate value"
+	}
+	if v.neg {
+		return "negative " + s
+	}
+	return s
+}
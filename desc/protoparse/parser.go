@@ -0,0 +1,206 @@
+// Package protoparse provides functionality for parsing *.proto source files
+// into descriptors that can be used with other protoreflect packages, like
+// dynamic messages and dynamic GRPC clients.
+//
+// This package links in no C/C++ code and does not invoke protoc. It is a
+// pure Go parser for the protobuf IDL. It supports both "proto2" and
+// "proto3" syntax, including imports, options (both standard and custom),
+// groups, maps, extensions, reserved ranges and names, and services. The
+// resulting descriptors include source code info, so comments and positions
+// of elements in the source files are available via the descriptors'
+// GetSourceInfo methods.
+//
+// The main entry point is the Parser type:
+//
+//	p := protoparse.Parser{ImportPaths: []string{"./protos"}}
+//	fds, err := p.ParseFiles("foo/bar.proto", "foo/baz.proto")
+//
+// Imports of the standard "google/protobuf/*.proto" files (such as
+// descriptor.proto and the well-known types) need not be present on the
+// import path. If they are not found there, the descriptors that are compiled
+// into the Go protobuf runtime are used instead.
+package protoparse
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/jhump/protoreflect/desc"
+)
+
+// FileAccessor is an abstraction for opening proto source files. It takes the
+// name of the file to open and returns either the input reader or an error.
+type FileAccessor func(filename string) (io.ReadCloser, error)
+
+// Parser parses proto source into descriptors.
+type Parser struct {
+	// The paths used to search for dependencies that are referenced in import
+	// statements in proto source files. If no import paths are provided then
+	// "." (current directory) is assumed to be the only import path.
+	ImportPaths []string
+
+	// Used to create a reader for a given filename, when loading proto source
+	// file contents. If unset, os.Open is used, and relative paths are thus
+	// relative to the process's current working directory.
+	Accessor FileAccessor
+}
+
+// SourcePos identifies a location in a proto source file. Line and column
+// numbers are one-based, and tab characters advance the column to the next
+// multiple of eight (the same as protoc).
+type SourcePos struct {
+	Filename  string
+	Line, Col int
+}
+
+func (pos SourcePos) String() string {
+	return fmt.Sprintf("%s:%d:%d", pos.Filename, pos.Line, pos.Col)
+}
+
+// ErrorWithSourcePos is an error about a proto source file that includes
+// information about the location in the file that caused the error.
+type ErrorWithSourcePos struct {
+	Underlying error
+	Pos        *SourcePos
+}
+
+// Error implements the error interface
+func (e ErrorWithSourcePos) Error() string {
+	return fmt.Sprintf("%v: %v", *e.Pos, e.Underlying)
+}
+
+// ParseFiles parses the named files into descriptors. The returned slice has
+// the same number of entries as the give filenames, in the same order. So the
+// first returned descriptor corresponds to the first given name, and so on.
+//
+// All dependencies for all specified files (including transitive dependencies)
+// must be accessible via the parser's Accessor or a link error will occur. The
+// exception to this rule is that files named "google/protobuf/*.proto" need
+// not be accessible, in which case the descriptors compiled into the Go
+// protobuf runtime will be used.
+//
+// The given filenames (and the names of imported files) are interpreted
+// relative to the parser's ImportPaths. The names of the resulting file
+// descriptors are the names given (or the names in the import statements).
+func (p Parser) ParseFiles(filenames ...string) ([]*desc.FileDescriptor, error) {
+	accessor := p.Accessor
+	if accessor == nil {
+		accessor = func(name string) (io.ReadCloser, error) {
+			return os.Open(name)
+		}
+	}
+	paths := p.ImportPaths
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	parsed := map[string]*parseResult{}
+	for _, name := range filenames {
+		if err := parseRecursive(name, accessor, paths, parsed, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	l := newLinker(parsed)
+	fds := make([]*desc.FileDescriptor, len(filenames))
+	for i, name := range filenames {
+		fd, err := l.linkFile(name)
+		if err != nil {
+			return nil, err
+		}
+		fds[i] = fd
+	}
+	return fds, nil
+}
+
+// parseRecursive parses the named file and, recursively, all files that it
+// imports, adding the results to the given map. The importedBy parameter is
+// the chain of files that led to this one (for detecting import cycles).
+func parseRecursive(filename string, accessor FileAccessor, paths []string, parsed map[string]*parseResult, importedBy []string) error {
+	for i, n := range importedBy {
+		if n == filename {
+			chain := append(importedBy[i:], filename)
+			return fmt.Errorf("cycle found in imports: %s", strings.Join(quoteAll(chain), " -> "))
+		}
+	}
+	if _, ok := parsed[filename]; ok {
+		return nil
+	}
+
+	contents, err := readFile(filename, accessor, paths)
+	if err != nil {
+		if os.IsNotExist(err) && isStandardImport(filename) {
+			// the linker will use the descriptor compiled into the runtime
+			if _, err := desc.LoadFileDescriptor(filename); err == nil {
+				parsed[filename] = nil
+				return nil
+			}
+		}
+		if len(importedBy) > 0 {
+			return fmt.Errorf("%s: could not load import %q: %v", importedBy[len(importedBy)-1], filename, err)
+		}
+		return err
+	}
+	res, err := parseProto(filename, contents)
+	if err != nil {
+		return err
+	}
+	parsed[filename] = res
+
+	importedBy = append(importedBy, filename)
+	for _, dep := range res.fd.GetDependency() {
+		if err := parseRecursive(dep, accessor, paths, parsed, importedBy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readFile(filename string, accessor FileAccessor, paths []string) ([]byte, error) {
+	var firstErr error
+	for _, path := range paths {
+		in, err := accessor(filepath.Join(path, filename))
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		contents, err := ioutil.ReadAll(in)
+		in.Close()
+		return contents, err
+	}
+	return nil, firstErr
+}
+
+func isStandardImport(filename string) bool {
+	return strings.HasPrefix(filename, "google/protobuf/")
+}
+
+func quoteAll(s []string) []string {
+	q := make([]string, len(s))
+	for i, str := range s {
+		q[i] = fmt.Sprintf("%q", str)
+	}
+	return q
+}
+
+// parseResult is the result of parsing a single file. It includes the file's
+// descriptor proto, in which type references are not yet resolved and options
+// are not yet interpreted, and other state needed to link it.
+type parseResult struct {
+	fd *dpb.FileDescriptorProto
+	// positions of elements, for reporting errors when linking
+	nodes map[interface{}]*node
+	// options that need to be interpreted
+	options []*optionDecl
+}
@@ -0,0 +1,329 @@
+package protoparse
+
+import (
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/desc_test"
+	_ "github.com/jhump/protoreflect/desc/desc_test/nopkg"
+	_ "github.com/jhump/protoreflect/desc/desc_test/pkg"
+	"github.com/jhump/protoreflect/dynamic"
+)
+
+var testFiles = []string{
+	"desc_test1.proto",
+	"desc_test2.proto",
+	"desc_test_proto3.proto",
+	"nopkg/desc_test_nopkg.proto",
+	"nopkg/desc_test_nopkg_new.proto",
+	"pkg/desc_test_pkg.proto",
+}
+
+func TestParseFilesMatchesProtoc(t *testing.T) {
+	p := Parser{ImportPaths: []string{"../desc_test"}}
+	fds, err := p.ParseFiles(testFiles...)
+	ok(t, err)
+	eq(t, len(testFiles), len(fds))
+	for i, fd := range fds {
+		eq(t, testFiles[i], fd.GetName())
+		// descriptors generated by protoc are compiled into the test protos
+		expected, err := desc.LoadFileDescriptor(testFiles[i])
+		ok(t, err)
+		actual := proto.Clone(fd.AsFileDescriptorProto()).(*dpb.FileDescriptorProto)
+		actual.SourceCodeInfo = nil
+		if !proto.Equal(expected.AsFileDescriptorProto(), actual) {
+			t.Errorf("%s: parsed descriptor does not match protoc's:\nexpected: %v\nactual:   %v", fd.GetName(), expected.AsFileDescriptorProto(), actual)
+		}
+	}
+}
+
+func TestParseFilesSourceInfoMatchesProtoc(t *testing.T) {
+	p := Parser{ImportPaths: []string{"../desc_test"}}
+	fds, err := p.ParseFiles("desc_test1.proto")
+	ok(t, err)
+	actual := fds[0].AsFileDescriptorProto().GetSourceCodeInfo()
+
+	var expected *dpb.SourceCodeInfo
+	for _, fd := range desc_test.GetDescriptorSet().GetFile() {
+		if fd.GetName() == "desc_test1.proto" {
+			expected = fd.GetSourceCodeInfo()
+		}
+	}
+
+	locs := map[string]*dpb.SourceCodeInfo_Location{}
+	for _, loc := range actual.GetLocation() {
+		locs[pathKey(loc.Path)] = loc
+	}
+	for _, exp := range expected.GetLocation() {
+		if isUninterpretedOption(exp.Path) || !isValidSpan(exp.Span) {
+			// the protoc that produced the descriptor set did not rewrite
+			// paths of options once interpreted, and it records some bogus
+			// spans for the labels of map fields
+			continue
+		}
+		act := locs[pathKey(exp.Path)]
+		if act == nil {
+			t.Errorf("missing location for path %v", exp.Path)
+			continue
+		}
+		if !proto.Equal(exp, act) {
+			t.Errorf("wrong location for path %v:\nexpected: %v\nactual:   %v", exp.Path, exp, act)
+		}
+	}
+}
+
+func pathKey(path []int32) string {
+	var buf []string
+	for _, p := range path {
+		buf = append(buf, strconv.Itoa(int(p)))
+	}
+	return strings.Join(buf, ",")
+}
+
+func isUninterpretedOption(path []int32) bool {
+	for _, p := range path {
+		if p == uninterpretedOptionsTag {
+			return true
+		}
+	}
+	return false
+}
+
+func isValidSpan(span []int32) bool {
+	return len(span) == 3 || (len(span) == 4 && span[2] >= span[0])
+}
+
+func TestParseFilesComments(t *testing.T) {
+	p := Parser{ImportPaths: []string{"../desc_test"}}
+	fds, err := p.ParseFiles("desc_test1.proto")
+	ok(t, err)
+	fd := fds[0]
+
+	md := fd.FindMessage("desc_test.TestMessage")
+	eq(t, " Comment for TestMessage\n", md.GetSourceInfo().GetLeadingComments())
+	eq(t, true, reflect.DeepEqual([]int32{7, 0, 63, 1}, md.GetSourceInfo().GetSpan()))
+
+	nested := fd.FindMessage("desc_test.TestMessage.NestedMessage")
+	eq(t, " Comment for NestedMessage\n", nested.GetSourceInfo().GetLeadingComments())
+
+	fld := md.FindFieldByName("nm")
+	eq(t, " Comment for nm\n", fld.GetSourceInfo().GetLeadingComments())
+}
+
+func TestParseFilesStandardImports(t *testing.T) {
+	p := Parser{Accessor: accessorFor(map[string]string{
+		"test.proto": `
+			import "google/protobuf/timestamp.proto";
+			import "google/protobuf/descriptor.proto";
+			message Foo {
+				optional google.protobuf.Timestamp ts = 1;
+				optional google.protobuf.FieldDescriptorProto.Type type = 2;
+			}`,
+	})}
+	fds, err := p.ParseFiles("test.proto")
+	ok(t, err)
+	md := fds[0].FindMessage("Foo")
+	eq(t, "google.protobuf.Timestamp", md.FindFieldByName("ts").GetMessageType().GetFullyQualifiedName())
+	eq(t, "google.protobuf.FieldDescriptorProto.Type", md.FindFieldByName("type").GetEnumType().GetFullyQualifiedName())
+}
+
+func TestParseFilesCustomOptions(t *testing.T) {
+	p := Parser{Accessor: accessorFor(map[string]string{
+		"test.proto": `
+			syntax = "proto2";
+			package foo.bar;
+			import "google/protobuf/descriptor.proto";
+			message Opts {
+				optional string s = 1;
+				repeated int32 i = 2;
+				optional Opts sub = 3;
+			}
+			extend google.protobuf.MessageOptions {
+				optional Opts opts = 10101;
+				repeated string tags = 10102;
+			}
+			extend google.protobuf.FieldOptions {
+				optional uint64 big = 10101;
+				optional double d = 10102;
+			}
+			enum Color {
+				option allow_alias = true;
+				RED = 0;
+				CRIMSON = 0;
+				GREEN = 1;
+			}
+			message Foo {
+				option (opts).s = "abc";
+				option (opts).sub = { s: "nested" i: [1, 2] };
+				option (foo.bar.tags) = "x";
+				option (.foo.bar.tags) = "y";
+				optional string name = 1 [(big) = 18446744073709551615, (d) = -inf, deprecated = true];
+			}`,
+	})}
+	fds, err := p.ParseFiles("test.proto")
+	ok(t, err)
+	fd := fds[0]
+	er := &dynamic.ExtensionRegistry{}
+	er.AddExtensionsFromFile(fd)
+
+	eq(t, true, fd.FindEnum("foo.bar.Color").GetEnumOptions().GetAllowAlias())
+
+	md := fd.FindMessage("foo.bar.Foo")
+	opts := asDynamic(t, md.GetOptions(), er)
+	custom := opts.GetFieldByName("foo.bar.opts").(*dynamic.Message)
+	eq(t, "abc", custom.GetFieldByName("s"))
+	sub := custom.GetFieldByName("sub").(*dynamic.Message)
+	eq(t, "nested", sub.GetFieldByName("s"))
+	eq(t, true, reflect.DeepEqual([]interface{}{int32(1), int32(2)}, sub.GetFieldByName("i")))
+	eq(t, true, reflect.DeepEqual([]interface{}{"x", "y"}, opts.GetFieldByName("foo.bar.tags")))
+
+	fld := md.FindFieldByName("name")
+	eq(t, true, fld.GetFieldOptions().GetDeprecated())
+	fldOpts := asDynamic(t, fld.GetOptions(), er)
+	eq(t, uint64(math.MaxUint64), fldOpts.GetFieldByName("foo.bar.big"))
+	eq(t, math.Inf(-1), fldOpts.GetFieldByName("foo.bar.d"))
+
+	// source locations refer to the interpreted options
+	paths := map[string]bool{}
+	for _, loc := range fd.AsFileDescriptorProto().GetSourceCodeInfo().GetLocation() {
+		paths[pathKey(loc.Path)] = true
+		eq(t, false, isUninterpretedOption(loc.Path), "path %v", loc.Path)
+	}
+	for _, path := range [][]int32{
+		{4, 1, 7, 10101, 1},
+		{4, 1, 7, 10101, 3},
+		{4, 1, 7, 10102, 0},
+		{4, 1, 7, 10102, 1},
+		{4, 1, 2, 0, 8, 10101},
+		{4, 1, 2, 0, 8, 3},
+		{5, 0, 3, 2},
+	} {
+		eq(t, true, paths[pathKey(path)], "missing location for path %v", path)
+	}
+}
+
+func asDynamic(t *testing.T, msg proto.Message, er *dynamic.ExtensionRegistry) *dynamic.Message {
+	md, err := desc.LoadMessageDescriptorForMessage(msg)
+	ok(t, err)
+	dm := dynamic.NewMessageWithExtensionRegistry(md, er)
+	ok(t, dm.ConvertFrom(msg))
+	return dm
+}
+
+func TestParseFilesErrors(t *testing.T) {
+	testCases := []struct {
+		source   string
+		line     int
+		col      int
+		contains string
+	}{
+		{`message Foo {`, 1, 14, "end of file"},
+		{`message Foo { int32 a = 1; }`, 1, 15, `expecting "required", "optional", or "repeated"`},
+		{`syntax = "proto3"; message Foo { int32 a = 1 [default = 1]; }`, 1, 47, "default values are not allowed in proto3"},
+		{`message Foo { optional Bar a = 1; }`, 1, 24, "unknown type Bar"},
+		{`message Foo { optional int32 a = 1; optional int32 b = 1; }`, 1, 56, "tag 1 is already used"},
+		{`message Foo { reserved 1 to 3; optional int32 a = 2; }`, 1, 51, "reserved range"},
+		{`message Foo { reserved "a"; optional int32 a = 2; }`, 1, 44, "reserved"},
+		{`message Foo { extensions 10 to 20; optional int32 a = 15; }`, 1, 55, "extension range"},
+		{`message Foo { optional int32 a = 0; }`, 1, 34, "tag"},
+		{`enum Foo { A = 0; B = 0; }`, 1, 23, "allow_alias"},
+		{`enum Foo { A = 0; } enum Bar { A = 1; }`, 1, 32, "already defined"},
+		{`message Foo {} message Foo {}`, 1, 24, "already defined"},
+		{`option java_package = "a"; option java_package = "b";`, 1, 28, "already set"},
+		{`option foo = 1;`, 1, 8, "does not exist"},
+		{`option java_multiple_files = 1;`, 1, 30, "expecting bool"},
+		{`option optimize_for = FASTEST;`, 1, 23, "no value named FASTEST"},
+		{`message Foo { extensions 1 to 10; } extend Foo { optional int32 a = 20; }`, 1, 69, "not in valid range"},
+		{`syntax = "proto3"; enum Foo { A = 1; }`, 1, 35, "first value"},
+		{`syntax = "proto4";`, 1, 10, "syntax value must be"},
+	}
+	for i, tc := range testCases {
+		p := Parser{Accessor: accessorFor(map[string]string{"test.proto": tc.source})}
+		_, err := p.ParseFiles("test.proto")
+		if err == nil {
+			t.Errorf("case %d: expecting error but got none", i)
+			continue
+		}
+		errWithPos, isErrWithPos := err.(ErrorWithSourcePos)
+		if !isErrWithPos {
+			t.Errorf("case %d: expecting error with source position, got %v", i, err)
+			continue
+		}
+		eq(t, "test.proto", errWithPos.Pos.Filename, "case %d", i)
+		eq(t, tc.line, errWithPos.Pos.Line, "case %d: %v", i, err)
+		eq(t, tc.col, errWithPos.Pos.Col, "case %d: %v", i, err)
+		eq(t, true, strings.Contains(err.Error(), tc.contains), "case %d: %q should contain %q", i, err.Error(), tc.contains)
+	}
+}
+
+func TestParseFilesImportErrors(t *testing.T) {
+	p := Parser{Accessor: accessorFor(map[string]string{
+		"a.proto": `import "b.proto";`,
+		"b.proto": `import "c.proto";`,
+		"c.proto": `import "a.proto";`,
+	})}
+	_, err := p.ParseFiles("a.proto")
+	eq(t, true, err != nil)
+	eq(t, `cycle found in imports: "a.proto" -> "b.proto" -> "c.proto" -> "a.proto"`, err.Error())
+
+	p = Parser{Accessor: accessorFor(map[string]string{
+		"a.proto": `import "b.proto";`,
+	})}
+	_, err = p.ParseFiles("a.proto")
+	eq(t, true, err != nil)
+	eq(t, true, strings.Contains(err.Error(), `could not load import "b.proto"`), err.Error())
+
+	// types from a file must be imported to be used
+	p = Parser{Accessor: accessorFor(map[string]string{
+		"a.proto": `import "b.proto"; message A { optional C c = 1; }`,
+		"b.proto": `import "c.proto"; message B { optional C c = 1; }`,
+		"c.proto": `message C { }`,
+	})}
+	_, err = p.ParseFiles("a.proto")
+	eq(t, true, err != nil)
+	eq(t, true, strings.Contains(err.Error(), "unknown type C"), err.Error())
+
+	// unless imported publicly
+	p = Parser{Accessor: accessorFor(map[string]string{
+		"a.proto": `import "b.proto"; message A { optional C c = 1; }`,
+		"b.proto": `import public "c.proto"; message B { optional C c = 1; }`,
+		"c.proto": `message C { }`,
+	})}
+	_, err = p.ParseFiles("a.proto")
+	ok(t, err)
+}
+
+func accessorFor(files map[string]string) FileAccessor {
+	return func(filename string) (io.ReadCloser, error) {
+		contents, ok := files[filename]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return ioutil.NopCloser(strings.NewReader(contents)), nil
+	}
+}
+
+func TestDirectorySource(t *testing.T) {
+	src := DirectorySource("../desc_test")
+	fd, err := desc.LoadFileDescriptorFromSource(src, "desc_test_proto3.proto")
+	ok(t, err)
+	expected, err := desc.LoadFileDescriptor("desc_test_proto3.proto")
+	ok(t, err)
+	actual := proto.Clone(fd.AsFileDescriptorProto()).(*dpb.FileDescriptorProto)
+	actual.SourceCodeInfo = nil
+	eq(t, true, proto.Equal(expected.AsFileDescriptorProto(), actual))
+	eq(t, "desc_test1.proto", fd.GetDependencies()[0].GetName())
+
+	_, err = desc.LoadFileDescriptorFromSource(src, "does_not_exist.proto")
+	eq(t, true, os.IsNotExist(err))
+}
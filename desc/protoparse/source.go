@@ -0,0 +1,33 @@
+package protoparse
+
+import (
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/jhump/protoreflect/desc"
+)
+
+// DirectorySource returns a source that provides descriptors by parsing proto
+// source files in the given directory. Names of requested files, and of the
+// files they import, are relative to the directory.
+func DirectorySource(dir string) desc.DescriptorSource {
+	return Parser{ImportPaths: []string{dir}}.AsSource()
+}
+
+// AsSource returns a source that provides descriptors by parsing proto source
+// files with this parser. Each requested file is parsed (along with the files
+// it imports) when it is requested.
+func (p Parser) AsSource() desc.DescriptorSource {
+	return parserSource{p}
+}
+
+type parserSource struct {
+	p Parser
+}
+
+func (s parserSource) FindFileProto(filename string) (*dpb.FileDescriptorProto, error) {
+	fds, err := s.p.ParseFiles(filename)
+	if err != nil {
+		return nil, err
+	}
+	return fds[0].AsFileDescriptorProto(), nil
+}
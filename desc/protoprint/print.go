@@ -0,0 +1,1064 @@
+// Package protoprint provides a mechanism to generate protobuf source code
+// from descriptors.
+//
+// This can be useful for turning descriptors that were fetched from a server
+// (e.g. using the grpcreflect package) back into readable source. The output
+// includes comments, if the descriptors have source code info, and all
+// options, including custom options, as long as the extensions that define
+// them are known to the descriptor's file or its dependencies.
+package protoprint
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+)
+
+// Printer knows how to format file descriptors as proto source code. Its
+// fields provide some control over how the resulting source file is
+// constructed and formatted.
+type Printer struct {
+	// If true, comments are not included in the output.
+	OmitComments bool
+
+	// The string used for each level of indentation. If empty, two spaces
+	// are used.
+	Indent string
+}
+
+// PrintProtoFile prints the given file descriptor as proto source to the
+// given writer.
+func (p *Printer) PrintProtoFile(fd *desc.FileDescriptor, out io.Writer) error {
+	return p.PrintProto(fd, out)
+}
+
+// PrintProtoToString prints the given descriptor as proto source and returns
+// the resulting string.
+func (p *Printer) PrintProtoToString(dsc desc.Descriptor) (string, error) {
+	var buf bytes.Buffer
+	if err := p.PrintProto(dsc, &buf); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// PrintProto prints the given descriptor as proto source to the given writer.
+// If the descriptor is a file, the entire file is printed. Otherwise, just
+// the given element is printed. If the element is an extension, it is
+// printed inside of an "extend" block.
+func (p *Printer) PrintProto(dsc desc.Descriptor, out io.Writer) error {
+	pr := newPrinter(p, dsc.GetFile())
+	switch d := dsc.(type) {
+	case *desc.FileDescriptor:
+		pr.printFile()
+	case *desc.MessageDescriptor:
+		pr.printMessage(d)
+	case *desc.FieldDescriptor:
+		if d.IsExtension() {
+			pr.printExtendBlock(desc.ScopeOf(d), []*desc.FieldDescriptor{d})
+		} else {
+			pr.printField(d, d.GetOwner().GetFullyQualifiedName())
+		}
+	case *desc.OneOfDescriptor:
+		pr.printOneOf(d)
+	case *desc.EnumDescriptor:
+		pr.printEnum(d)
+	case *desc.EnumValueDescriptor:
+		pr.printEnumValue(d)
+	case *desc.ServiceDescriptor:
+		pr.printService(d)
+	case *desc.MethodDescriptor:
+		pr.printMethod(d)
+	default:
+		return fmt.Errorf("unsupported descriptor type: %T", dsc)
+	}
+	if pr.err != nil {
+		return pr.err
+	}
+	_, err := out.Write(pr.buf.Bytes())
+	return err
+}
+
+// printer holds the state for printing a single element (and its children).
+type printer struct {
+	*Printer
+	buf    bytes.Buffer
+	indent int
+	err    error
+
+	fd *desc.FileDescriptor
+	// source locations by path, for elements that aren't descriptors
+	locs map[string]*dpb.SourceCodeInfo_Location
+	// paths of all descriptors in the file
+	paths map[desc.Descriptor][]int32
+	// all files that are visible for resolving names
+	files    []*desc.FileDescriptor
+	packages map[string]bool
+	er       *dynamic.ExtensionRegistry
+}
+
+func newPrinter(p *Printer, fd *desc.FileDescriptor) *printer {
+	pr := &printer{
+		Printer:  p,
+		fd:       fd,
+		locs:     map[string]*dpb.SourceCodeInfo_Location{},
+		paths:    map[desc.Descriptor][]int32{},
+		packages: map[string]bool{},
+		er:       &dynamic.ExtensionRegistry{},
+	}
+	for _, loc := range fd.AsFileDescriptorProto().GetSourceCodeInfo().GetLocation() {
+		key := pathKey(loc.Path)
+		if _, ok := pr.locs[key]; !ok {
+			pr.locs[key] = loc
+		}
+	}
+	pr.computePaths()
+	pr.addFile(fd, map[string]bool{})
+	return pr
+}
+
+func (pr *printer) addFile(fd *desc.FileDescriptor, seen map[string]bool) {
+	if seen[fd.GetName()] {
+		return
+	}
+	seen[fd.GetName()] = true
+	pr.files = append(pr.files, fd)
+	pr.er.AddExtensionsFromFile(fd)
+	if pkg := fd.GetPackage(); pkg != "" {
+		parts := strings.Split(pkg, ".")
+		for i := range parts {
+			pr.packages[strings.Join(parts[:i+1], ".")] = true
+		}
+	}
+	for _, dep := range fd.GetDependencies() {
+		pr.addFile(dep, seen)
+	}
+}
+
+func pathKey(path []int32) string {
+	var buf bytes.Buffer
+	for i, p := range path {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(strconv.Itoa(int(p)))
+	}
+	return buf.String()
+}
+
+const (
+	// tags for elements of descriptor protos, used to construct paths to
+	// source code locations
+	file_packageTag           = 2
+	file_dependencyTag        = 3
+	file_messagesTag          = 4
+	file_enumsTag             = 5
+	file_servicesTag          = 6
+	file_extensionsTag        = 7
+	file_optionsTag           = 8
+	file_syntaxTag            = 12
+	message_fieldsTag         = 2
+	message_nestedMessageTag  = 3
+	message_enumsTag          = 4
+	message_extensionRangeTag = 5
+	message_extensionsTag     = 6
+	message_optionsTag        = 7
+	message_oneOfsTag         = 8
+	message_reservedRangeTag  = 9
+	message_reservedNameTag   = 10
+	extensionRange_optionsTag = 3
+	field_optionsTag          = 8
+	oneOf_optionsTag          = 2
+	enum_valuesTag            = 2
+	enum_optionsTag           = 3
+	enum_reservedRangeTag     = 4
+	enum_reservedNameTag      = 5
+	enumVal_optionsTag        = 3
+	service_methodsTag        = 2
+	service_optionsTag        = 3
+	method_optionsTag         = 4
+)
+
+func appendPath(path []int32, elements ...int32) []int32 {
+	ret := make([]int32, len(path), len(path)+len(elements))
+	copy(ret, path)
+	return append(ret, elements...)
+}
+
+func (pr *printer) computePaths() {
+	for i, md := range pr.fd.GetMessageTypes() {
+		pr.computeMessagePaths(md, []int32{file_messagesTag, int32(i)})
+	}
+	for i, ed := range pr.fd.GetEnumTypes() {
+		pr.computeEnumPaths(ed, []int32{file_enumsTag, int32(i)})
+	}
+	for i, fld := range pr.fd.GetExtensions() {
+		pr.paths[fld] = []int32{file_extensionsTag, int32(i)}
+	}
+	for i, sd := range pr.fd.GetServices() {
+		path := []int32{file_servicesTag, int32(i)}
+		pr.paths[sd] = path
+		for j, mtd := range sd.GetMethods() {
+			pr.paths[mtd] = appendPath(path, service_methodsTag, int32(j))
+		}
+	}
+}
+
+func (pr *printer) computeMessagePaths(md *desc.MessageDescriptor, path []int32) {
+	pr.paths[md] = path
+	for i, fld := range md.GetFields() {
+		pr.paths[fld] = appendPath(path, message_fieldsTag, int32(i))
+	}
+	for i, od := range md.GetOneOfs() {
+		pr.paths[od] = appendPath(path, message_oneOfsTag, int32(i))
+	}
+	for i, nmd := range md.GetNestedMessageTypes() {
+		pr.computeMessagePaths(nmd, appendPath(path, message_nestedMessageTag, int32(i)))
+	}
+	for i, ed := range md.GetNestedEnumTypes() {
+		pr.computeEnumPaths(ed, appendPath(path, message_enumsTag, int32(i)))
+	}
+	for i, fld := range md.GetNestedExtensions() {
+		pr.paths[fld] = appendPath(path, message_extensionsTag, int32(i))
+	}
+}
+
+func (pr *printer) computeEnumPaths(ed *desc.EnumDescriptor, path []int32) {
+	pr.paths[ed] = path
+	for i, vd := range ed.GetValues() {
+		pr.paths[vd] = appendPath(path, enum_valuesTag, int32(i))
+	}
+}
+
+func (pr *printer) loc(path []int32) *dpb.SourceCodeInfo_Location {
+	return pr.locs[pathKey(path)]
+}
+
+// output helpers
+
+func (pr *printer) writeIndent() {
+	indent := pr.Indent
+	if indent == "" {
+		indent = "  "
+	}
+	for i := 0; i < pr.indent; i++ {
+		pr.buf.WriteString(indent)
+	}
+}
+
+// line writes a single line of output at the current indentation.
+func (pr *printer) line(format string, args ...interface{}) {
+	pr.writeIndent()
+	fmt.Fprintf(&pr.buf, format, args...)
+	pr.buf.WriteByte('\n')
+}
+
+func (pr *printer) blankLine() {
+	pr.buf.WriteByte('\n')
+}
+
+func (pr *printer) printComment(comment string) {
+	comment = strings.TrimSuffix(comment, "\n")
+	for _, l := range strings.Split(comment, "\n") {
+		pr.writeIndent()
+		pr.buf.WriteString("//")
+		pr.buf.WriteString(strings.TrimRight(l, " \t"))
+		pr.buf.WriteByte('\n')
+	}
+}
+
+func (pr *printer) printLeadingComments(loc *dpb.SourceCodeInfo_Location) {
+	if pr.OmitComments || loc == nil {
+		return
+	}
+	for _, c := range loc.GetLeadingDetachedComments() {
+		pr.printComment(c)
+		pr.blankLine()
+	}
+	if loc.LeadingComments != nil {
+		pr.printComment(loc.GetLeadingComments())
+	}
+}
+
+// printDecl prints a single-line declaration, terminated by a semicolon,
+// along with its comments. A single-line trailing comment is printed on the
+// same line as the declaration.
+func (pr *printer) printDecl(loc *dpb.SourceCodeInfo_Location, decl string) {
+	pr.printLeadingComments(loc)
+	pr.writeIndent()
+	pr.buf.WriteString(decl)
+	pr.buf.WriteByte(';')
+	if !pr.OmitComments && loc != nil && loc.TrailingComments != nil {
+		trailing := strings.TrimSuffix(loc.GetTrailingComments(), "\n")
+		if !strings.Contains(trailing, "\n") {
+			pr.buf.WriteString(" //")
+			pr.buf.WriteString(strings.TrimRight(trailing, " \t"))
+			pr.buf.WriteByte('\n')
+			return
+		}
+		pr.buf.WriteByte('\n')
+		pr.printComment(trailing)
+		return
+	}
+	pr.buf.WriteByte('\n')
+}
+
+// openBlock prints the start of a declaration that has a body enclosed in
+// braces. The trailing comment, which protoc associates with the open brace,
+// is printed as the first thing in the body.
+func (pr *printer) openBlock(loc *dpb.SourceCodeInfo_Location, decl string) {
+	pr.printLeadingComments(loc)
+	pr.line("%s {", decl)
+	pr.indent++
+	if !pr.OmitComments && loc != nil && loc.TrailingComments != nil {
+		pr.printComment(loc.GetTrailingComments())
+	}
+}
+
+func (pr *printer) closeBlock() {
+	pr.indent--
+	pr.line("}")
+}
+
+// element is a declaration in a file or in the body of a message, enum, or
+// service.
+type element struct {
+	loc *dpb.SourceCodeInfo_Location
+	// whether the element has a body (so it is separated from adjacent
+	// elements by blank lines)
+	block bool
+	print func()
+}
+
+type elementsBySource []element
+
+func (e elementsBySource) Len() int {
+	return len(e)
+}
+
+func (e elementsBySource) Less(i, j int) bool {
+	si, sj := e[i].loc.GetSpan(), e[j].loc.GetSpan()
+	if si[0] != sj[0] {
+		return si[0] < sj[0]
+	}
+	return si[1] < sj[1]
+}
+
+func (e elementsBySource) Swap(i, j int) {
+	e[i], e[j] = e[j], e[i]
+}
+
+// printElements prints the given elements. If all of them have source code
+// info, they are printed in the same order as they appeared in the source.
+// Otherwise, they are printed in the given order.
+func (pr *printer) printElements(elems []element) {
+	inSourceOrder := true
+	for _, e := range elems {
+		if len(e.loc.GetSpan()) < 3 {
+			inSourceOrder = false
+			break
+		}
+	}
+	if inSourceOrder {
+		sort.Stable(elementsBySource(elems))
+	}
+	for i, e := range elems {
+		if i > 0 && (e.block || elems[i-1].block) {
+			pr.blankLine()
+		}
+		e.print()
+	}
+}
+
+// file
+
+func (pr *printer) printFile() {
+	fdp := pr.fd.AsFileDescriptorProto()
+	syntax := fdp.GetSyntax()
+	if syntax == "" {
+		syntax = "proto2"
+	}
+	pr.printLeadingComments(pr.loc(nil))
+	pr.printDecl(pr.loc([]int32{file_syntaxTag}), fmt.Sprintf("syntax = %q", syntax))
+
+	if pkg := pr.fd.GetPackage(); pkg != "" {
+		pr.blankLine()
+		pr.printDecl(pr.loc([]int32{file_packageTag}), "package "+pkg)
+	}
+
+	if deps := fdp.GetDependency(); len(deps) > 0 {
+		pr.blankLine()
+		for i, dep := range deps {
+			kind := ""
+			if isIndexIn(fdp.GetPublicDependency(), i) {
+				kind = "public "
+			} else if isIndexIn(fdp.GetWeakDependency(), i) {
+				kind = "weak "
+			}
+			pr.printDecl(pr.loc([]int32{file_dependencyTag, int32(i)}), fmt.Sprintf("import %s%s", kind, quotedString(dep)))
+		}
+	}
+
+	opts := pr.options(pr.fd.GetOptions(), []int32{file_optionsTag}, pr.fd.GetPackage())
+	if len(opts) > 0 {
+		pr.blankLine()
+		pr.printOptionStatements(opts)
+	}
+
+	var elems []element
+	for _, md := range pr.fd.GetMessageTypes() {
+		md := md
+		elems = append(elems, element{loc: md.GetSourceInfo(), block: true, print: func() { pr.printMessage(md) }})
+	}
+	for _, ed := range pr.fd.GetEnumTypes() {
+		ed := ed
+		elems = append(elems, element{loc: ed.GetSourceInfo(), block: true, print: func() { pr.printEnum(ed) }})
+	}
+	elems = append(elems, pr.extendElements(pr.fd.GetPackage(), pr.fd.GetExtensions())...)
+	for _, sd := range pr.fd.GetServices() {
+		sd := sd
+		elems = append(elems, element{loc: sd.GetSourceInfo(), block: true, print: func() { pr.printService(sd) }})
+	}
+	if len(elems) > 0 {
+		pr.blankLine()
+		pr.printElements(elems)
+	}
+}
+
+func isIndexIn(indexes []int32, i int) bool {
+	for _, idx := range indexes {
+		if int(idx) == i {
+			return true
+		}
+	}
+	return false
+}
+
+// extendElements groups the given extensions into "extend" blocks. Adjacent
+// extensions for the same extendee go into the same block.
+func (pr *printer) extendElements(scope string, exts []*desc.FieldDescriptor) []element {
+	var elems []element
+	for len(exts) > 0 {
+		n := 1
+		for n < len(exts) && exts[n].GetOwner() == exts[0].GetOwner() {
+			n++
+		}
+		block := exts[:n]
+		elems = append(elems, element{loc: block[0].GetSourceInfo(), block: true, print: func() { pr.printExtendBlock(scope, block) }})
+		exts = exts[n:]
+	}
+	return elems
+}
+
+func (pr *printer) printExtendBlock(scope string, exts []*desc.FieldDescriptor) {
+	pr.line("extend %s {", pr.typeName(scope, exts[0].GetOwner().GetFullyQualifiedName()))
+	pr.indent++
+	var elems []element
+	for _, ext := range exts {
+		ext := ext
+		elems = append(elems, element{loc: ext.GetSourceInfo(), block: ext.GetType() == dpb.FieldDescriptorProto_TYPE_GROUP, print: func() { pr.printField(ext, scope) }})
+	}
+	pr.printElements(elems)
+	pr.closeBlock()
+}
+
+// messages
+
+func (pr *printer) printMessage(md *desc.MessageDescriptor) {
+	pr.openBlock(md.GetSourceInfo(), "message "+md.GetName())
+	pr.printMessageBody(md)
+	pr.closeBlock()
+}
+
+func (pr *printer) printMessageBody(md *desc.MessageDescriptor) {
+	path := pr.paths[md]
+	scope := md.GetFullyQualifiedName()
+	opts := pr.options(md.GetOptions(), appendPath(path, message_optionsTag), parentScope(scope))
+	pr.printOptionStatements(opts)
+
+	var elems []element
+	// nested messages that are group bodies are printed with their fields
+	groups := map[*desc.MessageDescriptor]bool{}
+	printedOneOfs := map[*desc.OneOfDescriptor]bool{}
+	for _, fld := range md.GetFields() {
+		fld := fld
+		if fld.GetType() == dpb.FieldDescriptorProto_TYPE_GROUP {
+			groups[fld.GetMessageType()] = true
+		}
+		if od := fld.GetOneOf(); od != nil && !isSyntheticOneOf(od) {
+			if !printedOneOfs[od] {
+				printedOneOfs[od] = true
+				elems = append(elems, element{loc: od.GetSourceInfo(), block: true, print: func() { pr.printOneOf(od) }})
+			}
+			continue
+		}
+		elems = append(elems, element{loc: fld.GetSourceInfo(), block: fld.GetType() == dpb.FieldDescriptorProto_TYPE_GROUP, print: func() { pr.printField(fld, scope) }})
+	}
+	for _, ext := range md.GetNestedExtensions() {
+		if ext.GetType() == dpb.FieldDescriptorProto_TYPE_GROUP {
+			groups[ext.GetMessageType()] = true
+		}
+	}
+	for _, nmd := range md.GetNestedMessageTypes() {
+		nmd := nmd
+		if nmd.IsMapEntry() || groups[nmd] {
+			continue
+		}
+		elems = append(elems, element{loc: nmd.GetSourceInfo(), block: true, print: func() { pr.printMessage(nmd) }})
+	}
+	for _, ed := range md.GetNestedEnumTypes() {
+		ed := ed
+		elems = append(elems, element{loc: ed.GetSourceInfo(), block: true, print: func() { pr.printEnum(ed) }})
+	}
+	elems = append(elems, pr.extendElements(scope, md.GetNestedExtensions())...)
+
+	mdp := md.AsDescriptorProto()
+	elems = append(elems, pr.extensionRangeElements(md, path)...)
+	if len(mdp.GetReservedRange()) > 0 {
+		var ranges []string
+		for _, rr := range mdp.GetReservedRange() {
+			ranges = append(ranges, rangeString(rr.GetStart(), rr.GetEnd()-1, maxTag))
+		}
+		elems = append(elems, element{loc: pr.loc(appendPath(path, message_reservedRangeTag, 0)), print: func() {
+			pr.printDecl(pr.loc(appendPath(path, message_reservedRangeTag)), "reserved "+strings.Join(ranges, ", "))
+		}})
+	}
+	if len(mdp.GetReservedName()) > 0 {
+		elems = append(elems, element{loc: pr.loc(appendPath(path, message_reservedNameTag, 0)), print: func() {
+			pr.printDecl(pr.loc(appendPath(path, message_reservedNameTag)), "reserved "+quotedStrings(mdp.GetReservedName()))
+		}})
+	}
+	if len(opts) > 0 && len(elems) > 0 {
+		pr.blankLine()
+	}
+	pr.printElements(elems)
+}
+
+// maxTag is the maximum tag number for a field.
+const maxTag = 536870911
+
+// rangeString formats the given range, whose end is inclusive, as it
+// appears in a reserved or extensions statement.
+func rangeString(start, end, max int32) string {
+	switch {
+	case start == end:
+		return strconv.Itoa(int(start))
+	case end == max:
+		return fmt.Sprintf("%d to max", start)
+	default:
+		return fmt.Sprintf("%d to %d", start, end)
+	}
+}
+
+func quotedStrings(s []string) string {
+	q := make([]string, len(s))
+	for i, str := range s {
+		q[i] = quotedString(str)
+	}
+	return strings.Join(q, ", ")
+}
+
+// extensionRangeElements returns elements for the message's extension
+// ranges. Adjacent ranges that have the same options are combined into a
+// single statement.
+func (pr *printer) extensionRangeElements(md *desc.MessageDescriptor, path []int32) []element {
+	var elems []element
+	ranges := md.AsDescriptorProto().GetExtensionRange()
+	max := int32(maxTag)
+	if md.GetMessageOptions().GetMessageSetWireFormat() {
+		max = math.MaxInt32
+	}
+	for i := 0; i < len(ranges); {
+		n := 1
+		for i+n < len(ranges) && proto.Equal(ranges[i].GetOptions(), ranges[i+n].GetOptions()) {
+			n++
+		}
+		var strs []string
+		for _, er := range ranges[i : i+n] {
+			strs = append(strs, rangeString(er.GetStart(), er.GetEnd()-1, max))
+		}
+		rangePath := appendPath(path, message_extensionRangeTag, int32(i))
+		opts := pr.options(ranges[i].GetOptions(), appendPath(rangePath, extensionRange_optionsTag), parentScope(md.GetFullyQualifiedName()))
+		elems = append(elems, element{loc: pr.loc(rangePath), print: func() {
+			pr.printDecl(pr.loc(rangePath), "extensions "+strings.Join(strs, ", ")+compactOptions(opts))
+		}})
+		i += n
+	}
+	return elems
+}
+
+func isSyntheticOneOf(od *desc.OneOfDescriptor) bool {
+	for _, fld := range od.GetOwner().GetFields() {
+		if fld.GetOneOf() == od {
+			return fld.AsFieldDescriptorProto().GetProto3Optional()
+		}
+	}
+	return false
+}
+
+func (pr *printer) printOneOf(od *desc.OneOfDescriptor) {
+	md := od.GetOwner()
+	scope := md.GetFullyQualifiedName()
+	pr.openBlock(od.GetSourceInfo(), "oneof "+od.GetName())
+	opts := pr.options(od.GetOptions(), appendPath(pr.paths[od], oneOf_optionsTag), scope)
+	pr.printOptionStatements(opts)
+	var elems []element
+	for _, fld := range md.GetFields() {
+		fld := fld
+		if fld.GetOneOf() == od {
+			elems = append(elems, element{loc: fld.GetSourceInfo(), block: fld.GetType() == dpb.FieldDescriptorProto_TYPE_GROUP, print: func() { pr.printField(fld, scope) }})
+		}
+	}
+	if len(opts) > 0 && len(elems) > 0 {
+		pr.blankLine()
+	}
+	pr.printElements(elems)
+	pr.closeBlock()
+}
+
+// fields
+
+func (pr *printer) printField(fld *desc.FieldDescriptor, scope string) {
+	fdp := fld.AsFieldDescriptorProto()
+	var decl bytes.Buffer
+
+	proto3 := fld.GetFile().AsFileDescriptorProto().GetSyntax() == "proto3"
+	switch {
+	case fld.IsMap():
+		// maps have no label
+	case fld.GetLabel() == dpb.FieldDescriptorProto_LABEL_REPEATED:
+		decl.WriteString("repeated ")
+	case fdp.GetProto3Optional():
+		decl.WriteString("optional ")
+	case proto3 || (fld.GetOneOf() != nil && !fld.IsExtension()):
+		// no label
+	case fld.GetLabel() == dpb.FieldDescriptorProto_LABEL_REQUIRED:
+		decl.WriteString("required ")
+	default:
+		decl.WriteString("optional ")
+	}
+
+	name := fld.GetName()
+	switch {
+	case fld.IsMap():
+		entry := fld.GetMessageType()
+		fmt.Fprintf(&decl, "map<%s, %s> ", pr.fieldTypeName(scope, entry.FindFieldByNumber(1)), pr.fieldTypeName(scope, entry.FindFieldByNumber(2)))
+	case fld.GetType() == dpb.FieldDescriptorProto_TYPE_GROUP:
+		decl.WriteString("group ")
+		name = fld.GetMessageType().GetName()
+	default:
+		decl.WriteString(pr.fieldTypeName(scope, fld))
+		decl.WriteByte(' ')
+	}
+	fmt.Fprintf(&decl, "%s = %d", name, fld.GetNumber())
+
+	var opts []option
+	if fdp.DefaultValue != nil {
+		opts = append(opts, option{name: "default", val: defaultValueString(fld)})
+	}
+	if fdp.JsonName != nil && !fld.IsExtension() && fdp.GetJsonName() != desc.DefaultJSONName(fld.GetName()) {
+		opts = append(opts, option{name: "json_name", val: quotedString(fdp.GetJsonName())})
+	}
+	opts = append(opts, pr.options(fld.GetOptions(), appendPath(pr.paths[fld], field_optionsTag), scope)...)
+	decl.WriteString(compactOptions(opts))
+
+	if fld.GetType() == dpb.FieldDescriptorProto_TYPE_GROUP {
+		pr.openBlock(fld.GetSourceInfo(), decl.String())
+		pr.printMessageBody(fld.GetMessageType())
+		pr.closeBlock()
+		return
+	}
+	pr.printDecl(fld.GetSourceInfo(), decl.String())
+}
+
+func (pr *printer) fieldTypeName(scope string, fld *desc.FieldDescriptor) string {
+	switch fld.GetType() {
+	case dpb.FieldDescriptorProto_TYPE_MESSAGE, dpb.FieldDescriptorProto_TYPE_GROUP:
+		return pr.typeName(scope, fld.GetMessageType().GetFullyQualifiedName())
+	case dpb.FieldDescriptorProto_TYPE_ENUM:
+		return pr.typeName(scope, fld.GetEnumType().GetFullyQualifiedName())
+	default:
+		return strings.ToLower(strings.TrimPrefix(fld.GetType().String(), "TYPE_"))
+	}
+}
+
+func defaultValueString(fld *desc.FieldDescriptor) string {
+	def := fld.AsFieldDescriptorProto().GetDefaultValue()
+	switch fld.GetType() {
+	case dpb.FieldDescriptorProto_TYPE_STRING:
+		return quotedString(def)
+	case dpb.FieldDescriptorProto_TYPE_BYTES:
+		// the default value for bytes fields is already escaped
+		return `"` + def + `"`
+	default:
+		return def
+	}
+}
+
+// enums
+
+func (pr *printer) printEnum(ed *desc.EnumDescriptor) {
+	path := pr.paths[ed]
+	pr.openBlock(ed.GetSourceInfo(), "enum "+ed.GetName())
+	opts := pr.options(ed.GetOptions(), appendPath(path, enum_optionsTag), parentScope(ed.GetFullyQualifiedName()))
+	pr.printOptionStatements(opts)
+
+	var elems []element
+	for _, vd := range ed.GetValues() {
+		vd := vd
+		elems = append(elems, element{loc: vd.GetSourceInfo(), print: func() { pr.printEnumValue(vd) }})
+	}
+	edp := ed.AsEnumDescriptorProto()
+	if len(edp.GetReservedRange()) > 0 {
+		var ranges []string
+		for _, rr := range edp.GetReservedRange() {
+			ranges = append(ranges, rangeString(rr.GetStart(), rr.GetEnd(), math.MaxInt32))
+		}
+		elems = append(elems, element{loc: pr.loc(appendPath(path, enum_reservedRangeTag, 0)), print: func() {
+			pr.printDecl(pr.loc(appendPath(path, enum_reservedRangeTag)), "reserved "+strings.Join(ranges, ", "))
+		}})
+	}
+	if len(edp.GetReservedName()) > 0 {
+		elems = append(elems, element{loc: pr.loc(appendPath(path, enum_reservedNameTag, 0)), print: func() {
+			pr.printDecl(pr.loc(appendPath(path, enum_reservedNameTag)), "reserved "+quotedStrings(edp.GetReservedName()))
+		}})
+	}
+	if len(opts) > 0 && len(elems) > 0 {
+		pr.blankLine()
+	}
+	pr.printElements(elems)
+	pr.closeBlock()
+}
+
+func (pr *printer) printEnumValue(vd *desc.EnumValueDescriptor) {
+	// enum values are siblings of their enum, not children of it
+	scope := parentScope(vd.GetEnum().GetFullyQualifiedName())
+	opts := pr.options(vd.GetOptions(), appendPath(pr.paths[vd], enumVal_optionsTag), scope)
+	pr.printDecl(vd.GetSourceInfo(), fmt.Sprintf("%s = %d%s", vd.GetName(), vd.GetNumber(), compactOptions(opts)))
+}
+
+// services
+
+func (pr *printer) printService(sd *desc.ServiceDescriptor) {
+	pr.openBlock(sd.GetSourceInfo(), "service "+sd.GetName())
+	opts := pr.options(sd.GetOptions(), appendPath(pr.paths[sd], service_optionsTag), parentScope(sd.GetFullyQualifiedName()))
+	pr.printOptionStatements(opts)
+	var elems []element
+	for _, mtd := range sd.GetMethods() {
+		mtd := mtd
+		elems = append(elems, element{loc: mtd.GetSourceInfo(), print: func() { pr.printMethod(mtd) }})
+	}
+	if len(opts) > 0 && len(elems) > 0 {
+		pr.blankLine()
+	}
+	pr.printElements(elems)
+	pr.closeBlock()
+}
+
+func (pr *printer) printMethod(mtd *desc.MethodDescriptor) {
+	scope := mtd.GetService().GetFullyQualifiedName()
+	streamKeyword := func(streaming bool) string {
+		if streaming {
+			return "stream "
+		}
+		return ""
+	}
+	decl := fmt.Sprintf("rpc %s(%s%s) returns (%s%s)", mtd.GetName(),
+		streamKeyword(mtd.IsClientStreaming()), pr.typeName(scope, mtd.GetInputType().GetFullyQualifiedName()),
+		streamKeyword(mtd.IsServerStreaming()), pr.typeName(scope, mtd.GetOutputType().GetFullyQualifiedName()))
+	opts := pr.options(mtd.GetOptions(), appendPath(pr.paths[mtd], method_optionsTag), scope)
+	if len(opts) == 0 {
+		pr.printDecl(mtd.GetSourceInfo(), decl)
+		return
+	}
+	pr.openBlock(mtd.GetSourceInfo(), decl)
+	pr.printOptionStatements(opts)
+	pr.closeBlock()
+}
+
+// options
+
+// option is a single option to print.
+type option struct {
+	name string
+	val  string
+	// source location for the option, if known
+	loc *dpb.SourceCodeInfo_Location
+}
+
+// options returns the options that are set in the given options message. The
+// path is the path to the options message, for finding the options' source
+// locations, and the scope is the fully-qualified name of the innermost scope
+// from which the names of custom options are resolved. Like protoc, that is
+// the scope that encloses the element (or, for fields, the scope in which the
+// field is declared).
+func (pr *printer) options(opts proto.Message, path []int32, scope string) []option {
+	if pr.err != nil {
+		return nil
+	}
+	if rv := reflect.ValueOf(opts); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return nil
+	}
+	md, err := desc.LoadMessageDescriptorForMessage(opts)
+	if err != nil {
+		pr.err = err
+		return nil
+	}
+	dm := dynamic.NewMessageWithExtensionRegistry(md, pr.er)
+	if err := dm.ConvertFrom(opts); err != nil {
+		pr.err = err
+		return nil
+	}
+
+	flds := append(md.GetFields(), dm.GetKnownExtensions()...)
+	sort.Sort(fieldsByNumber(flds))
+	var ret []option
+	for _, fld := range flds {
+		if !dm.HasField(fld) {
+			continue
+		}
+		if fld.GetNumber() == uninterpretedOptionsTag {
+			// descriptors produced by protoc never have any
+			continue
+		}
+		name := fld.GetName()
+		if fld.IsExtension() {
+			name = "(" + pr.name(scope, fld.GetFullyQualifiedName(), false) + ")"
+		}
+		fldPath := appendPath(path, fld.GetNumber())
+		if fld.IsRepeated() {
+			for i, v := range dm.GetField(fld).([]interface{}) {
+				ret = append(ret, option{name: name, val: pr.optionValue(fld, v), loc: pr.loc(appendPath(fldPath, int32(i)))})
+			}
+		} else {
+			ret = append(ret, option{name: name, val: pr.optionValue(fld, dm.GetField(fld)), loc: pr.loc(fldPath)})
+		}
+	}
+	return ret
+}
+
+const uninterpretedOptionsTag = 999
+
+type fieldsByNumber []*desc.FieldDescriptor
+
+func (f fieldsByNumber) Len() int {
+	return len(f)
+}
+
+func (f fieldsByNumber) Less(i, j int) bool {
+	return f[i].GetNumber() < f[j].GetNumber()
+}
+
+func (f fieldsByNumber) Swap(i, j int) {
+	f[i], f[j] = f[j], f[i]
+}
+
+func (pr *printer) optionValue(fld *desc.FieldDescriptor, v interface{}) string {
+	switch v := v.(type) {
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return quotedString(v)
+	case []byte:
+		return quotedString(string(v))
+	case float32:
+		return floatString(float64(v), 32)
+	case float64:
+		return floatString(v, 64)
+	case int32:
+		if ed := fld.GetEnumType(); ed != nil {
+			for _, vd := range ed.GetValues() {
+				if vd.GetNumber() == v {
+					return vd.GetName()
+				}
+			}
+		}
+		return strconv.FormatInt(int64(v), 10)
+	case *dynamic.Message:
+		txt, err := v.MarshalText()
+		if err != nil {
+			pr.err = err
+			return ""
+		}
+		if len(txt) == 0 {
+			return "{ }"
+		}
+		return "{ " + strings.TrimSpace(string(txt)) + " }"
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func floatString(f float64, bitSize int) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, bitSize)
+	}
+}
+
+func (pr *printer) printOptionStatements(opts []option) {
+	for _, opt := range opts {
+		pr.printDecl(opt.loc, fmt.Sprintf("option %s = %s", opt.name, opt.val))
+	}
+}
+
+// compactOptions formats the given options in the bracketed form that is
+// used for fields, enum values, and extension ranges.
+func compactOptions(opts []option) string {
+	if len(opts) == 0 {
+		return ""
+	}
+	strs := make([]string, len(opts))
+	for i, opt := range opts {
+		strs[i] = opt.name + " = " + opt.val
+	}
+	return " [" + strings.Join(strs, ", ") + "]"
+}
+
+// quotedString quotes the given string, escaping it the same way as protoc
+// does: non-printable and non-ASCII bytes are written as octal escapes.
+func quotedString(s string) string {
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '"':
+			buf.WriteString(`\"`)
+		case '\'':
+			buf.WriteString(`\'`)
+		case '\\':
+			buf.WriteString(`\\`)
+		default:
+			if c < 0x20 || c >= 0x7f {
+				fmt.Fprintf(&buf, "\\%03o", c)
+			} else {
+				buf.WriteByte(c)
+			}
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+// names
+
+// typeName returns the name to use to refer to the given message or enum
+// from the given scope.
+func (pr *printer) typeName(scope, fqn string) string {
+	return pr.name(scope, fqn, true)
+}
+
+// name returns the shortest name that refers to the element with the given
+// fully-qualified name when resolved from the given scope, using the same
+// scoping rules as protoc. If typesOnly is true, the name is resolved the way
+// references to messages and enums are. Names are either relative to the
+// element's package or are fully-qualified. If no such name works, the
+// fully-qualified name, with a leading dot, is returned.
+func (pr *printer) name(scope, fqn string, typesOnly bool) string {
+	parts := strings.Split(fqn, ".")
+	pkgParts := 0
+	if d := pr.findSymbol(fqn); d != nil && d.GetFile().GetPackage() != "" {
+		pkgParts = len(strings.Split(d.GetFile().GetPackage(), "."))
+	}
+	for i := len(parts) - 1; i >= pkgParts; i-- {
+		candidate := strings.Join(parts[i:], ".")
+		if pr.resolve(scope, candidate, typesOnly) == fqn {
+			return candidate
+		}
+	}
+	if pkgParts > 0 && pr.resolve(scope, fqn, typesOnly) == fqn {
+		return fqn
+	}
+	return "." + fqn
+}
+
+func (pr *printer) resolve(scope, name string, typesOnly bool) string {
+	firstPart := name
+	if dot := strings.IndexByte(name, '.'); dot >= 0 {
+		firstPart = name[:dot]
+	}
+	for {
+		candidate := joinName(scope, firstPart)
+		if pr.packages[candidate] {
+			if firstPart != name {
+				return joinName(scope, name)
+			}
+		} else if d := pr.findSymbol(candidate); d != nil {
+			if firstPart != name {
+				if isAggregate(d) {
+					return joinName(scope, name)
+				}
+			} else if !typesOnly || isType(d) {
+				return candidate
+			}
+		}
+		if scope == "" {
+			return ""
+		}
+		scope = parentScope(scope)
+	}
+}
+
+// parentScope returns the scope that encloses the element with the given
+// fully-qualified name.
+func parentScope(fqn string) string {
+	if dot := strings.LastIndexByte(fqn, '.'); dot >= 0 {
+		return fqn[:dot]
+	}
+	return ""
+}
+
+func joinName(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+func (pr *printer) findSymbol(fqn string) desc.Descriptor {
+	for _, fd := range pr.files {
+		if d := fd.FindSymbol(fqn); d != nil {
+			return d
+		}
+	}
+	return nil
+}
+
+func isType(d desc.Descriptor) bool {
+	switch d.(type) {
+	case *desc.MessageDescriptor, *desc.EnumDescriptor:
+		return true
+	}
+	return false
+}
+
+func isAggregate(d desc.Descriptor) bool {
+	switch d.(type) {
+	case *desc.MessageDescriptor, *desc.EnumDescriptor, *desc.ServiceDescriptor:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,215 @@
+package protoprint
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/desc_test"
+	_ "github.com/jhump/protoreflect/desc/desc_test/nopkg"
+	_ "github.com/jhump/protoreflect/desc/desc_test/pkg"
+	"github.com/jhump/protoreflect/desc/protoparse"
+)
+
+func TestPrintRoundTrips(t *testing.T) {
+	fromSet, err := desc.CreateFileDescriptorFromSet(desc_test.GetDescriptorSet())
+	ok(t, err)
+	fds := []*desc.FileDescriptor{fromSet}
+	for _, name := range []string{
+		"desc_test2.proto",
+		"desc_test_proto3.proto",
+		"nopkg/desc_test_nopkg.proto",
+		"nopkg/desc_test_nopkg_new.proto",
+		"pkg/desc_test_pkg.proto",
+	} {
+		fd, err := desc.LoadFileDescriptor(name)
+		ok(t, err)
+		fds = append(fds, fd)
+	}
+
+	var p Printer
+	for _, fd := range fds {
+		// print the file and its dependencies, then parse the results
+		sources := map[string]string{}
+		printAll(t, &p, fd, sources)
+		parser := protoparse.Parser{Accessor: accessorFor(sources)}
+		parsed, err := parser.ParseFiles(fd.GetName())
+		ok(t, err, "%s", fd.GetName())
+
+		expected := proto.Clone(fd.AsFileDescriptorProto()).(*dpb.FileDescriptorProto)
+		expected.SourceCodeInfo = nil
+		actual := proto.Clone(parsed[0].AsFileDescriptorProto()).(*dpb.FileDescriptorProto)
+		actual.SourceCodeInfo = nil
+		if !proto.Equal(expected, actual) {
+			t.Errorf("%s: re-parsed descriptor does not match:\nexpected: %v\nactual:   %v\nsource:\n%s", fd.GetName(), expected, actual, sources[fd.GetName()])
+		}
+	}
+}
+
+func printAll(t *testing.T, p *Printer, fd *desc.FileDescriptor, sources map[string]string) {
+	if _, ok := sources[fd.GetName()]; ok || strings.HasPrefix(fd.GetName(), "google/protobuf/") {
+		return
+	}
+	src, err := p.PrintProtoToString(fd)
+	ok(t, err)
+	sources[fd.GetName()] = src
+	for _, dep := range fd.GetDependencies() {
+		printAll(t, p, dep, sources)
+	}
+}
+
+func TestPrintComments(t *testing.T) {
+	fd, err := desc.CreateFileDescriptorFromSet(desc_test.GetDescriptorSet())
+	ok(t, err)
+
+	var p Printer
+	src, err := p.PrintProtoToString(fd.FindEnum("desc_test.TestMessage.NestedEnum"))
+	ok(t, err)
+	eq(t, `// Comment for NestedEnum
+enum NestedEnum {
+  // Comment for VALUE1
+  VALUE1 = 1;
+  // Comment for VALUE2
+  VALUE2 = 2;
+}
+`, src)
+
+	p = Printer{OmitComments: true, Indent: "\t"}
+	src, err = p.PrintProtoToString(fd.FindEnum("desc_test.TestMessage.NestedEnum"))
+	ok(t, err)
+	eq(t, "enum NestedEnum {\n\tVALUE1 = 1;\n\tVALUE2 = 2;\n}\n", src)
+}
+
+func TestPrintOptionsAndNames(t *testing.T) {
+	parser := protoparse.Parser{Accessor: accessorFor(map[string]string{
+		"test.proto": `
+			syntax = "proto2";
+			package foo.bar;
+			import "google/protobuf/descriptor.proto";
+			// Leading
+			message Opts { // Trailing
+				optional string s = 1;
+				repeated int32 i = 2;
+				optional Opts sub = 3;
+				map<string, Opts> m = 4 [json_name = "MMM"];
+				optional group Grp = 5 { optional int32 x = 1 [default = -5]; }
+				oneof o { string os = 6; bytes ob = 7 [default = "\000\001"]; }
+				extensions 100 to 200, 300 to max;
+				reserved 10 to 20, 30;
+				reserved "foo", "bar";
+				extend Opts { repeated Opts ee = 100; }
+				enum E { option allow_alias = true; A = 0; B = 0 [deprecated = true]; reserved 10 to max; }
+			}
+			extend google.protobuf.MessageOptions {
+				optional Opts opts = 10101;
+				repeated string tags = 10102;
+			}
+			message Foo {
+				option (opts).s = "abc";
+				option (opts).sub = { s: "nested" i: [1, 2] };
+				option (foo.bar.tags) = "x\n";
+				option (.foo.bar.tags) = "y";
+				optional .foo.bar.Opts.E e = 1 [default = B];
+				message Opts { }
+				optional .foo.bar.Opts o = 2;
+				optional Opts o2 = 3;
+			}
+			service Svc {
+				rpc Do(stream Foo) returns (Opts) { option deprecated = true; }
+				rpc Do2(Foo) returns (stream Opts);
+			}`,
+	})}
+	fds, err := parser.ParseFiles("test.proto")
+	ok(t, err)
+
+	var p Printer
+	src, err := p.PrintProtoToString(fds[0])
+	ok(t, err)
+	eq(t, `syntax = "proto2";
+
+package foo.bar;
+
+import "google/protobuf/descriptor.proto";
+
+// Leading
+message Opts {
+  // Trailing
+  optional string s = 1;
+  repeated int32 i = 2;
+  optional Opts sub = 3;
+  map<string, Opts> m = 4 [json_name = "MMM"];
+
+  optional group Grp = 5 {
+    optional int32 x = 1 [default = -5];
+  }
+
+  oneof o {
+    string os = 6;
+    bytes ob = 7 [default = "\000\001"];
+  }
+
+  extensions 100 to 200, 300 to max;
+  reserved 10 to 20, 30;
+  reserved "foo", "bar";
+
+  extend Opts {
+    repeated Opts ee = 100;
+  }
+
+  enum E {
+    option allow_alias = true;
+
+    A = 0;
+    B = 0 [deprecated = true];
+    reserved 10 to max;
+  }
+}
+
+extend google.protobuf.MessageOptions {
+  optional Opts opts = 10101;
+  repeated string tags = 10102;
+}
+
+message Foo {
+  option (opts) = { s:"abc" sub:<s:"nested" i:1 i:2 > };
+  option (tags) = "x\n";
+  option (tags) = "y";
+
+  optional foo.bar.Opts.E e = 1 [default = B];
+
+  message Opts {
+  }
+
+  optional foo.bar.Opts o = 2;
+  optional Opts o2 = 3;
+}
+
+service Svc {
+  rpc Do(stream Foo) returns (Opts) {
+    option deprecated = true;
+  }
+  rpc Do2(Foo) returns (stream Opts);
+}
+`, src)
+
+	// printing a single extension includes the extend block
+	src, err = p.PrintProtoToString(fds[0].FindMessage("foo.bar.Opts").GetNestedExtensions()[0])
+	ok(t, err)
+	eq(t, "extend Opts {\n  repeated Opts ee = 100;\n}\n", src)
+}
+
+func accessorFor(files map[string]string) protoparse.FileAccessor {
+	return func(filename string) (io.ReadCloser, error) {
+		contents, ok := files[filename]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return ioutil.NopCloser(strings.NewReader(contents)), nil
+	}
+}
@@ -0,0 +1,392 @@
+package desc
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// ReachableElements computes the minimal set of elements needed to describe
+// the given roots, keyed by fully-qualified name. The roots may be messages,
+// enums, services, methods, or extensions. The result includes the roots and
+// everything reachable from them:
+//   - A message reaches the message and enum types of its fields, and any
+//     extensions of it (see below).
+//   - A service reaches its methods, and a method reaches its request and
+//     response types.
+//   - An extension reaches the message it extends and its type.
+//   - Every element that is kept in a pruned file (see
+//     ToPrunedFileDescriptorSet), including the file itself, reaches the
+//     extensions that define the custom options set on it.
+//
+// Extensions of reachable messages are included if they are declared in the
+// roots' files or in any of their transitive dependencies. Extensions declared
+// in other files, which the roots' files do not know about, are not included.
+//
+// An error is returned if any root is not one of the supported kinds of
+// element.
+func ReachableElements(roots ...Descriptor) (map[string]Descriptor, error) {
+	r := reachability{reached: map[string]Descriptor{}, exts: extensionCache{}}
+	files := map[*FileDescriptor]struct{}{}
+	for _, root := range roots {
+		switch d := root.(type) {
+		case *MessageDescriptor, *EnumDescriptor, *ServiceDescriptor, *MethodDescriptor:
+		case *FieldDescriptor:
+			if !d.IsExtension() {
+				return nil, fmt.Errorf("%s is a field, not an extension", d.GetFullyQualifiedName())
+			}
+		default:
+			return nil, fmt.Errorf("%s is a %T; roots must be messages, enums, services, methods, or extensions", root.GetFullyQualifiedName(), root)
+		}
+		r.visit(root)
+		addFileAndDeps(root.GetFile(), files)
+	}
+
+	// extensions of reachable messages are reachable, too, as are the
+	// extensions for custom options; since they can reach more messages, we
+	// repeat until no more are found
+	var exts []*FieldDescriptor
+	for fd := range files {
+		for _, d := range fd.symbols {
+			if exd, ok := d.(*FieldDescriptor); ok && exd.IsExtension() {
+				exts = append(exts, exd)
+			}
+		}
+	}
+	optionsChecked := map[Descriptor]bool{}
+	for {
+		found := false
+		var kept []Descriptor
+		for _, d := range r.reached {
+			kept = append(kept, keptElements(d)...)
+		}
+		for _, d := range kept {
+			if optionsChecked[d] {
+				continue
+			}
+			optionsChecked[d] = true
+			for _, exd := range r.exts.customOptions(d) {
+				if _, ok := r.reached[exd.fqn]; !ok {
+					r.visit(exd)
+					found = true
+				}
+			}
+		}
+		for _, exd := range exts {
+			if _, ok := r.reached[exd.fqn]; ok {
+				continue
+			}
+			if _, ok := r.reached[exd.owner.fqn]; ok {
+				r.visit(exd)
+				found = true
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	return r.reached, nil
+}
+
+func addFileAndDeps(fd *FileDescriptor, files map[*FileDescriptor]struct{}) {
+	if _, ok := files[fd]; ok {
+		return
+	}
+	files[fd] = struct{}{}
+	for _, dep := range fd.deps {
+		addFileAndDeps(dep, files)
+	}
+}
+
+// keptElements returns the elements whose protos are kept in a pruned file
+// when the given element is reachable: the element, its file, and the parts of
+// it that are not pruned separately. Since a service is kept with any of its
+// reachable methods, a method's service is included.
+func keptElements(d Descriptor) []Descriptor {
+	kept := []Descriptor{d, d.GetFile()}
+	switch d := d.(type) {
+	case *MessageDescriptor:
+		for _, fld := range d.fields {
+			kept = append(kept, fld)
+		}
+		for _, ood := range d.oneOfs {
+			kept = append(kept, ood)
+		}
+	case *EnumDescriptor:
+		for _, vd := range d.values {
+			kept = append(kept, vd)
+		}
+	case *MethodDescriptor:
+		kept = append(kept, d.parent)
+	}
+	return kept
+}
+
+// extensionCache holds the extensions visible from each file, for resolving
+// custom options.
+type extensionCache map[*FileDescriptor]map[string]*FieldDescriptor
+
+// customOptions returns the extensions for the custom options set on the given
+// element.
+func (c extensionCache) customOptions(d Descriptor) []*FieldDescriptor {
+	fd := d.GetFile()
+	exts, ok := c[fd]
+	if !ok {
+		exts = fd.visibleExtensions()
+		c[fd] = exts
+	}
+	return optionExtensions(d, exts)
+}
+
+type reachability struct {
+	reached map[string]Descriptor
+	exts    extensionCache
+}
+
+func (r *reachability) visit(d Descriptor) {
+	if _, ok := r.reached[d.GetFullyQualifiedName()]; ok {
+		return
+	}
+	r.reached[d.GetFullyQualifiedName()] = d
+	switch d := d.(type) {
+	case *MessageDescriptor:
+		for _, fld := range d.fields {
+			r.visitFieldType(fld)
+		}
+	case *ServiceDescriptor:
+		for _, mtd := range d.methods {
+			r.visit(mtd)
+		}
+	case *MethodDescriptor:
+		r.visit(d.inType)
+		r.visit(d.outType)
+	case *FieldDescriptor:
+		r.visit(d.owner)
+		r.visitFieldType(d)
+	}
+}
+
+func (r *reachability) visitFieldType(fld *FieldDescriptor) {
+	if fld.msgType != nil {
+		r.visit(fld.msgType)
+	} else if fld.enumType != nil {
+		r.visit(fld.enumType)
+	}
+}
+
+// ToPrunedFileDescriptorSet creates a file descriptor set that contains only
+// the elements needed to describe the given roots, as computed by
+// ReachableElements. This is useful for publishing the schema for a service,
+// for example, without also publishing unrelated elements that happen to be
+// declared in the same files.
+//
+// The set includes only files that contain at least one reachable element, in
+// topological order. Each file's imports are rewritten to be just the files
+// that its remaining elements refer to. Unreachable elements are removed,
+// except that a message that encloses a reachable element is retained, but
+// without any of its own fields, so that the reachable element keeps its
+// fully-qualified name. Similarly, a service is retained with only its
+// reachable methods. Options are retained, and files that declare the custom
+// options set on retained elements are included. Source code info is not included since its locations
+// refer to the original, unpruned files.
+func ToPrunedFileDescriptorSet(roots ...Descriptor) (*dpb.FileDescriptorSet, error) {
+	reached, err := ReachableElements(roots...)
+	if err != nil {
+		return nil, err
+	}
+	p := pruner{reached: reached, deps: map[string]map[*FileDescriptor]struct{}{}, exts: extensionCache{}}
+
+	var files []*FileDescriptor
+	seen := map[*FileDescriptor]bool{}
+	for _, d := range reached {
+		if fd := d.GetFile(); !seen[fd] {
+			seen[fd] = true
+			files = append(files, fd)
+		}
+	}
+	sort.Sort(filesByName(files))
+	pruned := map[string]*dpb.FileDescriptorProto{}
+	for _, fd := range files {
+		pruned[fd.GetName()] = p.pruneFile(fd)
+	}
+
+	// emit files in topological order, dependencies first
+	var result []*dpb.FileDescriptorProto
+	added := map[string]bool{}
+	var add func(name string)
+	add = func(name string) {
+		if added[name] {
+			return
+		}
+		added[name] = true
+		for _, dep := range pruned[name].Dependency {
+			add(dep)
+		}
+		result = append(result, pruned[name])
+	}
+	for _, fd := range files {
+		add(fd.GetName())
+	}
+	return &dpb.FileDescriptorSet{File: result}, nil
+}
+
+type pruner struct {
+	reached map[string]Descriptor
+	// for each file, the files referenced by its retained elements
+	deps map[string]map[*FileDescriptor]struct{}
+	exts extensionCache
+}
+
+func (p *pruner) isReached(d Descriptor) bool {
+	_, ok := p.reached[d.GetFullyQualifiedName()]
+	return ok
+}
+
+// ref records that the given file refers to an element in the given file.
+func (p *pruner) ref(from *FileDescriptor, to Descriptor) {
+	if to == nil || to.GetFile() == from {
+		return
+	}
+	m := p.deps[from.GetName()]
+	if m == nil {
+		m = map[*FileDescriptor]struct{}{}
+		p.deps[from.GetName()] = m
+	}
+	m[to.GetFile()] = struct{}{}
+}
+
+// refOptions records that the given element's file refers to the extensions
+// for the custom options set on the element.
+func (p *pruner) refOptions(d Descriptor) {
+	for _, exd := range p.exts.customOptions(d) {
+		p.ref(d.GetFile(), exd)
+	}
+}
+
+func (p *pruner) refFieldTypes(fld *FieldDescriptor) {
+	if fld.msgType != nil {
+		p.ref(fld.file, fld.msgType)
+	} else if fld.enumType != nil {
+		p.ref(fld.file, fld.enumType)
+	}
+}
+
+func (p *pruner) pruneFile(fd *FileDescriptor) *dpb.FileDescriptorProto {
+	fdp := &dpb.FileDescriptorProto{
+		Name:    fd.proto.Name,
+		Package: fd.proto.Package,
+		Syntax:  fd.proto.Syntax,
+	}
+	if fd.proto.Options != nil {
+		fdp.Options = proto.Clone(fd.proto.Options).(*dpb.FileOptions)
+		p.refOptions(fd)
+	}
+	fdp.MessageType = p.pruneMessages(fd.messages)
+	fdp.EnumType = p.pruneEnums(fd.enums)
+	fdp.Extension = p.pruneExtensions(fd.extensions)
+	for _, sd := range fd.services {
+		var methods []*dpb.MethodDescriptorProto
+		for _, mtd := range sd.methods {
+			if p.isReached(mtd) {
+				p.refOptions(mtd)
+				p.ref(fd, mtd.inType)
+				p.ref(fd, mtd.outType)
+				methods = append(methods, proto.Clone(mtd.proto).(*dpb.MethodDescriptorProto))
+			}
+		}
+		if len(methods) > 0 {
+			p.refOptions(sd)
+			sdp := proto.Clone(sd.proto).(*dpb.ServiceDescriptorProto)
+			sdp.Method = methods
+			fdp.Service = append(fdp.Service, sdp)
+		}
+	}
+
+	// imports that are still used keep their original order, and files that
+	// were only imported indirectly (via public imports) are added after
+	var deps []*FileDescriptor
+	for dep := range p.deps[fd.GetName()] {
+		deps = append(deps, dep)
+	}
+	sort.Sort(filesByName(deps))
+	for _, dep := range fd.deps {
+		if _, ok := p.deps[fd.GetName()][dep]; ok {
+			fdp.Dependency = append(fdp.Dependency, dep.GetName())
+		}
+	}
+	for _, dep := range deps {
+		found := false
+		for _, d := range fd.deps {
+			if d == dep {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fdp.Dependency = append(fdp.Dependency, dep.GetName())
+		}
+	}
+	return fdp
+}
+
+func (p *pruner) pruneMessages(mds []*MessageDescriptor) []*dpb.DescriptorProto {
+	var ret []*dpb.DescriptorProto
+	for _, md := range mds {
+		nested := p.pruneMessages(md.nested)
+		enums := p.pruneEnums(md.enums)
+		exts := p.pruneExtensions(md.extensions)
+		if p.isReached(md) {
+			p.refOptions(md)
+			for _, fld := range md.fields {
+				p.refOptions(fld)
+				p.refFieldTypes(fld)
+			}
+			for _, ood := range md.oneOfs {
+				p.refOptions(ood)
+			}
+			mdp := proto.Clone(md.proto).(*dpb.DescriptorProto)
+			mdp.NestedType = nested
+			mdp.EnumType = enums
+			mdp.Extension = exts
+			ret = append(ret, mdp)
+		} else if len(nested) > 0 || len(enums) > 0 || len(exts) > 0 {
+			// retain the message just as a namespace for its nested elements
+			ret = append(ret, &dpb.DescriptorProto{
+				Name:       md.proto.Name,
+				NestedType: nested,
+				EnumType:   enums,
+				Extension:  exts,
+			})
+		}
+	}
+	return ret
+}
+
+func (p *pruner) pruneEnums(eds []*EnumDescriptor) []*dpb.EnumDescriptorProto {
+	var ret []*dpb.EnumDescriptorProto
+	for _, ed := range eds {
+		if p.isReached(ed) {
+			p.refOptions(ed)
+			for _, vd := range ed.values {
+				p.refOptions(vd)
+			}
+			ret = append(ret, proto.Clone(ed.proto).(*dpb.EnumDescriptorProto))
+		}
+	}
+	return ret
+}
+
+func (p *pruner) pruneExtensions(exts []*FieldDescriptor) []*dpb.FieldDescriptorProto {
+	var ret []*dpb.FieldDescriptorProto
+	for _, exd := range exts {
+		if p.isReached(exd) {
+			p.refOptions(exd)
+			p.ref(exd.file, exd.owner)
+			p.refFieldTypes(exd)
+			ret = append(ret, proto.Clone(exd.proto).(*dpb.FieldDescriptorProto))
+		}
+	}
+	return ret
+}
@@ -0,0 +1,216 @@
+package desc
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+func createPruneTestFiles(t *testing.T) map[string]*FileDescriptor {
+	values := []*dpb.EnumValueDescriptorProto{{Name: proto.String("ZERO"), Number: proto.Int32(0)}}
+	ext := fieldProto("tag", 100, dpb.FieldDescriptorProto_TYPE_STRING, "")
+	ext.Extendee = proto.String(".common.Used")
+	files, err := CreateFileDescriptorsFromSet(&dpb.FileDescriptorSet{
+		File: []*dpb.FileDescriptorProto{
+			{
+				Name:    proto.String("common.proto"),
+				Package: proto.String("common"),
+				MessageType: []*dpb.DescriptorProto{
+					{
+						Name:           proto.String("Used"),
+						Field:          []*dpb.FieldDescriptorProto{messageFieldProto("inner", 1, ".common.Used.Inner")},
+						NestedType:     []*dpb.DescriptorProto{{Name: proto.String("Inner")}},
+						ExtensionRange: []*dpb.DescriptorProto_ExtensionRange{{Start: proto.Int32(100), End: proto.Int32(201)}},
+					},
+					{
+						Name:  proto.String("Unused"),
+						Field: []*dpb.FieldDescriptorProto{fieldProto("kind", 1, dpb.FieldDescriptorProto_TYPE_ENUM, ".common.UnusedKind")},
+					},
+					{
+						Name:       proto.String("Outer"),
+						Field:      []*dpb.FieldDescriptorProto{messageFieldProto("u", 1, ".common.Unused")},
+						NestedType: []*dpb.DescriptorProto{{Name: proto.String("Nested"), Field: []*dpb.FieldDescriptorProto{fieldProto("k", 1, dpb.FieldDescriptorProto_TYPE_ENUM, ".common.Kind")}}},
+					},
+				},
+				EnumType: []*dpb.EnumDescriptorProto{
+					{Name: proto.String("Kind"), Value: values},
+					{Name: proto.String("UnusedKind"), Value: values},
+				},
+			},
+			{
+				Name:        proto.String("ext.proto"),
+				Package:     proto.String("ext"),
+				Dependency:  []string{"common.proto"},
+				MessageType: []*dpb.DescriptorProto{{Name: proto.String("ExtOnly")}},
+				Extension:   []*dpb.FieldDescriptorProto{ext},
+			},
+			{
+				Name:        proto.String("other.proto"),
+				Package:     proto.String("other"),
+				MessageType: []*dpb.DescriptorProto{{Name: proto.String("Other")}},
+			},
+			{
+				Name:       proto.String("svc.proto"),
+				Package:    proto.String("svc"),
+				Dependency: []string{"common.proto", "other.proto", "ext.proto"},
+				MessageType: []*dpb.DescriptorProto{
+					{
+						Name: proto.String("Req"),
+						Field: []*dpb.FieldDescriptorProto{
+							messageFieldProto("used", 1, ".common.Used"),
+							messageFieldProto("nested", 2, ".common.Outer.Nested"),
+						},
+					},
+					{
+						Name:  proto.String("Unrelated"),
+						Field: []*dpb.FieldDescriptorProto{messageFieldProto("other", 1, ".other.Other")},
+					},
+				},
+				Service: []*dpb.ServiceDescriptorProto{
+					{
+						Name: proto.String("Svc"),
+						Method: []*dpb.MethodDescriptorProto{
+							{Name: proto.String("A"), InputType: proto.String(".svc.Req"), OutputType: proto.String(".svc.Req")},
+							{Name: proto.String("B"), InputType: proto.String(".svc.Unrelated"), OutputType: proto.String(".svc.Unrelated")},
+						},
+					},
+				},
+			},
+		},
+	})
+	ok(t, err)
+	return files
+}
+
+func TestReachableElements(t *testing.T) {
+	files := createPruneTestFiles(t)
+	svc := files["svc.proto"].FindService("svc.Svc")
+	reached, err := ReachableElements(svc.GetMethods()[0])
+	ok(t, err)
+	var names []string
+	for n := range reached {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	eq(t, "common.Kind,common.Outer.Nested,common.Used,common.Used.Inner,ext.tag,svc.Req,svc.Svc.A", strings.Join(names, ","))
+
+	// a whole service reaches all of its methods
+	reached, err = ReachableElements(svc)
+	ok(t, err)
+	eq(t, true, reached["svc.Svc.B"] != nil)
+	eq(t, true, reached["other.Other"] != nil)
+
+	_, err = ReachableElements(files["svc.proto"].FindMessage("svc.Req").GetFields()[0])
+	eq(t, "svc.Req.used is a field, not an extension", err.Error())
+	_, err = ReachableElements(files["svc.proto"])
+	eq(t, true, err != nil)
+}
+
+func TestToPrunedFileDescriptorSet(t *testing.T) {
+	files := createPruneTestFiles(t)
+	fds, err := ToPrunedFileDescriptorSet(files["svc.proto"].FindService("svc.Svc").GetMethods()[0])
+	ok(t, err)
+
+	var names []string
+	for _, fd := range fds.GetFile() {
+		names = append(names, fd.GetName())
+	}
+	eq(t, "common.proto,ext.proto,svc.proto", strings.Join(names, ","))
+
+	common, ext, svc := fds.GetFile()[0], fds.GetFile()[1], fds.GetFile()[2]
+	eq(t, 2, len(common.GetMessageType()))
+	used := common.GetMessageType()[0]
+	eq(t, "Used", used.GetName())
+	eq(t, 1, len(used.GetField()))
+	eq(t, 1, len(used.GetNestedType()))
+	eq(t, 1, len(used.GetExtensionRange()))
+	// Outer is retained as a namespace for Nested, without its fields
+	outer := common.GetMessageType()[1]
+	eq(t, "Outer", outer.GetName())
+	eq(t, 0, len(outer.GetField()))
+	eq(t, "Nested", outer.GetNestedType()[0].GetName())
+	eq(t, 1, len(common.GetEnumType()))
+	eq(t, "Kind", common.GetEnumType()[0].GetName())
+
+	eq(t, 0, len(ext.GetMessageType()))
+	eq(t, "tag", ext.GetExtension()[0].GetName())
+	eq(t, "common.proto", strings.Join(ext.GetDependency(), ","))
+
+	// imports are rewritten to only those still used
+	eq(t, "common.proto", strings.Join(svc.GetDependency(), ","))
+	eq(t, 1, len(svc.GetMessageType()))
+	eq(t, "Req", svc.GetMessageType()[0].GetName())
+	eq(t, 1, len(svc.GetService()[0].GetMethod()))
+	eq(t, "A", svc.GetService()[0].GetMethod()[0].GetName())
+
+	// the original files are not modified
+	eq(t, 3, len(files["svc.proto"].AsFileDescriptorProto().GetDependency()))
+	eq(t, 2, len(files["svc.proto"].FindService("svc.Svc").GetMethods()))
+
+	// the pruned files are valid
+	pruned, err := CreateFileDescriptorsFromSetStrict(fds)
+	ok(t, err)
+	eq(t, 3, len(pruned))
+	eq(t, "common.Kind", pruned["common.proto"].FindMessage("common.Outer.Nested").GetFields()[0].GetEnumType().GetFullyQualifiedName())
+}
+
+func TestToPrunedFileDescriptorSetCustomOptions(t *testing.T) {
+	descriptorProto, err := LoadFileDescriptor("google/protobuf/descriptor.proto")
+	ok(t, err)
+	auth := fieldProto("auth", 50001, dpb.FieldDescriptorProto_TYPE_BOOL, "")
+	auth.Extendee = proto.String(".google.protobuf.MethodOptions")
+	// (opts.auth) = true
+	var mtdOpts dpb.MethodOptions
+	ok(t, proto.Unmarshal([]byte{0x88, 0xb5, 0x18, 1}, &mtdOpts))
+	files, err := CreateFileDescriptorsFromSet(&dpb.FileDescriptorSet{
+		File: []*dpb.FileDescriptorProto{
+			descriptorProto.AsFileDescriptorProto(),
+			{
+				Name:       proto.String("opts.proto"),
+				Package:    proto.String("opts"),
+				Dependency: []string{"google/protobuf/descriptor.proto"},
+				Extension:  []*dpb.FieldDescriptorProto{auth},
+			},
+			{
+				Name:        proto.String("svc.proto"),
+				Package:     proto.String("svc"),
+				Dependency:  []string{"opts.proto"},
+				MessageType: []*dpb.DescriptorProto{{Name: proto.String("Req")}},
+				Service: []*dpb.ServiceDescriptorProto{
+					{
+						Name: proto.String("S"),
+						Method: []*dpb.MethodDescriptorProto{
+							{Name: proto.String("Do"), InputType: proto.String(".svc.Req"), OutputType: proto.String(".svc.Req"), Options: &mtdOpts},
+						},
+					},
+				},
+			},
+		},
+	})
+	ok(t, err)
+
+	reached, err := ReachableElements(files["svc.proto"].FindService("svc.S"))
+	ok(t, err)
+	eq(t, true, reached["opts.auth"] != nil)
+
+	fds, err := ToPrunedFileDescriptorSet(files["svc.proto"].FindService("svc.S"))
+	ok(t, err)
+	var names []string
+	for _, fd := range fds.GetFile() {
+		names = append(names, fd.GetName())
+	}
+	eq(t, "google/protobuf/descriptor.proto,opts.proto,svc.proto", strings.Join(names, ","))
+	eq(t, "opts.proto", strings.Join(fds.GetFile()[2].GetDependency(), ","))
+
+	// the option still resolves in the pruned files
+	pruned, err := CreateFileDescriptorsFromSetStrict(fds)
+	ok(t, err)
+	svc := pruned["svc.proto"]
+	mtd := svc.FindService("svc.S").GetMethods()[0]
+	exts := optionExtensions(mtd, svc.visibleExtensions())
+	eq(t, 1, len(exts))
+	eq(t, "opts.auth", exts[0].GetFullyQualifiedName())
+}
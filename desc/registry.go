@@ -0,0 +1,304 @@
+package desc
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Registry is a pool of file descriptors that can be searched for elements
+// across all of the files it contains. It is useful for holding schemas loaded
+// at runtime, such as from protosets or from a server via the grpcreflect
+// package, without relying on the global cache used by LoadFileDescriptor and
+// LoadMessageDescriptor (which only contains files for linked Go code).
+//
+// A registry ensures that the files it contains are consistent: no two files
+// may have the same name unless they are equal (ignoring source code info, see
+// EqualIgnoringSourceInfo), no two files may define the same symbol, and no two
+// extensions of the same message may have the same tag number.
+//
+// The zero value is an empty registry that is ready to use. A registry is safe
+// for concurrent use by multiple goroutines.
+type Registry struct {
+	mu      sync.RWMutex
+	files   map[string]*FileDescriptor
+	symbols map[string]Descriptor
+	exts    map[string]map[int32]*FieldDescriptor
+}
+
+// AddFile adds the given file, and all of its transitive dependencies, to the
+// registry. Files that the registry already contains are skipped. An error is
+// returned if any of the files conflict with files already in the registry or
+// with each other. If an error is returned, the registry is not modified.
+func (r *Registry) AddFile(fd *FileDescriptor) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.addFilesLocked([]*FileDescriptor{fd})
+}
+
+// Merge adds all of the files in the given registry to this one. An error is
+// returned if any of them conflict with files already in this registry. If an
+// error is returned, this registry is not modified.
+func (r *Registry) Merge(other *Registry) error {
+	files := other.GetFiles()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.addFilesLocked(files)
+}
+
+func (r *Registry) addFilesLocked(fds []*FileDescriptor) error {
+	// first we collect and check the new files, so that we can bail without
+	// modifying anything if there is a conflict
+	added := map[string]*FileDescriptor{}
+	var toAdd []*FileDescriptor
+	var collect func(fd *FileDescriptor) error
+	collect = func(fd *FileDescriptor) error {
+		name := fd.GetName()
+		existing := r.files[name]
+		if existing == nil {
+			existing = added[name]
+		}
+		if existing != nil {
+			if !EqualIgnoringSourceInfo(existing, fd) {
+				return fmt.Errorf("registry already contains a different file named %q", name)
+			}
+			return nil
+		}
+		added[name] = fd
+		toAdd = append(toAdd, fd)
+		for _, dep := range fd.deps {
+			if err := collect(dep); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, fd := range fds {
+		if err := collect(fd); err != nil {
+			return err
+		}
+	}
+
+	symbols := map[string]Descriptor{}
+	exts := map[string]map[int32]*FieldDescriptor{}
+	for _, fd := range toAdd {
+		for sym, d := range fd.symbols {
+			existing := r.symbols[sym]
+			if existing == nil {
+				existing = symbols[sym]
+			}
+			if existing != nil {
+				return fmt.Errorf("symbol %q is defined in both %q and %q", sym, existing.GetFile().GetName(), fd.GetName())
+			}
+			symbols[sym] = d
+			if exd, ok := d.(*FieldDescriptor); ok && exd.IsExtension() {
+				extendee := exd.GetOwner().GetFullyQualifiedName()
+				existing := r.exts[extendee][exd.GetNumber()]
+				if existing == nil {
+					existing = exts[extendee][exd.GetNumber()]
+				}
+				if existing != nil {
+					return fmt.Errorf("extensions %s (in %q) and %s (in %q) both extend %s with tag number %d", existing.GetFullyQualifiedName(), existing.GetFile().GetName(), exd.GetFullyQualifiedName(), fd.GetName(), extendee, exd.GetNumber())
+				}
+				m := exts[extendee]
+				if m == nil {
+					m = map[int32]*FieldDescriptor{}
+					exts[extendee] = m
+				}
+				m[exd.GetNumber()] = exd
+			}
+		}
+	}
+
+	// no conflicts, so now we can add everything
+	if r.files == nil {
+		r.files = map[string]*FileDescriptor{}
+		r.symbols = map[string]Descriptor{}
+		r.exts = map[string]map[int32]*FieldDescriptor{}
+	}
+	for name, fd := range added {
+		r.files[name] = fd
+	}
+	for sym, d := range symbols {
+		r.symbols[sym] = d
+	}
+	for extendee, m := range exts {
+		existing := r.exts[extendee]
+		if existing == nil {
+			existing = map[int32]*FieldDescriptor{}
+			r.exts[extendee] = existing
+		}
+		for tag, exd := range m {
+			existing[tag] = exd
+		}
+	}
+	return nil
+}
+
+// FindFile returns the file with the given name. If the registry has no such
+// file then nil is returned.
+func (r *Registry) FindFile(name string) *FileDescriptor {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.files[name]
+}
+
+// GetFiles returns all files in the registry, sorted by name.
+func (r *Registry) GetFiles() []*FileDescriptor {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	files := make([]*FileDescriptor, 0, len(r.files))
+	for _, fd := range r.files {
+		files = append(files, fd)
+	}
+	sort.Sort(filesByName(files))
+	return files
+}
+
+// FindSymbol returns the descriptor for the element with the given
+// fully-qualified name, which may be in any file in the registry. If no such
+// element exists then nil is returned.
+func (r *Registry) FindSymbol(symbol string) Descriptor {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.symbols[symbol]
+}
+
+// FindMessage finds the message with the given fully-qualified name. If no
+// such element exists in the registry then nil is returned.
+func (r *Registry) FindMessage(msgName string) *MessageDescriptor {
+	md, _ := r.FindSymbol(msgName).(*MessageDescriptor)
+	return md
+}
+
+// FindEnum finds the enum with the given fully-qualified name. If no such
+// element exists in the registry then nil is returned.
+func (r *Registry) FindEnum(enumName string) *EnumDescriptor {
+	ed, _ := r.FindSymbol(enumName).(*EnumDescriptor)
+	return ed
+}
+
+// FindService finds the service with the given fully-qualified name. If no
+// such element exists in the registry then nil is returned.
+func (r *Registry) FindService(serviceName string) *ServiceDescriptor {
+	sd, _ := r.FindSymbol(serviceName).(*ServiceDescriptor)
+	return sd
+}
+
+// FindExtension finds the extension field for the given extended type name
+// (which must be fully-qualified) and tag number. If no such element exists in
+// the registry then nil is returned.
+func (r *Registry) FindExtension(extendeeName string, tagNumber int32) *FieldDescriptor {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.exts[extendeeName][tagNumber]
+}
+
+// AllExtensionsForType returns all extensions of the message with the given
+// fully-qualified name, sorted by tag number.
+func (r *Registry) AllExtensionsForType(extendeeName string) []*FieldDescriptor {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	m := r.exts[extendeeName]
+	if len(m) == 0 {
+		return nil
+	}
+	exts := make([]*FieldDescriptor, 0, len(m))
+	for _, exd := range m {
+		exts = append(exts, exd)
+	}
+	sort.Sort(fieldsByNumber(exts))
+	return exts
+}
+
+// GetServices returns all services defined in files in the registry, sorted by
+// fully-qualified name.
+func (r *Registry) GetServices() []*ServiceDescriptor {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var svcs []*ServiceDescriptor
+	for _, fd := range r.files {
+		svcs = append(svcs, fd.services...)
+	}
+	sort.Sort(servicesByName(svcs))
+	return svcs
+}
+
+// GetDependents returns the files in the registry that directly import the file
+// with the given name, sorted by name.
+func (r *Registry) GetDependents(filename string) []*FileDescriptor {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var dependents []*FileDescriptor
+	for _, fd := range r.files {
+		for _, dep := range fd.deps {
+			if dep.GetName() == filename {
+				dependents = append(dependents, fd)
+				break
+			}
+		}
+	}
+	sort.Sort(filesByName(dependents))
+	return dependents
+}
+
+// GetTransitiveDependents returns the files in the registry that import the file
+// with the given name, directly or indirectly, sorted by name. These are the
+// files that could be affected by a change to the named file.
+func (r *Registry) GetTransitiveDependents(filename string) []*FileDescriptor {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var dependents []*FileDescriptor
+	for _, fd := range r.files {
+		for _, dep := range fd.GetTransitiveDependencies() {
+			if dep.GetName() == filename {
+				dependents = append(dependents, fd)
+				break
+			}
+		}
+	}
+	sort.Sort(filesByName(dependents))
+	return dependents
+}
+
+type filesByName []*FileDescriptor
+
+func (f filesByName) Len() int {
+	return len(f)
+}
+
+func (f filesByName) Less(i, j int) bool {
+	return f[i].GetName() < f[j].GetName()
+}
+
+func (f filesByName) Swap(i, j int) {
+	f[i], f[j] = f[j], f[i]
+}
+
+type fieldsByNumber []*FieldDescriptor
+
+func (f fieldsByNumber) Len() int {
+	return len(f)
+}
+
+func (f fieldsByNumber) Less(i, j int) bool {
+	return f[i].GetNumber() < f[j].GetNumber()
+}
+
+func (f fieldsByNumber) Swap(i, j int) {
+	f[i], f[j] = f[j], f[i]
+}
+
+type servicesByName []*ServiceDescriptor
+
+func (s servicesByName) Len() int {
+	return len(s)
+}
+
+func (s servicesByName) Less(i, j int) bool {
+	return s[i].GetFullyQualifiedName() < s[j].GetFullyQualifiedName()
+}
+
+func (s servicesByName) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
@@ -0,0 +1,159 @@
+package desc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+func TestRegistry(t *testing.T) {
+	var r Registry
+	eq(t, 0, len(r.GetFiles()))
+	eq(t, nil, r.FindSymbol("desc_test.TestMessage"))
+
+	fd, err := LoadFileDescriptor("desc_test_proto3.proto")
+	ok(t, err)
+	ok(t, r.AddFile(fd))
+
+	// dependencies are added, too
+	var names []string
+	for _, f := range r.GetFiles() {
+		names = append(names, f.GetName())
+	}
+	eq(t, "desc_test1.proto,desc_test_proto3.proto,pkg/desc_test_pkg.proto", strings.Join(names, ","))
+	eq(t, fd, r.FindFile("desc_test_proto3.proto"))
+
+	md := r.FindMessage("desc_test.TestMessage")
+	eq(t, "desc_test1.proto", md.GetFile().GetName())
+	eq(t, md, r.FindSymbol("desc_test.TestMessage"))
+	eq(t, true, r.FindSymbol("desc_test.TestMessage.NestedMessage.AnotherNestedMessage.YetAnotherNestedMessage.DeeplyNestedEnum") != nil)
+	eq(t, true, r.FindEnum("desc_test.TestMessage.NestedMessage.AnotherNestedMessage.YetAnotherNestedMessage.DeeplyNestedEnum") != nil)
+	eq(t, (*MessageDescriptor)(nil), r.FindMessage("desc_test.TestService"))
+	eq(t, "desc_test.TestService", r.FindService("desc_test.TestService").GetFullyQualifiedName())
+
+	svcs := r.GetServices()
+	eq(t, 1, len(svcs))
+	eq(t, "desc_test.TestService", svcs[0].GetFullyQualifiedName())
+
+	exd := r.FindExtension("desc_test.AnotherTestMessage", 101)
+	eq(t, "desc_test.xs", exd.GetFullyQualifiedName())
+	eq(t, (*FieldDescriptor)(nil), r.FindExtension("desc_test.AnotherTestMessage", 150))
+	var exts []string
+	for _, exd := range r.AllExtensionsForType("desc_test.AnotherTestMessage") {
+		exts = append(exts, exd.GetFullyQualifiedName())
+	}
+	eq(t, "desc_test.xtm,desc_test.xs,desc_test.xi,desc_test.xui,desc_test.TestMessage.NestedMessage.AnotherNestedMessage.flags", strings.Join(exts, ","))
+	eq(t, 0, len(r.AllExtensionsForType("desc_test.TestMessage")))
+
+	// adding the same files again is fine
+	ok(t, r.AddFile(fd))
+	fd1, err := CreateFileDescriptor(md.GetFile().AsFileDescriptorProto())
+	ok(t, err)
+	ok(t, r.AddFile(fd1))
+	eq(t, md, r.FindMessage("desc_test.TestMessage"))
+}
+
+func TestRegistryConflicts(t *testing.T) {
+	var r Registry
+	fd, err := LoadFileDescriptor("desc_test1.proto")
+	ok(t, err)
+	ok(t, r.AddFile(fd))
+
+	testCases := []struct {
+		name  string
+		fdp   *dpb.FileDescriptorProto
+		error string
+	}{
+		{
+			name: "different file with same name",
+			fdp: &dpb.FileDescriptorProto{
+				Name:    proto.String("desc_test1.proto"),
+				Package: proto.String("foo"),
+			},
+			error: `registry already contains a different file named "desc_test1.proto"`,
+		},
+		{
+			name: "duplicate symbol",
+			fdp: &dpb.FileDescriptorProto{
+				Name:        proto.String("other.proto"),
+				Package:     proto.String("desc_test"),
+				MessageType: []*dpb.DescriptorProto{{Name: proto.String("Foo")}, {Name: proto.String("TestMessage")}},
+			},
+			error: `symbol "desc_test.TestMessage" is defined in both "desc_test1.proto" and "other.proto"`,
+		},
+		{
+			name: "duplicate extension",
+			fdp: &dpb.FileDescriptorProto{
+				Name:       proto.String("other.proto"),
+				Package:    proto.String("foo"),
+				Dependency: []string{"desc_test1.proto"},
+				Extension: []*dpb.FieldDescriptorProto{
+					{Name: proto.String("bar"), Number: proto.Int32(101), Label: dpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), Type: dpb.FieldDescriptorProto_TYPE_INT32.Enum(), Extendee: proto.String(".desc_test.AnotherTestMessage")},
+				},
+			},
+			error: `extensions desc_test.xs (in "desc_test1.proto") and foo.bar (in "other.proto") both extend desc_test.AnotherTestMessage with tag number 101`,
+		},
+	}
+	for _, tc := range testCases {
+		var deps []*FileDescriptor
+		if len(tc.fdp.Dependency) > 0 {
+			deps = append(deps, fd)
+		}
+		other, err := CreateFileDescriptor(tc.fdp, deps...)
+		ok(t, err, "%s", tc.name)
+		err = r.AddFile(other)
+		eq(t, true, err != nil, "%s: expecting an error", tc.name)
+		if err != nil {
+			eq(t, tc.error, err.Error(), "%s", tc.name)
+		}
+		// registry is unchanged
+		eq(t, 1, len(r.GetFiles()), "%s", tc.name)
+		eq(t, (*FileDescriptor)(nil), r.FindFile("other.proto"), "%s", tc.name)
+		eq(t, nil, r.FindSymbol("foo.bar"), "%s", tc.name)
+		eq(t, nil, r.FindSymbol("desc_test.Foo"), "%s", tc.name)
+	}
+}
+
+func TestRegistryMerge(t *testing.T) {
+	var r1, r2 Registry
+	fd1, err := LoadFileDescriptor("desc_test1.proto")
+	ok(t, err)
+	ok(t, r1.AddFile(fd1))
+	fd2, err := LoadFileDescriptor("pkg/desc_test_pkg.proto")
+	ok(t, err)
+	ok(t, r2.AddFile(fd2))
+
+	ok(t, r1.Merge(&r2))
+	eq(t, fd2, r1.FindFile("pkg/desc_test_pkg.proto"))
+	eq(t, fd1, r1.FindFile("desc_test1.proto"))
+	eq(t, true, r1.FindEnum("jhump.protoreflect.desc.Foo") != nil)
+	// the other registry is not changed
+	eq(t, (*FileDescriptor)(nil), r2.FindFile("desc_test1.proto"))
+
+	var r3 Registry
+	conflict, err := CreateFileDescriptor(&dpb.FileDescriptorProto{
+		Name:        proto.String("conflict.proto"),
+		Package:     proto.String("desc_test"),
+		MessageType: []*dpb.DescriptorProto{{Name: proto.String("TestMessage")}},
+	})
+	ok(t, err)
+	ok(t, r3.AddFile(conflict))
+	eq(t, true, r1.Merge(&r3) != nil)
+	eq(t, (*FileDescriptor)(nil), r1.FindFile("conflict.proto"))
+}
+
+func TestRegistryDependents(t *testing.T) {
+	var r Registry
+	for _, name := range []string{"desc_test2.proto", "desc_test_proto3.proto"} {
+		fd, err := LoadFileDescriptor(name)
+		ok(t, err)
+		ok(t, r.AddFile(fd))
+	}
+	eq(t, "desc_test2.proto,desc_test_proto3.proto", fileNames(r.GetDependents("desc_test1.proto")))
+	eq(t, "nopkg/desc_test_nopkg.proto", fileNames(r.GetDependents("nopkg/desc_test_nopkg_new.proto")))
+	eq(t, "desc_test2.proto,nopkg/desc_test_nopkg.proto", fileNames(r.GetTransitiveDependents("nopkg/desc_test_nopkg_new.proto")))
+	eq(t, 0, len(r.GetDependents("desc_test2.proto")))
+	eq(t, 0, len(r.GetTransitiveDependents("does/not/exist.proto")))
+}
@@ -0,0 +1,257 @@
+package desc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// DescriptorSource is a source of file descriptor protos. Sources can be
+// backed by descriptors linked into the program (see LinkedSource), protoset
+// files (see ProtosetSource), proto source files (see the protoparse package),
+// or a server that supports reflection (see the grpcreflect package).
+//
+// Use LoadFileDescriptorFromSource or LoadMessageDescriptorFromSource to create
+// rich descriptors for elements provided by a source.
+type DescriptorSource interface {
+	// FindFileProto returns the descriptor proto for the file with the given
+	// name or an error if the source cannot provide it.
+	FindFileProto(filename string) (*dpb.FileDescriptorProto, error)
+}
+
+// LinkedSource returns a source that provides descriptors for files that are
+// linked into the program, using the bytes registered by generated code and
+// returned by proto.FileDescriptor.
+func LinkedSource() DescriptorSource {
+	return linkedSource{}
+}
+
+type linkedSource struct{}
+
+func (linkedSource) FindFileProto(filename string) (*dpb.FileDescriptorProto, error) {
+	fdb := proto.FileDescriptor(filename)
+	if fdb == nil {
+		return nil, fmt.Errorf("No such file: %q", filename)
+	}
+	return decodeFileDescriptor(filename, fdb)
+}
+
+// FileDescriptorSetSource returns a source that provides the files in the
+// given descriptor sets. If more than one set contains a file with the same
+// name, the first one is used.
+func FileDescriptorSetSource(sets ...*dpb.FileDescriptorSet) DescriptorSource {
+	src := fileSetSource{}
+	for _, fds := range sets {
+		for _, fd := range fds.File {
+			if _, ok := src[fd.GetName()]; !ok {
+				src[fd.GetName()] = fd
+			}
+		}
+	}
+	return src
+}
+
+// ProtosetSource returns a source that provides the files in the given
+// protoset files. A protoset file contains a FileDescriptorSet in the protobuf
+// binary format, like those produced by protoc's --descriptor_set_out option.
+// An error is returned if any of the files cannot be read.
+func ProtosetSource(filenames ...string) (DescriptorSource, error) {
+	sets := make([]*dpb.FileDescriptorSet, len(filenames))
+	for i, filename := range filenames {
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		var fds dpb.FileDescriptorSet
+		if err := proto.Unmarshal(b, &fds); err != nil {
+			return nil, fmt.Errorf("bad protoset %q: %v", filename, err)
+		}
+		sets[i] = &fds
+	}
+	return FileDescriptorSetSource(sets...), nil
+}
+
+type fileSetSource map[string]*dpb.FileDescriptorProto
+
+func (s fileSetSource) FindFileProto(filename string) (*dpb.FileDescriptorProto, error) {
+	if fd, ok := s[filename]; ok {
+		return fd, nil
+	}
+	return nil, fmt.Errorf("No such file: %q", filename)
+}
+
+// ChainSources returns a source that queries each of the given sources in
+// order, returning the first file found. If none of them can provide a file,
+// the error returned by the first source is returned.
+func ChainSources(sources ...DescriptorSource) DescriptorSource {
+	return sourceChain(sources)
+}
+
+type sourceChain []DescriptorSource
+
+func (c sourceChain) FindFileProto(filename string) (*dpb.FileDescriptorProto, error) {
+	var firstErr error
+	for _, src := range c {
+		fd, err := src.FindFileProto(filename)
+		if err == nil {
+			return fd, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		firstErr = fmt.Errorf("No such file: %q", filename)
+	}
+	return nil, firstErr
+}
+
+// LoadFileDescriptorFromSource creates a file descriptor for the named file
+// using the descriptor proto provided by the given source. Its dependencies,
+// including transitive dependencies, are also loaded from the source.
+//
+// Unlike LoadFileDescriptor, descriptors are not cached between calls. Use
+// LoadFileDescriptorFromSourceWithCache to re-use descriptors across calls, or
+// a Registry to hold loaded descriptors for later lookup.
+func LoadFileDescriptorFromSource(src DescriptorSource, filename string) (*FileDescriptor, error) {
+	return LoadFileDescriptorFromSourceWithCache(src, fileCache{}, filename)
+}
+
+// LoadFileDescriptorFromSourceWithCache is like LoadFileDescriptorFromSource,
+// except that files already in the given cache are used instead of being
+// loaded from the source, and files that are loaded from the source are added
+// to the cache.
+func LoadFileDescriptorFromSourceWithCache(src DescriptorSource, cache FileDescriptorCache, filename string) (*FileDescriptor, error) {
+	l := loader{src: src, cache: cache}
+	return l.load(filename)
+}
+
+// FileDescriptorCache holds file descriptors that have already been created,
+// so that loading them from a source does not create them again. See
+// LoadFileDescriptorFromSourceWithCache.
+type FileDescriptorCache interface {
+	// GetFileDescriptor returns the descriptor for the named file, or nil if the
+	// cache does not have it.
+	GetFileDescriptor(filename string) *FileDescriptor
+	// PutFileDescriptor adds the given newly created descriptor to the cache. It
+	// returns the descriptor that should be used, which may be a different one
+	// if the cache already has a descriptor for the same file (for example, one
+	// added concurrently by another goroutine).
+	PutFileDescriptor(fd *FileDescriptor) *FileDescriptor
+}
+
+type fileCache map[string]*FileDescriptor
+
+func (c fileCache) GetFileDescriptor(filename string) *FileDescriptor {
+	return c[filename]
+}
+
+func (c fileCache) PutFileDescriptor(fd *FileDescriptor) *FileDescriptor {
+	if existing := c[fd.GetName()]; existing != nil {
+		return existing
+	}
+	c[fd.GetName()] = fd
+	return fd
+}
+
+// LoadMessageDescriptorFromSource loads the descriptor for the named message
+// from the given source. The file that declares the message is identified
+// using the message's linked Go type (so the message must be linked into the
+// program, as for LoadMessageDescriptor), but the file and its dependencies
+// are loaded from the source. This is useful, for example, for getting
+// descriptors that include comments for linked messages from a source that
+// has source code info.
+func LoadMessageDescriptorFromSource(src DescriptorSource, message string) (*MessageDescriptor, error) {
+	pt := proto.MessageType(message)
+	if pt == nil {
+		return nil, fmt.Errorf("unknown type: %q", message)
+	}
+	msg, err := messageFromType(pt)
+	if err != nil {
+		return nil, err
+	}
+	return loadMessageDescriptorFromSource(src, message, msg)
+}
+
+// LoadMessageDescriptorForTypeFromSource loads the descriptor for the given
+// message type from the given source. See LoadMessageDescriptorFromSource.
+func LoadMessageDescriptorForTypeFromSource(src DescriptorSource, messageType reflect.Type) (*MessageDescriptor, error) {
+	m, err := messageFromType(messageType)
+	if err != nil {
+		return nil, err
+	}
+	return LoadMessageDescriptorForMessageFromSource(src, m)
+}
+
+// LoadMessageDescriptorForMessageFromSource loads the descriptor for the type
+// of the given message from the given source. See
+// LoadMessageDescriptorFromSource.
+func LoadMessageDescriptorForMessageFromSource(src DescriptorSource, message proto.Message) (*MessageDescriptor, error) {
+	pm, ok := message.(protoMessage)
+	if !ok {
+		return nil, fmt.Errorf("message type %T does not provide its descriptor", message)
+	}
+	return loadMessageDescriptorFromSource(src, proto.MessageName(message), pm)
+}
+
+func loadMessageDescriptorFromSource(src DescriptorSource, name string, message protoMessage) (*MessageDescriptor, error) {
+	fdb, _ := message.Descriptor()
+	fdp, err := decodeFileDescriptor(name, fdb)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := LoadFileDescriptorFromSource(src, fdp.GetName())
+	if err != nil {
+		return nil, err
+	}
+	md := fd.FindMessage(name)
+	if md == nil {
+		return nil, fmt.Errorf("file %q from source does not declare message %q", fd.GetName(), name)
+	}
+	return md, nil
+}
+
+// loader creates file descriptors for files provided by a source. Files are
+// memoized in the given cache, so each is only created once.
+type loader struct {
+	src        DescriptorSource
+	cache      FileDescriptorCache
+	importedBy []string
+}
+
+func (l *loader) load(filename string) (*FileDescriptor, error) {
+	if fd := l.cache.GetFileDescriptor(filename); fd != nil {
+		return fd, nil
+	}
+	if err := checkImportCycle(filename, l.importedBy); err != nil {
+		return nil, err
+	}
+	fdp, err := l.src.FindFileProto(filename)
+	if err != nil {
+		return nil, err
+	}
+	l.importedBy = append(l.importedBy, filename)
+	fd, err := l.create(fdp)
+	l.importedBy = l.importedBy[:len(l.importedBy)-1]
+	if err != nil {
+		return nil, err
+	}
+	return l.cache.PutFileDescriptor(fd), nil
+}
+
+// create creates a file descriptor for the given proto, loading its
+// dependencies from the source.
+func (l *loader) create(fdp *dpb.FileDescriptorProto) (*FileDescriptor, error) {
+	deps := make([]*FileDescriptor, len(fdp.GetDependency()))
+	for i, dep := range fdp.GetDependency() {
+		var err error
+		deps[i], err = l.load(dep)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return CreateFileDescriptor(fdp, deps...)
+}
@@ -0,0 +1,137 @@
+package desc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/jhump/protoreflect/desc/desc_test"
+)
+
+func TestLoadFileDescriptorFromSource(t *testing.T) {
+	fd, err := LoadFileDescriptorFromSource(LinkedSource(), "desc_test_proto3.proto")
+	ok(t, err)
+	cached, err := LoadFileDescriptor("desc_test_proto3.proto")
+	ok(t, err)
+	// descriptors from sources are not cached, so this is a new instance
+	eq(t, true, fd != cached)
+	eq(t, true, proto.Equal(cached.AsProto(), fd.AsProto()))
+	eq(t, 2, len(fd.GetDependencies()))
+	eq(t, "desc_test1.proto", fd.GetDependencies()[0].GetName())
+	eq(t, "desc_test.TestRequest", fd.FindService("desc_test.TestService").GetMethods()[0].GetInputType().GetFullyQualifiedName())
+
+	_, err = LoadFileDescriptorFromSource(LinkedSource(), "does/not/exist.proto")
+	eq(t, `No such file: "does/not/exist.proto"`, err.Error())
+}
+
+func TestProtosetSource(t *testing.T) {
+	_, err := ProtosetSource("does/not/exist.protoset")
+	eq(t, true, err != nil)
+
+	src, err := ProtosetSource("desc_test/desc_test1.protoset")
+	ok(t, err)
+	fd, err := LoadFileDescriptorFromSource(src, "desc_test1.proto")
+	ok(t, err)
+	// the protoset includes source code info
+	eq(t, " Comment for TestMessage\n", fd.FindMessage("desc_test.TestMessage").GetLeadingComments())
+
+	_, err = LoadFileDescriptorFromSource(src, "desc_test2.proto")
+	eq(t, `No such file: "desc_test2.proto"`, err.Error())
+}
+
+func TestChainSources(t *testing.T) {
+	// a set with a file that depends on a linked file
+	fdp := &dpb.FileDescriptorProto{
+		Name:       proto.String("foo.proto"),
+		Package:    proto.String("foo"),
+		Dependency: []string{"desc_test1.proto"},
+		MessageType: []*dpb.DescriptorProto{
+			{
+				Name: proto.String("Foo"),
+				Field: []*dpb.FieldDescriptorProto{
+					{Name: proto.String("tm"), Number: proto.Int32(1), Label: dpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), Type: dpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(), TypeName: proto.String(".desc_test.TestMessage")},
+				},
+			},
+		},
+	}
+	set := FileDescriptorSetSource(&dpb.FileDescriptorSet{File: []*dpb.FileDescriptorProto{fdp}})
+	_, err := LoadFileDescriptorFromSource(set, "foo.proto")
+	eq(t, `No such file: "desc_test1.proto"`, err.Error())
+
+	src := ChainSources(set, LinkedSource())
+	fd, err := LoadFileDescriptorFromSource(src, "foo.proto")
+	ok(t, err)
+	eq(t, "desc_test.TestMessage", fd.FindMessage("foo.Foo").GetFields()[0].GetMessageType().GetFullyQualifiedName())
+
+	_, err = LoadFileDescriptorFromSource(src, "does/not/exist.proto")
+	eq(t, `No such file: "does/not/exist.proto"`, err.Error())
+	_, err = LoadFileDescriptorFromSource(ChainSources(), "foo.proto")
+	eq(t, `No such file: "foo.proto"`, err.Error())
+}
+
+func TestLoadFileDescriptorFromSourceImportCycle(t *testing.T) {
+	src := FileDescriptorSetSource(&dpb.FileDescriptorSet{
+		File: []*dpb.FileDescriptorProto{
+			{Name: proto.String("a.proto"), Dependency: []string{"b.proto"}},
+			{Name: proto.String("b.proto"), Dependency: []string{"c.proto"}},
+			{Name: proto.String("c.proto"), Dependency: []string{"a.proto"}},
+		},
+	})
+	_, err := LoadFileDescriptorFromSource(src, "a.proto")
+	eq(t, true, err != nil)
+	eq(t, true, strings.Contains(err.Error(), `"a.proto" -> "b.proto" -> "c.proto" -> "a.proto"`), err.Error())
+}
+
+func TestLoadFileDescriptorFromSourceWithCache(t *testing.T) {
+	cache := fileCache{}
+	fd, err := LoadFileDescriptorFromSourceWithCache(LinkedSource(), cache, "desc_test_proto3.proto")
+	ok(t, err)
+	eq(t, fd, cache["desc_test_proto3.proto"])
+	// dependencies are cached, too
+	eq(t, fd.GetDependencies()[0], cache["desc_test1.proto"])
+
+	// cached files, including dependencies, are re-used instead of being loaded
+	// from the source again
+	empty := FileDescriptorSetSource()
+	fd2, err := LoadFileDescriptorFromSourceWithCache(empty, cache, "desc_test_proto3.proto")
+	ok(t, err)
+	eq(t, fd, fd2)
+	fd3, err := LoadFileDescriptorFromSourceWithCache(empty, cache, "desc_test1.proto")
+	ok(t, err)
+	eq(t, fd.GetDependencies()[0], fd3)
+}
+
+func TestLoadMessageDescriptorFromSource(t *testing.T) {
+	src, err := ProtosetSource("desc_test/desc_test1.protoset")
+	ok(t, err)
+
+	md, err := LoadMessageDescriptorFromSource(src, "desc_test.TestMessage")
+	ok(t, err)
+	eq(t, "desc_test.TestMessage", md.GetFullyQualifiedName())
+	// the descriptor comes from the source, which includes source code info
+	eq(t, " Comment for TestMessage\n", md.GetLeadingComments())
+
+	md, err = LoadMessageDescriptorForTypeFromSource(src, reflect.TypeOf((*desc_test.TestMessage_NestedMessage)(nil)))
+	ok(t, err)
+	eq(t, "desc_test.TestMessage.NestedMessage", md.GetFullyQualifiedName())
+	eq(t, " Comment for NestedMessage\n", md.GetLeadingComments())
+
+	md, err = LoadMessageDescriptorForMessageFromSource(src, (*desc_test.AnotherTestMessage)(nil))
+	ok(t, err)
+	eq(t, "desc_test.AnotherTestMessage", md.GetFullyQualifiedName())
+	eq(t, "desc_test1.proto", md.GetFile().GetName())
+
+	_, err = LoadMessageDescriptorFromSource(src, "does.not.Exist")
+	eq(t, `unknown type: "does.not.Exist"`, err.Error())
+	// the source must provide the file that declares the message
+	_, err = LoadMessageDescriptorForMessageFromSource(src, (*desc_test.TestRequest)(nil))
+	eq(t, `No such file: "desc_test_proto3.proto"`, err.Error())
+	// and the file it provides must declare the message
+	_, err = LoadMessageDescriptorFromSource(FileDescriptorSetSource(&dpb.FileDescriptorSet{
+		File: []*dpb.FileDescriptorProto{{Name: proto.String("desc_test1.proto")}},
+	}), "desc_test.TestMessage")
+	eq(t, `file "desc_test1.proto" from source does not declare message "desc_test.TestMessage"`, err.Error())
+}
@@ -0,0 +1,162 @@
+package desc
+
+import "sort"
+
+// TypeGraph describes the references between message and enum types in a set
+// of files. A field whose type is a message or enum is a reference from the
+// message that contains it (or, for an extension, from the message that it
+// extends) to its type.
+type TypeGraph struct {
+	refs map[Descriptor][]*FieldDescriptor
+	exts map[*MessageDescriptor][]*FieldDescriptor
+}
+
+// NewTypeGraph creates the type graph for the given files and all of their
+// transitive dependencies. Extensions are included, so the graph accounts for
+// extensions declared in any of the files.
+func NewTypeGraph(files ...*FileDescriptor) *TypeGraph {
+	all := map[string]*FileDescriptor{}
+	for _, fd := range files {
+		all[fd.GetName()] = fd
+		for _, dep := range fd.GetTransitiveDependencies() {
+			all[dep.GetName()] = dep
+		}
+	}
+	sorted := make([]*FileDescriptor, 0, len(all))
+	for _, fd := range all {
+		sorted = append(sorted, fd)
+	}
+	sort.Sort(filesByName(sorted))
+
+	g := &TypeGraph{
+		refs: map[Descriptor][]*FieldDescriptor{},
+		exts: map[*MessageDescriptor][]*FieldDescriptor{},
+	}
+	for _, fd := range sorted {
+		Walk(fd, func(d Descriptor, _ []int32) error {
+			fld, ok := d.(*FieldDescriptor)
+			if !ok {
+				return nil
+			}
+			if fld.IsExtension() {
+				g.exts[fld.owner] = append(g.exts[fld.owner], fld)
+			}
+			if fld.msgType != nil {
+				g.refs[fld.msgType] = append(g.refs[fld.msgType], fld)
+			} else if fld.enumType != nil {
+				g.refs[fld.enumType] = append(g.refs[fld.enumType], fld)
+			}
+			return nil
+		})
+	}
+	return g
+}
+
+// FindReferences returns the fields, including extensions, whose type is the
+// given message or enum. Each returned field's owner (see
+// FieldDescriptor.GetOwner) is a message that refers to the given type.
+func (g *TypeGraph) FindReferences(d Descriptor) []*FieldDescriptor {
+	return g.refs[d]
+}
+
+// GetReferringMessages returns the messages that have a field, or that are
+// extended by an extension, whose type is the given message or enum. Each
+// message appears once, in the order in which their references are found.
+func (g *TypeGraph) GetReferringMessages(d Descriptor) []*MessageDescriptor {
+	var mds []*MessageDescriptor
+	seen := map[*MessageDescriptor]bool{}
+	for _, fld := range g.refs[d] {
+		if !seen[fld.owner] {
+			seen[fld.owner] = true
+			mds = append(mds, fld.owner)
+		}
+	}
+	return mds
+}
+
+// getMessageFields returns the fields and extensions of the given message
+// whose types are messages.
+func (g *TypeGraph) getMessageFields(md *MessageDescriptor) []*FieldDescriptor {
+	var flds []*FieldDescriptor
+	for _, fld := range md.fields {
+		if fld.msgType != nil {
+			flds = append(flds, fld)
+		}
+	}
+	for _, exd := range g.exts[md] {
+		if exd.msgType != nil {
+			flds = append(flds, exd)
+		}
+	}
+	return flds
+}
+
+// IsRecursive returns true if the given message can contain itself, either
+// directly, via a field whose type is the message, or mutually, via a chain of
+// fields through other messages.
+func (g *TypeGraph) IsRecursive(md *MessageDescriptor) bool {
+	return g.FindRecursion(md) != nil
+}
+
+// FindRecursion returns a chain of fields through which the given message can
+// contain itself. The first field belongs to the given message and the last
+// field's type is the given message. If the message is not recursive, nil is
+// returned.
+func (g *TypeGraph) FindRecursion(md *MessageDescriptor) []*FieldDescriptor {
+	visited := map[*MessageDescriptor]bool{}
+	var path []*FieldDescriptor
+	var search func(m *MessageDescriptor) bool
+	search = func(m *MessageDescriptor) bool {
+		for _, fld := range g.getMessageFields(m) {
+			path = append(path, fld)
+			if fld.msgType == md {
+				return true
+			}
+			if !visited[fld.msgType] {
+				visited[fld.msgType] = true
+				if search(fld.msgType) {
+					return true
+				}
+			}
+			path = path[:len(path)-1]
+		}
+		return false
+	}
+	if search(md) {
+		return path
+	}
+	return nil
+}
+
+// GetMaxDepth returns the maximum depth of nested messages in a message of the
+// given type. A message with no fields whose types are messages has a depth
+// of one; otherwise its depth is one more than the greatest depth of those
+// fields' types. Map fields count as a level, since map entries are encoded as
+// nested messages. If messages of the given type can be nested arbitrarily
+// deep, because the message can reach a recursive message, then bounded is
+// false and depth is zero.
+func (g *TypeGraph) GetMaxDepth(md *MessageDescriptor) (depth int, bounded bool) {
+	depths := map[*MessageDescriptor]int{}
+	// a depth of -1 means the message is currently being visited, so reaching
+	// it again means there is a cycle
+	var compute func(m *MessageDescriptor) (int, bool)
+	compute = func(m *MessageDescriptor) (int, bool) {
+		if d, ok := depths[m]; ok {
+			return d, d >= 0
+		}
+		depths[m] = -1
+		max := 0
+		for _, fld := range g.getMessageFields(m) {
+			d, ok := compute(fld.msgType)
+			if !ok {
+				return 0, false
+			}
+			if d > max {
+				max = d
+			}
+		}
+		depths[m] = max + 1
+		return max + 1, true
+	}
+	return compute(md)
+}
@@ -0,0 +1,146 @@
+package desc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+func createTypeGraphTestFiles(t *testing.T) map[string]*FileDescriptor {
+	mapField := messageFieldProto("m", 2, ".graph.Tree.MEntry")
+	mapField.Label = dpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	key := fieldProto("key", 1, dpb.FieldDescriptorProto_TYPE_STRING, "")
+	ext := messageFieldProto("child", 100, ".graph.Ext")
+	ext.Extendee = proto.String(".graph.Ext")
+	files, err := CreateFileDescriptorsFromSet(&dpb.FileDescriptorSet{
+		File: []*dpb.FileDescriptorProto{
+			{
+				Name:    proto.String("graph.proto"),
+				Package: proto.String("graph"),
+				MessageType: []*dpb.DescriptorProto{
+					{
+						Name:  proto.String("Leaf"),
+						Field: []*dpb.FieldDescriptorProto{fieldProto("kind", 1, dpb.FieldDescriptorProto_TYPE_ENUM, ".graph.Kind")},
+					},
+					{
+						Name: proto.String("A"),
+						Field: []*dpb.FieldDescriptorProto{
+							messageFieldProto("b", 1, ".graph.B"),
+							messageFieldProto("leaf", 2, ".graph.Leaf"),
+						},
+					},
+					{
+						Name:  proto.String("B"),
+						Field: []*dpb.FieldDescriptorProto{messageFieldProto("a", 1, ".graph.A")},
+					},
+					{
+						Name:  proto.String("Self"),
+						Field: []*dpb.FieldDescriptorProto{messageFieldProto("self", 1, ".graph.Self")},
+					},
+					{
+						Name:  proto.String("Top"),
+						Field: []*dpb.FieldDescriptorProto{messageFieldProto("a", 1, ".graph.A")},
+					},
+					{
+						Name:  proto.String("Tree"),
+						Field: []*dpb.FieldDescriptorProto{messageFieldProto("leaf", 1, ".graph.Leaf"), mapField},
+						NestedType: []*dpb.DescriptorProto{
+							{
+								Name:    proto.String("MEntry"),
+								Field:   []*dpb.FieldDescriptorProto{key, messageFieldProto("value", 2, ".graph.Leaf")},
+								Options: &dpb.MessageOptions{MapEntry: proto.Bool(true)},
+							},
+						},
+					},
+					{
+						Name:           proto.String("Ext"),
+						ExtensionRange: []*dpb.DescriptorProto_ExtensionRange{{Start: proto.Int32(100), End: proto.Int32(201)}},
+					},
+				},
+				EnumType: []*dpb.EnumDescriptorProto{
+					{Name: proto.String("Kind"), Value: []*dpb.EnumValueDescriptorProto{{Name: proto.String("ZERO"), Number: proto.Int32(0)}}},
+				},
+			},
+			{
+				Name:       proto.String("graphext.proto"),
+				Package:    proto.String("graphext"),
+				Dependency: []string{"graph.proto"},
+				Extension:  []*dpb.FieldDescriptorProto{ext},
+			},
+		},
+	})
+	ok(t, err)
+	return files
+}
+
+func messageNames(mds []*MessageDescriptor) string {
+	names := make([]string, len(mds))
+	for i, md := range mds {
+		names[i] = md.GetFullyQualifiedName()
+	}
+	return strings.Join(names, ",")
+}
+
+func TestTypeGraphReferences(t *testing.T) {
+	files := createTypeGraphTestFiles(t)
+	g := NewTypeGraph(files["graphext.proto"])
+	fd := files["graph.proto"]
+
+	eq(t, "graph.A,graph.Tree,graph.Tree.MEntry", messageNames(g.GetReferringMessages(fd.FindMessage("graph.Leaf"))))
+	eq(t, "graph.Leaf", messageNames(g.GetReferringMessages(fd.FindEnum("graph.Kind"))))
+	eq(t, "graph.B,graph.Top", messageNames(g.GetReferringMessages(fd.FindMessage("graph.A"))))
+	eq(t, "", messageNames(g.GetReferringMessages(fd.FindMessage("graph.Top"))))
+
+	// an extension refers to its type from the message it extends
+	refs := g.FindReferences(fd.FindMessage("graph.Ext"))
+	eq(t, 1, len(refs))
+	eq(t, "graphext.child", refs[0].GetFullyQualifiedName())
+	eq(t, "graph.Ext", messageNames(g.GetReferringMessages(fd.FindMessage("graph.Ext"))))
+
+	// a graph for just one file doesn't know about extensions in other files
+	g = NewTypeGraph(fd)
+	eq(t, 0, len(g.FindReferences(fd.FindMessage("graph.Ext"))))
+}
+
+func TestTypeGraphRecursion(t *testing.T) {
+	files := createTypeGraphTestFiles(t)
+	g := NewTypeGraph(files["graphext.proto"])
+	fd := files["graph.proto"]
+
+	cases := []struct {
+		msg       string
+		recursion string
+		depth     int
+		bounded   bool
+	}{
+		{msg: "graph.Leaf", depth: 1, bounded: true},
+		{msg: "graph.A", recursion: "graph.A.b,graph.B.a"},
+		{msg: "graph.B", recursion: "graph.B.a,graph.A.b"},
+		{msg: "graph.Self", recursion: "graph.Self.self"},
+		{msg: "graph.Top"},
+		{msg: "graph.Tree", depth: 3, bounded: true},
+		{msg: "graph.Ext", recursion: "graphext.child"},
+	}
+	for _, c := range cases {
+		md := fd.FindMessage(c.msg)
+		var names []string
+		for _, fld := range g.FindRecursion(md) {
+			names = append(names, fld.GetFullyQualifiedName())
+		}
+		eq(t, c.recursion, strings.Join(names, ","), c.msg)
+		eq(t, c.recursion != "", g.IsRecursive(md), c.msg)
+		depth, bounded := g.GetMaxDepth(md)
+		eq(t, c.depth, depth, c.msg)
+		eq(t, c.bounded, bounded, c.msg)
+	}
+
+	// without the extension, Ext is not recursive
+	g = NewTypeGraph(fd)
+	md := fd.FindMessage("graph.Ext")
+	eq(t, false, g.IsRecursive(md))
+	depth, bounded := g.GetMaxDepth(md)
+	eq(t, 1, depth)
+	eq(t, true, bounded)
+}
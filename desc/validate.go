@@ -0,0 +1,222 @@
+package desc
+
+import (
+	"fmt"
+	"strings"
+
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+const (
+	maxFieldNumber           = 536870911
+	firstReservedFieldNumber = 19000
+	lastReservedFieldNumber  = 19999
+)
+
+// ValidationError is the error returned by CreateFileDescriptorStrict when a file
+// descriptor is not valid. It describes all of the problems that were found.
+type ValidationError struct {
+	// File is the name of the invalid file.
+	File string
+	// Problems describes each problem found, in the order they were found.
+	Problems []string
+}
+
+// Error returns a description of all of the problems found.
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("File %q is invalid: %s", e.File, strings.Join(e.Problems, "; "))
+}
+
+// CreateFileDescriptorStrict instantiates a new file descriptor for the given descriptor
+// proto, like CreateFileDescriptor, and then validates it. Validation checks the rules
+// that protoc enforces, which CreateFileDescriptor otherwise does not. These include:
+//   - Field numbers must be unique, in the valid range, not reserved (either by the
+//     message or because they are in the range 19000-19999 reserved for the protobuf
+//     implementation), and not in an extension range. Field names must not be reserved.
+//   - Extension field numbers must be in one of the extendee's extension ranges.
+//   - The JSON names of a message's fields must be unique.
+//   - Enum value numbers must be unique, unless the enum allows aliases, and not reserved.
+//     Enum value names must not be reserved.
+//   - In proto3 files, enums' first value must be zero and fields cannot be required.
+//   - Map entry messages must be well-formed.
+//
+// If the file is not valid, the returned error is a *ValidationError.
+func CreateFileDescriptorStrict(fd *dpb.FileDescriptorProto, deps ...*FileDescriptor) (*FileDescriptor, error) {
+	ret, err := CreateFileDescriptor(fd, deps...)
+	if err != nil {
+		return nil, err
+	}
+	v := validator{proto3: ret.IsProto3()}
+	for _, md := range ret.messages {
+		v.validateMessage(md)
+	}
+	for _, ed := range ret.enums {
+		v.validateEnum(ed)
+	}
+	for _, exd := range ret.extensions {
+		v.validateExtension(exd)
+	}
+	if len(v.problems) > 0 {
+		return nil, &ValidationError{File: fd.GetName(), Problems: v.problems}
+	}
+	return ret, nil
+}
+
+type validator struct {
+	proto3   bool
+	problems []string
+}
+
+func (v *validator) report(d Descriptor, format string, args ...interface{}) {
+	v.problems = append(v.problems, d.GetFullyQualifiedName()+": "+fmt.Sprintf(format, args...))
+}
+
+func (v *validator) validateMessage(md *MessageDescriptor) {
+	if md.IsMapEntry() {
+		v.validateMapEntry(md)
+	}
+	byNumber := map[int32]*FieldDescriptor{}
+	byJSONName := map[string]*FieldDescriptor{}
+	for _, fld := range md.fields {
+		v.validateFieldNumber(fld)
+		num := fld.GetNumber()
+		if other := byNumber[num]; other != nil {
+			v.report(fld, "field number %d is already used by %s", num, other.GetName())
+		} else {
+			byNumber[num] = fld
+		}
+		if md.IsReservedNumber(num) {
+			v.report(fld, "field number %d is reserved", num)
+		}
+		if md.IsReservedName(fld.GetName()) {
+			v.report(fld, "field name %s is reserved", fld.GetName())
+		}
+		if md.IsExtension(num) {
+			v.report(fld, "field number %d is in an extension range", num)
+		}
+		jsonName := fld.GetJSONName()
+		if other := byJSONName[jsonName]; other != nil {
+			v.report(fld, "JSON name %q conflicts with field %s", jsonName, other.GetName())
+		} else {
+			byJSONName[jsonName] = fld
+		}
+	}
+	for _, nmd := range md.nested {
+		v.validateMessage(nmd)
+	}
+	for _, ed := range md.enums {
+		v.validateEnum(ed)
+	}
+	for _, exd := range md.extensions {
+		v.validateExtension(exd)
+	}
+}
+
+func (v *validator) validateFieldNumber(fld *FieldDescriptor) {
+	num := fld.GetNumber()
+	if num < 1 || num > maxFieldNumber {
+		v.report(fld, "field number %d is out of range 1 to %d", num, maxFieldNumber)
+	} else if num >= firstReservedFieldNumber && num <= lastReservedFieldNumber {
+		v.report(fld, "field number %d is in the range %d to %d, which is reserved for the protobuf implementation", num, firstReservedFieldNumber, lastReservedFieldNumber)
+	}
+	if v.proto3 && fld.IsRequired() {
+		v.report(fld, "required fields are not allowed in proto3")
+	}
+}
+
+func (v *validator) validateExtension(exd *FieldDescriptor) {
+	v.validateFieldNumber(exd)
+	if !exd.GetOwner().IsExtension(exd.GetNumber()) {
+		v.report(exd, "field number %d is not in an extension range of %s", exd.GetNumber(), exd.GetOwner().GetFullyQualifiedName())
+	}
+}
+
+func (v *validator) validateMapEntry(md *MessageDescriptor) {
+	parent, ok := md.parent.(*MessageDescriptor)
+	if !ok {
+		v.report(md, "map entry must be nested in a message")
+		return
+	}
+	if len(md.nested) > 0 || len(md.enums) > 0 || len(md.extensions) > 0 || len(md.oneOfs) > 0 || len(md.extRanges) > 0 {
+		v.report(md, "map entry must only have key and value fields")
+	}
+	var key, val *FieldDescriptor
+	for _, fld := range md.fields {
+		switch {
+		case fld.GetNumber() == 1 && fld.GetName() == "key":
+			key = fld
+		case fld.GetNumber() == 2 && fld.GetName() == "value":
+			val = fld
+		}
+	}
+	if key == nil || val == nil || len(md.fields) != 2 {
+		v.report(md, "map entry must have exactly two fields: key = 1 and value = 2")
+	}
+	for _, fld := range []*FieldDescriptor{key, val} {
+		if fld != nil && fld.GetLabel() != dpb.FieldDescriptorProto_LABEL_OPTIONAL {
+			v.report(fld, "map entry field must be optional")
+		}
+	}
+	if key != nil {
+		switch key.GetType() {
+		case dpb.FieldDescriptorProto_TYPE_FLOAT, dpb.FieldDescriptorProto_TYPE_DOUBLE,
+			dpb.FieldDescriptorProto_TYPE_BYTES, dpb.FieldDescriptorProto_TYPE_ENUM,
+			dpb.FieldDescriptorProto_TYPE_MESSAGE, dpb.FieldDescriptorProto_TYPE_GROUP:
+			v.report(key, "map key cannot have type %v", key.GetType())
+		}
+	}
+	used := false
+	for _, fld := range parent.fields {
+		if fld.msgType == md {
+			if !fld.IsRepeated() || mapEntryName(fld.GetName()) != md.GetName() {
+				v.report(fld, "field of map entry type %s must be repeated and named to match it", md.GetName())
+			}
+			used = true
+		}
+	}
+	if !used {
+		v.report(md, "map entry is not the type of any field in %s", parent.GetFullyQualifiedName())
+	}
+}
+
+// mapEntryName returns the name protoc uses for the map entry message of a map
+// field with the given name: the name in UpperCamelCase with an "Entry" suffix.
+func mapEntryName(fieldName string) string {
+	var b []byte
+	upper := true
+	for i := 0; i < len(fieldName); i++ {
+		c := fieldName[i]
+		switch {
+		case c == '_':
+			upper = true
+		case upper && c >= 'a' && c <= 'z':
+			b = append(b, c-'a'+'A')
+			upper = false
+		default:
+			b = append(b, c)
+			upper = false
+		}
+	}
+	return string(b) + "Entry"
+}
+
+func (v *validator) validateEnum(ed *EnumDescriptor) {
+	if v.proto3 && (len(ed.values) == 0 || ed.values[0].GetNumber() != 0) {
+		v.report(ed, "first value of an enum in proto3 must be zero")
+	}
+	byNumber := map[int32]*EnumValueDescriptor{}
+	for _, vd := range ed.values {
+		num := vd.GetNumber()
+		if other := byNumber[num]; other != nil && !ed.AllowsAlias() {
+			v.report(vd, "number %d is already used by %s; set the allow_alias option to allow aliases", num, other.GetName())
+		} else if other == nil {
+			byNumber[num] = vd
+		}
+		if ed.IsReservedNumber(num) {
+			v.report(vd, "number %d is reserved", num)
+		}
+		if ed.IsReservedName(vd.GetName()) {
+			v.report(vd, "name %s is reserved", vd.GetName())
+		}
+	}
+}
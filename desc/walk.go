@@ -0,0 +1,140 @@
+package desc
+
+import "errors"
+
+// SkipChildren can be returned by a WalkFunc to indicate that the children of
+// the current element should not be visited. It is not returned as an error
+// by Walk.
+var SkipChildren = errors.New("skip children")
+
+// WalkFunc is the type of the function called by Walk for each element. The
+// given path is the element's source path: the path that identifies the
+// element in the file's source code info (see SourceCodeInfo.Location in
+// descriptor.proto). The function may retain the path, since each call gets
+// its own copy.
+//
+// If the function returns SkipChildren, the children of the element are not
+// visited but the walk otherwise continues. If it returns any other non-nil
+// error, the walk stops and Walk returns that error.
+type WalkFunc func(d Descriptor, path []int32) error
+
+// Walk visits every element in the given file, depth-first, calling fn for
+// each one. The file itself is not visited. Elements are visited in the order
+// they are declared, with each kind of element visited as a group:
+//   - In a file: messages, then enums, then extensions, then services.
+//   - In a message: fields, then one-ofs, then nested messages, then nested
+//     enums, then nested extensions.
+//   - In an enum: its values.
+//   - In a service: its methods.
+//
+// A one-of's fields are visited as children of the enclosing message, not of
+// the one-of.
+func Walk(fd *FileDescriptor, fn WalkFunc) error {
+	w := walker{fn: fn}
+	return w.walkFile(fd)
+}
+
+type walker struct {
+	fn   WalkFunc
+	path []int32
+}
+
+// visit calls the walk function for the given element, whose path is the
+// walker's current path plus the given tag and index. If the function does not
+// return an error, children is called to visit the element's children, with
+// the walker's path set to the element's path.
+func (w *walker) visit(d Descriptor, tag int32, index int, children func() error) error {
+	w.path = append(w.path, tag, int32(index))
+	defer func() {
+		w.path = w.path[:len(w.path)-2]
+	}()
+	path := make([]int32, len(w.path))
+	copy(path, w.path)
+	if err := w.fn(d, path); err != nil {
+		if err == SkipChildren {
+			return nil
+		}
+		return err
+	}
+	if children != nil {
+		return children()
+	}
+	return nil
+}
+
+func (w *walker) walkFile(fd *FileDescriptor) error {
+	for i, md := range fd.messages {
+		if err := w.walkMessage(md, file_messagesTag, i); err != nil {
+			return err
+		}
+	}
+	for i, ed := range fd.enums {
+		if err := w.walkEnum(ed, file_enumsTag, i); err != nil {
+			return err
+		}
+	}
+	for i, exd := range fd.extensions {
+		if err := w.visit(exd, file_extensionsTag, i, nil); err != nil {
+			return err
+		}
+	}
+	for i, sd := range fd.services {
+		if err := w.walkService(sd, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *walker) walkMessage(md *MessageDescriptor, tag int32, index int) error {
+	return w.visit(md, tag, index, func() error {
+		for i, fld := range md.fields {
+			if err := w.visit(fld, message_fieldsTag, i, nil); err != nil {
+				return err
+			}
+		}
+		for i, ood := range md.oneOfs {
+			if err := w.visit(ood, message_oneOfsTag, i, nil); err != nil {
+				return err
+			}
+		}
+		for i, nmd := range md.nested {
+			if err := w.walkMessage(nmd, message_nestedMessagesTag, i); err != nil {
+				return err
+			}
+		}
+		for i, ed := range md.enums {
+			if err := w.walkEnum(ed, message_enumsTag, i); err != nil {
+				return err
+			}
+		}
+		for i, exd := range md.extensions {
+			if err := w.visit(exd, message_extensionsTag, i, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (w *walker) walkEnum(ed *EnumDescriptor, tag int32, index int) error {
+	return w.visit(ed, tag, index, func() error {
+		for i, vd := range ed.values {
+			if err := w.visit(vd, enum_valuesTag, i, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (w *walker) walkService(sd *ServiceDescriptor, index int) error {
+	return w.visit(sd, file_servicesTag, index, func() error {
+		for i, mtd := range sd.methods {
+			if err := w.visit(mtd, service_methodsTag, i, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,65 @@
+package desc
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/jhump/protoreflect/desc/desc_test"
+)
+
+func TestWalk(t *testing.T) {
+	fd, err := CreateFileDescriptorFromSet(desc_test.GetDescriptorSet())
+	ok(t, err)
+
+	// every symbol is visited exactly once, and paths match source info
+	visited := map[string]int{}
+	err = Walk(fd, func(d Descriptor, path []int32) error {
+		visited[d.GetFullyQualifiedName()]++
+		eq(t, true, proto.Equal(d.GetSourceInfo(), findLocation(fd, path)), "%s: wrong path %v", d.GetFullyQualifiedName(), path)
+		return nil
+	})
+	ok(t, err)
+	eq(t, len(fd.symbols), len(visited))
+	for sym := range fd.symbols {
+		eq(t, 1, visited[sym], "%s", sym)
+	}
+
+	// order of visits
+	var names []string
+	err = Walk(fd, func(d Descriptor, path []int32) error {
+		names = append(names, d.GetFullyQualifiedName())
+		if _, ok := d.(*MessageDescriptor); ok {
+			return SkipChildren
+		}
+		return nil
+	})
+	ok(t, err)
+	eq(t, "[desc_test.TestMessage desc_test.AnotherTestMessage desc_test.xtm desc_test.xs desc_test.xi desc_test.xui]", fmt.Sprint(names))
+
+	// aborting the walk
+	stop := errors.New("stop")
+	count := 0
+	err = Walk(fd, func(d Descriptor, path []int32) error {
+		count++
+		if d.GetName() == "NestedMessage" {
+			return stop
+		}
+		return nil
+	})
+	eq(t, stop, err)
+	// TestMessage, its four fields, and then NestedMessage
+	eq(t, 6, count)
+}
+
+func findLocation(fd *FileDescriptor, path []int32) *dpb.SourceCodeInfo_Location {
+	for _, loc := range fd.AsFileDescriptorProto().GetSourceCodeInfo().GetLocation() {
+		if fmt.Sprint(loc.Path) == fmt.Sprint(path) {
+			return loc
+		}
+	}
+	return nil
+}
@@ -0,0 +1,748 @@
+package dynamic
+
+// Binary serialization and de-serialization for dynamic messages
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/jhump/protoreflect/desc"
+)
+
+// Marshal serializes this message to bytes, returning an error if the operation
+// fails. The resulting bytes are in the standard protocol buffer binary format.
+func (m *Message) Marshal() ([]byte, error) {
+	var b codedBuffer
+	if err := m.marshal(&b); err != nil {
+		return nil, err
+	}
+	return b.buf, nil
+}
+
+func (m *Message) marshal(b *codedBuffer) error {
+	if err := m.marshalKnownFields(b); err != nil {
+		return err
+	}
+	return m.marshalUnknownFields(b)
+}
+
+func (m *Message) marshalKnownFields(b *codedBuffer) error {
+	for _, tag := range m.allKnownFieldTags() {
+		fd := m.FindFieldDescriptor(tag)
+		if fd == nil {
+			return fmt.Errorf("message %s has value for tag %d, but no field descriptor", m.md.GetFullyQualifiedName(), tag)
+		}
+		if err := marshalField(tag, fd, m.values[tag], b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Message) marshalUnknownFields(b *codedBuffer) error {
+	for _, u := range m.unknownFields {
+		b.buf = append(b.buf, u.raw...)
+	}
+	return nil
+}
+
+func marshalField(tag int32, fd *desc.FieldDescriptor, val interface{}, b *codedBuffer) error {
+	if fd.IsMap() {
+		mp := val.(map[interface{}]interface{})
+		entryType := fd.GetMessageType()
+		keyType := entryType.FindFieldByNumber(1)
+		valType := entryType.FindFieldByNumber(2)
+		var entryBuffer codedBuffer
+		for _, k := range sortedMapKeys(mp) {
+			entryBuffer.buf = entryBuffer.buf[:0]
+			if err := marshalValue(1, keyType, k, &entryBuffer); err != nil {
+				return err
+			}
+			if err := marshalValue(2, valType, mp[k], &entryBuffer); err != nil {
+				return err
+			}
+			b.encodeTagAndWireType(tag, proto.WireBytes)
+			b.encodeRawBytes(entryBuffer.buf)
+		}
+	} else if fd.IsRepeated() {
+		sl := val.([]interface{})
+		if isPacked(fd) && len(sl) > 0 {
+			var packedBuffer codedBuffer
+			for _, v := range sl {
+				if err := encodeValueNoTag(fd, v, &packedBuffer); err != nil {
+					return err
+				}
+			}
+			b.encodeTagAndWireType(tag, proto.WireBytes)
+			b.encodeRawBytes(packedBuffer.buf)
+		} else {
+			for _, v := range sl {
+				if err := marshalValue(tag, fd, v, b); err != nil {
+					return err
+				}
+			}
+		}
+	} else {
+		return marshalValue(tag, fd, val, b)
+	}
+	return nil
+}
+
+// isPacked returns true if the given repeated field uses packed encoding. Fields
+// in proto3 files are packed by default; fields in proto2 files must opt in.
+func isPacked(fd *desc.FieldDescriptor) bool {
+	if !isPackable(fd.GetType()) {
+		return false
+	}
+	opts := fd.GetFieldOptions()
+	if opts != nil && opts.Packed != nil {
+		return opts.GetPacked()
+	}
+	return fd.GetFile().AsFileDescriptorProto().GetSyntax() == "proto3"
+}
+
+// isPackable returns true if repeated fields of the given type may use packed
+// encoding. Only scalar numeric types (which includes bools and enums) are
+// packable.
+func isPackable(t dpb.FieldDescriptorProto_Type) bool {
+	switch t {
+	case dpb.FieldDescriptorProto_TYPE_STRING,
+		dpb.FieldDescriptorProto_TYPE_BYTES,
+		dpb.FieldDescriptorProto_TYPE_MESSAGE,
+		dpb.FieldDescriptorProto_TYPE_GROUP:
+		return false
+	default:
+		return true
+	}
+}
+
+// wireTypeFor returns the wire type used to encode values of the given type.
+func wireTypeFor(t dpb.FieldDescriptorProto_Type) int8 {
+	switch t {
+	case dpb.FieldDescriptorProto_TYPE_FIXED32,
+		dpb.FieldDescriptorProto_TYPE_SFIXED32,
+		dpb.FieldDescriptorProto_TYPE_FLOAT:
+		return proto.WireFixed32
+	case dpb.FieldDescriptorProto_TYPE_FIXED64,
+		dpb.FieldDescriptorProto_TYPE_SFIXED64,
+		dpb.FieldDescriptorProto_TYPE_DOUBLE:
+		return proto.WireFixed64
+	case dpb.FieldDescriptorProto_TYPE_STRING,
+		dpb.FieldDescriptorProto_TYPE_BYTES,
+		dpb.FieldDescriptorProto_TYPE_MESSAGE:
+		return proto.WireBytes
+	case dpb.FieldDescriptorProto_TYPE_GROUP:
+		return proto.WireStartGroup
+	default:
+		return proto.WireVarint
+	}
+}
+
+func marshalValue(tag int32, fd *desc.FieldDescriptor, val interface{}, b *codedBuffer) error {
+	if fd.GetType() == dpb.FieldDescriptorProto_TYPE_GROUP {
+		b.encodeTagAndWireType(tag, proto.WireStartGroup)
+		if dm, ok := val.(*Message); ok {
+			if err := dm.marshal(b); err != nil {
+				return err
+			}
+		} else {
+			bytes, err := proto.Marshal(val.(proto.Message))
+			if err != nil {
+				return err
+			}
+			b.buf = append(b.buf, bytes...)
+		}
+		b.encodeTagAndWireType(tag, proto.WireEndGroup)
+		return nil
+	}
+	b.encodeTagAndWireType(tag, wireTypeFor(fd.GetType()))
+	return encodeValueNoTag(fd, val, b)
+}
+
+func encodeValueNoTag(fd *desc.FieldDescriptor, val interface{}, b *codedBuffer) error {
+	switch fd.GetType() {
+	case dpb.FieldDescriptorProto_TYPE_BOOL:
+		v := val.(bool)
+		if v {
+			b.encodeVarint(1)
+		} else {
+			b.encodeVarint(0)
+		}
+
+	case dpb.FieldDescriptorProto_TYPE_ENUM,
+		dpb.FieldDescriptorProto_TYPE_INT32:
+		v := val.(int32)
+		b.encodeVarint(uint64(v))
+
+	case dpb.FieldDescriptorProto_TYPE_SFIXED32:
+		v := val.(int32)
+		b.encodeFixed32(uint64(v))
+
+	case dpb.FieldDescriptorProto_TYPE_SINT32:
+		v := val.(int32)
+		b.encodeVarint(encodeZigZag32(int64(v)))
+
+	case dpb.FieldDescriptorProto_TYPE_UINT32:
+		v := val.(uint32)
+		b.encodeVarint(uint64(v))
+
+	case dpb.FieldDescriptorProto_TYPE_FIXED32:
+		v := val.(uint32)
+		b.encodeFixed32(uint64(v))
+
+	case dpb.FieldDescriptorProto_TYPE_INT64:
+		v := val.(int64)
+		b.encodeVarint(uint64(v))
+
+	case dpb.FieldDescriptorProto_TYPE_SFIXED64:
+		v := val.(int64)
+		b.encodeFixed64(uint64(v))
+
+	case dpb.FieldDescriptorProto_TYPE_SINT64:
+		v := val.(int64)
+		b.encodeVarint(encodeZigZag64(v))
+
+	case dpb.FieldDescriptorProto_TYPE_UINT64:
+		v := val.(uint64)
+		b.encodeVarint(v)
+
+	case dpb.FieldDescriptorProto_TYPE_FIXED64:
+		v := val.(uint64)
+		b.encodeFixed64(v)
+
+	case dpb.FieldDescriptorProto_TYPE_DOUBLE:
+		v := val.(float64)
+		b.encodeFixed64(math.Float64bits(v))
+
+	case dpb.FieldDescriptorProto_TYPE_FLOAT:
+		v := val.(float32)
+		b.encodeFixed32(uint64(math.Float32bits(v)))
+
+	case dpb.FieldDescriptorProto_TYPE_BYTES:
+		v := val.([]byte)
+		b.encodeRawBytes(v)
+
+	case dpb.FieldDescriptorProto_TYPE_STRING:
+		v := val.(string)
+		b.encodeRawBytes([]byte(v))
+
+	case dpb.FieldDescriptorProto_TYPE_MESSAGE:
+		m := val.(proto.Message)
+		var bytes []byte
+		var err error
+		if dm, ok := m.(*Message); ok {
+			bytes, err = dm.Marshal()
+		} else {
+			bytes, err = proto.Marshal(m)
+		}
+		if err != nil {
+			return err
+		}
+		b.encodeRawBytes(bytes)
+
+	default:
+		return fmt.Errorf("unrecognized field type: %v", fd.GetType())
+	}
+	return nil
+}
+
+// sortedMapKeys returns the keys of the given map in a deterministic order.
+// Map keys are always all of the same type, which is an integer, bool, or
+// string type.
+func sortedMapKeys(mp map[interface{}]interface{}) []interface{} {
+	keys := make([]interface{}, 0, len(mp))
+	for k := range mp {
+		keys = append(keys, k)
+	}
+	sort.Sort(sortableKeys(keys))
+	return keys
+}
+
+type sortableKeys []interface{}
+
+func (s sortableKeys) Len() int {
+	return len(s)
+}
+
+func (s sortableKeys) Less(i, j int) bool {
+	switch t := s[i].(type) {
+	case int32:
+		return t < s[j].(int32)
+	case int64:
+		return t < s[j].(int64)
+	case uint32:
+		return t < s[j].(uint32)
+	case uint64:
+		return t < s[j].(uint64)
+	case string:
+		return t < s[j].(string)
+	case bool:
+		return !t && s[j].(bool)
+	default:
+		panic(fmt.Sprintf("invalid type for map key: %T", s[i]))
+	}
+}
+
+func (s sortableKeys) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// Unmarshal de-serializes the message that is present in the given bytes into
+// this message. It first resets the current message. It returns an error if the
+// given bytes do not contain a valid encoding of this message type.
+func (m *Message) Unmarshal(b []byte) error {
+	m.Reset()
+	return m.UnmarshalMerge(b)
+}
+
+// UnmarshalMerge de-serializes the message that is present in the given bytes
+// into this message. Unlike Unmarshal, it does not first reset the message,
+// instead merging the data in the given bytes into the existing data in this
+// message.
+func (m *Message) UnmarshalMerge(b []byte) error {
+	return m.unmarshal(newCodedBuffer(b), 0)
+}
+
+// unmarshal parses fields from the given buffer into this message. If groupTag
+// is non-zero then the message being parsed is a group and parsing ends at the
+// end-group tag for that tag number.
+func (m *Message) unmarshal(buf *codedBuffer, groupTag int32) error {
+	for !buf.eof() {
+		start := buf.index
+		tagNumber, wireType, err := buf.decodeTagAndWireType()
+		if err != nil {
+			return err
+		}
+		if wireType == proto.WireEndGroup {
+			if groupTag == 0 || groupTag != tagNumber {
+				return fmt.Errorf("unexpected end group tag for field %d", tagNumber)
+			}
+			return nil
+		}
+		if tagNumber <= 0 {
+			return fmt.Errorf("illegal tag number: %d", tagNumber)
+		}
+		fd := m.FindFieldDescriptor(tagNumber)
+		if fd == nil {
+			err = m.unmarshalUnknownField(tagNumber, wireType, start, buf)
+		} else {
+			err = m.unmarshalKnownField(fd, wireType, start, buf)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if groupTag != 0 {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// unmarshalKnownField parses the value for the given field from the buffer. The
+// given start is the index of the field's tag in the buffer.
+func (m *Message) unmarshalKnownField(fd *desc.FieldDescriptor, wireType int8, start int, buf *codedBuffer) error {
+	if wireType == proto.WireBytes && fd.IsRepeated() && isPackable(fd.GetType()) {
+		// packed repeated field
+		raw, err := buf.decodeRawBytes(false)
+		if err != nil {
+			return err
+		}
+		packed := newCodedBuffer(raw)
+		wt := wireTypeFor(fd.GetType())
+		for !packed.eof() {
+			valStart := packed.index
+			v, err := m.decodeValue(fd, wt, packed)
+			if err != nil {
+				return err
+			}
+			if isUnknownEnumValue(fd, v) {
+				// retained as if it had been encoded on its own, unpacked
+				var b codedBuffer
+				b.encodeTagAndWireType(fd.GetNumber(), wt)
+				b.buf = append(b.buf, raw[valStart:packed.index]...)
+				m.addUnknownField(fd.GetNumber(), b.buf)
+				continue
+			}
+			if err := m.mergeFieldValue(fd, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if wireType != wireTypeFor(fd.GetType()) {
+		// the field's data is not encoded correctly for its type; so we
+		// retain it as an unknown field
+		return m.unmarshalUnknownField(fd.GetNumber(), wireType, start, buf)
+	}
+	v, err := m.decodeValue(fd, wireType, buf)
+	if err != nil {
+		return err
+	}
+	if isUnknownEnumValue(fd, v) {
+		m.addUnknownField(fd.GetNumber(), buf.buf[start:buf.index])
+		return nil
+	}
+	return m.mergeFieldValue(fd, v)
+}
+
+// isUnknownEnumValue returns true if the given value, decoded for the given
+// field, is a number that the field's enum does not define and the enum is
+// closed (see desc.EnumDescriptor.IsOpen). Such values are retained as unknown
+// fields instead of being stored as the field's value. For map fields, the
+// value is a map entry, and the whole entry is retained as an unknown field if
+// its value was retained as an unknown field of the entry.
+func isUnknownEnumValue(fd *desc.FieldDescriptor, v interface{}) bool {
+	if fd.IsMap() {
+		valFd := fd.GetMessageType().FindFieldByNumber(2)
+		return valFd.GetEnumType() != nil && v.(*Message).hasUnknownField(2)
+	}
+	ed := fd.GetEnumType()
+	if ed == nil || ed.IsOpen() {
+		return false
+	}
+	num, ok := v.(int32)
+	return ok && ed.FindValueByNumber(num) == nil
+}
+
+// decodeValue decodes a single value for the given field from the buffer. For
+// map fields, the value is the map entry message.
+func (m *Message) decodeValue(fd *desc.FieldDescriptor, wireType int8, buf *codedBuffer) (interface{}, error) {
+	switch wireType {
+	case proto.WireVarint:
+		u, err := buf.decodeVarint()
+		if err != nil {
+			return nil, err
+		}
+		return decodeVarintValue(fd, u)
+
+	case proto.WireFixed32:
+		u, err := buf.decodeFixed32()
+		if err != nil {
+			return nil, err
+		}
+		switch fd.GetType() {
+		case dpb.FieldDescriptorProto_TYPE_FLOAT:
+			return math.Float32frombits(uint32(u)), nil
+		case dpb.FieldDescriptorProto_TYPE_SFIXED32:
+			return int32(u), nil
+		default:
+			return uint32(u), nil
+		}
+
+	case proto.WireFixed64:
+		u, err := buf.decodeFixed64()
+		if err != nil {
+			return nil, err
+		}
+		switch fd.GetType() {
+		case dpb.FieldDescriptorProto_TYPE_DOUBLE:
+			return math.Float64frombits(u), nil
+		case dpb.FieldDescriptorProto_TYPE_SFIXED64:
+			return int64(u), nil
+		default:
+			return u, nil
+		}
+
+	case proto.WireBytes:
+		switch fd.GetType() {
+		case dpb.FieldDescriptorProto_TYPE_BYTES:
+			return buf.decodeRawBytes(true)
+		case dpb.FieldDescriptorProto_TYPE_STRING:
+			b, err := buf.decodeRawBytes(false)
+			if err != nil {
+				return nil, err
+			}
+			return string(b), nil
+		default:
+			b, err := buf.decodeRawBytes(false)
+			if err != nil {
+				return nil, err
+			}
+			msg := m.newMessage(fd.GetMessageType())
+			if err := msg.UnmarshalMerge(b); err != nil {
+				return nil, err
+			}
+			return msg, nil
+		}
+
+	case proto.WireStartGroup:
+		msg := m.newMessage(fd.GetMessageType())
+		if err := msg.unmarshal(buf, fd.GetNumber()); err != nil {
+			return nil, err
+		}
+		return msg, nil
+
+	default:
+		return nil, fmt.Errorf("unrecognized wire type: %d", wireType)
+	}
+}
+
+func decodeVarintValue(fd *desc.FieldDescriptor, u uint64) (interface{}, error) {
+	// like generated code, values too large for 32-bit fields are truncated,
+	// which makes changing between 32- and 64-bit types wire-compatible
+	switch fd.GetType() {
+	case dpb.FieldDescriptorProto_TYPE_BOOL:
+		return u != 0, nil
+	case dpb.FieldDescriptorProto_TYPE_UINT32:
+		return uint32(u), nil
+	case dpb.FieldDescriptorProto_TYPE_INT32,
+		dpb.FieldDescriptorProto_TYPE_ENUM:
+		return int32(u), nil
+	case dpb.FieldDescriptorProto_TYPE_SINT32:
+		return int32(decodeZigZag32(u)), nil
+	case dpb.FieldDescriptorProto_TYPE_UINT64:
+		return u, nil
+	case dpb.FieldDescriptorProto_TYPE_INT64:
+		return int64(u), nil
+	case dpb.FieldDescriptorProto_TYPE_SINT64:
+		return decodeZigZag64(u), nil
+	default:
+		return nil, fmt.Errorf("field %s of type %v cannot be encoded as a varint", fd.GetFullyQualifiedName(), fd.GetType())
+	}
+}
+
+// mergeFieldValue merges a single value, de-serialized from the binary format,
+// into the given field. Values for repeated fields are appended; values for map
+// fields are map entry messages, which are added to the map; and message values
+// are merged into any existing value. For other fields, the given value replaces
+// any existing one.
+func (m *Message) mergeFieldValue(fd *desc.FieldDescriptor, val interface{}) error {
+	if fd.IsMap() {
+		entry := val.(*Message)
+		entryType := fd.GetMessageType()
+		k, err := entry.TryGetFieldByNumber(1)
+		if err != nil {
+			return err
+		}
+		v, err := entry.TryGetFieldByNumber(2)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			// absent message value is an empty message
+			v = m.newMessage(entryType.FindFieldByNumber(2).GetMessageType())
+		}
+		mp, _ := m.values[fd.GetNumber()].(map[interface{}]interface{})
+		if mp == nil {
+			m.storeFieldValue(fd, map[interface{}]interface{}{k: v})
+		} else {
+			mp[k] = v
+		}
+		return nil
+	}
+	if fd.IsRepeated() {
+		sl, _ := m.values[fd.GetNumber()].([]interface{})
+		m.storeFieldValue(fd, append(sl, val))
+		return nil
+	}
+	if newMsg, ok := val.(*Message); ok {
+		if existing, ok := m.values[fd.GetNumber()]; ok {
+			// merge new message into existing one
+			if existingDm, ok := existing.(*Message); ok {
+				existingDm.mergeFrom(newMsg)
+				return nil
+			}
+			b, err := newMsg.Marshal()
+			if err != nil {
+				return err
+			}
+			return proto.UnmarshalMerge(b, existing.(proto.Message))
+		}
+	}
+	m.storeFieldValue(fd, val)
+	return nil
+}
+
+// mergeFrom merges the values of the given message into this one. Both must be
+// the same message type. Values are not copied, so this is only used with
+// messages that were freshly de-serialized and are not otherwise referenced.
+func (m *Message) mergeFrom(other *Message) {
+	for _, tag := range other.allKnownFieldTags() {
+		fd := other.FindFieldDescriptor(tag)
+		val := other.values[tag]
+		if fd.IsMap() {
+			for k, v := range val.(map[interface{}]interface{}) {
+				m.mergeFieldValue(fd, mapEntry(m, fd, k, v))
+			}
+		} else if fd.IsRepeated() {
+			for _, v := range val.([]interface{}) {
+				m.mergeFieldValue(fd, v)
+			}
+		} else {
+			m.mergeFieldValue(fd, val)
+		}
+	}
+	m.unknownFields = append(m.unknownFields, other.unknownFields...)
+}
+
+func mapEntry(m *Message, fd *desc.FieldDescriptor, k, v interface{}) *Message {
+	entry := m.newMessage(fd.GetMessageType())
+	entry.values[1] = k
+	entry.values[2] = v
+	return entry
+}
+
+// unmarshalUnknownField consumes the value for an unrecognized field from the
+// buffer and retains the field's raw bytes, starting at the given index of its
+// tag, so that it can be re-serialized exactly as it was read.
+func (m *Message) unmarshalUnknownField(tagNumber int32, wireType int8, start int, buf *codedBuffer) error {
+	if err := buf.skipValue(tagNumber, wireType); err != nil {
+		return err
+	}
+	m.addUnknownField(tagNumber, buf.buf[start:buf.index])
+	return nil
+}
+
+// addUnknownField retains a copy of the given raw bytes, which encode a field
+// with the given tag number, as an unknown field.
+func (m *Message) addUnknownField(tagNumber int32, b []byte) {
+	raw := make([]byte, len(b))
+	copy(raw, b)
+	m.unknownFields = append(m.unknownFields, unknownField{tag: tagNumber, raw: raw})
+}
+
+// parseUnknownField interprets any unknown values for the given field's tag
+// number using the given field descriptor. On success, the values become known
+// and the field descriptor is remembered by the message.
+func (m *Message) parseUnknownField(fd *desc.FieldDescriptor) error {
+	if !fd.IsExtension() {
+		// the message's own fields were known when it was de-serialized, so
+		// any unknown values with their tags could not be parsed then either
+		return nil
+	}
+	var b []byte
+	for _, u := range m.unknownFields {
+		if u.tag == fd.GetNumber() {
+			b = append(b, u.raw...)
+		}
+	}
+	if b == nil {
+		return nil
+	}
+	// parse into a scratch message so that a failure leaves this one unchanged
+	scratch := m.newMessage(m.md)
+	scratch.extraFields[fd.GetNumber()] = fd
+	if err := scratch.UnmarshalMerge(b); err != nil {
+		return err
+	}
+	// values that the field can't represent, like numbers that a closed enum
+	// does not define, remain unknown; but values encoded with the wrong wire
+	// type mean the field is not compatible
+	remaining := scratch.unknownFields
+	for _, u := range remaining {
+		if wt := u.decode().Encoding; wt != wireTypeFor(fd.GetType()) && !(wt == proto.WireBytes && fd.IsRepeated() && isPackable(fd.GetType())) {
+			return fmt.Errorf("unknown field %d is not compatible with field %s", fd.GetNumber(), fd.GetFullyQualifiedName())
+		}
+	}
+	if _, ok := scratch.values[fd.GetNumber()]; !ok {
+		// nothing was recognized, so leave the unknown values as they are
+		return nil
+	}
+	m.clearUnknownField(fd.GetNumber())
+	m.unknownFields = append(m.unknownFields, remaining...)
+	m.extraFields[fd.GetNumber()] = fd
+	if v, ok := scratch.values[fd.GetNumber()]; ok {
+		m.values[fd.GetNumber()] = v
+	}
+	return nil
+}
+
+// ConvertTo converts this dynamic message into the given message. This is
+// shorthand for resetting then merging:
+//
+//	target.Reset()
+//	m.MergeInto(target)
+func (m *Message) ConvertTo(target proto.Message) error {
+	if err := m.checkType(target); err != nil {
+		return err
+	}
+	target.Reset()
+	return m.mergeInto(target)
+}
+
+// MergeInto merges this dynamic message into the given message. All field
+// values in this message will be set on the given message. For map fields,
+// entries are added to the given message (if the given message has existing
+// values for like keys, they are overwritten). For slice fields, elements are
+// added.
+//
+// If the given message has a different set of known fields, it is possible for
+// some known fields in this message to be represented as unknown fields in the
+// given message after merging, and vice versa.
+func (m *Message) MergeInto(target proto.Message) error {
+	if err := m.checkType(target); err != nil {
+		return err
+	}
+	return m.mergeInto(target)
+}
+
+func (m *Message) mergeInto(target proto.Message) error {
+	b, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+	if dm, ok := target.(*Message); ok {
+		return dm.UnmarshalMerge(b)
+	}
+	return proto.UnmarshalMerge(b, target)
+}
+
+// ConvertFrom converts the given message into this dynamic message. This is
+// shorthand for resetting then merging:
+//
+//	m.Reset()
+//	m.MergeFrom(source)
+func (m *Message) ConvertFrom(source proto.Message) error {
+	if err := m.checkType(source); err != nil {
+		return err
+	}
+	m.Reset()
+	return m.mergeFromMessage(source)
+}
+
+// MergeFrom merges the given message into this dynamic message. All field
+// values in the given message will be set on this message. For map fields,
+// entries are added to this message (if this message has existing values for
+// like keys, they are overwritten). For slice fields, elements are added.
+//
+// If the given message has a different set of known fields, it is possible for
+// some known fields in that message to be represented as unknown fields in this
+// message after merging, and vice versa.
+func (m *Message) MergeFrom(source proto.Message) error {
+	if err := m.checkType(source); err != nil {
+		return err
+	}
+	return m.mergeFromMessage(source)
+}
+
+func (m *Message) mergeFromMessage(source proto.Message) error {
+	var b []byte
+	var err error
+	if dm, ok := source.(*Message); ok {
+		b, err = dm.Marshal()
+	} else {
+		b, err = proto.Marshal(source)
+	}
+	if err != nil {
+		return err
+	}
+	return m.UnmarshalMerge(b)
+}
+
+func (m *Message) checkType(other proto.Message) error {
+	if name := messageName(other); name != m.md.GetFullyQualifiedName() {
+		return fmt.Errorf("given message has wrong type: %q; expecting %q", name, m.md.GetFullyQualifiedName())
+	}
+	return nil
+}
+
+// Verify that *Message implements the interfaces used by the proto package
+// for custom serialization.
+var _ proto.Marshaler = (*Message)(nil)
+var _ proto.Unmarshaler = (*Message)(nil)
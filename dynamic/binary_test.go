@@ -0,0 +1,317 @@
+package dynamic
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/desc_test"
+)
+
+func TestBinaryRoundTrip(t *testing.T) {
+	rnr := &desc_test.AnotherTestMessage_RockNRoll{Beatles: proto.String("abc"), Stones: proto.String("def")}
+	dne := desc_test.TestMessage_NestedMessage_AnotherNestedMessage_YetAnotherNestedMessage_VALUE1
+	atm := &desc_test.AnotherTestMessage{
+		Dne:       &dne,
+		MapField1: map[int32]string{1: "one", 2: "two", 3: "three"},
+		MapField2: map[int64]float32{-1: 1.5, 100: 3.25},
+		MapField3: map[uint32]bool{1: true, 2: false},
+		MapField4: map[string]*desc_test.AnotherTestMessage{"a": {MapField1: map[int32]string{4: "four"}}},
+		Rocknroll: rnr,
+	}
+	ok(t, proto.SetExtension(atm, desc_test.E_Xs, proto.String("ext")))
+	ok(t, proto.SetExtension(atm, desc_test.E_Xi, proto.Int32(-99)))
+
+	b, err := proto.Marshal(atm)
+	ok(t, err)
+
+	md, err := desc.LoadMessageDescriptorForMessage(atm)
+	ok(t, err)
+	dm := NewMessageWithExtensionRegistry(md, NewExtensionRegistryWithDefaults())
+	ok(t, dm.Unmarshal(b))
+
+	eq(t, int32(dne), dm.GetFieldByName("dne"))
+	eq(t, "two", dm.GetMapFieldByName("map_field1", int32(2)))
+	eq(t, float32(3.25), dm.GetMapFieldByName("map_field2", int64(100)))
+	eq(t, false, dm.GetMapFieldByName("map_field3", uint32(2)))
+	nested := dm.GetMapFieldByName("map_field4", "a").(*Message)
+	eq(t, "four", nested.GetMapFieldByName("map_field1", int32(4)))
+	group := dm.GetFieldByName("rocknroll").(*Message)
+	eq(t, "abc", group.GetFieldByName("beatles"))
+	eq(t, "ext", dm.GetFieldByName("desc_test.xs"))
+	eq(t, int32(-99), dm.GetFieldByName("desc_test.xi"))
+
+	// and back again
+	b, err = dm.Marshal()
+	ok(t, err)
+	var atm2 desc_test.AnotherTestMessage
+	ok(t, proto.Unmarshal(b, &atm2))
+	eq(t, true, proto.Equal(atm, &atm2))
+
+	// conversion does the same thing
+	var atm3 desc_test.AnotherTestMessage
+	ok(t, dm.ConvertTo(&atm3))
+	eq(t, true, proto.Equal(atm, &atm3))
+
+	dm2 := NewMessage(md)
+	ok(t, dm2.ConvertFrom(atm))
+	b2, err := dm2.Marshal()
+	ok(t, err)
+	eq(t, true, bytes.Equal(b, b2))
+}
+
+func TestBinaryOneOfs(t *testing.T) {
+	f := &desc_test.Frobnitz{
+		Abc: &desc_test.Frobnitz_C1{C1: &desc_test.TestMessage_NestedMessage{}},
+		Def: &desc_test.Frobnitz_G3{G3: 321},
+		F:   []string{"a", "b", "c"},
+	}
+	b, err := proto.Marshal(f)
+	ok(t, err)
+
+	md, err := desc.LoadMessageDescriptorForMessage(f)
+	ok(t, err)
+	dm := NewMessage(md)
+	ok(t, dm.Unmarshal(b))
+	eq(t, true, dm.HasFieldName("c1"))
+	eq(t, uint32(321), dm.GetFieldByName("g3"))
+	eq(t, 3, dm.FieldLengthByName("f"))
+
+	// a later value for another field in the oneof replaces the earlier one
+	var buf codedBuffer
+	buf.encodeTagAndWireType(8, proto.WireVarint)
+	buf.encodeVarint(99)
+	ok(t, dm.UnmarshalMerge(buf.buf))
+	eq(t, false, dm.HasFieldName("g3"))
+	eq(t, int32(99), dm.GetFieldByName("g1"))
+}
+
+func TestBinaryPacked(t *testing.T) {
+	req := &desc_test.TestRequest{Foo: []desc_test.Proto3Enum{desc_test.Proto3Enum_VALUE1, desc_test.Proto3Enum_VALUE2, desc_test.Proto3Enum_VALUE1}}
+	expected, err := proto.Marshal(req)
+	ok(t, err)
+
+	md, err := desc.LoadMessageDescriptorForMessage(req)
+	ok(t, err)
+	dm := NewMessage(md)
+	dm.SetFieldByName("foo", []int32{1, 2, 1})
+	b, err := dm.Marshal()
+	ok(t, err)
+	// repeated scalars in proto3 are packed by default
+	eq(t, true, bytes.Equal(expected, b))
+
+	// un-packed encoding is accepted, too
+	var buf codedBuffer
+	for _, v := range []uint64{1, 2, 1} {
+		buf.encodeTagAndWireType(1, proto.WireVarint)
+		buf.encodeVarint(v)
+	}
+	dm2 := NewMessage(md)
+	ok(t, dm2.Unmarshal(buf.buf))
+	eq(t, 3, dm2.FieldLengthByName("foo"))
+	eq(t, int32(2), dm2.GetRepeatedFieldByName("foo", 1))
+	b, err = dm2.Marshal()
+	ok(t, err)
+	eq(t, true, bytes.Equal(expected, b))
+}
+
+func TestBinaryUnknownFields(t *testing.T) {
+	md, err := desc.LoadMessageDescriptor("desc_test.TestMessage")
+	ok(t, err)
+
+	var buf codedBuffer
+	buf.encodeTagAndWireType(4, proto.WireVarint)
+	buf.encodeVarint(2)
+	buf.encodeTagAndWireType(20, proto.WireFixed32)
+	buf.encodeFixed32(1234)
+	buf.encodeTagAndWireType(21, proto.WireStartGroup)
+	buf.encodeTagAndWireType(1, proto.WireBytes)
+	buf.encodeRawBytes([]byte("nested"))
+	buf.encodeTagAndWireType(21, proto.WireEndGroup)
+	buf.encodeTagAndWireType(22, proto.WireBytes)
+	buf.encodeRawBytes([]byte{0, 1, 2, 3})
+	buf.encodeTagAndWireType(22, proto.WireVarint)
+	buf.encodeVarint(1 << 40)
+	input := buf.buf
+
+	dm := NewMessage(md)
+	ok(t, dm.Unmarshal(input))
+	eq(t, 1, dm.FieldLengthByName("ne"))
+	eq(t, 3, len(dm.GetUnknownFields()))
+	eq(t, uint64(1234), dm.GetUnknownField(20)[0].Value)
+	eq(t, 2, len(dm.GetUnknownField(22)))
+	eq(t, "[0 1 2 3]", fmtBytes(dm.GetUnknownField(22)[0].Contents))
+
+	// unknown fields are re-serialized as they were read
+	b, err := dm.Marshal()
+	ok(t, err)
+	eq(t, true, bytes.Equal(input, b))
+
+	// and survive a trip through a generated message
+	var tm desc_test.TestMessage
+	ok(t, dm.ConvertTo(&tm))
+	b, err = proto.Marshal(&tm)
+	ok(t, err)
+	eq(t, true, bytes.Equal(input, b))
+}
+
+func TestBinaryUnknownFieldsVerbatim(t *testing.T) {
+	md, err := desc.LoadMessageDescriptor("desc_test.TestMessage")
+	ok(t, err)
+
+	// interleaved tags 1000, 999, 1000, and 998, the last with a non-minimal
+	// varint encoding of 1
+	input, err := hex.DecodeString("c03e05b83e06c03e07b03e818000")
+	ok(t, err)
+	dm := NewMessage(md)
+	ok(t, dm.Unmarshal(input))
+	eq(t, 3, len(dm.GetUnknownFields()))
+	eq(t, 2, len(dm.GetUnknownField(1000)))
+	eq(t, uint64(7), dm.GetUnknownField(1000)[1].Value)
+	eq(t, uint64(1), dm.GetUnknownField(998)[0].Value)
+	b, err := dm.Marshal()
+	ok(t, err)
+	eq(t, hex.EncodeToString(input), hex.EncodeToString(b))
+
+	// known fields are written first, but unknown fields keep their order
+	var buf codedBuffer
+	buf.encodeTagAndWireType(4, proto.WireVarint)
+	buf.encodeVarint(2)
+	known := buf.buf
+	mixed := append(append(append([]byte{}, input[:6]...), known...), input[6:]...)
+	ok(t, dm.Unmarshal(mixed))
+	eq(t, 1, dm.FieldLengthByName("ne"))
+	b, err = dm.Marshal()
+	ok(t, err)
+	eq(t, hex.EncodeToString(append(append([]byte{}, known...), input...)), hex.EncodeToString(b))
+}
+
+func TestBinaryClosedEnumUnknownValues(t *testing.T) {
+	md, err := desc.LoadMessageDescriptor("desc_test.AnotherTestMessage")
+	ok(t, err)
+
+	// 99 is not a value of the (closed, proto2) enum of field dne
+	var buf codedBuffer
+	buf.encodeTagAndWireType(1, proto.WireVarint)
+	buf.encodeVarint(99)
+	input := buf.buf
+	dm := NewMessage(md)
+	ok(t, dm.Unmarshal(input))
+	eq(t, false, dm.HasFieldName("dne"))
+	eq(t, uint64(99), dm.GetUnknownField(1)[0].Value)
+	js, err := dm.MarshalJSON()
+	ok(t, err)
+	eq(t, "{}", string(js))
+	eq(t, "1:99", strings.TrimSpace(dm.String()))
+	b, err := dm.Marshal()
+	ok(t, err)
+	eq(t, hex.EncodeToString(input), hex.EncodeToString(b))
+
+	// unknown numbers in packed values are retained as unpacked values
+	md, err = desc.LoadMessageDescriptor("desc_test.TestMessage")
+	ok(t, err)
+	buf = codedBuffer{}
+	buf.encodeTagAndWireType(4, proto.WireBytes)
+	buf.encodeRawBytes([]byte{1, 99, 2})
+	dm = NewMessage(md)
+	ok(t, dm.Unmarshal(buf.buf))
+	eq(t, 1, len(dm.GetUnknownField(4)))
+	eq(t, 2, dm.FieldLengthByName("ne"))
+	eq(t, int32(2), dm.GetRepeatedFieldByName("ne", 1))
+	eq(t, 1, len(dm.GetUnknownField(4)))
+	eq(t, uint64(99), dm.GetUnknownField(4)[0].Value)
+}
+
+func TestBinaryTruncates32BitVarints(t *testing.T) {
+	md, err := desc.LoadMessageDescriptor("desc_test.AnotherTestMessage")
+	ok(t, err)
+
+	// 64-bit values, as written for the same fields with 64-bit types
+	var buf codedBuffer
+	buf.encodeTagAndWireType(102, proto.WireVarint) // int32 extension xi
+	xi := -(int64(1) << 33) - 3
+	buf.encodeVarint(uint64(xi))
+	var entry codedBuffer
+	entry.encodeTagAndWireType(1, proto.WireVarint) // uint32 key
+	entry.encodeVarint(1<<32 + 7)
+	entry.encodeTagAndWireType(2, proto.WireVarint)
+	entry.encodeVarint(1)
+	buf.encodeTagAndWireType(4, proto.WireBytes) // map_field3
+	buf.encodeRawBytes(entry.buf)
+
+	er := NewExtensionRegistryWithDefaults()
+	dm := NewMessageWithExtensionRegistry(md, er)
+	ok(t, dm.Unmarshal(buf.buf))
+	eq(t, int32(-3), dm.GetFieldByName("desc_test.xi"))
+	eq(t, true, dm.GetMapFieldByName("map_field3", uint32(7)))
+
+	// generated code truncates the same way
+	var atm desc_test.AnotherTestMessage
+	ok(t, proto.Unmarshal(buf.buf, &atm))
+	ext, err := proto.GetExtension(&atm, desc_test.E_Xi)
+	ok(t, err)
+	eq(t, int32(-3), *ext.(*int32))
+	eq(t, true, atm.MapField3[7])
+}
+
+func TestBinaryUnknownExtensions(t *testing.T) {
+	atm := &desc_test.AnotherTestMessage{}
+	ok(t, proto.SetExtension(atm, desc_test.E_Xui, proto.Uint64(1234)))
+	b, err := proto.Marshal(atm)
+	ok(t, err)
+
+	fd, err := desc.LoadFileDescriptor("desc_test1.proto")
+	ok(t, err)
+	md := fd.FindMessage("desc_test.AnotherTestMessage")
+
+	// without an extension registry, extensions are unknown fields
+	dm := NewMessage(md)
+	ok(t, dm.Unmarshal(b))
+	eq(t, 0, len(dm.GetKnownExtensions()))
+	eq(t, 1, len(dm.GetUnknownField(103)))
+
+	// until accessed using the extension's descriptor
+	xui := fd.FindExtensionByName("desc_test.xui")
+	eq(t, uint64(1234), dm.GetField(xui))
+	eq(t, 0, len(dm.GetUnknownFields()))
+	eq(t, 1, len(dm.GetKnownExtensions()))
+
+	// with a registry, they are recognized during de-serialization
+	er := &ExtensionRegistry{}
+	er.AddExtensionsFromFile(fd)
+	dm = NewMessageWithExtensionRegistry(md, er)
+	ok(t, dm.Unmarshal(b))
+	eq(t, 0, len(dm.GetUnknownFields()))
+	eq(t, uint64(1234), dm.GetFieldByNumber(103))
+}
+
+func TestBinaryProtoMarshaler(t *testing.T) {
+	md, err := desc.LoadMessageDescriptor("desc_test.TestRequest")
+	ok(t, err)
+	dm := NewMessage(md)
+	dm.SetFieldByName("bar", "bedazzle")
+	tm := NewMessage(md.FindFieldByName("baz").GetMessageType())
+	tm.AddRepeatedFieldByName("ne", int32(1))
+	dm.SetFieldByName("baz", tm)
+
+	// the proto package uses the dynamic message's Marshal and Unmarshal methods
+	b, err := proto.Marshal(dm)
+	ok(t, err)
+	var req desc_test.TestRequest
+	ok(t, proto.Unmarshal(b, &req))
+	eq(t, "bedazzle", req.Bar)
+	eq(t, 1, len(req.Baz.Ne))
+
+	dm2 := NewMessage(md)
+	ok(t, proto.Unmarshal(b, dm2))
+	eq(t, "bedazzle", dm2.GetFieldByName("bar"))
+	eq(t, 1, dm2.GetFieldByName("baz").(*Message).FieldLengthByName("ne"))
+
+	// type mismatches are rejected
+	eq(t, true, dm.ConvertTo(&desc_test.TestMessage{}) != nil)
+	eq(t, true, dm.MergeFrom(&desc_test.TestMessage{}) != nil)
+}
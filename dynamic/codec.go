@@ -0,0 +1,231 @@
+package dynamic
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// errOverflow is returned when an integer is too large to be represented.
+var errOverflow = errors.New("proto: integer overflow")
+
+// codedBuffer is a reader and a writer for the protobuf binary wire format.
+// Encoding methods append to the buffer; decoding methods consume bytes from
+// the buffer, starting at index.
+type codedBuffer struct {
+	buf   []byte
+	index int
+}
+
+func newCodedBuffer(buf []byte) *codedBuffer {
+	return &codedBuffer{buf: buf}
+}
+
+func (cb *codedBuffer) eof() bool {
+	return cb.index >= len(cb.buf)
+}
+
+func (cb *codedBuffer) decodeVarint() (uint64, error) {
+	i := cb.index
+	l := len(cb.buf)
+	var x uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		if i >= l {
+			return 0, io.ErrUnexpectedEOF
+		}
+		b := cb.buf[i]
+		i++
+		x |= (uint64(b) & 0x7F) << shift
+		if b < 0x80 {
+			cb.index = i
+			return x, nil
+		}
+	}
+	// the varint is too long
+	return 0, errOverflow
+}
+
+func (cb *codedBuffer) decodeTagAndWireType() (tag int32, wireType int8, err error) {
+	var v uint64
+	v, err = cb.decodeVarint()
+	if err != nil {
+		return
+	}
+	// low 7 bits is wire type
+	wireType = int8(v & 7)
+	// rest is int32 tag number
+	v = v >> 3
+	if v > math.MaxInt32 {
+		err = fmt.Errorf("tag number out of range: %d", v)
+		return
+	}
+	tag = int32(v)
+	return
+}
+
+func (cb *codedBuffer) decodeFixed64() (x uint64, err error) {
+	// x, err already 0
+	i := cb.index + 8
+	if i < 0 || i > len(cb.buf) {
+		err = io.ErrUnexpectedEOF
+		return
+	}
+	cb.index = i
+
+	x = uint64(cb.buf[i-8])
+	x |= uint64(cb.buf[i-7]) << 8
+	x |= uint64(cb.buf[i-6]) << 16
+	x |= uint64(cb.buf[i-5]) << 24
+	x |= uint64(cb.buf[i-4]) << 32
+	x |= uint64(cb.buf[i-3]) << 40
+	x |= uint64(cb.buf[i-2]) << 48
+	x |= uint64(cb.buf[i-1]) << 56
+	return
+}
+
+func (cb *codedBuffer) decodeFixed32() (x uint64, err error) {
+	// x, err already 0
+	i := cb.index + 4
+	if i < 0 || i > len(cb.buf) {
+		err = io.ErrUnexpectedEOF
+		return
+	}
+	cb.index = i
+
+	x = uint64(cb.buf[i-4])
+	x |= uint64(cb.buf[i-3]) << 8
+	x |= uint64(cb.buf[i-2]) << 16
+	x |= uint64(cb.buf[i-1]) << 24
+	return
+}
+
+func (cb *codedBuffer) decodeRawBytes(alloc bool) (buf []byte, err error) {
+	n, err := cb.decodeVarint()
+	if err != nil {
+		return nil, err
+	}
+
+	nb := int(n)
+	if nb < 0 {
+		return nil, fmt.Errorf("proto: bad byte length %d", nb)
+	}
+	end := cb.index + nb
+	if end < cb.index || end > len(cb.buf) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	if !alloc {
+		buf = cb.buf[cb.index:end]
+		cb.index += nb
+		return
+	}
+
+	buf = make([]byte, nb)
+	copy(buf, cb.buf[cb.index:])
+	cb.index += nb
+	return
+}
+
+// skipGroup consumes bytes up to and including the end-group tag that matches
+// the given tag number. It returns the contents of the group (excluding the
+// end-group tag).
+func (cb *codedBuffer) skipGroup(tag int32) ([]byte, error) {
+	start := cb.index
+	for {
+		if cb.eof() {
+			return nil, io.ErrUnexpectedEOF
+		}
+		end := cb.index
+		t, wt, err := cb.decodeTagAndWireType()
+		if err != nil {
+			return nil, err
+		}
+		if wt == proto.WireEndGroup {
+			if t != tag {
+				return nil, fmt.Errorf("mismatched end group: expecting %d, got %d", tag, t)
+			}
+			return cb.buf[start:end], nil
+		}
+		if err := cb.skipValue(t, wt); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// skipValue consumes the value for a field with the given tag number and wire
+// type.
+func (cb *codedBuffer) skipValue(tag int32, wireType int8) error {
+	var err error
+	switch wireType {
+	case proto.WireVarint:
+		_, err = cb.decodeVarint()
+	case proto.WireFixed32:
+		_, err = cb.decodeFixed32()
+	case proto.WireFixed64:
+		_, err = cb.decodeFixed64()
+	case proto.WireBytes:
+		_, err = cb.decodeRawBytes(false)
+	case proto.WireStartGroup:
+		_, err = cb.skipGroup(tag)
+	default:
+		err = fmt.Errorf("proto: bad wire type %d for field %d", wireType, tag)
+	}
+	return err
+}
+
+func (cb *codedBuffer) encodeVarint(x uint64) {
+	for x >= 1<<7 {
+		cb.buf = append(cb.buf, uint8(x&0x7f|0x80))
+		x >>= 7
+	}
+	cb.buf = append(cb.buf, uint8(x))
+}
+
+func (cb *codedBuffer) encodeTagAndWireType(tag int32, wireType int8) {
+	v := uint64((int64(tag) << 3) | int64(wireType))
+	cb.encodeVarint(v)
+}
+
+func (cb *codedBuffer) encodeFixed64(x uint64) {
+	cb.buf = append(cb.buf,
+		uint8(x),
+		uint8(x>>8),
+		uint8(x>>16),
+		uint8(x>>24),
+		uint8(x>>32),
+		uint8(x>>40),
+		uint8(x>>48),
+		uint8(x>>56))
+}
+
+func (cb *codedBuffer) encodeFixed32(x uint64) {
+	cb.buf = append(cb.buf,
+		uint8(x),
+		uint8(x>>8),
+		uint8(x>>16),
+		uint8(x>>24))
+}
+
+func (cb *codedBuffer) encodeRawBytes(b []byte) {
+	cb.encodeVarint(uint64(len(b)))
+	cb.buf = append(cb.buf, b...)
+}
+
+func encodeZigZag32(v int64) uint64 {
+	return uint64((uint32(v) << 1) ^ uint32((v >> 31)))
+}
+
+func encodeZigZag64(v int64) uint64 {
+	return (uint64(v) << 1) ^ uint64(v>>63)
+}
+
+func decodeZigZag32(v uint64) int64 {
+	return int64(int32(uint32(v)>>1) ^ -int32(v&1))
+}
+
+func decodeZigZag64(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
@@ -0,0 +1,35 @@
+package dynamic
+
+import (
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/jhump/protoreflect/desc"
+)
+
+// getDefaultValue returns the value that should be reported for the given field
+// when a message has no value for it.
+func getDefaultValue(fd *desc.FieldDescriptor) interface{} {
+	if fd.IsMap() {
+		return map[interface{}]interface{}{}
+	} else if fd.IsRepeated() {
+		return []interface{}{}
+	}
+	switch v := fd.GetDefaultValue().(type) {
+	case *desc.EnumValueDescriptor:
+		return v.GetNumber()
+	case []byte:
+		// copy, so the caller cannot modify the descriptor's default value
+		if v == nil {
+			return v
+		}
+		return append([]byte{}, v...)
+	case nil:
+		if fd.GetType() == dpb.FieldDescriptorProto_TYPE_ENUM {
+			// an enum with no values
+			return int32(0)
+		}
+		return nil
+	default:
+		return v
+	}
+}
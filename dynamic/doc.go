@@ -0,0 +1,60 @@
+// Package dynamic provides an implementation for a dynamic protobuf message.
+//
+// The dynamic message is essentially a message descriptor along with a map of
+// tag numbers to values. It has a broad API for interacting with the message,
+// including inspection and modification. Generally, most operations have two
+// forms: a regular method that panics on bad input or error and a "Try" form
+// of the method that will instead return an error.
+//
+// Dynamic messages implement the proto.Message interface, so they can be used
+// anywhere a generated message type could be used. But they are backed by a
+// *desc.MessageDescriptor instead of generated Go code, so they can represent
+// message types that a program was not compiled with, such as types learned
+// at runtime from a server via the grpcreflect package.
+//
+// Field values are represented using the following Go types:
+//
+//	int32, sint32, sfixed32, enums: int32
+//	int64, sint64, sfixed64:        int64
+//	uint32, fixed32:                uint32
+//	uint64, fixed64:                uint64
+//	float:                          float32
+//	double:                         float64
+//	bool:                           bool
+//	string:                         string
+//	bytes:                          []byte
+//	messages and groups:            proto.Message
+//
+// Repeated fields are represented as []interface{}, where each element is of
+// the type described above. Map fields are represented as
+// map[interface{}]interface{}, where keys and values are of the types described
+// above for the map entry's key and value fields. When setting field values,
+// other forms are accepted and converted where possible: for example, any
+// slice type can be used for a repeated field and any Go integer type whose
+// value is in range can be used for an integral field.
+//
+// Dynamic messages can be serialized to and de-serialized from the standard
+// protobuf binary format. When de-serializing, fields whose tag numbers are not
+// recognized are retained as unknown fields. Their raw bytes are kept in the
+// order they were read and are re-serialized verbatim, after the known fields.
+// Extensions are only recognized if they are present in the message's
+// ExtensionRegistry; otherwise they too are retained as unknown fields, which
+// are parsed later if the extension is accessed using its field descriptor.
+// Values for fields of closed enum types (see desc.EnumDescriptor.IsOpen) whose
+// numbers the enum does not define are also retained as unknown fields, as in
+// generated code, so the fields appear unset.
+//
+// Dynamic messages can also be serialized to and de-serialized from JSON,
+// following the canonical proto3 JSON mapping (including the special forms for
+// well-known types). The output is the same as that produced by the jsonpb
+// package for generated message types, and *Message implements the interfaces
+// that jsonpb uses for custom marshalling.
+//
+// Finally, dynamic messages support the standard protobuf text format, both
+// compact and "pretty-printed", including extensions (referenced by name in
+// brackets) and the expanded form of google.protobuf.Any messages.
+//
+// This package can also interpret the options of any descriptor, including
+// custom options, as a dynamic message. See InterpretOptions and
+// GetCustomOptions.
+package dynamic
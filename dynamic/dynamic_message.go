@@ -0,0 +1,1715 @@
+package dynamic
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/jhump/protoreflect/desc"
+)
+
+// UnknownTagNumberError is a sentinel error that is returned when an operation
+// refers to a tag number that is not known by the message's descriptor.
+var UnknownTagNumberError = errors.New("unknown tag number")
+
+// UnknownFieldNameError is a sentinel error that is returned when an operation
+// refers to a field name that is not known by the message's descriptor.
+var UnknownFieldNameError = errors.New("unknown field name")
+
+// FieldIsNotMapError is a sentinel error that is returned when a map operation
+// is attempted on a field that is not a map field.
+var FieldIsNotMapError = errors.New("field is not a map type")
+
+// FieldIsNotRepeatedError is a sentinel error that is returned when a repeated
+// field operation is attempted on a field that is not repeated.
+var FieldIsNotRepeatedError = errors.New("field is not repeated")
+
+// IndexOutOfRangeError is a sentinel error that is returned when a repeated
+// field operation refers to an index that is out of range.
+var IndexOutOfRangeError = errors.New("index is out of range")
+
+// Message is a dynamic protobuf message. Instead of a generated struct,
+// like most protobuf messages, this is a map of field number to values and
+// a message descriptor, which is used to validate the field values and
+// also to de-serialize messages (from the standard binary format, as well
+// as from the text format and from JSON).
+type Message struct {
+	md            *desc.MessageDescriptor
+	er            *ExtensionRegistry
+	extraFields   map[int32]*desc.FieldDescriptor
+	values        map[int32]interface{}
+	unknownFields []unknownField // in the order they were read
+}
+
+// UnknownField represents a field that was parsed from the binary wire
+// format for a message, but was not a recognized field number. The message
+// retains the field's raw bytes, exactly as they were read, and re-serializing
+// the message writes them back unchanged. An UnknownField is a decoded view of
+// those bytes.
+type UnknownField struct {
+	// Encoding indicates how the unknown field was encoded on the wire. If it
+	// is proto.WireBytes or proto.WireStartGroup then Contents will be set to
+	// the raw bytes. If it is proto.WireFixed32, proto.WireFixed64, or
+	// proto.WireVarint then the Value field will be set to the decoded value.
+	Encoding int8
+	// Contents holds the value of the field if the encoding is
+	// proto.WireBytes or proto.WireStartGroup. For groups, this is the raw
+	// bytes of the group's fields, excluding the end group tag.
+	Contents []byte
+	// Value holds the value of the field if the encoding is proto.WireVarint,
+	// proto.WireFixed32, or proto.WireFixed64. The value is the raw wire value,
+	// not interpreted in any way (e.g. no zig-zag decoding or conversion to
+	// floating point).
+	Value uint64
+}
+
+// unknownField is an unrecognized field as it was read from the wire. The raw
+// bytes include the field's tag and its value (and, for groups, the end group
+// tag).
+type unknownField struct {
+	tag int32
+	raw []byte
+}
+
+// decode returns the decoded view of the field's raw bytes.
+func (u unknownField) decode() UnknownField {
+	// the bytes were validated when they were read, so errors are not possible
+	buf := newCodedBuffer(u.raw)
+	_, wireType, _ := buf.decodeTagAndWireType()
+	ret := UnknownField{Encoding: wireType}
+	switch wireType {
+	case proto.WireFixed32:
+		ret.Value, _ = buf.decodeFixed32()
+	case proto.WireFixed64:
+		ret.Value, _ = buf.decodeFixed64()
+	case proto.WireVarint:
+		ret.Value, _ = buf.decodeVarint()
+	case proto.WireBytes:
+		ret.Contents, _ = buf.decodeRawBytes(false)
+	case proto.WireStartGroup:
+		ret.Contents, _ = buf.skipGroup(u.tag)
+	}
+	return ret
+}
+
+// NewMessage creates a new dynamic message for the type represented by the given
+// message descriptor.
+func NewMessage(md *desc.MessageDescriptor) *Message {
+	return NewMessageWithExtensionRegistry(md, nil)
+}
+
+// NewMessageWithExtensionRegistry creates a new dynamic message for the type
+// represented by the given message descriptor. During de-serialization, the given
+// ExtensionRegistry is used to parse extension fields and nested messages will all
+// be created using the same registry.
+func NewMessageWithExtensionRegistry(md *desc.MessageDescriptor, er *ExtensionRegistry) *Message {
+	return &Message{
+		md:          md,
+		er:          er,
+		extraFields: map[int32]*desc.FieldDescriptor{},
+		values:      map[int32]interface{}{},
+	}
+}
+
+// newMessage creates a new dynamic message of the given type that uses the same
+// extension registry as m. This is used when de-serializing nested messages.
+func (m *Message) newMessage(md *desc.MessageDescriptor) *Message {
+	return NewMessageWithExtensionRegistry(md, m.er)
+}
+
+// GetMessageDescriptor returns a descriptor for this message's type.
+func (m *Message) GetMessageDescriptor() *desc.MessageDescriptor {
+	return m.md
+}
+
+// GetKnownFields returns a slice of descriptors for all known fields. The
+// fields will not be in any defined order.
+func (m *Message) GetKnownFields() []*desc.FieldDescriptor {
+	if len(m.extraFields) == 0 {
+		return m.md.GetFields()
+	}
+	flds := make([]*desc.FieldDescriptor, len(m.md.GetFields()), len(m.md.GetFields())+len(m.extraFields))
+	copy(flds, m.md.GetFields())
+	for _, fld := range m.extraFields {
+		flds = append(flds, fld)
+	}
+	return flds
+}
+
+// GetKnownExtensions returns a slice of descriptors for all extensions known by
+// the message's extension registry as well as extensions that have been set on
+// the message. The fields will not be in any defined order.
+func (m *Message) GetKnownExtensions() []*desc.FieldDescriptor {
+	if !m.md.IsExtendable() {
+		return nil
+	}
+	exts := m.er.AllExtensionsForType(m.md.GetFullyQualifiedName())
+	for _, fld := range m.extraFields {
+		if !fld.IsExtension() {
+			continue
+		}
+		// extensions set on the message supersede those in the registry
+		dup := false
+		for i, ext := range exts {
+			if ext.GetNumber() == fld.GetNumber() {
+				exts[i] = fld
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			exts = append(exts, fld)
+		}
+	}
+	return exts
+}
+
+// GetUnknownFields returns a slice of tag numbers for all unknown fields that
+// this message contains. The tags will not be in any defined order.
+func (m *Message) GetUnknownFields() []int32 {
+	var flds []int32
+	seen := map[int32]bool{}
+	for _, u := range m.unknownFields {
+		if !seen[u.tag] {
+			seen[u.tag] = true
+			flds = append(flds, u.tag)
+		}
+	}
+	return flds
+}
+
+// GetUnknownField gets the value(s) for the given unknown tag number. If this
+// message has no unknown fields with the given tag, nil is returned.
+func (m *Message) GetUnknownField(tagNumber int32) []UnknownField {
+	var ret []UnknownField
+	for _, u := range m.unknownFields {
+		if u.tag == tagNumber {
+			ret = append(ret, u.decode())
+		}
+	}
+	return ret
+}
+
+func (m *Message) hasUnknownField(tagNumber int32) bool {
+	for _, u := range m.unknownFields {
+		if u.tag == tagNumber {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Message) clearUnknownField(tagNumber int32) {
+	kept := m.unknownFields[:0]
+	for _, u := range m.unknownFields {
+		if u.tag != tagNumber {
+			kept = append(kept, u)
+		}
+	}
+	if len(kept) == 0 {
+		kept = nil
+	}
+	m.unknownFields = kept
+}
+
+// Reset clears all fields in the message.
+func (m *Message) Reset() {
+	m.extraFields = map[int32]*desc.FieldDescriptor{}
+	m.values = map[int32]interface{}{}
+	m.unknownFields = nil
+}
+
+// String returns a human-readable representation of the message. It uses the
+// compact text format (see MarshalText). If an error occurs while formatting the
+// message, the text up to the point of the error is returned.
+func (m *Message) String() string {
+	w := textWriter{compact: true, complete: true}
+	w.writeMessage(m)
+	return string(w.buf)
+}
+
+// ProtoMessage is present to satisfy the proto.Message interface.
+func (m *Message) ProtoMessage() {
+}
+
+// FindFieldDescriptor returns a field descriptor for the given tag number. This
+// searches known fields in the descriptor, known fields discovered during calls
+// to GetField or SetField, and extension fields known by the message's extension
+// registry. If no field matches the given tag number, nil is returned.
+func (m *Message) FindFieldDescriptor(tagNumber int32) *desc.FieldDescriptor {
+	fd := m.extraFields[tagNumber]
+	if fd != nil {
+		return fd
+	}
+	fd = m.md.FindFieldByNumber(tagNumber)
+	if fd != nil {
+		return fd
+	}
+	if m.md.IsExtension(tagNumber) {
+		fd = m.er.FindExtension(m.md.GetFullyQualifiedName(), tagNumber)
+		if fd != nil {
+			m.extraFields[tagNumber] = fd
+			return fd
+		}
+	}
+	return nil
+}
+
+// FindFieldDescriptorByName returns a field descriptor for the given field
+// name. This searches known fields in the descriptor, known fields discovered
+// during calls to GetField or SetField, and extension fields known by the
+// message's extension registry. If no field matches the given name, nil is
+// returned. Extension fields are matched using their fully-qualified name.
+func (m *Message) FindFieldDescriptorByName(name string) *desc.FieldDescriptor {
+	if name == "" {
+		return nil
+	}
+	fd := m.md.FindFieldByName(name)
+	if fd != nil {
+		return fd
+	}
+	for _, fd := range m.extraFields {
+		if fd.IsExtension() && fd.GetFullyQualifiedName() == name {
+			return fd
+		}
+	}
+	if m.md.IsExtendable() {
+		fd = m.er.FindExtensionByName(m.md.GetFullyQualifiedName(), name)
+		if fd != nil {
+			m.extraFields[fd.GetNumber()] = fd
+			return fd
+		}
+	}
+	return nil
+}
+
+func (m *Message) checkField(fd *desc.FieldDescriptor) error {
+	return checkField(fd, m.md)
+}
+
+func checkField(fd *desc.FieldDescriptor, md *desc.MessageDescriptor) error {
+	if fd.GetOwner().GetFullyQualifiedName() != md.GetFullyQualifiedName() {
+		return fmt.Errorf("given field, %s, is for wrong message type: %s; expecting %s", fd.GetName(), fd.GetOwner().GetFullyQualifiedName(), md.GetFullyQualifiedName())
+	}
+	if fd.IsExtension() && !md.IsExtension(fd.GetNumber()) {
+		return fmt.Errorf("given field, %s, is an extension but is not in message extension range: %v", fd.GetFullyQualifiedName(), md.GetExtensionRanges())
+	}
+	return nil
+}
+
+// GetField returns the value for the given field descriptor. It panics if an
+// error is encountered. See TryGetField.
+func (m *Message) GetField(fd *desc.FieldDescriptor) interface{} {
+	if v, err := m.TryGetField(fd); err != nil {
+		panic(err.Error())
+	} else {
+		return v
+	}
+}
+
+// TryGetField returns the value for the given field descriptor. An error is
+// returned if the given field descriptor does not belong to the right message
+// type.
+//
+// The Go type of the returned value, for scalar fields, is the same as protoc
+// would generate for the field (in a non-dynamic message). The table below
+// lists the scalar types and the corresponding Go types.
+//
+//	+-------------------------+-----------+
+//	|       Declared Type     |  Go Type  |
+//	+-------------------------+-----------+
+//	| int32, sint32, sfixed32 | int32     |
+//	| int64, sint64, sfixed64 | int64     |
+//	| uint32, fixed32         | uint32    |
+//	| uint64, fixed64         | uint64    |
+//	| float                   | float32   |
+//	| double                  | float64   |
+//	| bool                    | bool      |
+//	| string                  | string    |
+//	| bytes                   | []byte    |
+//	+-------------------------+-----------+
+//
+// Values for enum fields will always be int32 values. Values for message fields
+// may be an instance of a generated type or an instance of *dynamic.Message;
+// an unset message field has a nil value.
+//
+// If the given field is a map field, the returned type will be
+// map[interface{}]interface{}. The actual concrete types of keys and values is
+// as described above. If the given field is a (non-map) repeated field, the
+// returned type is always []interface{}; the type of the actual elements is as
+// described above. The returned slice or map must not be modified: callers
+// should use the repeated and map field accessors on the message instead.
+//
+// If the given field descriptor is not known (e.g. not present in the message
+// descriptor) but corresponds to an unknown field, the unknown value will be
+// parsed and become known. The parsed value will be returned, or an error will
+// be returned if the unknown value cannot be parsed according to the field
+// descriptor's type information.
+//
+// If this message has no value for the given field, its default value is
+// returned. If the message is defined in a file with "proto3" syntax, the
+// default is always the zero value for the field. The default value for map
+// and repeated fields is an empty map or slice (respectively). For fields whose
+// type is a message, the default value is nil.
+func (m *Message) TryGetField(fd *desc.FieldDescriptor) (interface{}, error) {
+	if err := m.checkField(fd); err != nil {
+		return nil, err
+	}
+	return m.getField(fd)
+}
+
+// GetFieldByName returns the value for the field with the given name. It panics
+// if an error is encountered. See TryGetFieldByName.
+func (m *Message) GetFieldByName(name string) interface{} {
+	if v, err := m.TryGetFieldByName(name); err != nil {
+		panic(err.Error())
+	} else {
+		return v
+	}
+}
+
+// TryGetFieldByName returns the value for the field with the given name. An
+// error is returned if the given name is unknown. If the given name refers to
+// an extension field, it should be fully qualified.
+//
+// (See TryGetField for more info on types supported.)
+func (m *Message) TryGetFieldByName(name string) (interface{}, error) {
+	if fd := m.FindFieldDescriptorByName(name); fd == nil {
+		return nil, UnknownFieldNameError
+	} else {
+		return m.getField(fd)
+	}
+}
+
+// GetFieldByNumber returns the value for the field with the given tag number.
+// It panics if an error is encountered. See TryGetFieldByNumber.
+func (m *Message) GetFieldByNumber(tagNumber int) interface{} {
+	if v, err := m.TryGetFieldByNumber(tagNumber); err != nil {
+		panic(err.Error())
+	} else {
+		return v
+	}
+}
+
+// TryGetFieldByNumber returns the value for the field with the given tag
+// number. An error is returned if the given tag is unknown.
+//
+// (See TryGetField for more info on types supported.)
+func (m *Message) TryGetFieldByNumber(tagNumber int) (interface{}, error) {
+	if fd := m.FindFieldDescriptor(int32(tagNumber)); fd == nil {
+		return nil, UnknownTagNumberError
+	} else {
+		return m.getField(fd)
+	}
+}
+
+func (m *Message) getField(fd *desc.FieldDescriptor) (interface{}, error) {
+	if err := m.parseUnknownField(fd); err != nil {
+		return nil, err
+	}
+	if v, ok := m.values[fd.GetNumber()]; ok {
+		return v, nil
+	}
+	return getDefaultValue(fd), nil
+}
+
+// HasField returns true if this message has a value for the given field. If the
+// given field is not valid (e.g. belongs to a different message type), false is
+// returned. If this message is defined in a file with "proto3" syntax, this
+// will return false even if a field was explicitly assigned its zero value (the
+// zero values for a field are intentionally indistinguishable from absent).
+func (m *Message) HasField(fd *desc.FieldDescriptor) bool {
+	if err := m.checkField(fd); err != nil {
+		return false
+	}
+	return m.hasField(fd)
+}
+
+// HasFieldName returns true if this message has a value for a field with the
+// given name. If the given name is unknown, this returns false.
+func (m *Message) HasFieldName(name string) bool {
+	if fd := m.FindFieldDescriptorByName(name); fd == nil {
+		return false
+	} else {
+		return m.hasField(fd)
+	}
+}
+
+// HasFieldNumber returns true if this message has a value for a field with the
+// given tag number. If the given tag is unknown, this returns false.
+func (m *Message) HasFieldNumber(tagNumber int) bool {
+	if fd := m.FindFieldDescriptor(int32(tagNumber)); fd != nil {
+		return m.hasField(fd)
+	}
+	return m.hasUnknownField(int32(tagNumber))
+}
+
+func (m *Message) hasField(fd *desc.FieldDescriptor) bool {
+	// unknown values with the field's tag only count if they can be parsed as
+	// values of the field; numbers that a closed enum does not define cannot
+	if err := m.parseUnknownField(fd); err != nil {
+		return m.hasUnknownField(fd.GetNumber())
+	}
+	_, ok := m.values[fd.GetNumber()]
+	return ok
+}
+
+// SetField sets the value for the given field descriptor to the given value. It
+// panics if an error is encountered. See TrySetField.
+func (m *Message) SetField(fd *desc.FieldDescriptor, val interface{}) {
+	if err := m.TrySetField(fd, val); err != nil {
+		panic(err.Error())
+	}
+}
+
+// TrySetField sets the value for the given field descriptor to the given value.
+// An error is returned if the given field descriptor does not belong to the
+// right message type or if the given value is not a correct/compatible type for
+// the given field.
+//
+// The Go type expected for a field is the same as TryGetField would return for
+// the field. So message values can be supplied as either the correct generated
+// message type or as a *dynamic.Message.
+//
+// Since it is cumbersome to work with dynamic messages, some concessions are
+// made to simplify usage regarding types:
+//
+//  1. If a numeric type is provided that can be converted *without loss or
+//     overflow*, it is accepted. This allows for setting int64 fields using int
+//     or int32 values. Similarly for uint64 with uint and uint32 values and for
+//     float64 fields with float32 values.
+//  2. The value can be a named type, as long as its underlying type is correct.
+//  3. Map and repeated fields can be set using any kind of concrete map or
+//     slice type, as long as the values within are all of the correct type. So
+//     a field defined as a 'map<string, int32>` can be set using a
+//     map[string]int32, a map[string]interface{}, or even a
+//     map[interface{}]interface{}.
+//  4. Finally, dynamic code that chooses to not treat maps as a special-case
+//     will find that they can set map fields using a slice where each element
+//     is a message that matches the implicit map-entry field message type.
+//
+// If the given field descriptor is an extension that is not yet known by the
+// message, it will become known. Subsequent operations using tag numbers or
+// names will be able to resolve the newly-known extension. If the message has a
+// value for an unknown field with the same tag number, it will be discarded.
+func (m *Message) TrySetField(fd *desc.FieldDescriptor, val interface{}) error {
+	if err := m.checkField(fd); err != nil {
+		return err
+	}
+	return m.setField(fd, val)
+}
+
+// SetFieldByName sets the value for the field with the given name to the given
+// value. It panics if an error is encountered. See TrySetFieldByName.
+func (m *Message) SetFieldByName(name string, val interface{}) {
+	if err := m.TrySetFieldByName(name, val); err != nil {
+		panic(err.Error())
+	}
+}
+
+// TrySetFieldByName sets the value for the field with the given name to the
+// given value. An error is returned if the given name is unknown or if the
+// given value has an incorrect type. If the given name refers to an extension
+// field, it should be fully qualified.
+//
+// (See TrySetField for more info on types supported.)
+func (m *Message) TrySetFieldByName(name string, val interface{}) error {
+	if fd := m.FindFieldDescriptorByName(name); fd == nil {
+		return UnknownFieldNameError
+	} else {
+		return m.setField(fd, val)
+	}
+}
+
+// SetFieldByNumber sets the value for the field with the given tag number to
+// the given value. It panics if an error is encountered. See
+// TrySetFieldByNumber.
+func (m *Message) SetFieldByNumber(tagNumber int, val interface{}) {
+	if err := m.TrySetFieldByNumber(tagNumber, val); err != nil {
+		panic(err.Error())
+	}
+}
+
+// TrySetFieldByNumber sets the value for the field with the given tag number to
+// the given value. An error is returned if the given tag is unknown or if the
+// given value has an incorrect type.
+//
+// (See TrySetField for more info on types supported.)
+func (m *Message) TrySetFieldByNumber(tagNumber int, val interface{}) error {
+	if fd := m.FindFieldDescriptor(int32(tagNumber)); fd == nil {
+		return UnknownTagNumberError
+	} else {
+		return m.setField(fd, val)
+	}
+}
+
+func (m *Message) setField(fd *desc.FieldDescriptor, val interface{}) error {
+	var err error
+	if val, err = validFieldValue(fd, val); err != nil {
+		return err
+	}
+	m.internalSetField(fd, val)
+	return nil
+}
+
+func (m *Message) internalSetField(fd *desc.FieldDescriptor, val interface{}) {
+	// a known value supersedes any unknown value with the same tag
+	m.clearUnknownField(fd.GetNumber())
+	m.storeFieldValue(fd, val)
+}
+
+// storeFieldValue stores the given value for the given field. Unlike
+// internalSetField, it leaves any unknown values with the field's tag in place,
+// which is what de-serializing does when merging values into the message.
+func (m *Message) storeFieldValue(fd *desc.FieldDescriptor, val interface{}) {
+	if fd.IsRepeated() {
+		// Unset fields and zero-length fields are indistinguishable, in both
+		// proto2 and proto3 syntax
+		if reflect.ValueOf(val).Len() == 0 {
+			m.internalClearField(fd)
+			return
+		}
+	} else if isProto3(m.md) && fd.GetOneOf() == nil && fd.GetMessageType() == nil {
+		// proto3 considers fields that are set to their zero value as unset
+		if isZeroValue(val) {
+			m.internalClearField(fd)
+			return
+		}
+	}
+	if fd.IsExtension() {
+		m.extraFields[fd.GetNumber()] = fd
+	}
+	if od := fd.GetOneOf(); od != nil {
+		// clear any other fields in the same one-of
+		for _, other := range od.GetChoices() {
+			if other.GetNumber() != fd.GetNumber() {
+				delete(m.values, other.GetNumber())
+			}
+		}
+	}
+	m.values[fd.GetNumber()] = val
+}
+
+// ClearField removes any value for the given field. It panics if an error is
+// encountered. See TryClearField.
+func (m *Message) ClearField(fd *desc.FieldDescriptor) {
+	if err := m.TryClearField(fd); err != nil {
+		panic(err.Error())
+	}
+}
+
+// TryClearField removes any value for the given field. An error is returned if
+// the given field descriptor does not belong to the right message type.
+func (m *Message) TryClearField(fd *desc.FieldDescriptor) error {
+	if err := m.checkField(fd); err != nil {
+		return err
+	}
+	m.internalClearField(fd)
+	return nil
+}
+
+// ClearFieldByName removes any value for the field with the given name. It
+// panics if an error is encountered. See TryClearFieldByName.
+func (m *Message) ClearFieldByName(name string) {
+	if err := m.TryClearFieldByName(name); err != nil {
+		panic(err.Error())
+	}
+}
+
+// TryClearFieldByName removes any value for the field with the given name. An
+// error is returned if the given name is unknown. If the given name refers to
+// an extension field, it should be fully qualified.
+func (m *Message) TryClearFieldByName(name string) error {
+	if fd := m.FindFieldDescriptorByName(name); fd == nil {
+		return UnknownFieldNameError
+	} else {
+		m.internalClearField(fd)
+		return nil
+	}
+}
+
+// ClearFieldByNumber removes any value for the field with the given tag number.
+// It panics if an error is encountered. See TryClearFieldByNumber.
+func (m *Message) ClearFieldByNumber(tagNumber int) {
+	if err := m.TryClearFieldByNumber(tagNumber); err != nil {
+		panic(err.Error())
+	}
+}
+
+// TryClearFieldByNumber removes any value for the field with the given tag
+// number. An error is returned if the given tag is unknown.
+func (m *Message) TryClearFieldByNumber(tagNumber int) error {
+	if fd := m.FindFieldDescriptor(int32(tagNumber)); fd == nil {
+		return UnknownTagNumberError
+	} else {
+		m.internalClearField(fd)
+		return nil
+	}
+}
+
+func (m *Message) internalClearField(fd *desc.FieldDescriptor) {
+	delete(m.values, fd.GetNumber())
+	m.clearUnknownField(fd.GetNumber())
+}
+
+// GetOneOfField returns which of the given one-of's fields is set and the
+// corresponding value. It panics if an error is encountered. See
+// TryGetOneOfField.
+func (m *Message) GetOneOfField(od *desc.OneOfDescriptor) (*desc.FieldDescriptor, interface{}) {
+	if fd, val, err := m.TryGetOneOfField(od); err != nil {
+		panic(err.Error())
+	} else {
+		return fd, val
+	}
+}
+
+// TryGetOneOfField returns which of the given one-of's fields is set and the
+// corresponding value. An error is returned if the given one-of belongs to the
+// wrong message type. If the given one-of has no field set, this method will
+// return nil, nil.
+//
+// The type of the value, if one is set, is the same as would be returned by
+// TryGetField using the returned field descriptor.
+func (m *Message) TryGetOneOfField(od *desc.OneOfDescriptor) (*desc.FieldDescriptor, interface{}, error) {
+	if od.GetOwner().GetFullyQualifiedName() != m.md.GetFullyQualifiedName() {
+		return nil, nil, fmt.Errorf("given one-of, %s, is for wrong message type: %s; expecting %s", od.GetName(), od.GetOwner().GetFullyQualifiedName(), m.md.GetFullyQualifiedName())
+	}
+	for _, fd := range od.GetChoices() {
+		if v, ok := m.values[fd.GetNumber()]; ok {
+			return fd, v, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+// ClearOneOfField removes any value for any of the given one-of's fields. It
+// panics if an error is encountered. See TryClearOneOfField.
+func (m *Message) ClearOneOfField(od *desc.OneOfDescriptor) {
+	if err := m.TryClearOneOfField(od); err != nil {
+		panic(err.Error())
+	}
+}
+
+// TryClearOneOfField removes any value for any of the given one-of's fields. An
+// error is returned if the given one-of descriptor does not belong to the right
+// message type.
+func (m *Message) TryClearOneOfField(od *desc.OneOfDescriptor) error {
+	if fd, _, err := m.TryGetOneOfField(od); err != nil {
+		return err
+	} else if fd != nil {
+		m.internalClearField(fd)
+	}
+	return nil
+}
+
+// FieldLength returns the number of elements in this message for the given
+// field descriptor. It panics if an error is encountered. See TryFieldLength.
+func (m *Message) FieldLength(fd *desc.FieldDescriptor) int {
+	l, err := m.TryFieldLength(fd)
+	if err != nil {
+		panic(err.Error())
+	}
+	return l
+}
+
+// TryFieldLength returns the number of elements in this message for the given
+// field descriptor. An error is returned if the given field descriptor does not
+// belong to the right message type or if it is neither a map field nor a
+// repeated field.
+func (m *Message) TryFieldLength(fd *desc.FieldDescriptor) (int, error) {
+	if err := m.checkField(fd); err != nil {
+		return 0, err
+	}
+	return m.fieldLength(fd)
+}
+
+// FieldLengthByName returns the number of elements in this message for the
+// field with the given name. It panics if an error is encountered. See
+// TryFieldLengthByName.
+func (m *Message) FieldLengthByName(name string) int {
+	l, err := m.TryFieldLengthByName(name)
+	if err != nil {
+		panic(err.Error())
+	}
+	return l
+}
+
+// TryFieldLengthByName returns the number of elements in this message for the
+// field with the given name. An error is returned if the given name is unknown
+// or if the named field is neither a map field nor a repeated field.
+func (m *Message) TryFieldLengthByName(name string) (int, error) {
+	if fd := m.FindFieldDescriptorByName(name); fd == nil {
+		return 0, UnknownFieldNameError
+	} else {
+		return m.fieldLength(fd)
+	}
+}
+
+// FieldLengthByNumber returns the number of elements in this message for the
+// field with the given tag number. It panics if an error is encountered. See
+// TryFieldLengthByNumber.
+func (m *Message) FieldLengthByNumber(tagNumber int32) int {
+	l, err := m.TryFieldLengthByNumber(tagNumber)
+	if err != nil {
+		panic(err.Error())
+	}
+	return l
+}
+
+// TryFieldLengthByNumber returns the number of elements in this message for the
+// field with the given tag number. An error is returned if the given tag is
+// unknown or if the named field is neither a map field nor a repeated field.
+func (m *Message) TryFieldLengthByNumber(tagNumber int32) (int, error) {
+	if fd := m.FindFieldDescriptor(tagNumber); fd == nil {
+		return 0, UnknownTagNumberError
+	} else {
+		return m.fieldLength(fd)
+	}
+}
+
+func (m *Message) fieldLength(fd *desc.FieldDescriptor) (int, error) {
+	if !fd.IsRepeated() {
+		return 0, FieldIsNotRepeatedError
+	}
+	val := m.values[fd.GetNumber()]
+	if val == nil {
+		return 0, nil
+	}
+	return reflect.ValueOf(val).Len(), nil
+}
+
+// GetRepeatedField returns the value for the given repeated field descriptor at
+// the given index. It panics if an error is encountered. See
+// TryGetRepeatedField.
+func (m *Message) GetRepeatedField(fd *desc.FieldDescriptor, index int) interface{} {
+	if v, err := m.TryGetRepeatedField(fd, index); err != nil {
+		panic(err.Error())
+	} else {
+		return v
+	}
+}
+
+// TryGetRepeatedField returns the value for the given repeated field descriptor
+// at the given index. An error is returned if the given field descriptor does
+// not belong to the right message type, if it is not a repeated field, or if
+// the given index is out of range (less than zero or greater than or equal to
+// the length of the repeated field). Also, even though map fields technically
+// are repeated fields, if the given field is a map field an error will result:
+// map representation does not lend itself to random access by index.
+//
+// The Go type of the value returned mirrors the type that protoc would generate
+// for the field's element type. (See TryGetField for more details on types).
+func (m *Message) TryGetRepeatedField(fd *desc.FieldDescriptor, index int) (interface{}, error) {
+	if err := m.checkField(fd); err != nil {
+		return nil, err
+	}
+	return m.getRepeatedField(fd, index)
+}
+
+// GetRepeatedFieldByName returns the value for the repeated field with the
+// given name at the given index. It panics if an error is encountered. See
+// TryGetRepeatedFieldByName.
+func (m *Message) GetRepeatedFieldByName(name string, index int) interface{} {
+	if v, err := m.TryGetRepeatedFieldByName(name, index); err != nil {
+		panic(err.Error())
+	} else {
+		return v
+	}
+}
+
+// TryGetRepeatedFieldByName returns the value for the repeated field with the
+// given name at the given index. An error is returned if the given name is
+// unknown, if it names a field that is not a repeated field (or is a map
+// field), or if the given index is out of range (less than zero or greater
+// than or equal to the length of the repeated field).
+//
+// (See TryGetField for more info on types supported.)
+func (m *Message) TryGetRepeatedFieldByName(name string, index int) (interface{}, error) {
+	if fd := m.FindFieldDescriptorByName(name); fd == nil {
+		return nil, UnknownFieldNameError
+	} else {
+		return m.getRepeatedField(fd, index)
+	}
+}
+
+// GetRepeatedFieldByNumber returns the value for the repeated field with the
+// given tag number at the given index. It panics if an error is encountered.
+// See TryGetRepeatedFieldByNumber.
+func (m *Message) GetRepeatedFieldByNumber(tagNumber int, index int) interface{} {
+	if v, err := m.TryGetRepeatedFieldByNumber(tagNumber, index); err != nil {
+		panic(err.Error())
+	} else {
+		return v
+	}
+}
+
+// TryGetRepeatedFieldByNumber returns the value for the repeated field with the
+// given tag number at the given index. An error is returned if the given tag is
+// unknown, if it indicates a field that is not a repeated field (or is a map
+// field), or if the given index is out of range (less than zero or greater than
+// or equal to the length of the repeated field).
+//
+// (See TryGetField for more info on types supported.)
+func (m *Message) TryGetRepeatedFieldByNumber(tagNumber int, index int) (interface{}, error) {
+	if fd := m.FindFieldDescriptor(int32(tagNumber)); fd == nil {
+		return nil, UnknownTagNumberError
+	} else {
+		return m.getRepeatedField(fd, index)
+	}
+}
+
+func (m *Message) getRepeatedField(fd *desc.FieldDescriptor, index int) (interface{}, error) {
+	if fd.IsMap() || !fd.IsRepeated() {
+		return nil, FieldIsNotRepeatedError
+	}
+	sl, _ := m.values[fd.GetNumber()].([]interface{})
+	if index < 0 || index >= len(sl) {
+		return nil, IndexOutOfRangeError
+	}
+	return sl[index], nil
+}
+
+// AddRepeatedField appends the given value to the given repeated field. It
+// panics if an error is encountered. See TryAddRepeatedField.
+func (m *Message) AddRepeatedField(fd *desc.FieldDescriptor, val interface{}) {
+	if err := m.TryAddRepeatedField(fd, val); err != nil {
+		panic(err.Error())
+	}
+}
+
+// TryAddRepeatedField appends the given value to the given repeated field. An
+// error is returned if the given field descriptor does not belong to the right
+// message type, if the given field is not repeated, or if the given value is
+// not a correct/compatible type for the given field. If the given field is a
+// map field, the call will succeed if the given value is an instance of the
+// map's entry message type.
+//
+// The Go type expected for a field is the same as required by TrySetField for
+// a non-repeated field of the same type.
+func (m *Message) TryAddRepeatedField(fd *desc.FieldDescriptor, val interface{}) error {
+	if err := m.checkField(fd); err != nil {
+		return err
+	}
+	return m.addRepeatedField(fd, val)
+}
+
+// AddRepeatedFieldByName appends the given value to the repeated field with the
+// given name. It panics if an error is encountered. See
+// TryAddRepeatedFieldByName.
+func (m *Message) AddRepeatedFieldByName(name string, val interface{}) {
+	if err := m.TryAddRepeatedFieldByName(name, val); err != nil {
+		panic(err.Error())
+	}
+}
+
+// TryAddRepeatedFieldByName appends the given value to the repeated field with
+// the given name. An error is returned if the given name is unknown, if it
+// names a field that is not repeated, or if the given value has an incorrect
+// type.
+//
+// (See TrySetField for more info on types supported.)
+func (m *Message) TryAddRepeatedFieldByName(name string, val interface{}) error {
+	if fd := m.FindFieldDescriptorByName(name); fd == nil {
+		return UnknownFieldNameError
+	} else {
+		return m.addRepeatedField(fd, val)
+	}
+}
+
+// AddRepeatedFieldByNumber appends the given value to the repeated field with
+// the given tag number. It panics if an error is encountered. See
+// TryAddRepeatedFieldByNumber.
+func (m *Message) AddRepeatedFieldByNumber(tagNumber int, val interface{}) {
+	if err := m.TryAddRepeatedFieldByNumber(tagNumber, val); err != nil {
+		panic(err.Error())
+	}
+}
+
+// TryAddRepeatedFieldByNumber appends the given value to the repeated field
+// with the given tag number. An error is returned if the given tag is unknown,
+// if it indicates a field that is not repeated, or if the given value has an
+// incorrect type.
+//
+// (See TrySetField for more info on types supported.)
+func (m *Message) TryAddRepeatedFieldByNumber(tagNumber int, val interface{}) error {
+	if fd := m.FindFieldDescriptor(int32(tagNumber)); fd == nil {
+		return UnknownTagNumberError
+	} else {
+		return m.addRepeatedField(fd, val)
+	}
+}
+
+func (m *Message) addRepeatedField(fd *desc.FieldDescriptor, val interface{}) error {
+	if !fd.IsRepeated() {
+		return FieldIsNotRepeatedError
+	}
+	val, err := validElementFieldValue(fd, val)
+	if err != nil {
+		return err
+	}
+
+	if fd.IsMap() {
+		// We're lenient. Just as we allow setting a map field to a slice of entry messages, we also allow
+		// adding entries one at a time (as if the field were a normal repeated field).
+		msg := val.(proto.Message)
+		dm, err := asDynamicMessage(msg, fd.GetMessageType(), m.er)
+		if err != nil {
+			return err
+		}
+		k, err := dm.TryGetFieldByNumber(1)
+		if err != nil {
+			return err
+		}
+		v, err := dm.TryGetFieldByNumber(2)
+		if err != nil {
+			return err
+		}
+		return m.putMapField(fd, k, v)
+	}
+
+	sl, _ := m.values[fd.GetNumber()].([]interface{})
+	m.internalSetField(fd, append(sl, val))
+	return nil
+}
+
+// SetRepeatedField sets the value for the given repeated field descriptor and
+// given index to the given value. It panics if an error is encountered. See
+// TrySetRepeatedField.
+func (m *Message) SetRepeatedField(fd *desc.FieldDescriptor, index int, val interface{}) {
+	if err := m.TrySetRepeatedField(fd, index, val); err != nil {
+		panic(err.Error())
+	}
+}
+
+// TrySetRepeatedField sets the value for the given repeated field descriptor
+// and given index to the given value. An error is returned if the given field
+// descriptor does not belong to the right message type, if it is not a repeated
+// field, or if the given index is out of range (less than zero or greater than
+// or equal to the length of the repeated field). Also, even though map fields
+// technically are repeated fields, if the given field is a map field an error
+// will result: map representation does not lend itself to random access by
+// index.
+//
+// The Go type expected for a field is the same as required by TrySetField for
+// a non-repeated field of the same type.
+func (m *Message) TrySetRepeatedField(fd *desc.FieldDescriptor, index int, val interface{}) error {
+	if err := m.checkField(fd); err != nil {
+		return err
+	}
+	return m.setRepeatedField(fd, index, val)
+}
+
+// SetRepeatedFieldByName sets the value for the repeated field with the given
+// name and given index to the given value. It panics if an error is
+// encountered. See TrySetRepeatedFieldByName.
+func (m *Message) SetRepeatedFieldByName(name string, index int, val interface{}) {
+	if err := m.TrySetRepeatedFieldByName(name, index, val); err != nil {
+		panic(err.Error())
+	}
+}
+
+// TrySetRepeatedFieldByName sets the value for the repeated field with the
+// given name and the given index to the given value. An error is returned if
+// the given name is unknown, if it names a field that is not a repeated field
+// (or is a map field), or if the given index is out of range (less than zero or
+// greater than or equal to the length of the repeated field).
+//
+// (See TrySetField for more info on types supported.)
+func (m *Message) TrySetRepeatedFieldByName(name string, index int, val interface{}) error {
+	if fd := m.FindFieldDescriptorByName(name); fd == nil {
+		return UnknownFieldNameError
+	} else {
+		return m.setRepeatedField(fd, index, val)
+	}
+}
+
+// SetRepeatedFieldByNumber sets the value for the repeated field with the given
+// tag number and given index to the given value. It panics if an error is
+// encountered. See TrySetRepeatedFieldByNumber.
+func (m *Message) SetRepeatedFieldByNumber(tagNumber int, index int, val interface{}) {
+	if err := m.TrySetRepeatedFieldByNumber(tagNumber, index, val); err != nil {
+		panic(err.Error())
+	}
+}
+
+// TrySetRepeatedFieldByNumber set the value for the repeated field with the
+// given tag number and the given index to the given value. An error is returned
+// if the given tag is unknown, if it indicates a field that is not a repeated
+// field (or is a map field), or if the given index is out of range (less than
+// zero or greater than or equal to the length of the repeated field).
+//
+// (See TrySetField for more info on types supported.)
+func (m *Message) TrySetRepeatedFieldByNumber(tagNumber int, index int, val interface{}) error {
+	if fd := m.FindFieldDescriptor(int32(tagNumber)); fd == nil {
+		return UnknownTagNumberError
+	} else {
+		return m.setRepeatedField(fd, index, val)
+	}
+}
+
+func (m *Message) setRepeatedField(fd *desc.FieldDescriptor, index int, val interface{}) error {
+	if fd.IsMap() || !fd.IsRepeated() {
+		return FieldIsNotRepeatedError
+	}
+	val, err := validElementFieldValue(fd, val)
+	if err != nil {
+		return err
+	}
+	sl, _ := m.values[fd.GetNumber()].([]interface{})
+	if index < 0 || index >= len(sl) {
+		return IndexOutOfRangeError
+	}
+	sl[index] = val
+	return nil
+}
+
+// GetMapField returns the value for the given map field descriptor and given
+// key. It panics if an error is encountered. See TryGetMapField.
+func (m *Message) GetMapField(fd *desc.FieldDescriptor, key interface{}) interface{} {
+	if v, err := m.TryGetMapField(fd, key); err != nil {
+		panic(err.Error())
+	} else {
+		return v
+	}
+}
+
+// TryGetMapField returns the value for the given map field descriptor and given
+// key. An error is returned if the given field descriptor does not belong to
+// the right message type or if it is not a map field.
+//
+// If the map field does not contain the requested key, this method returns
+// nil, nil. The Go type of the value returned mirrors the type that protoc
+// would generate for the field. (See TryGetField for more details on types).
+func (m *Message) TryGetMapField(fd *desc.FieldDescriptor, key interface{}) (interface{}, error) {
+	if err := m.checkField(fd); err != nil {
+		return nil, err
+	}
+	return m.getMapField(fd, key)
+}
+
+// GetMapFieldByName returns the value for the map field with the given name and
+// given key. It panics if an error is encountered. See TryGetMapFieldByName.
+func (m *Message) GetMapFieldByName(name string, key interface{}) interface{} {
+	if v, err := m.TryGetMapFieldByName(name, key); err != nil {
+		panic(err.Error())
+	} else {
+		return v
+	}
+}
+
+// TryGetMapFieldByName returns the value for the map field with the given name
+// and given key. An error is returned if the given name is unknown or if it
+// names a field that is not a map field.
+//
+// If this message has no value for the given field or the value has no value
+// for the requested key, then this method returns nil, nil.
+//
+// (See TryGetField for more info on types supported.)
+func (m *Message) TryGetMapFieldByName(name string, key interface{}) (interface{}, error) {
+	if fd := m.FindFieldDescriptorByName(name); fd == nil {
+		return nil, UnknownFieldNameError
+	} else {
+		return m.getMapField(fd, key)
+	}
+}
+
+// GetMapFieldByNumber returns the value for the map field with the given tag
+// number and given key. It panics if an error is encountered. See
+// TryGetMapFieldByNumber.
+func (m *Message) GetMapFieldByNumber(tagNumber int, key interface{}) interface{} {
+	if v, err := m.TryGetMapFieldByNumber(tagNumber, key); err != nil {
+		panic(err.Error())
+	} else {
+		return v
+	}
+}
+
+// TryGetMapFieldByNumber returns the value for the map field with the given tag
+// number and given key. An error is returned if the given tag is unknown or if
+// it indicates a field that is not a map field.
+//
+// If this message has no value for the given field or the value has no value
+// for the requested key, then this method returns nil, nil.
+//
+// (See TryGetField for more info on types supported.)
+func (m *Message) TryGetMapFieldByNumber(tagNumber int, key interface{}) (interface{}, error) {
+	if fd := m.FindFieldDescriptor(int32(tagNumber)); fd == nil {
+		return nil, UnknownTagNumberError
+	} else {
+		return m.getMapField(fd, key)
+	}
+}
+
+func (m *Message) getMapField(fd *desc.FieldDescriptor, key interface{}) (interface{}, error) {
+	if !fd.IsMap() {
+		return nil, FieldIsNotMapError
+	}
+	kfd := fd.GetMessageType().GetFields()[0]
+	ki, err := validElementFieldValue(kfd, key)
+	if err != nil {
+		return nil, err
+	}
+	mp, _ := m.values[fd.GetNumber()].(map[interface{}]interface{})
+	if mp == nil {
+		return nil, nil
+	}
+	return mp[ki], nil
+}
+
+// ForEachMapFieldEntry executes the given function for each entry in the map
+// value for the given field descriptor. It stops iteration if the function
+// returns false. It panics if an error is encountered. See
+// TryForEachMapFieldEntry.
+func (m *Message) ForEachMapFieldEntry(fd *desc.FieldDescriptor, fn func(key, val interface{}) bool) {
+	if err := m.TryForEachMapFieldEntry(fd, fn); err != nil {
+		panic(err.Error())
+	}
+}
+
+// TryForEachMapFieldEntry executes the given function for each entry in the map
+// value for the given field descriptor. An error is returned if the given field
+// descriptor does not belong to the right message type or if it is not a map
+// field.
+//
+// Iteration ends either when all entries have been examined or when the given
+// function returns false. So the function is expected to return true for normal
+// iteration and false to break out. Entries are visited in no defined order.
+func (m *Message) TryForEachMapFieldEntry(fd *desc.FieldDescriptor, fn func(key, val interface{}) bool) error {
+	if err := m.checkField(fd); err != nil {
+		return err
+	}
+	return m.forEachMapFieldEntry(fd, fn)
+}
+
+// ForEachMapFieldEntryByName executes the given function for each entry in the
+// map value for the field with the given name. It stops iteration if the
+// function returns false. It panics if an error is encountered. See
+// TryForEachMapFieldEntryByName.
+func (m *Message) ForEachMapFieldEntryByName(name string, fn func(key, val interface{}) bool) {
+	if err := m.TryForEachMapFieldEntryByName(name, fn); err != nil {
+		panic(err.Error())
+	}
+}
+
+// TryForEachMapFieldEntryByName executes the given function for each entry in
+// the map value for the field with the given name. It stops iteration if the
+// function returns false. An error is returned if the given name is unknown or
+// if it names a field that is not a map field.
+func (m *Message) TryForEachMapFieldEntryByName(name string, fn func(key, val interface{}) bool) error {
+	if fd := m.FindFieldDescriptorByName(name); fd == nil {
+		return UnknownFieldNameError
+	} else {
+		return m.forEachMapFieldEntry(fd, fn)
+	}
+}
+
+// ForEachMapFieldEntryByNumber executes the given function for each entry in
+// the map value for the field with the given tag number. It stops iteration if
+// the function returns false. It panics if an error is encountered. See
+// TryForEachMapFieldEntryByNumber.
+func (m *Message) ForEachMapFieldEntryByNumber(tagNumber int, fn func(key, val interface{}) bool) {
+	if err := m.TryForEachMapFieldEntryByNumber(tagNumber, fn); err != nil {
+		panic(err.Error())
+	}
+}
+
+// TryForEachMapFieldEntryByNumber executes the given function for each entry in
+// the map value for the field with the given tag number. It stops iteration if
+// the function returns false. An error is returned if the given tag is unknown
+// or if it indicates a field that is not a map field.
+func (m *Message) TryForEachMapFieldEntryByNumber(tagNumber int, fn func(key, val interface{}) bool) error {
+	if fd := m.FindFieldDescriptor(int32(tagNumber)); fd == nil {
+		return UnknownTagNumberError
+	} else {
+		return m.forEachMapFieldEntry(fd, fn)
+	}
+}
+
+func (m *Message) forEachMapFieldEntry(fd *desc.FieldDescriptor, fn func(key, val interface{}) bool) error {
+	if !fd.IsMap() {
+		return FieldIsNotMapError
+	}
+	mp, _ := m.values[fd.GetNumber()].(map[interface{}]interface{})
+	for k, v := range mp {
+		if !fn(k, v) {
+			break
+		}
+	}
+	return nil
+}
+
+// PutMapField sets the value for the given map field descriptor and given key
+// to the given value. It panics if an error is encountered. See TryPutMapField.
+func (m *Message) PutMapField(fd *desc.FieldDescriptor, key interface{}, val interface{}) {
+	if err := m.TryPutMapField(fd, key, val); err != nil {
+		panic(err.Error())
+	}
+}
+
+// TryPutMapField sets the value for the given map field descriptor and given
+// key to the given value. An error is returned if the given field descriptor
+// does not belong to the right message type, if the given field is not a map
+// field, or if the given value is not a correct/compatible type for the given
+// field.
+//
+// The Go type expected for a field is the same as required by TrySetField for
+// a field with the same type as the map's value type.
+func (m *Message) TryPutMapField(fd *desc.FieldDescriptor, key interface{}, val interface{}) error {
+	if err := m.checkField(fd); err != nil {
+		return err
+	}
+	return m.putMapField(fd, key, val)
+}
+
+// PutMapFieldByName sets the value for the map field with the given name and
+// given key to the given value. It panics if an error is encountered. See
+// TryPutMapFieldByName.
+func (m *Message) PutMapFieldByName(name string, key interface{}, val interface{}) {
+	if err := m.TryPutMapFieldByName(name, key, val); err != nil {
+		panic(err.Error())
+	}
+}
+
+// TryPutMapFieldByName sets the value for the map field with the given name and
+// the given key to the given value. An error is returned if the given name is
+// unknown, if it names a field that is not a map, or if the given value has an
+// incorrect type.
+//
+// (See TrySetField for more info on types supported.)
+func (m *Message) TryPutMapFieldByName(name string, key interface{}, val interface{}) error {
+	if fd := m.FindFieldDescriptorByName(name); fd == nil {
+		return UnknownFieldNameError
+	} else {
+		return m.putMapField(fd, key, val)
+	}
+}
+
+// PutMapFieldByNumber sets the value for the map field with the given tag
+// number and given key to the given value. It panics if an error is
+// encountered. See TryPutMapFieldByNumber.
+func (m *Message) PutMapFieldByNumber(tagNumber int, key interface{}, val interface{}) {
+	if err := m.TryPutMapFieldByNumber(tagNumber, key, val); err != nil {
+		panic(err.Error())
+	}
+}
+
+// TryPutMapFieldByNumber sets the value for the map field with the given tag
+// number and the given key to the given value. An error is returned if the
+// given tag is unknown, if it indicates a field that is not a map, or if the
+// given value has an incorrect type.
+//
+// (See TrySetField for more info on types supported.)
+func (m *Message) TryPutMapFieldByNumber(tagNumber int, key interface{}, val interface{}) error {
+	if fd := m.FindFieldDescriptor(int32(tagNumber)); fd == nil {
+		return UnknownTagNumberError
+	} else {
+		return m.putMapField(fd, key, val)
+	}
+}
+
+func (m *Message) putMapField(fd *desc.FieldDescriptor, key interface{}, val interface{}) error {
+	if !fd.IsMap() {
+		return FieldIsNotMapError
+	}
+	kfd := fd.GetMessageType().GetFields()[0]
+	ki, err := validElementFieldValue(kfd, key)
+	if err != nil {
+		return err
+	}
+	vfd := fd.GetMessageType().GetFields()[1]
+	vi, err := validElementFieldValue(vfd, val)
+	if err != nil {
+		return err
+	}
+	mp, _ := m.values[fd.GetNumber()].(map[interface{}]interface{})
+	if mp == nil {
+		m.internalSetField(fd, map[interface{}]interface{}{ki: vi})
+		return nil
+	}
+	mp[ki] = vi
+	return nil
+}
+
+// RemoveMapField changes the value for the given field descriptor by removing
+// any value associated with the given key. It panics if an error is
+// encountered. See TryRemoveMapField.
+func (m *Message) RemoveMapField(fd *desc.FieldDescriptor, key interface{}) {
+	if err := m.TryRemoveMapField(fd, key); err != nil {
+		panic(err.Error())
+	}
+}
+
+// TryRemoveMapField changes the value for the given field descriptor by
+// removing any value associated with the given key. An error is returned if the
+// given field descriptor does not belong to the right message type or if the
+// given field is not a map field.
+func (m *Message) TryRemoveMapField(fd *desc.FieldDescriptor, key interface{}) error {
+	if err := m.checkField(fd); err != nil {
+		return err
+	}
+	return m.removeMapField(fd, key)
+}
+
+// RemoveMapFieldByName changes the value for the field with the given name by
+// removing any value associated with the given key. It panics if an error is
+// encountered. See TryRemoveMapFieldByName.
+func (m *Message) RemoveMapFieldByName(name string, key interface{}) {
+	if err := m.TryRemoveMapFieldByName(name, key); err != nil {
+		panic(err.Error())
+	}
+}
+
+// TryRemoveMapFieldByName changes the value for the field with the given name
+// by removing any value associated with the given key. An error is returned if
+// the given name is unknown or if it names a field that is not a map.
+func (m *Message) TryRemoveMapFieldByName(name string, key interface{}) error {
+	if fd := m.FindFieldDescriptorByName(name); fd == nil {
+		return UnknownFieldNameError
+	} else {
+		return m.removeMapField(fd, key)
+	}
+}
+
+// RemoveMapFieldByNumber changes the value for the field with the given tag
+// number by removing any value associated with the given key. It panics if an
+// error is encountered. See TryRemoveMapFieldByNumber.
+func (m *Message) RemoveMapFieldByNumber(tagNumber int, key interface{}) {
+	if err := m.TryRemoveMapFieldByNumber(tagNumber, key); err != nil {
+		panic(err.Error())
+	}
+}
+
+// TryRemoveMapFieldByNumber changes the value for the field with the given tag
+// number by removing any value associated with the given key. An error is
+// returned if the given tag is unknown or if it indicates a field that is not
+// a map.
+func (m *Message) TryRemoveMapFieldByNumber(tagNumber int, key interface{}) error {
+	if fd := m.FindFieldDescriptor(int32(tagNumber)); fd == nil {
+		return UnknownTagNumberError
+	} else {
+		return m.removeMapField(fd, key)
+	}
+}
+
+func (m *Message) removeMapField(fd *desc.FieldDescriptor, key interface{}) error {
+	if !fd.IsMap() {
+		return FieldIsNotMapError
+	}
+	kfd := fd.GetMessageType().GetFields()[0]
+	ki, err := validElementFieldValue(kfd, key)
+	if err != nil {
+		return err
+	}
+	mp, _ := m.values[fd.GetNumber()].(map[interface{}]interface{})
+	if mp == nil {
+		return nil
+	}
+	delete(mp, ki)
+	if len(mp) == 0 {
+		delete(m.values, fd.GetNumber())
+	}
+	return nil
+}
+
+// allKnownFieldTags returns the tag numbers of all fields that have values, in
+// ascending order.
+func (m *Message) allKnownFieldTags() []int32 {
+	tags := make([]int32, 0, len(m.values))
+	for tag := range m.values {
+		tags = append(tags, tag)
+	}
+	sort.Sort(int32Slice(tags))
+	return tags
+}
+
+type int32Slice []int32
+
+func (s int32Slice) Len() int {
+	return len(s)
+}
+
+func (s int32Slice) Less(i, j int) bool {
+	return s[i] < s[j]
+}
+
+func (s int32Slice) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func isProto3(md *desc.MessageDescriptor) bool {
+	return md.GetFile().AsFileDescriptorProto().GetSyntax() == "proto3"
+}
+
+func isZeroValue(v interface{}) bool {
+	switch v := v.(type) {
+	case []byte:
+		return len(v) == 0
+	case nil:
+		return true
+	default:
+		return reflect.ValueOf(v).Interface() == reflect.Zero(reflect.TypeOf(v)).Interface()
+	}
+}
+
+// asDynamicMessage returns the given message as a *Message. If it is not
+// already a dynamic message, it is converted into one.
+func asDynamicMessage(m proto.Message, md *desc.MessageDescriptor, er *ExtensionRegistry) (*Message, error) {
+	if dm, ok := m.(*Message); ok {
+		return dm, nil
+	}
+	dm := NewMessageWithExtensionRegistry(md, er)
+	if err := dm.ConvertFrom(m); err != nil {
+		return nil, err
+	}
+	return dm, nil
+}
+
+// messageName returns the fully-qualified name of the given message's type.
+func messageName(m proto.Message) string {
+	if dm, ok := m.(*Message); ok {
+		return dm.md.GetFullyQualifiedName()
+	}
+	return proto.MessageName(m)
+}
+
+func validFieldValue(fd *desc.FieldDescriptor, val interface{}) (interface{}, error) {
+	if fd.IsMap() {
+		return validMapValue(fd, val)
+	} else if fd.IsRepeated() {
+		return validRepeatedValue(fd, val)
+	}
+	return validElementFieldValue(fd, val)
+}
+
+func validMapValue(fd *desc.FieldDescriptor, val interface{}) (interface{}, error) {
+	mdesc := fd.GetMessageType()
+	kfd := mdesc.GetFields()[0]
+	vfd := mdesc.GetFields()[1]
+	rv := reflect.ValueOf(val)
+	if rv.Kind() == reflect.Slice {
+		// we'll also accept a slice of map entry messages
+		m := map[interface{}]interface{}{}
+		for i := 0; i < rv.Len(); i++ {
+			e, err := validElementFieldValue(fd, rv.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			dm, err := asDynamicMessage(e.(proto.Message), mdesc, nil)
+			if err != nil {
+				return nil, err
+			}
+			k, err := dm.TryGetFieldByNumber(1)
+			if err != nil {
+				return nil, err
+			}
+			v, err := dm.TryGetFieldByNumber(2)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+		}
+		return m, nil
+	}
+	if rv.Kind() != reflect.Map {
+		return nil, fmt.Errorf("map field %s requires a map or slice of entries; got %v", fd.GetFullyQualifiedName(), rv.Type())
+	}
+	m := map[interface{}]interface{}{}
+	for _, key := range rv.MapKeys() {
+		k, err := validElementFieldValue(kfd, key.Interface())
+		if err != nil {
+			return nil, err
+		}
+		v, err := validElementFieldValue(vfd, rv.MapIndex(key).Interface())
+		if err != nil {
+			return nil, err
+		}
+		m[k] = v
+	}
+	return m, nil
+}
+
+func validRepeatedValue(fd *desc.FieldDescriptor, val interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(val)
+	if rv.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("repeated field %s requires a slice; got %v", fd.GetFullyQualifiedName(), rv.Type())
+	}
+	sl := make([]interface{}, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		v, err := validElementFieldValue(fd, rv.Index(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+		sl[i] = v
+	}
+	return sl, nil
+}
+
+func validElementFieldValue(fd *desc.FieldDescriptor, val interface{}) (interface{}, error) {
+	if val == nil {
+		return nil, fmt.Errorf("value for field %s must not be nil", fd.GetFullyQualifiedName())
+	}
+	t := fd.GetType()
+	if t == dpb.FieldDescriptorProto_TYPE_MESSAGE || t == dpb.FieldDescriptorProto_TYPE_GROUP {
+		m, ok := val.(proto.Message)
+		if !ok {
+			return nil, fmt.Errorf("message field %s requires a proto.Message; got %T", fd.GetFullyQualifiedName(), val)
+		}
+		if rv := reflect.ValueOf(m); rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return nil, fmt.Errorf("value for field %s must not be nil", fd.GetFullyQualifiedName())
+		}
+		if name := messageName(m); name != fd.GetMessageType().GetFullyQualifiedName() {
+			return nil, fmt.Errorf("message field %s requires value of type %s; got %s", fd.GetFullyQualifiedName(), fd.GetMessageType().GetFullyQualifiedName(), name)
+		}
+		return m, nil
+	}
+
+	rv := reflect.ValueOf(val)
+	if rv.Kind() == reflect.Ptr {
+		// we accept pointers to scalars, like are used in generated code for proto2 fields
+		if rv.IsNil() {
+			return nil, fmt.Errorf("value for field %s must not be nil", fd.GetFullyQualifiedName())
+		}
+		rv = rv.Elem()
+	}
+	switch t {
+	case dpb.FieldDescriptorProto_TYPE_SFIXED32,
+		dpb.FieldDescriptorProto_TYPE_INT32,
+		dpb.FieldDescriptorProto_TYPE_SINT32,
+		dpb.FieldDescriptorProto_TYPE_ENUM:
+		return toInt32(fd, rv)
+	case dpb.FieldDescriptorProto_TYPE_SFIXED64,
+		dpb.FieldDescriptorProto_TYPE_INT64,
+		dpb.FieldDescriptorProto_TYPE_SINT64:
+		return toInt64(fd, rv)
+	case dpb.FieldDescriptorProto_TYPE_FIXED32,
+		dpb.FieldDescriptorProto_TYPE_UINT32:
+		return toUint32(fd, rv)
+	case dpb.FieldDescriptorProto_TYPE_FIXED64,
+		dpb.FieldDescriptorProto_TYPE_UINT64:
+		return toUint64(fd, rv)
+	case dpb.FieldDescriptorProto_TYPE_FLOAT:
+		return toFloat32(fd, rv)
+	case dpb.FieldDescriptorProto_TYPE_DOUBLE:
+		return toFloat64(fd, rv)
+	case dpb.FieldDescriptorProto_TYPE_BOOL:
+		if rv.Kind() == reflect.Bool {
+			return rv.Bool(), nil
+		}
+	case dpb.FieldDescriptorProto_TYPE_STRING:
+		if rv.Kind() == reflect.String {
+			return rv.String(), nil
+		}
+	case dpb.FieldDescriptorProto_TYPE_BYTES:
+		if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8 {
+			return rv.Bytes(), nil
+		}
+	default:
+		return nil, fmt.Errorf("field %s has unrecognized type: %v", fd.GetFullyQualifiedName(), t)
+	}
+	return nil, typeError(fd, rv)
+}
+
+func typeError(fd *desc.FieldDescriptor, rv reflect.Value) error {
+	return fmt.Errorf("%s field %s is not compatible with value of type %v", fieldTypeName(fd), fd.GetFullyQualifiedName(), rv.Type())
+}
+
+func fieldTypeName(fd *desc.FieldDescriptor) string {
+	// strip the "TYPE_" prefix and use lower-case, to match proto source syntax
+	n := dpb.FieldDescriptorProto_Type_name[int32(fd.GetType())]
+	if len(n) > 5 {
+		n = n[5:]
+	}
+	return strings.ToLower(n)
+}
+
+func toInt32(fd *desc.FieldDescriptor, rv reflect.Value) (interface{}, error) {
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := rv.Int()
+		if i >= math.MinInt32 && i <= math.MaxInt32 {
+			return int32(i), nil
+		}
+		return nil, fmt.Errorf("value %d overflows %s field %s", i, fieldTypeName(fd), fd.GetFullyQualifiedName())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u := rv.Uint()
+		if u <= math.MaxInt32 {
+			return int32(u), nil
+		}
+		return nil, fmt.Errorf("value %d overflows %s field %s", u, fieldTypeName(fd), fd.GetFullyQualifiedName())
+	}
+	return nil, typeError(fd, rv)
+}
+
+func toInt64(fd *desc.FieldDescriptor, rv reflect.Value) (interface{}, error) {
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u := rv.Uint()
+		if u <= math.MaxInt64 {
+			return int64(u), nil
+		}
+		return nil, fmt.Errorf("value %d overflows %s field %s", u, fieldTypeName(fd), fd.GetFullyQualifiedName())
+	}
+	return nil, typeError(fd, rv)
+}
+
+func toUint32(fd *desc.FieldDescriptor, rv reflect.Value) (interface{}, error) {
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := rv.Int()
+		if i >= 0 && i <= math.MaxUint32 {
+			return uint32(i), nil
+		}
+		return nil, fmt.Errorf("value %d overflows %s field %s", i, fieldTypeName(fd), fd.GetFullyQualifiedName())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u := rv.Uint()
+		if u <= math.MaxUint32 {
+			return uint32(u), nil
+		}
+		return nil, fmt.Errorf("value %d overflows %s field %s", u, fieldTypeName(fd), fd.GetFullyQualifiedName())
+	}
+	return nil, typeError(fd, rv)
+}
+
+func toUint64(fd *desc.FieldDescriptor, rv reflect.Value) (interface{}, error) {
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := rv.Int()
+		if i >= 0 {
+			return uint64(i), nil
+		}
+		return nil, fmt.Errorf("value %d overflows %s field %s", i, fieldTypeName(fd), fd.GetFullyQualifiedName())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return rv.Uint(), nil
+	}
+	return nil, typeError(fd, rv)
+}
+
+func toFloat32(fd *desc.FieldDescriptor, rv reflect.Value) (interface{}, error) {
+	switch rv.Kind() {
+	case reflect.Float32:
+		return float32(rv.Float()), nil
+	case reflect.Float64:
+		f := rv.Float()
+		if math.IsInf(f, 0) || math.IsNaN(f) || (f >= -math.MaxFloat32 && f <= math.MaxFloat32) {
+			return float32(f), nil
+		}
+		return nil, fmt.Errorf("value %v overflows %s field %s", f, fieldTypeName(fd), fd.GetFullyQualifiedName())
+	}
+	return nil, typeError(fd, rv)
+}
+
+func toFloat64(fd *desc.FieldDescriptor, rv reflect.Value) (interface{}, error) {
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	}
+	return nil, typeError(fd, rv)
+}
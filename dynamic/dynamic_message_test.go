@@ -0,0 +1,249 @@
+package dynamic
+
+import (
+	"testing"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/desc_test"
+)
+
+func TestGetSetClearScalarFields(t *testing.T) {
+	md, err := desc.LoadMessageDescriptor("TopLevel")
+	ok(t, err)
+	dm := NewMessage(md)
+
+	cases := []struct {
+		name     string
+		val      interface{}
+		expected interface{}
+	}{
+		{"i", int32(-123), int32(-123)},
+		{"j", int64(-12345), int64(-12345)},
+		{"k", int8(-12), int32(-12)},
+		{"l", 42, int64(42)},
+		{"m", uint32(123), uint32(123)},
+		{"n", uint64(12345), uint64(12345)},
+		{"o", uint16(1024), uint32(1024)},
+		{"p", 42, uint64(42)},
+		{"q", int32(-1), int32(-1)},
+		{"r", int64(-1), int64(-1)},
+		{"s", float32(3.14159), float32(3.14159)},
+		{"t", float32(1.5), float64(1.5)},
+		{"v", "foobar", "foobar"},
+		{"w", true, true},
+	}
+	for _, c := range cases {
+		eq(t, false, dm.HasFieldName(c.name), c.name)
+		dm.SetFieldByName(c.name, c.val)
+		eq(t, true, dm.HasFieldName(c.name), c.name)
+		eq(t, c.expected, dm.GetFieldByName(c.name), c.name)
+		fd := md.FindFieldByName(c.name)
+		eq(t, c.expected, dm.GetField(fd), c.name)
+		eq(t, c.expected, dm.GetFieldByNumber(int(fd.GetNumber())), c.name)
+	}
+
+	// bytes are not comparable with ==
+	dm.SetFieldByName("u", []byte{1, 2, 3})
+	eq(t, "[1 2 3]", fmtBytes(dm.GetFieldByName("u").([]byte)))
+
+	for _, c := range cases {
+		dm.ClearFieldByName(c.name)
+		eq(t, false, dm.HasFieldName(c.name), c.name)
+	}
+	dm.ClearFieldByNumber(13)
+	eq(t, false, dm.HasFieldNumber(13))
+}
+
+func TestSetFieldBadValues(t *testing.T) {
+	md, err := desc.LoadMessageDescriptor("TopLevel")
+	ok(t, err)
+	dm := NewMessage(md)
+
+	// wrong types
+	eq(t, true, dm.TrySetFieldByName("i", "abc") != nil)
+	eq(t, true, dm.TrySetFieldByName("v", 123) != nil)
+	eq(t, true, dm.TrySetFieldByName("w", 1) != nil)
+	eq(t, true, dm.TrySetFieldByName("s", 1) != nil)
+	// overflow
+	eq(t, true, dm.TrySetFieldByName("i", int64(1)<<40) != nil)
+	eq(t, true, dm.TrySetFieldByName("m", -1) != nil)
+	// repeated value for a non-repeated field
+	eq(t, true, dm.TrySetFieldByName("i", []int32{1, 2, 3}) != nil)
+	// unknown names and numbers
+	eq(t, UnknownFieldNameError, dm.TrySetFieldByName("foo", 1))
+	eq(t, UnknownTagNumberError, dm.TrySetFieldByNumber(99, 1))
+	_, err = dm.TryGetFieldByName("foo")
+	eq(t, UnknownFieldNameError, err)
+
+	// field from a different message
+	other, err := desc.LoadMessageDescriptor("desc_test.TestMessage")
+	ok(t, err)
+	eq(t, true, dm.TrySetField(other.GetFields()[0], 1) != nil)
+}
+
+func TestDefaultValues(t *testing.T) {
+	md, err := desc.LoadMessageDescriptor("desc_test.Frobnitz")
+	ok(t, err)
+	dm := NewMessage(md)
+	// explicit default
+	eq(t, int32(desc_test.TestMessage_VALUE2), dm.GetFieldByName("e"))
+	// implicit defaults
+	eq(t, int32(0), dm.GetFieldByName("g1"))
+	eq(t, nil, dm.GetFieldByName("a"))
+	eq(t, 0, len(dm.GetFieldByName("f").([]interface{})))
+	eq(t, false, dm.HasFieldName("e"))
+
+	md, err = desc.LoadMessageDescriptor("desc_test.TestMessage")
+	ok(t, err)
+	dm = NewMessage(md)
+	eq(t, 0, len(dm.GetFieldByName("ne").([]interface{})))
+}
+
+func TestProto3ZeroValues(t *testing.T) {
+	md, err := desc.LoadMessageDescriptor("desc_test.TestRequest")
+	ok(t, err)
+	dm := NewMessage(md)
+	dm.SetFieldByName("bar", "abc")
+	eq(t, true, dm.HasFieldName("bar"))
+	// setting to zero value in proto3 is the same as clearing the field
+	dm.SetFieldByName("bar", "")
+	eq(t, false, dm.HasFieldName("bar"))
+	eq(t, "", dm.GetFieldByName("bar"))
+}
+
+func TestMessageFields(t *testing.T) {
+	md, err := desc.LoadMessageDescriptor("desc_test.TestMessage")
+	ok(t, err)
+	dm := NewMessage(md)
+
+	nmd := md.GetNestedMessageTypes()[0]
+	nm := NewMessage(nmd)
+	dm.SetFieldByName("nm", nm)
+	eq(t, nm, dm.GetFieldByName("nm"))
+
+	// generated messages are accepted too
+	gnm := &desc_test.TestMessage_NestedMessage{}
+	dm.SetFieldByName("nm", gnm)
+	eq(t, gnm, dm.GetFieldByName("nm"))
+
+	// but not if they are the wrong type
+	eq(t, true, dm.TrySetFieldByName("nm", &desc_test.TestMessage{}) != nil)
+	eq(t, true, dm.TrySetFieldByName("nm", NewMessage(md)) != nil)
+}
+
+func TestRepeatedFields(t *testing.T) {
+	md, err := desc.LoadMessageDescriptor("desc_test.TestMessage")
+	ok(t, err)
+	dm := NewMessage(md)
+
+	eq(t, 0, dm.FieldLengthByName("ne"))
+	dm.AddRepeatedFieldByName("ne", desc_test.TestMessage_VALUE1)
+	dm.AddRepeatedFieldByName("ne", int32(2))
+	eq(t, 2, dm.FieldLengthByName("ne"))
+	eq(t, int32(1), dm.GetRepeatedFieldByName("ne", 0))
+	eq(t, int32(2), dm.GetRepeatedFieldByName("ne", 1))
+
+	dm.SetRepeatedFieldByName("ne", 0, int32(2))
+	eq(t, int32(2), dm.GetRepeatedFieldByName("ne", 0))
+
+	_, err = dm.TryGetRepeatedFieldByName("ne", 2)
+	eq(t, IndexOutOfRangeError, err)
+	eq(t, IndexOutOfRangeError, dm.TrySetRepeatedFieldByName("ne", -1, int32(1)))
+	eq(t, FieldIsNotRepeatedError, dm.TryAddRepeatedFieldByName("nm", NewMessage(md.GetNestedMessageTypes()[0])))
+
+	// set the whole thing using a typed slice
+	dm.SetFieldByName("ne", []desc_test.TestMessage_NestedEnum{desc_test.TestMessage_VALUE1})
+	eq(t, 1, dm.FieldLengthByName("ne"))
+	eq(t, int32(1), dm.GetRepeatedFieldByNumber(4, 0))
+
+	// an empty slice clears the field
+	dm.SetFieldByName("ne", []int32{})
+	eq(t, false, dm.HasFieldName("ne"))
+}
+
+func TestMapFields(t *testing.T) {
+	md, err := desc.LoadMessageDescriptor("desc_test.AnotherTestMessage")
+	ok(t, err)
+	dm := NewMessage(md)
+
+	dm.PutMapFieldByName("map_field1", int32(1), "one")
+	dm.PutMapFieldByName("map_field1", 2, "two")
+	eq(t, 2, dm.FieldLengthByName("map_field1"))
+	eq(t, "one", dm.GetMapFieldByName("map_field1", 1))
+	eq(t, "two", dm.GetMapFieldByName("map_field1", int32(2)))
+	eq(t, nil, dm.GetMapFieldByName("map_field1", 3))
+
+	count := 0
+	dm.ForEachMapFieldEntryByName("map_field1", func(k, v interface{}) bool {
+		count++
+		return true
+	})
+	eq(t, 2, count)
+
+	dm.RemoveMapFieldByName("map_field1", 1)
+	eq(t, 1, dm.FieldLengthByName("map_field1"))
+	dm.RemoveMapFieldByName("map_field1", 2)
+	eq(t, false, dm.HasFieldName("map_field1"))
+
+	// set whole map using typed map
+	dm.SetFieldByName("map_field2", map[int64]float32{1: 1.5, 2: 2.5})
+	eq(t, float32(2.5), dm.GetMapFieldByNumber(3, int64(2)))
+
+	// bad key and value types
+	eq(t, true, dm.TryPutMapFieldByName("map_field3", "abc", true) != nil)
+	eq(t, true, dm.TryPutMapFieldByName("map_field3", uint32(1), "abc") != nil)
+
+	// map operations on non-map fields
+	eq(t, FieldIsNotMapError, dm.TryPutMapFieldByName("dne", 1, 1))
+	_, err = dm.TryGetMapFieldByName("dne", 1)
+	eq(t, FieldIsNotMapError, err)
+}
+
+func TestOneOfFields(t *testing.T) {
+	md, err := desc.LoadMessageDescriptor("desc_test.Frobnitz")
+	ok(t, err)
+	dm := NewMessage(md)
+	od := md.GetOneOfs()[1]
+
+	fd, v := dm.GetOneOfField(od)
+	eq(t, true, fd == nil)
+	eq(t, nil, v)
+
+	dm.SetFieldByName("g1", 123)
+	fd, v = dm.GetOneOfField(od)
+	eq(t, "g1", fd.GetName())
+	eq(t, int32(123), v)
+
+	// setting another choice clears the first
+	dm.SetFieldByName("g3", 456)
+	eq(t, false, dm.HasFieldName("g1"))
+	fd, v = dm.GetOneOfField(od)
+	eq(t, "g3", fd.GetName())
+	eq(t, uint32(456), v)
+
+	dm.ClearOneOfField(od)
+	eq(t, false, dm.HasFieldName("g3"))
+}
+
+func TestExtensionFields(t *testing.T) {
+	fd, err := desc.LoadFileDescriptor("desc_test1.proto")
+	ok(t, err)
+	md := fd.FindMessage("desc_test.AnotherTestMessage")
+	dm := NewMessage(md)
+
+	xs := fd.FindExtensionByName("desc_test.xs")
+	eq(t, false, dm.HasField(xs))
+	eq(t, true, dm.FindFieldDescriptorByName("desc_test.xs") == nil)
+
+	dm.SetField(xs, "foo")
+	eq(t, "foo", dm.GetField(xs))
+	// now that the extension is known, it can be referenced by name and number
+	eq(t, "foo", dm.GetFieldByName("desc_test.xs"))
+	eq(t, "foo", dm.GetFieldByNumber(101))
+	eq(t, 1, len(dm.GetKnownExtensions()))
+
+	// extensions for other messages are rejected
+	md2, err := desc.LoadMessageDescriptor("desc_test.TestMessage")
+	ok(t, err)
+	eq(t, true, NewMessage(md2).TrySetField(xs, "foo") != nil)
+}
@@ -0,0 +1,1024 @@
+package dynamic
+
+// JSON marshalling and unmarshalling for dynamic messages
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/jhump/protoreflect/desc"
+)
+
+// MarshalJSON serializes this message to bytes in JSON format, returning an
+// error if the operation fails. The resulting bytes will be a valid UTF8
+// string.
+//
+// This method uses a compact form: no newlines, and spaces between fields and
+// between field identifiers and values are elided.
+//
+// This method is convenient shorthand for invoking MarshalJSONPB with a default
+// (zero value) marshaler:
+//
+//	m.MarshalJSONPB(&jsonpb.Marshaler{})
+//
+// So enums are serialized using enum value name strings, and values that are
+// not present (including those with default/zero value for messages defined in
+// "proto3" syntax) are omitted.
+func (m *Message) MarshalJSON() ([]byte, error) {
+	return m.MarshalJSONPB(&jsonpb.Marshaler{})
+}
+
+// MarshalJSONIndent serializes this message to bytes in JSON format, returning
+// an error if the operation fails. The resulting bytes will be a valid UTF8
+// string.
+//
+// This method uses a "pretty-printed" form, with each field on its own line and
+// spaces between field identifiers and values. Indentation of two spaces is
+// used.
+//
+// This method is convenient shorthand for invoking MarshalJSONPB with a default
+// (zero value) marshaler:
+//
+//	m.MarshalJSONPB(&jsonpb.Marshaler{Indent: "  "})
+//
+// So enums are serialized using enum value name strings, and values that are
+// not present (including those with default/zero value for messages defined in
+// "proto3" syntax) are omitted.
+func (m *Message) MarshalJSONIndent() ([]byte, error) {
+	return m.MarshalJSONPB(&jsonpb.Marshaler{Indent: "  "})
+}
+
+// MarshalJSONPB serializes this message to bytes in JSON format, returning an
+// error if the operation fails. The resulting bytes will be a valid UTF8
+// string. The given marshaler is used to convey options used during marshaling.
+//
+// The output follows the canonical proto3 JSON mapping, including the special
+// representations of well-known types (such as google.protobuf.Timestamp and
+// google.protobuf.Any), and is formatted the same way as output produced by
+// the jsonpb package for generated message types.
+//
+// If this message contains nested messages that are generated message types (as
+// opposed to dynamic messages), they are converted to dynamic messages before
+// being serialized.
+//
+// Any unknown fields will be ignored/skipped. And for a message that includes
+// extensions, only those extensions known to the message are serialized.
+func (m *Message) MarshalJSONPB(opts *jsonpb.Marshaler) ([]byte, error) {
+	w := jsonWriter{Marshaler: opts}
+	if err := w.marshalMessage(m, "", ""); err != nil {
+		return nil, err
+	}
+	return w.buf.Bytes(), nil
+}
+
+type jsonWriter struct {
+	*jsonpb.Marshaler
+	buf bytes.Buffer
+}
+
+func (w *jsonWriter) write(s string) {
+	w.buf.WriteString(s)
+}
+
+func (w *jsonWriter) writeComma() {
+	if w.Indent != "" {
+		w.write(",\n")
+	} else {
+		w.write(",")
+	}
+}
+
+func (w *jsonWriter) marshalMessage(m *Message, indent, typeUrl string) error {
+	if typeUrl == "" {
+		handled, err := w.marshalWellKnownType(m, indent)
+		if handled || err != nil {
+			return err
+		}
+	}
+
+	w.write("{")
+	if w.Indent != "" {
+		w.write("\n")
+	}
+
+	first := true
+	if typeUrl != "" {
+		if err := w.marshalTypeUrl(indent, typeUrl); err != nil {
+			return err
+		}
+		first = false
+	}
+
+	for _, fd := range m.md.GetFields() {
+		var v interface{}
+		if m.HasField(fd) {
+			v = m.GetField(fd)
+		} else {
+			if !w.EmitDefaults || fd.GetOneOf() != nil {
+				continue
+			}
+			if fd.IsRepeated() || (fd.GetMessageType() == nil && isProto3(m.md)) {
+				v = m.GetField(fd)
+			}
+			// otherwise, v is nil and is rendered as "null"
+		}
+		if !first {
+			w.writeComma()
+		}
+		if err := w.marshalField(fd, v, indent); err != nil {
+			return err
+		}
+		first = false
+	}
+
+	exts := m.GetKnownExtensions()
+	sort.Sort(fieldsByNumber(exts))
+	for _, fd := range exts {
+		if !m.HasField(fd) {
+			continue
+		}
+		if !first {
+			w.writeComma()
+		}
+		if err := w.marshalField(fd, m.GetField(fd), indent); err != nil {
+			return err
+		}
+		first = false
+	}
+
+	if w.Indent != "" {
+		w.write("\n")
+		w.write(indent)
+	}
+	w.write("}")
+	return nil
+}
+
+// marshalWellKnownType writes the special JSON form for the given message if it
+// is a well-known type that has one. If it is not such a type, it returns false
+// and writes nothing.
+func (w *jsonWriter) marshalWellKnownType(m *Message, indent string) (bool, error) {
+	switch wellKnownType(m.md.GetFullyQualifiedName()) {
+	case "Any":
+		return true, w.marshalAny(m, indent)
+	case "BoolValue", "BytesValue", "StringValue",
+		"Int32Value", "UInt32Value", "FloatValue",
+		"Int64Value", "UInt64Value", "DoubleValue":
+		fd := m.md.FindFieldByNumber(1)
+		return true, w.marshalValue(fd, m.GetField(fd), indent)
+	case "Duration":
+		const maxSecondsInDuration = 315576000000
+		// "Generated output always contains 0, 3, 6, or 9 fractional digits,
+		//  depending on required precision."
+		s := m.GetFieldByNumber(1).(int64)
+		ns := int64(m.GetFieldByNumber(2).(int32))
+		if s < -maxSecondsInDuration || s > maxSecondsInDuration {
+			return true, fmt.Errorf("seconds out of range %v", s)
+		}
+		if ns <= -int64(time.Second) || ns >= int64(time.Second) {
+			return true, fmt.Errorf("ns out of range (%v, %v)", -int64(time.Second), int64(time.Second))
+		}
+		if (s > 0 && ns < 0) || (s < 0 && ns > 0) {
+			return true, errors.New("signs of seconds and nanos do not match")
+		}
+		var sign string
+		if s < 0 || ns < 0 {
+			sign, s, ns = "-", -s, -ns
+		}
+		x := fmt.Sprintf("%s%d.%09d", sign, s, ns)
+		x = strings.TrimSuffix(x, "000")
+		x = strings.TrimSuffix(x, "000")
+		x = strings.TrimSuffix(x, ".000")
+		w.write(fmt.Sprintf(`"%vs"`, x))
+		return true, nil
+	case "Timestamp":
+		// "RFC 3339, where generated output will always be Z-normalized
+		//  and uses 0, 3, 6 or 9 fractional digits."
+		s := m.GetFieldByNumber(1).(int64)
+		ns := int64(m.GetFieldByNumber(2).(int32))
+		if ns < 0 || ns >= int64(time.Second) {
+			return true, fmt.Errorf("ns out of range [0, %v)", int64(time.Second))
+		}
+		t := time.Unix(s, ns).UTC()
+		// time.RFC3339Nano isn't exactly right (we need to get 3/6/9 fractional digits).
+		x := t.Format("2006-01-02T15:04:05.000000000")
+		x = strings.TrimSuffix(x, "000")
+		x = strings.TrimSuffix(x, "000")
+		x = strings.TrimSuffix(x, ".000")
+		w.write(fmt.Sprintf(`"%vZ"`, x))
+		return true, nil
+	case "Value":
+		// JSON value; which is a null, number, string, bool, object, or array.
+		fd, v := m.GetOneOfField(m.md.GetOneOfs()[0])
+		if fd == nil {
+			return true, errors.New("nil Value")
+		}
+		return true, w.marshalValue(fd, v, indent)
+	case "Struct", "ListValue":
+		// JSON object or array.
+		fd := m.md.FindFieldByNumber(1)
+		return true, w.marshalValue(fd, m.GetField(fd), indent)
+	}
+	return false, nil
+}
+
+func (w *jsonWriter) marshalAny(m *Message, indent string) error {
+	// "If the Any contains a value that has a special JSON mapping,
+	//  it will be converted as follows: {"@type": xxx, "value": yyy}.
+	//  Otherwise, the value will be converted into a JSON object,
+	//  and the "@type" field will be inserted to indicate the actual data type."
+	typeUrl := m.GetFieldByNumber(1).(string)
+	msg, err := m.resolveAny(typeUrl, w.AnyResolver)
+	if err != nil {
+		return err
+	}
+	if err := msg.Unmarshal(m.GetFieldByNumber(2).([]byte)); err != nil {
+		return err
+	}
+
+	if wellKnownType(msg.md.GetFullyQualifiedName()) == "" {
+		return w.marshalMessage(msg, indent, typeUrl)
+	}
+
+	w.write("{")
+	if w.Indent != "" {
+		w.write("\n")
+	}
+	if err := w.marshalTypeUrl(indent, typeUrl); err != nil {
+		return err
+	}
+	w.writeComma()
+	if w.Indent != "" {
+		w.write(indent)
+		w.write(w.Indent)
+		w.write(`"value": `)
+	} else {
+		w.write(`"value":`)
+	}
+	if err := w.marshalMessage(msg, indent+w.Indent, ""); err != nil {
+		return err
+	}
+	if w.Indent != "" {
+		w.write("\n")
+		w.write(indent)
+	}
+	w.write("}")
+	return nil
+}
+
+func (w *jsonWriter) marshalTypeUrl(indent, typeUrl string) error {
+	if w.Indent != "" {
+		w.write(indent)
+		w.write(w.Indent)
+	}
+	w.write(`"@type":`)
+	if w.Indent != "" {
+		w.write(" ")
+	}
+	b, err := json.Marshal(typeUrl)
+	if err != nil {
+		return err
+	}
+	w.write(string(b))
+	return nil
+}
+
+func (w *jsonWriter) marshalField(fd *desc.FieldDescriptor, v interface{}, indent string) error {
+	if w.Indent != "" {
+		w.write(indent)
+		w.write(w.Indent)
+	}
+	w.write(`"`)
+	switch {
+	case fd.IsExtension():
+		w.write("[" + fd.GetFullyQualifiedName() + "]")
+	case w.OrigName:
+		if fd.GetType() == dpb.FieldDescriptorProto_TYPE_GROUP {
+			w.write(fd.GetMessageType().GetName())
+		} else {
+			w.write(fd.GetName())
+		}
+	default:
+		w.write(fd.GetJSONName())
+	}
+	w.write(`":`)
+	if w.Indent != "" {
+		w.write(" ")
+	}
+	return w.marshalValue(fd, v, indent)
+}
+
+func (w *jsonWriter) marshalValue(fd *desc.FieldDescriptor, v interface{}, indent string) error {
+	switch {
+	case fd.IsMap():
+		mp := v.(map[interface{}]interface{})
+		valFd := fd.GetMessageType().FindFieldByNumber(2)
+		w.write("{")
+		comma := ""
+		for _, k := range sortedMapKeys(mp) {
+			w.write(comma)
+			if w.Indent != "" {
+				w.write("\n")
+				w.write(indent)
+				w.write(w.Indent)
+				w.write(w.Indent)
+			}
+			b, err := json.Marshal(fmt.Sprint(k))
+			if err != nil {
+				return err
+			}
+			w.write(string(b))
+			w.write(":")
+			if w.Indent != "" {
+				w.write(" ")
+			}
+			if err := w.marshalSingularValue(valFd, mp[k], indent+w.Indent); err != nil {
+				return err
+			}
+			comma = ","
+		}
+		if w.Indent != "" {
+			w.write("\n")
+			w.write(indent)
+			w.write(w.Indent)
+		}
+		w.write("}")
+		return nil
+	case fd.IsRepeated() && v != nil:
+		sl := v.([]interface{})
+		w.write("[")
+		comma := ""
+		for _, item := range sl {
+			w.write(comma)
+			if w.Indent != "" {
+				w.write("\n")
+				w.write(indent)
+				w.write(w.Indent)
+				w.write(w.Indent)
+			}
+			if err := w.marshalSingularValue(fd, item, indent+w.Indent); err != nil {
+				return err
+			}
+			comma = ","
+		}
+		if w.Indent != "" {
+			w.write("\n")
+			w.write(indent)
+			w.write(w.Indent)
+		}
+		w.write("]")
+		return nil
+	default:
+		return w.marshalSingularValue(fd, v, indent)
+	}
+}
+
+func (w *jsonWriter) marshalSingularValue(fd *desc.FieldDescriptor, v interface{}, indent string) error {
+	if v == nil {
+		w.write("null")
+		return nil
+	}
+	switch fd.GetType() {
+	case dpb.FieldDescriptorProto_TYPE_MESSAGE, dpb.FieldDescriptorProto_TYPE_GROUP:
+		dm, err := asDynamicMessage(v.(proto.Message), fd.GetMessageType(), nil)
+		if err != nil {
+			return err
+		}
+		return w.marshalMessage(dm, indent+w.Indent, "")
+
+	case dpb.FieldDescriptorProto_TYPE_ENUM:
+		ed := fd.GetEnumType()
+		if ed.GetFullyQualifiedName() == "google.protobuf.NullValue" {
+			w.write("null")
+			return nil
+		}
+		num := v.(int32)
+		if vd := ed.FindValueByNumber(num); vd == nil || w.EnumsAsInts {
+			w.write(strconv.Itoa(int(num)))
+		} else {
+			w.write(`"` + vd.GetName() + `"`)
+		}
+		return nil
+	}
+
+	switch v := v.(type) {
+	case float32, float64:
+		var f float64
+		if f32, ok := v.(float32); ok {
+			f = float64(f32)
+		} else {
+			f = v.(float64)
+		}
+		switch {
+		case math.IsInf(f, 1):
+			w.write(`"Infinity"`)
+			return nil
+		case math.IsInf(f, -1):
+			w.write(`"-Infinity"`)
+			return nil
+		case math.IsNaN(f):
+			w.write(`"NaN"`)
+			return nil
+		}
+	case int64, uint64:
+		w.write(fmt.Sprintf(`"%d"`, v))
+		return nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.write(string(b))
+	return nil
+}
+
+// wellKnownType returns the simple name of the given message type if it is one
+// of the well-known types that has a special JSON representation. Otherwise, it
+// returns the empty string.
+func wellKnownType(messageName string) string {
+	if !strings.HasPrefix(messageName, "google.protobuf.") {
+		return ""
+	}
+	name := messageName[len("google.protobuf."):]
+	switch name {
+	case "Empty", "Any",
+		"BoolValue", "BytesValue", "StringValue",
+		"Int32Value", "UInt32Value", "FloatValue",
+		"Int64Value", "UInt64Value", "DoubleValue",
+		"Duration", "Timestamp",
+		"NullValue", "Struct", "Value", "ListValue":
+		return name
+	}
+	return ""
+}
+
+// resolveAny returns an empty message for the type indicated by the given type
+// URL. If a resolver is given, it is used to find the message type. Otherwise,
+// the message type is first searched for in the file that defines this message
+// and its transitive dependencies, and then in the types linked into the
+// current program. The returned message is always a dynamic message.
+func (m *Message) resolveAny(typeUrl string, resolver jsonpb.AnyResolver) (*Message, error) {
+	if resolver != nil {
+		msg, err := resolver.Resolve(typeUrl)
+		if err != nil {
+			return nil, err
+		}
+		if dm, ok := msg.(*Message); ok {
+			return m.newMessage(dm.md), nil
+		}
+		md, err := desc.LoadMessageDescriptorForMessage(msg)
+		if err != nil {
+			return nil, err
+		}
+		return m.newMessage(md), nil
+	}
+
+	name := typeUrl
+	if slash := strings.LastIndex(name, "/"); slash >= 0 {
+		name = name[slash+1:]
+	}
+	md := findMessageInFiles(m.md.GetFile(), name, map[string]struct{}{})
+	if md == nil {
+		var err error
+		if md, err = desc.LoadMessageDescriptor(name); err != nil {
+			return nil, err
+		}
+	}
+	if md == nil {
+		return nil, fmt.Errorf("could not resolve Any message type: %s", typeUrl)
+	}
+	return m.newMessage(md), nil
+}
+
+// findMessageInFiles searches the given file and its transitive dependencies
+// for the named message type. It returns nil if it is not found.
+func findMessageInFiles(fd *desc.FileDescriptor, msgName string, checked map[string]struct{}) *desc.MessageDescriptor {
+	if _, ok := checked[fd.GetName()]; ok {
+		return nil
+	}
+	checked[fd.GetName()] = struct{}{}
+	if md := fd.FindMessage(msgName); md != nil {
+		return md
+	}
+	for _, dep := range fd.GetDependencies() {
+		if md := findMessageInFiles(dep, msgName, checked); md != nil {
+			return md
+		}
+	}
+	return nil
+}
+
+type fieldsByNumber []*desc.FieldDescriptor
+
+func (s fieldsByNumber) Len() int {
+	return len(s)
+}
+
+func (s fieldsByNumber) Less(i, j int) bool {
+	return s[i].GetNumber() < s[j].GetNumber()
+}
+
+func (s fieldsByNumber) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// UnmarshalJSON de-serializes the message that is present, in JSON format, in
+// the given bytes into this message. It first resets the current message. It
+// returns an error if the given bytes do not contain a valid encoding of this
+// message type in JSON format.
+//
+// This method is shorthand for invoking UnmarshalJSONPB with a default (zero
+// value) unmarshaler:
+//
+//	m.UnmarshalJSONPB(&jsonpb.Unmarshaler{}, js)
+//
+// So unknown fields will result in an error, and no provided jsonpb.AnyResolver
+// will be used when parsing google.protobuf.Any messages.
+func (m *Message) UnmarshalJSON(js []byte) error {
+	return m.UnmarshalJSONPB(&jsonpb.Unmarshaler{}, js)
+}
+
+// UnmarshalMergeJSON de-serializes the message that is present, in JSON format,
+// in the given bytes into this message. Unlike UnmarshalJSON, it does not first
+// reset the message, instead merging the data in the given bytes into the
+// existing data in this message.
+func (m *Message) UnmarshalMergeJSON(js []byte) error {
+	return m.UnmarshalMergeJSONPB(&jsonpb.Unmarshaler{}, js)
+}
+
+// UnmarshalJSONPB de-serializes the message that is present, in JSON format, in
+// the given bytes into this message. The given unmarshaler conveys options used
+// when parsing the JSON. This function first resets the current message. It
+// returns an error if the given bytes do not contain a valid encoding of this
+// message type in JSON format.
+//
+// The decoding is lenient in the same ways as the jsonpb package: fields may be
+// identified by either their JSON name or their original proto name, enum
+// values may be given as names or numbers, and 64-bit integers and floating
+// point values may be given as either JSON numbers or strings.
+func (m *Message) UnmarshalJSONPB(opts *jsonpb.Unmarshaler, js []byte) error {
+	m.Reset()
+	return m.UnmarshalMergeJSONPB(opts, js)
+}
+
+// UnmarshalMergeJSONPB de-serializes the message that is present, in JSON
+// format, in the given bytes into this message. The given unmarshaler conveys
+// options used when parsing the JSON. Unlike UnmarshalJSONPB, it does not first
+// reset the message, instead merging the data in the given bytes into the
+// existing data in this message.
+func (m *Message) UnmarshalMergeJSONPB(opts *jsonpb.Unmarshaler, js []byte) error {
+	return m.unmarshalJSON(opts, js)
+}
+
+func (m *Message) unmarshalJSON(opts *jsonpb.Unmarshaler, in []byte) error {
+	if string(in) == "null" && m.md.GetFullyQualifiedName() != "google.protobuf.Value" {
+		return nil
+	}
+
+	switch wellKnownType(m.md.GetFullyQualifiedName()) {
+	case "Any":
+		return m.unmarshalAny(opts, in)
+	case "BoolValue", "BytesValue", "StringValue",
+		"Int32Value", "UInt32Value", "FloatValue",
+		"Int64Value", "UInt64Value", "DoubleValue":
+		fd := m.md.FindFieldByNumber(1)
+		v, err := m.unmarshalSingularValue(opts, fd, in)
+		if err != nil {
+			return err
+		}
+		return m.TrySetField(fd, v)
+	case "Duration":
+		v, err := unquoteString(in)
+		if err != nil {
+			return err
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("bad Duration: %v", err)
+		}
+		m.SetFieldByNumber(1, d.Nanoseconds()/int64(time.Second))
+		m.SetFieldByNumber(2, int32(d.Nanoseconds()%int64(time.Second)))
+		return nil
+	case "Timestamp":
+		v, err := unquoteString(in)
+		if err != nil {
+			return err
+		}
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return fmt.Errorf("bad Timestamp: %v", err)
+		}
+		m.SetFieldByNumber(1, t.Unix())
+		m.SetFieldByNumber(2, int32(t.Nanosecond()))
+		return nil
+	case "Value":
+		return m.unmarshalValueMessage(opts, in)
+	case "ListValue":
+		var jsonArray []json.RawMessage
+		if err := json.Unmarshal(in, &jsonArray); err != nil {
+			return fmt.Errorf("bad ListValue: %v", err)
+		}
+		fd := m.md.FindFieldByNumber(1)
+		for _, raw := range jsonArray {
+			v := m.newMessage(fd.GetMessageType())
+			if err := v.unmarshalJSON(opts, raw); err != nil {
+				return err
+			}
+			if err := m.TryAddRepeatedField(fd, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "Struct":
+		var jsonObject map[string]json.RawMessage
+		if err := json.Unmarshal(in, &jsonObject); err != nil {
+			return fmt.Errorf("bad StructValue: %v", err)
+		}
+		fd := m.md.FindFieldByNumber(1)
+		valType := fd.GetMessageType().FindFieldByNumber(2).GetMessageType()
+		for key, raw := range jsonObject {
+			v := m.newMessage(valType)
+			if err := v.unmarshalJSON(opts, raw); err != nil {
+				return fmt.Errorf("bad value in StructValue for key %q: %v", key, err)
+			}
+			if err := m.TryPutMapField(fd, key, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var jsonObject map[string]json.RawMessage
+	if err := json.Unmarshal(in, &jsonObject); err != nil {
+		return err
+	}
+
+	// handle known fields
+	for _, fd := range m.md.GetFields() {
+		raw, ok := jsonObject[fd.GetName()]
+		if ok {
+			delete(jsonObject, fd.GetName())
+		}
+		if fd.GetType() == dpb.FieldDescriptorProto_TYPE_GROUP {
+			if v, ok := jsonObject[fd.GetMessageType().GetName()]; ok {
+				delete(jsonObject, fd.GetMessageType().GetName())
+				raw = v
+			}
+		}
+		if v, ok := jsonObject[fd.GetJSONName()]; ok {
+			delete(jsonObject, fd.GetJSONName())
+			raw = v
+		}
+		if raw == nil {
+			continue
+		}
+		if err := m.unmarshalJSONField(opts, fd, raw); err != nil {
+			return err
+		}
+	}
+
+	// handle extensions, which are identified by their fully-qualified name in
+	// brackets, and any other unrecognized keys
+	for name, raw := range jsonObject {
+		var fd *desc.FieldDescriptor
+		if strings.HasPrefix(name, "[") && strings.HasSuffix(name, "]") {
+			fd = m.FindFieldDescriptorByName(name[1 : len(name)-1])
+		}
+		if fd == nil {
+			if opts.AllowUnknownFields {
+				continue
+			}
+			return fmt.Errorf("unknown field %q in %s", name, m.md.GetFullyQualifiedName())
+		}
+		if err := m.unmarshalJSONField(opts, fd, raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Message) unmarshalJSONField(opts *jsonpb.Unmarshaler, fd *desc.FieldDescriptor, raw json.RawMessage) error {
+	if string(raw) == "null" {
+		if fd.IsRepeated() || fd.GetMessageType() == nil || fd.GetMessageType().GetFullyQualifiedName() != "google.protobuf.Value" {
+			// null means absent
+			return nil
+		}
+	}
+
+	switch {
+	case fd.IsMap():
+		var jsonObject map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &jsonObject); err != nil {
+			return fmt.Errorf("bad value for map field %s: %v", fd.GetFullyQualifiedName(), err)
+		}
+		keyFd := fd.GetMessageType().FindFieldByNumber(1)
+		valFd := fd.GetMessageType().FindFieldByNumber(2)
+		mp := make(map[interface{}]interface{}, len(jsonObject))
+		for ks, rawVal := range jsonObject {
+			var kraw []byte
+			if keyFd.GetType() == dpb.FieldDescriptorProto_TYPE_STRING {
+				var err error
+				if kraw, err = json.Marshal(ks); err != nil {
+					return err
+				}
+			} else {
+				kraw = []byte(ks)
+			}
+			k, err := m.unmarshalSingularValue(opts, keyFd, kraw)
+			if err != nil {
+				return err
+			}
+			v, err := m.unmarshalSingularValue(opts, valFd, rawVal)
+			if err != nil {
+				return err
+			}
+			mp[k] = v
+		}
+		return m.TrySetField(fd, mp)
+
+	case fd.IsRepeated():
+		var jsonArray []json.RawMessage
+		if err := json.Unmarshal(raw, &jsonArray); err != nil {
+			return fmt.Errorf("bad value for repeated field %s: %v", fd.GetFullyQualifiedName(), err)
+		}
+		sl := make([]interface{}, len(jsonArray))
+		for i, rawVal := range jsonArray {
+			v, err := m.unmarshalSingularValue(opts, fd, rawVal)
+			if err != nil {
+				return err
+			}
+			sl[i] = v
+		}
+		return m.TrySetField(fd, sl)
+
+	case fd.GetMessageType() != nil:
+		// merge into an existing message if there is one
+		if existing, ok := m.values[fd.GetNumber()].(*Message); ok {
+			return existing.unmarshalJSON(opts, raw)
+		}
+		v, err := m.unmarshalSingularValue(opts, fd, raw)
+		if err != nil {
+			return err
+		}
+		return m.TrySetField(fd, v)
+
+	default:
+		v, err := m.unmarshalSingularValue(opts, fd, raw)
+		if err != nil {
+			return err
+		}
+		return m.TrySetField(fd, v)
+	}
+}
+
+func (m *Message) unmarshalSingularValue(opts *jsonpb.Unmarshaler, fd *desc.FieldDescriptor, raw json.RawMessage) (interface{}, error) {
+	switch fd.GetType() {
+	case dpb.FieldDescriptorProto_TYPE_MESSAGE, dpb.FieldDescriptorProto_TYPE_GROUP:
+		msg := m.newMessage(fd.GetMessageType())
+		if err := msg.unmarshalJSON(opts, raw); err != nil {
+			return nil, err
+		}
+		return msg, nil
+
+	case dpb.FieldDescriptorProto_TYPE_ENUM:
+		ed := fd.GetEnumType()
+		if ed.GetFullyQualifiedName() == "google.protobuf.NullValue" && string(raw) == "null" {
+			return int32(0), nil
+		}
+		if len(raw) > 0 && raw[0] == '"' {
+			name, err := unquoteString(raw)
+			if err != nil {
+				return nil, err
+			}
+			if vd := ed.FindValueByName(name); vd != nil {
+				return vd.GetNumber(), nil
+			}
+			return nil, fmt.Errorf("unknown value %q for enum %s", name, ed.GetFullyQualifiedName())
+		}
+		i, err := strconv.ParseInt(string(raw), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad value for enum %s: %s", ed.GetFullyQualifiedName(), raw)
+		}
+		return int32(i), nil
+
+	case dpb.FieldDescriptorProto_TYPE_BOOL:
+		var b bool
+		if err := json.Unmarshal(raw, &b); err != nil {
+			return nil, fmt.Errorf("bad value for bool field %s: %s", fd.GetFullyQualifiedName(), raw)
+		}
+		return b, nil
+
+	case dpb.FieldDescriptorProto_TYPE_STRING:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, fmt.Errorf("bad value for string field %s: %s", fd.GetFullyQualifiedName(), raw)
+		}
+		return s, nil
+
+	case dpb.FieldDescriptorProto_TYPE_BYTES:
+		s, err := unquoteString(raw)
+		if err != nil {
+			return nil, fmt.Errorf("bad value for bytes field %s: %s", fd.GetFullyQualifiedName(), raw)
+		}
+		return decodeBase64(s)
+
+	case dpb.FieldDescriptorProto_TYPE_FLOAT, dpb.FieldDescriptorProto_TYPE_DOUBLE:
+		bits := 64
+		if fd.GetType() == dpb.FieldDescriptorProto_TYPE_FLOAT {
+			bits = 32
+		}
+		s := numericString(raw)
+		var f float64
+		switch s {
+		case "NaN":
+			f = math.NaN()
+		case "Infinity":
+			f = math.Inf(1)
+		case "-Infinity":
+			f = math.Inf(-1)
+		default:
+			var err error
+			if f, err = strconv.ParseFloat(s, bits); err != nil {
+				return nil, fmt.Errorf("bad value for %s field %s: %s", fieldTypeName(fd), fd.GetFullyQualifiedName(), raw)
+			}
+		}
+		if bits == 32 {
+			return float32(f), nil
+		}
+		return f, nil
+
+	case dpb.FieldDescriptorProto_TYPE_INT32,
+		dpb.FieldDescriptorProto_TYPE_SINT32,
+		dpb.FieldDescriptorProto_TYPE_SFIXED32:
+		i, err := strconv.ParseInt(numericString(raw), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad value for %s field %s: %s", fieldTypeName(fd), fd.GetFullyQualifiedName(), raw)
+		}
+		return int32(i), nil
+
+	case dpb.FieldDescriptorProto_TYPE_INT64,
+		dpb.FieldDescriptorProto_TYPE_SINT64,
+		dpb.FieldDescriptorProto_TYPE_SFIXED64:
+		i, err := strconv.ParseInt(numericString(raw), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad value for %s field %s: %s", fieldTypeName(fd), fd.GetFullyQualifiedName(), raw)
+		}
+		return i, nil
+
+	case dpb.FieldDescriptorProto_TYPE_UINT32,
+		dpb.FieldDescriptorProto_TYPE_FIXED32:
+		u, err := strconv.ParseUint(numericString(raw), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad value for %s field %s: %s", fieldTypeName(fd), fd.GetFullyQualifiedName(), raw)
+		}
+		return uint32(u), nil
+
+	case dpb.FieldDescriptorProto_TYPE_UINT64,
+		dpb.FieldDescriptorProto_TYPE_FIXED64:
+		u, err := strconv.ParseUint(numericString(raw), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad value for %s field %s: %s", fieldTypeName(fd), fd.GetFullyQualifiedName(), raw)
+		}
+		return u, nil
+
+	default:
+		return nil, fmt.Errorf("unrecognized field type: %v", fd.GetType())
+	}
+}
+
+func (m *Message) unmarshalAny(opts *jsonpb.Unmarshaler, in []byte) error {
+	var jsonObject map[string]json.RawMessage
+	if err := json.Unmarshal(in, &jsonObject); err != nil {
+		return err
+	}
+
+	rawTypeUrl, ok := jsonObject["@type"]
+	if !ok {
+		return errors.New("Any JSON doesn't have '@type'")
+	}
+	typeUrl, err := unquoteString(rawTypeUrl)
+	if err != nil {
+		return fmt.Errorf("can't unmarshal Any's '@type': %q", rawTypeUrl)
+	}
+
+	msg, err := m.resolveAny(typeUrl, opts.AnyResolver)
+	if err != nil {
+		return err
+	}
+
+	if wellKnownType(msg.md.GetFullyQualifiedName()) != "" {
+		rawValue, ok := jsonObject["value"]
+		if !ok {
+			return errors.New("Any JSON doesn't have 'value'")
+		}
+		if err := msg.unmarshalJSON(opts, rawValue); err != nil {
+			return fmt.Errorf("can't unmarshal Any nested proto %v: %v", typeUrl, err)
+		}
+	} else {
+		delete(jsonObject, "@type")
+		rawJson, err := json.Marshal(jsonObject)
+		if err != nil {
+			return fmt.Errorf("can't generate JSON for Any's nested proto to be unmarshaled: %v", err)
+		}
+		if err := msg.unmarshalJSON(opts, rawJson); err != nil {
+			return fmt.Errorf("can't unmarshal Any nested proto %v: %v", typeUrl, err)
+		}
+	}
+
+	b, err := msg.Marshal()
+	if err != nil {
+		return fmt.Errorf("can't marshal proto %v into Any.Value: %v", typeUrl, err)
+	}
+	m.SetFieldByNumber(1, typeUrl)
+	m.SetFieldByNumber(2, b)
+	return nil
+}
+
+func (m *Message) unmarshalValueMessage(opts *jsonpb.Unmarshaler, in []byte) error {
+	s := string(in)
+	switch {
+	case s == "null":
+		return m.TrySetFieldByNumber(1, int32(0))
+	case s == "true":
+		return m.TrySetFieldByNumber(4, true)
+	case s == "false":
+		return m.TrySetFieldByNumber(4, false)
+	case hasPrefixAndSuffix('"', in, '"'):
+		str, err := unquoteString(in)
+		if err != nil {
+			return fmt.Errorf("unrecognized type for Value %q", in)
+		}
+		return m.TrySetFieldByNumber(3, str)
+	case hasPrefixAndSuffix('[', in, ']'), hasPrefixAndSuffix('{', in, '}'):
+		tag := 5
+		if in[0] == '[' {
+			tag = 6
+		}
+		fd := m.md.FindFieldByNumber(int32(tag))
+		v := m.newMessage(fd.GetMessageType())
+		if err := v.unmarshalJSON(opts, in); err != nil {
+			return err
+		}
+		return m.TrySetField(fd, v)
+	default:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("unrecognized type for Value %q", in)
+		}
+		return m.TrySetFieldByNumber(2, f)
+	}
+}
+
+func hasPrefixAndSuffix(prefix byte, in []byte, suffix byte) bool {
+	return len(in) >= 2 && in[0] == prefix && in[len(in)-1] == suffix
+}
+
+func unquoteString(in []byte) (string, error) {
+	var s string
+	err := json.Unmarshal(in, &s)
+	return s, err
+}
+
+// numericString returns the given JSON value as a string suitable for parsing
+// as a number. Numeric values may be given as JSON strings (which is how 64-bit
+// integers are always serialized), in which case the quotes are removed.
+func numericString(raw []byte) string {
+	if hasPrefixAndSuffix('"', raw, '"') {
+		if s, err := unquoteString(raw); err == nil {
+			return s
+		}
+	}
+	return string(raw)
+}
+
+// decodeBase64 decodes the given string using either standard or URL-safe
+// base64 encoding, with or without padding.
+func decodeBase64(s string) ([]byte, error) {
+	encodings := []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding}
+	var err error
+	for _, enc := range encodings {
+		var b []byte
+		if b, err = enc.DecodeString(s); err == nil {
+			return b, nil
+		}
+	}
+	return nil, err
+}
+
+// Verify that *Message implements the interfaces used by the jsonpb package
+// for custom serialization.
+var _ jsonpb.JSONPBMarshaler = (*Message)(nil)
+var _ jsonpb.JSONPBUnmarshaler = (*Message)(nil)
@@ -0,0 +1,173 @@
+package dynamic
+
+import (
+	"math"
+	"testing"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/any"
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/golang/protobuf/ptypes/struct"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/golang/protobuf/ptypes/wrappers"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/desc_test"
+)
+
+func TestJSONMatchesJsonpb(t *testing.T) {
+	dne := desc_test.TestMessage_NestedMessage_AnotherNestedMessage_YetAnotherNestedMessage_VALUE2
+	atm := &desc_test.AnotherTestMessage{
+		Dne:       &dne,
+		MapField1: map[int32]string{1: "one", 2: "two"},
+		MapField2: map[int64]float32{-1: 1.5, 100: float32(math.Inf(1))},
+		MapField3: map[uint32]bool{1: true},
+		MapField4: map[string]*desc_test.AnotherTestMessage{"a": {MapField1: map[int32]string{4: "<four>"}}},
+		Rocknroll: &desc_test.AnotherTestMessage_RockNRoll{Beatles: proto.String("abc")},
+	}
+	ok(t, proto.SetExtension(atm, desc_test.E_Xs, proto.String("ext")))
+	ok(t, proto.SetExtension(atm, desc_test.E_Xui, proto.Uint64(math.MaxUint64)))
+	req := &desc_test.TestRequest{
+		Foo: []desc_test.Proto3Enum{desc_test.Proto3Enum_VALUE1, desc_test.Proto3Enum_VALUE2},
+		Baz: &desc_test.TestMessage{Ne: []desc_test.TestMessage_NestedEnum{desc_test.TestMessage_VALUE1}},
+	}
+	frob := &desc_test.Frobnitz{
+		Def: &desc_test.Frobnitz_G2{G2: -12345},
+		F:   []string{"x", "y"},
+	}
+
+	marshalers := []*jsonpb.Marshaler{
+		{},
+		{Indent: "  "},
+		{OrigName: true, EnumsAsInts: true},
+		{EmitDefaults: true, Indent: "\t"},
+	}
+	for _, msg := range []proto.Message{atm, req, frob} {
+		md, err := desc.LoadMessageDescriptorForMessage(msg)
+		ok(t, err)
+		dm := NewMessageWithExtensionRegistry(md, NewExtensionRegistryWithDefaults())
+		ok(t, dm.ConvertFrom(msg))
+		for i, m := range marshalers {
+			expected, err := m.MarshalToString(msg)
+			ok(t, err)
+			actual, err := dm.MarshalJSONPB(m)
+			ok(t, err)
+			eq(t, expected, string(actual), "%s marshaler #%d", md.GetName(), i)
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	dne := desc_test.TestMessage_NestedMessage_AnotherNestedMessage_YetAnotherNestedMessage_VALUE1
+	atm := &desc_test.AnotherTestMessage{
+		Dne:       &dne,
+		MapField2: map[int64]float32{-1: 1.5, 2: float32(math.NaN())},
+		MapField3: map[uint32]bool{1: true, 2: false},
+		MapField4: map[string]*desc_test.AnotherTestMessage{"a": {}},
+		Rocknroll: &desc_test.AnotherTestMessage_RockNRoll{Stones: proto.String("def")},
+	}
+	ok(t, proto.SetExtension(atm, desc_test.E_Xi, proto.Int32(-101)))
+
+	md, err := desc.LoadMessageDescriptorForMessage(atm)
+	ok(t, err)
+	dm := NewMessageWithExtensionRegistry(md, NewExtensionRegistryWithDefaults())
+	ok(t, dm.ConvertFrom(atm))
+	js, err := dm.MarshalJSON()
+	ok(t, err)
+
+	dm2 := NewMessageWithExtensionRegistry(md, NewExtensionRegistryWithDefaults())
+	ok(t, dm2.UnmarshalJSON(js))
+	var atm2 desc_test.AnotherTestMessage
+	ok(t, dm2.ConvertTo(&atm2))
+	// NaN != NaN, so check that one separately
+	eq(t, true, math.IsNaN(float64(atm2.MapField2[2])))
+	delete(atm.MapField2, 2)
+	delete(atm2.MapField2, 2)
+	eq(t, true, proto.Equal(atm, &atm2))
+}
+
+func TestJSONLenientParsing(t *testing.T) {
+	md, err := desc.LoadMessageDescriptor("desc_test.AnotherTestMessage")
+	ok(t, err)
+	dm := NewMessage(md)
+	// original names, enums as numbers, and numbers as strings are all accepted
+	js := `{"dne": 2, "map_field2": {"1": "2.5"}, "mapField3": {"10": true}, "RockNRoll": {"beatles": "xyz"}}`
+	ok(t, dm.UnmarshalJSON([]byte(js)))
+	eq(t, int32(2), dm.GetFieldByName("dne"))
+	eq(t, float32(2.5), dm.GetMapFieldByName("map_field2", int64(1)))
+	eq(t, true, dm.GetMapFieldByName("map_field3", uint32(10)))
+	eq(t, "xyz", dm.GetFieldByName("rocknroll").(*Message).GetFieldByName("beatles"))
+
+	// unknown fields
+	js = `{"foo": 123}`
+	eq(t, true, dm.UnmarshalJSON([]byte(js)) != nil)
+	ok(t, dm.UnmarshalJSONPB(&jsonpb.Unmarshaler{AllowUnknownFields: true}, []byte(js)))
+
+	// bad values
+	eq(t, true, dm.UnmarshalJSON([]byte(`{"dne": "NOT_A_VALUE"}`)) != nil)
+	eq(t, true, dm.UnmarshalJSON([]byte(`{"mapField3": {"abc": true}}`)) != nil)
+
+	// merging
+	ok(t, dm.UnmarshalJSON([]byte(`{"dne": "VALUE1"}`)))
+	ok(t, dm.UnmarshalMergeJSON([]byte(`{"mapField1": {"1": "one"}}`)))
+	eq(t, int32(1), dm.GetFieldByName("dne"))
+	eq(t, "one", dm.GetMapFieldByName("map_field1", int32(1)))
+}
+
+func TestJSONWellKnownTypes(t *testing.T) {
+	anyTm, err := marshalAny(&desc_test.TestMessage{Ne: []desc_test.TestMessage_NestedEnum{desc_test.TestMessage_VALUE2}})
+	ok(t, err)
+	anyDur, err := marshalAny(&duration.Duration{Seconds: 3, Nanos: 500000000})
+	ok(t, err)
+
+	testCases := []proto.Message{
+		&timestamp.Timestamp{Seconds: 1500000000, Nanos: 123000000},
+		&duration.Duration{Seconds: -10, Nanos: -1000},
+		&wrappers.Int64Value{Value: 123456789012},
+		&wrappers.StringValue{Value: "foo"},
+		&wrappers.BoolValue{Value: true},
+		&structpb.Struct{Fields: map[string]*structpb.Value{
+			"null":   {Kind: &structpb.Value_NullValue{}},
+			"number": {Kind: &structpb.Value_NumberValue{NumberValue: 3.5}},
+			"string": {Kind: &structpb.Value_StringValue{StringValue: "abc"}},
+			"bool":   {Kind: &structpb.Value_BoolValue{BoolValue: true}},
+			"list": {Kind: &structpb.Value_ListValue{ListValue: &structpb.ListValue{Values: []*structpb.Value{
+				{Kind: &structpb.Value_NumberValue{NumberValue: 1}},
+				{Kind: &structpb.Value_StringValue{StringValue: "two"}},
+			}}}},
+			"struct": {Kind: &structpb.Value_StructValue{StructValue: &structpb.Struct{}}},
+		}},
+		anyTm,
+		anyDur,
+	}
+	for _, m := range []*jsonpb.Marshaler{{}, {Indent: "  "}} {
+		for _, msg := range testCases {
+			md, err := desc.LoadMessageDescriptorForMessage(msg)
+			ok(t, err)
+			dm := NewMessage(md)
+			ok(t, dm.ConvertFrom(msg))
+
+			expected, err := m.MarshalToString(msg)
+			ok(t, err)
+			actual, err := dm.MarshalJSONPB(m)
+			ok(t, err)
+			eq(t, expected, string(actual), md.GetFullyQualifiedName())
+
+			// and back again
+			dm2 := NewMessage(md)
+			ok(t, dm2.UnmarshalJSON(actual))
+			msg2 := proto.Clone(msg)
+			ok(t, dm2.ConvertTo(msg2))
+			eq(t, true, proto.Equal(msg, msg2), md.GetFullyQualifiedName())
+		}
+	}
+}
+
+func marshalAny(msg proto.Message) (*any.Any, error) {
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return &any.Any{TypeUrl: "type.googleapis.com/" + proto.MessageName(msg), Value: b}, nil
+}
@@ -0,0 +1,299 @@
+package dynamic
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/jhump/protoreflect/desc"
+)
+
+// uninterpretedOptionsTag is the tag number of the uninterpreted_option field,
+// which is the same in all options messages.
+const uninterpretedOptionsTag = 999
+
+// InterpretOptions returns the options for the given descriptor as a dynamic
+// message. Custom options, which are extensions of the options message, are
+// resolved using the extensions declared in the descriptor's file and its
+// transitive dependencies, so they can be inspected even if no Go code for
+// them is linked into the program.
+//
+// Options that were not interpreted when the descriptor was created, which
+// appear as entries in the options' uninterpreted_option field, are also
+// interpreted. They are removed from the returned message's
+// uninterpreted_option field and set as regular fields and extensions. An
+// error is returned if any of them refer to unknown fields or extensions or
+// have values of the wrong type.
+func InterpretOptions(d desc.Descriptor) (*Message, error) {
+	opts := d.GetOptions()
+	rv := reflect.ValueOf(opts)
+	if rv.Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("options for %s have unexpected type %T", d.GetFullyQualifiedName(), opts)
+	}
+	if rv.IsNil() {
+		// no options set, so we use an empty options message
+		opts = reflect.New(rv.Type().Elem()).Interface().(proto.Message)
+	}
+	md, err := desc.LoadMessageDescriptorForMessage(opts)
+	if err != nil {
+		return nil, err
+	}
+	er := &ExtensionRegistry{}
+	addExtensionsFromDeps(d.GetFile(), er, map[*desc.FileDescriptor]bool{})
+	dm := NewMessageWithExtensionRegistry(md, er)
+	if err := dm.ConvertFrom(opts); err != nil {
+		return nil, err
+	}
+
+	uninterp := md.FindFieldByNumber(uninterpretedOptionsTag)
+	if uninterp == nil || dm.FieldLength(uninterp) == 0 {
+		return dm, nil
+	}
+	var uos []*dpb.UninterpretedOption
+	for _, v := range dm.GetField(uninterp).([]interface{}) {
+		uo := &dpb.UninterpretedOption{}
+		if err := v.(*Message).ConvertTo(uo); err != nil {
+			return nil, err
+		}
+		uos = append(uos, uo)
+	}
+	dm.ClearField(uninterp)
+	for _, uo := range uos {
+		if err := interpretOption(d, dm, er, uo.GetName(), uo); err != nil {
+			return nil, fmt.Errorf("%s: option %s: %v", d.GetFullyQualifiedName(), optionName(uo), err)
+		}
+	}
+	return dm, nil
+}
+
+// GetCustomOptions returns the values of all custom options set on the given
+// descriptor, keyed by the extensions that define them. The values are of the
+// types described in the package doc, so options whose type is a message are
+// returned as *Message. See InterpretOptions for how custom options are
+// resolved.
+func GetCustomOptions(d desc.Descriptor) (map[*desc.FieldDescriptor]interface{}, error) {
+	dm, err := InterpretOptions(d)
+	if err != nil {
+		return nil, err
+	}
+	ret := map[*desc.FieldDescriptor]interface{}{}
+	for _, ext := range dm.GetKnownExtensions() {
+		if dm.HasField(ext) {
+			ret[ext] = dm.GetField(ext)
+		}
+	}
+	return ret, nil
+}
+
+// GetCustomOption returns the value of the custom option with the given name
+// that is set on the given descriptor. The name is the fully-qualified name of
+// the extension that defines the option. If the option is not set, its
+// default value is returned. An error is returned if no such extension exists
+// in the descriptor's file or its transitive dependencies.
+func GetCustomOption(d desc.Descriptor, name string) (interface{}, error) {
+	dm, err := InterpretOptions(d)
+	if err != nil {
+		return nil, err
+	}
+	ext := dm.er.FindExtensionByName(dm.GetMessageDescriptor().GetFullyQualifiedName(), name)
+	if ext == nil {
+		return nil, fmt.Errorf("no option named %s for %s", name, d.GetFullyQualifiedName())
+	}
+	return dm.TryGetField(ext)
+}
+
+func addExtensionsFromDeps(fd *desc.FileDescriptor, er *ExtensionRegistry, seen map[*desc.FileDescriptor]bool) {
+	if seen[fd] {
+		return
+	}
+	seen[fd] = true
+	er.AddExtensionsFromFile(fd)
+	for _, dep := range fd.GetDependencies() {
+		addExtensionsFromDeps(dep, er, seen)
+	}
+}
+
+func optionName(uo *dpb.UninterpretedOption) string {
+	parts := make([]string, len(uo.GetName()))
+	for i, n := range uo.GetName() {
+		if n.GetIsExtension() {
+			parts[i] = "(" + n.GetNamePart() + ")"
+		} else {
+			parts[i] = n.GetNamePart()
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// interpretOption sets the field indicated by the given name to the given
+// uninterpreted option's value. The name is the option's name or, when
+// recursing into nested messages, the rest of it. Every part of the name but
+// the last must refer to a singular message field; intermediate messages are
+// created as needed.
+func interpretOption(d desc.Descriptor, dm *Message, er *ExtensionRegistry, names []*dpb.UninterpretedOption_NamePart, uo *dpb.UninterpretedOption) error {
+	if len(names) == 0 {
+		return fmt.Errorf("option has no name")
+	}
+	fld, err := findOptionField(d, dm, er, names[0])
+	if err != nil {
+		return err
+	}
+	if len(names) == 1 {
+		return setOptionValue(dm, er, fld, uo)
+	}
+	if fld.IsRepeated() || fld.GetMessageType() == nil {
+		return fmt.Errorf("%s is not a singular message field", names[0].GetNamePart())
+	}
+	var nested *Message
+	if dm.HasField(fld) {
+		nested = dm.GetField(fld).(*Message)
+	} else {
+		nested = NewMessageWithExtensionRegistry(fld.GetMessageType(), er)
+	}
+	if err := interpretOption(d, nested, er, names[1:], uo); err != nil {
+		return err
+	}
+	return dm.TrySetField(fld, nested)
+}
+
+func findOptionField(d desc.Descriptor, dm *Message, er *ExtensionRegistry, name *dpb.UninterpretedOption_NamePart) (*desc.FieldDescriptor, error) {
+	msgName := dm.GetMessageDescriptor().GetFullyQualifiedName()
+	if !name.GetIsExtension() {
+		if fld := dm.GetMessageDescriptor().FindFieldByName(name.GetNamePart()); fld != nil {
+			return fld, nil
+		}
+		return nil, fmt.Errorf("%s has no field named %s", msgName, name.GetNamePart())
+	}
+	extName := name.GetNamePart()
+	if strings.HasPrefix(extName, ".") {
+		if ext := er.FindExtensionByName(msgName, extName[1:]); ext != nil {
+			return ext, nil
+		}
+	} else {
+		// relative names are resolved the same way as type references: starting
+		// in the scope that encloses the element and moving outwards
+		scope := d.GetFullyQualifiedName()
+		if _, ok := d.(*desc.FileDescriptor); !ok {
+			scope = scopeOf(scope)
+		}
+		for {
+			candidate := extName
+			if scope != "" {
+				candidate = scope + "." + extName
+			}
+			if ext := er.FindExtensionByName(msgName, candidate); ext != nil {
+				return ext, nil
+			}
+			if scope == "" {
+				break
+			}
+			scope = scopeOf(scope)
+		}
+	}
+	return nil, fmt.Errorf("no extension of %s named %s", msgName, extName)
+}
+
+func scopeOf(name string) string {
+	if pos := strings.LastIndex(name, "."); pos >= 0 {
+		return name[:pos]
+	}
+	return ""
+}
+
+func setOptionValue(dm *Message, er *ExtensionRegistry, fld *desc.FieldDescriptor, uo *dpb.UninterpretedOption) error {
+	v, err := optionValue(er, fld, uo)
+	if err != nil {
+		return err
+	}
+	if fld.IsRepeated() {
+		return dm.TryAddRepeatedField(fld, v)
+	}
+	if dm.HasField(fld) {
+		return fmt.Errorf("option is already set")
+	}
+	return dm.TrySetField(fld, v)
+}
+
+func optionValue(er *ExtensionRegistry, fld *desc.FieldDescriptor, uo *dpb.UninterpretedOption) (interface{}, error) {
+	mismatch := func(expected string) error {
+		return fmt.Errorf("value should be %s", expected)
+	}
+	switch fld.GetType() {
+	case dpb.FieldDescriptorProto_TYPE_ENUM:
+		if uo.IdentifierValue == nil {
+			return nil, mismatch("an enum value name")
+		}
+		vd := fld.GetEnumType().FindValueByName(uo.GetIdentifierValue())
+		if vd == nil {
+			return nil, fmt.Errorf("enum %s has no value named %s", fld.GetEnumType().GetFullyQualifiedName(), uo.GetIdentifierValue())
+		}
+		return vd.GetNumber(), nil
+
+	case dpb.FieldDescriptorProto_TYPE_MESSAGE, dpb.FieldDescriptorProto_TYPE_GROUP:
+		if uo.AggregateValue == nil {
+			return nil, mismatch("a message literal")
+		}
+		msg := NewMessageWithExtensionRegistry(fld.GetMessageType(), er)
+		if err := msg.UnmarshalText([]byte(uo.GetAggregateValue())); err != nil {
+			return nil, err
+		}
+		return msg, nil
+
+	case dpb.FieldDescriptorProto_TYPE_BOOL:
+		switch uo.GetIdentifierValue() {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+		return nil, mismatch("true or false")
+
+	case dpb.FieldDescriptorProto_TYPE_STRING:
+		if uo.StringValue == nil {
+			return nil, mismatch("a string")
+		}
+		return string(uo.GetStringValue()), nil
+
+	case dpb.FieldDescriptorProto_TYPE_BYTES:
+		if uo.StringValue == nil {
+			return nil, mismatch("a string")
+		}
+		return uo.GetStringValue(), nil
+
+	case dpb.FieldDescriptorProto_TYPE_FLOAT, dpb.FieldDescriptorProto_TYPE_DOUBLE:
+		var f float64
+		switch {
+		case uo.DoubleValue != nil:
+			f = uo.GetDoubleValue()
+		case uo.PositiveIntValue != nil:
+			f = float64(uo.GetPositiveIntValue())
+		case uo.NegativeIntValue != nil:
+			f = float64(uo.GetNegativeIntValue())
+		case uo.GetIdentifierValue() == "inf":
+			f = math.Inf(1)
+		case uo.GetIdentifierValue() == "nan":
+			f = math.NaN()
+		default:
+			return nil, mismatch("a number")
+		}
+		if fld.GetType() == dpb.FieldDescriptorProto_TYPE_FLOAT {
+			return float32(f), nil
+		}
+		return f, nil
+
+	default:
+		// integer types; TrySetField checks that the value is in range
+		switch {
+		case uo.PositiveIntValue != nil:
+			return uo.GetPositiveIntValue(), nil
+		case uo.NegativeIntValue != nil:
+			return uo.GetNegativeIntValue(), nil
+		default:
+			return nil, mismatch("an integer")
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package dynamic
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/jhump/protoreflect/desc"
+)
+
+// createOptionsFiles returns descriptors for two files: "opts.proto", which
+// declares custom method options, and "svc.proto", which declares a service
+// whose method uses them (with the given options). No Go code is linked for
+// the custom options.
+func createOptionsFiles(t *testing.T, mopts *dpb.MethodOptions) (*desc.FileDescriptor, *desc.FileDescriptor) {
+	descFd, err := desc.LoadFileDescriptor("google/protobuf/descriptor.proto")
+	ok(t, err)
+	ext := func(name string, num int32, label dpb.FieldDescriptorProto_Label, typ dpb.FieldDescriptorProto_Type, typeName string) *dpb.FieldDescriptorProto {
+		f := &dpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			Number:   proto.Int32(num),
+			Label:    label.Enum(),
+			Type:     typ.Enum(),
+			Extendee: proto.String(".google.protobuf.MethodOptions"),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	optsFd, err := desc.CreateFileDescriptor(&dpb.FileDescriptorProto{
+		Name:       proto.String("opts.proto"),
+		Package:    proto.String("opts"),
+		Dependency: []string{"google/protobuf/descriptor.proto"},
+		MessageType: []*dpb.DescriptorProto{
+			{
+				Name: proto.String("Policy"),
+				Field: []*dpb.FieldDescriptorProto{
+					{Name: proto.String("role"), Number: proto.Int32(1), Label: dpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), Type: dpb.FieldDescriptorProto_TYPE_STRING.Enum()},
+					{Name: proto.String("rate"), Number: proto.Int32(2), Label: dpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), Type: dpb.FieldDescriptorProto_TYPE_INT32.Enum()},
+				},
+			},
+		},
+		EnumType: []*dpb.EnumDescriptorProto{
+			{
+				Name: proto.String("Level"),
+				Value: []*dpb.EnumValueDescriptorProto{
+					{Name: proto.String("LOW"), Number: proto.Int32(0)},
+					{Name: proto.String("HIGH"), Number: proto.Int32(1)},
+				},
+			},
+		},
+		Extension: []*dpb.FieldDescriptorProto{
+			ext("role", 50001, dpb.FieldDescriptorProto_LABEL_OPTIONAL, dpb.FieldDescriptorProto_TYPE_STRING, ""),
+			ext("limits", 50002, dpb.FieldDescriptorProto_LABEL_REPEATED, dpb.FieldDescriptorProto_TYPE_INT32, ""),
+			ext("policy", 50003, dpb.FieldDescriptorProto_LABEL_OPTIONAL, dpb.FieldDescriptorProto_TYPE_MESSAGE, ".opts.Policy"),
+			ext("level", 50004, dpb.FieldDescriptorProto_LABEL_OPTIONAL, dpb.FieldDescriptorProto_TYPE_ENUM, ".opts.Level"),
+		},
+	}, descFd)
+	ok(t, err)
+	svcFd, err := desc.CreateFileDescriptor(&dpb.FileDescriptorProto{
+		Name:        proto.String("svc.proto"),
+		Package:     proto.String("svc"),
+		Dependency:  []string{"opts.proto"},
+		MessageType: []*dpb.DescriptorProto{{Name: proto.String("Req")}},
+		Service: []*dpb.ServiceDescriptorProto{
+			{
+				Name: proto.String("Svc"),
+				Method: []*dpb.MethodDescriptorProto{
+					{Name: proto.String("Do"), InputType: proto.String(".svc.Req"), OutputType: proto.String(".svc.Req"), Options: mopts},
+				},
+			},
+		},
+	}, optsFd)
+	ok(t, err)
+	return optsFd, svcFd
+}
+
+func TestInterpretOptions(t *testing.T) {
+	// first we encode options using the extension descriptors
+	optsFd, _ := createOptionsFiles(t, nil)
+	er := &ExtensionRegistry{}
+	er.AddExtensionsFromFile(optsFd)
+	md, err := desc.LoadMessageDescriptorForMessage((*dpb.MethodOptions)(nil))
+	ok(t, err)
+	dm := NewMessageWithExtensionRegistry(md, er)
+	dm.SetFieldByName("deprecated", true)
+	dm.SetField(optsFd.FindExtensionByName("opts.role"), "admin")
+	dm.AddRepeatedField(optsFd.FindExtensionByName("opts.limits"), 10)
+	dm.AddRepeatedField(optsFd.FindExtensionByName("opts.limits"), 20)
+	var mopts dpb.MethodOptions
+	ok(t, dm.ConvertTo(&mopts))
+
+	// then interpret them from a descriptor
+	optsFd, svcFd := createOptionsFiles(t, &mopts)
+	mtd := svcFd.FindService("svc.Svc").GetMethods()[0]
+	opts, err := GetCustomOptions(mtd)
+	ok(t, err)
+	eq(t, 2, len(opts))
+	eq(t, "admin", opts[optsFd.FindExtensionByName("opts.role")])
+	limits := opts[optsFd.FindExtensionByName("opts.limits")].([]interface{})
+	eq(t, 2, len(limits))
+	eq(t, int32(10), limits[0])
+	eq(t, int32(20), limits[1])
+
+	v, err := GetCustomOption(mtd, "opts.role")
+	ok(t, err)
+	eq(t, "admin", v)
+	// unset options have their default value
+	v, err = GetCustomOption(mtd, "opts.level")
+	ok(t, err)
+	eq(t, int32(0), v)
+	_, err = GetCustomOption(mtd, "opts.foo")
+	eq(t, true, err != nil)
+
+	dm, err = InterpretOptions(mtd)
+	ok(t, err)
+	eq(t, true, dm.GetFieldByName("deprecated"))
+
+	// elements without options
+	opts, err = GetCustomOptions(svcFd.FindService("svc.Svc"))
+	ok(t, err)
+	eq(t, 0, len(opts))
+}
+
+func TestInterpretOptionsUninterpreted(t *testing.T) {
+	name := func(parts ...string) []*dpb.UninterpretedOption_NamePart {
+		var ret []*dpb.UninterpretedOption_NamePart
+		for i, p := range parts {
+			ret = append(ret, &dpb.UninterpretedOption_NamePart{NamePart: proto.String(p), IsExtension: proto.Bool(i == 0)})
+		}
+		return ret
+	}
+	mopts := &dpb.MethodOptions{
+		UninterpretedOption: []*dpb.UninterpretedOption{
+			{Name: name("opts.level"), IdentifierValue: proto.String("HIGH")},
+			{Name: name(".opts.limits"), PositiveIntValue: proto.Uint64(5)},
+			{Name: name("opts.limits"), NegativeIntValue: proto.Int64(-5)},
+			{Name: name("opts.policy", "rate"), PositiveIntValue: proto.Uint64(100)},
+			{Name: name("opts.policy", "role"), StringValue: []byte("admin")},
+		},
+	}
+	optsFd, svcFd := createOptionsFiles(t, mopts)
+	mtd := svcFd.FindService("svc.Svc").GetMethods()[0]
+	dm, err := InterpretOptions(mtd)
+	ok(t, err)
+	eq(t, 0, dm.FieldLengthByName("uninterpreted_option"))
+	eq(t, int32(1), dm.GetField(optsFd.FindExtensionByName("opts.level")))
+	limits := dm.GetField(optsFd.FindExtensionByName("opts.limits")).([]interface{})
+	eq(t, 2, len(limits))
+	eq(t, int32(5), limits[0])
+	eq(t, int32(-5), limits[1])
+	policy := dm.GetField(optsFd.FindExtensionByName("opts.policy")).(*Message)
+	eq(t, int32(100), policy.GetFieldByName("rate"))
+	eq(t, "admin", policy.GetFieldByName("role"))
+
+	// aggregate values for message options
+	mopts = &dpb.MethodOptions{
+		UninterpretedOption: []*dpb.UninterpretedOption{
+			{Name: name("opts.policy"), AggregateValue: proto.String(`role: "root" rate: 3`)},
+		},
+	}
+	optsFd, svcFd = createOptionsFiles(t, mopts)
+	mtd = svcFd.FindService("svc.Svc").GetMethods()[0]
+	v, err := GetCustomOption(mtd, "opts.policy")
+	ok(t, err)
+	eq(t, "root", v.(*Message).GetFieldByName("role"))
+	eq(t, int32(3), v.(*Message).GetFieldByName("rate"))
+
+	// errors
+	for _, uo := range []*dpb.UninterpretedOption{
+		{Name: name("opts.foo"), IdentifierValue: proto.String("HIGH")},
+		{Name: name("opts.level"), IdentifierValue: proto.String("MEDIUM")},
+		{Name: name("opts.role"), PositiveIntValue: proto.Uint64(1)},
+		{Name: name("opts.policy", "rate"), PositiveIntValue: proto.Uint64(1 << 40)},
+		{Name: name("opts.role", "rate"), PositiveIntValue: proto.Uint64(1)},
+	} {
+		_, svcFd = createOptionsFiles(t, &dpb.MethodOptions{UninterpretedOption: []*dpb.UninterpretedOption{uo}})
+		_, err := InterpretOptions(svcFd.FindService("svc.Svc").GetMethods()[0])
+		eq(t, true, err != nil, "option %s", optionName(uo))
+	}
+}
@@ -0,0 +1,1001 @@
+package dynamic
+
+// Marshalling and unmarshalling of dynamic messages to/from proto's standard text format
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/jhump/protoreflect/desc"
+)
+
+// MarshalText serializes this message to bytes in the standard text format,
+// returning an error if the operation fails. The resulting bytes will be a
+// valid UTF8 string.
+//
+// This method uses a compact form: no newlines, and spaces between field
+// identifiers and values are elided. The output is the same as that of
+// proto.CompactTextString for a generated message of the same type (with the
+// exception that google.protobuf.Any messages are expanded, as described
+// below).
+//
+// Values of google.protobuf.Any fields are written in the expanded form, with
+// the type URL in brackets followed by the embedded message, if the embedded
+// message type can be resolved. A type is resolved by searching the file that
+// defines this message, and its dependencies, and then the message types
+// that are linked into the current program.
+func (m *Message) MarshalText() ([]byte, error) {
+	var w textWriter
+	w.compact = true
+	w.complete = true
+	if err := w.writeMessage(m); err != nil {
+		return nil, err
+	}
+	return w.buf, nil
+}
+
+// MarshalTextIndent serializes this message to bytes in the standard text
+// format, returning an error if the operation fails. The resulting bytes will be
+// a valid UTF8 string.
+//
+// This method uses a "pretty-printed" form, with each field on its own line and
+// spaces between field identifiers and values. Nested messages are indented by
+// two spaces. The output is the same as that of proto.MarshalTextString for a
+// generated message of the same type (except for the expansion of Any messages;
+// see MarshalText).
+func (m *Message) MarshalTextIndent() ([]byte, error) {
+	var w textWriter
+	w.complete = true
+	if err := w.writeMessage(m); err != nil {
+		return nil, err
+	}
+	return w.buf, nil
+}
+
+// textWriter writes the text format. It tracks indentation so that each line
+// is indented according to its nesting depth. In compact mode, newlines are
+// replaced by spaces.
+type textWriter struct {
+	compact  bool // use compact text format (one line)
+	complete bool // whether the current position is a complete line
+	indent   int  // indentation level; never negative
+	buf      []byte
+}
+
+func (w *textWriter) write(s string) {
+	newlines := strings.Count(s, "\n")
+	if newlines == 0 {
+		if !w.compact && w.complete {
+			w.writeIndent()
+		}
+		w.buf = append(w.buf, s...)
+		w.complete = false
+		return
+	}
+
+	frags := strings.SplitN(s, "\n", newlines+1)
+	if w.compact {
+		for i, frag := range frags {
+			if i > 0 {
+				w.buf = append(w.buf, ' ')
+			}
+			w.buf = append(w.buf, frag...)
+		}
+		return
+	}
+
+	for i, frag := range frags {
+		if w.complete {
+			w.writeIndent()
+		}
+		w.buf = append(w.buf, frag...)
+		if i+1 < len(frags) {
+			w.buf = append(w.buf, '\n')
+		}
+	}
+	w.complete = len(frags[len(frags)-1]) == 0
+}
+
+func (w *textWriter) writeByte(c byte) {
+	if w.compact && c == '\n' {
+		c = ' '
+	}
+	if !w.compact && w.complete {
+		w.writeIndent()
+	}
+	w.buf = append(w.buf, c)
+	w.complete = c == '\n'
+}
+
+func (w *textWriter) writeIndent() {
+	if !w.complete {
+		return
+	}
+	for i := 0; i < w.indent*2; i++ {
+		w.buf = append(w.buf, ' ')
+	}
+	w.complete = false
+}
+
+func (w *textWriter) writeName(fd *desc.FieldDescriptor) {
+	if !w.compact && w.complete {
+		w.writeIndent()
+	}
+	w.complete = false
+
+	if fd.GetType() != dpb.FieldDescriptorProto_TYPE_GROUP {
+		w.buf = append(w.buf, fd.GetName()...)
+		w.writeByte(':')
+	} else {
+		// use message type name for group field name
+		w.buf = append(w.buf, fd.GetMessageType().GetName()...)
+	}
+
+	if !w.compact {
+		w.writeByte(' ')
+	}
+}
+
+func (w *textWriter) writeMessage(m *Message) error {
+	if m.md.GetFullyQualifiedName() == "google.protobuf.Any" {
+		if expanded, err := w.writeExpandedAny(m); expanded {
+			return err
+		}
+	}
+
+	for _, fd := range m.md.GetFields() {
+		if !m.HasField(fd) {
+			continue
+		}
+		v := m.GetField(fd)
+		switch {
+		case fd.IsMap():
+			mp := v.(map[interface{}]interface{})
+			keyFd := fd.GetMessageType().FindFieldByNumber(1)
+			valFd := fd.GetMessageType().FindFieldByNumber(2)
+			for _, k := range sortedMapKeys(mp) {
+				w.writeName(fd)
+				w.writeByte('<')
+				if !w.compact {
+					w.writeByte('\n')
+				}
+				w.indent++
+				w.writeName(keyFd)
+				if err := w.writeSingularValue(keyFd, k); err != nil {
+					return err
+				}
+				w.writeByte('\n')
+				w.writeName(valFd)
+				if err := w.writeSingularValue(valFd, mp[k]); err != nil {
+					return err
+				}
+				w.writeByte('\n')
+				w.indent--
+				w.writeByte('>')
+				w.writeByte('\n')
+			}
+		case fd.IsRepeated():
+			for _, item := range v.([]interface{}) {
+				w.writeName(fd)
+				if err := w.writeSingularValue(fd, item); err != nil {
+					return err
+				}
+				w.writeByte('\n')
+			}
+		default:
+			w.writeName(fd)
+			if err := w.writeSingularValue(fd, v); err != nil {
+				return err
+			}
+			w.writeByte('\n')
+		}
+	}
+
+	if len(m.unknownFields) > 0 {
+		var b codedBuffer
+		if err := m.marshalUnknownFields(&b); err != nil {
+			return err
+		}
+		w.writeUnknownFields(b.buf)
+	}
+
+	exts := m.GetKnownExtensions()
+	sort.Sort(fieldsByNumber(exts))
+	for _, fd := range exts {
+		if !m.HasField(fd) {
+			continue
+		}
+		v := m.GetField(fd)
+		if fd.IsRepeated() {
+			for _, item := range v.([]interface{}) {
+				if err := w.writeExtension(fd, item); err != nil {
+					return err
+				}
+			}
+		} else if err := w.writeExtension(fd, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *textWriter) writeExtension(fd *desc.FieldDescriptor, v interface{}) error {
+	w.write(fmt.Sprintf("[%s]:", fd.GetFullyQualifiedName()))
+	if !w.compact {
+		w.writeByte(' ')
+	}
+	if err := w.writeSingularValue(fd, v); err != nil {
+		return err
+	}
+	w.writeByte('\n')
+	return nil
+}
+
+// writeExpandedAny writes the given google.protobuf.Any message in expanded
+// form. It returns false, and writes nothing, if the embedded message type
+// cannot be resolved or the embedded message cannot be de-serialized.
+func (w *textWriter) writeExpandedAny(m *Message) (bool, error) {
+	typeUrl := m.GetFieldByNumber(1).(string)
+	msg, err := m.resolveAny(typeUrl, nil)
+	if err != nil {
+		return false, nil
+	}
+	if err := msg.Unmarshal(m.GetFieldByNumber(2).([]byte)); err != nil {
+		return false, nil
+	}
+
+	w.write("[")
+	if requiresQuotes(typeUrl) {
+		w.writeQuotedString(typeUrl)
+	} else {
+		w.write(typeUrl)
+	}
+	if w.compact {
+		w.write("]:<")
+	} else {
+		w.write("]: <\n")
+		w.indent++
+	}
+	if err := w.writeMessage(msg); err != nil {
+		return true, err
+	}
+	if w.compact {
+		w.write("> ")
+	} else {
+		w.indent--
+		w.write(">\n")
+	}
+	return true, nil
+}
+
+// requiresQuotes returns true if the given type URL contains any characters
+// other than [0-9A-Za-z./_], in which case it must be quoted.
+func requiresQuotes(u string) bool {
+	for _, ch := range u {
+		switch {
+		case ch == '.' || ch == '/' || ch == '_':
+			continue
+		case '0' <= ch && ch <= '9':
+			continue
+		case 'A' <= ch && ch <= 'Z':
+			continue
+		case 'a' <= ch && ch <= 'z':
+			continue
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+func (w *textWriter) writeSingularValue(fd *desc.FieldDescriptor, v interface{}) error {
+	switch fd.GetType() {
+	case dpb.FieldDescriptorProto_TYPE_FLOAT, dpb.FieldDescriptorProto_TYPE_DOUBLE:
+		var f float64
+		if f32, ok := v.(float32); ok {
+			f = float64(f32)
+		} else {
+			f = v.(float64)
+		}
+		switch {
+		case math.IsInf(f, 1):
+			w.write("inf")
+		case math.IsInf(f, -1):
+			w.write("-inf")
+		case math.IsNaN(f):
+			w.write("nan")
+		default:
+			w.write(fmt.Sprint(v))
+		}
+
+	case dpb.FieldDescriptorProto_TYPE_STRING:
+		w.writeQuotedString(v.(string))
+
+	case dpb.FieldDescriptorProto_TYPE_BYTES:
+		w.writeQuotedString(string(v.([]byte)))
+
+	case dpb.FieldDescriptorProto_TYPE_MESSAGE, dpb.FieldDescriptorProto_TYPE_GROUP:
+		var bra, ket byte = '<', '>'
+		if fd.GetType() == dpb.FieldDescriptorProto_TYPE_GROUP {
+			bra, ket = '{', '}'
+		}
+		dm, err := asDynamicMessage(v.(proto.Message), fd.GetMessageType(), nil)
+		if err != nil {
+			return err
+		}
+		w.writeByte(bra)
+		if !w.compact {
+			w.writeByte('\n')
+		}
+		w.indent++
+		if err := w.writeMessage(dm); err != nil {
+			return err
+		}
+		w.indent--
+		w.writeByte(ket)
+
+	case dpb.FieldDescriptorProto_TYPE_ENUM:
+		num := v.(int32)
+		if vd := fd.GetEnumType().FindValueByNumber(num); vd != nil {
+			w.write(vd.GetName())
+		} else {
+			w.write(strconv.Itoa(int(num)))
+		}
+
+	default:
+		w.write(fmt.Sprint(v))
+	}
+	return nil
+}
+
+// writeQuotedString writes a quoted string in the protocol buffer text format.
+func (w *textWriter) writeQuotedString(s string) {
+	w.writeByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\n':
+			w.buf = append(w.buf, `\n`...)
+		case '\r':
+			w.buf = append(w.buf, `\r`...)
+		case '\t':
+			w.buf = append(w.buf, `\t`...)
+		case '"':
+			w.buf = append(w.buf, `\"`...)
+		case '\\':
+			w.buf = append(w.buf, `\\`...)
+		default:
+			if c >= 0x20 && c < 0x7f {
+				w.buf = append(w.buf, c)
+			} else {
+				w.buf = append(w.buf, fmt.Sprintf(`\%03o`, c)...)
+			}
+		}
+	}
+	w.writeByte('"')
+}
+
+// writeUnknownFields writes the given bytes, which are unknown fields in the
+// binary format, using field numbers in place of field names.
+func (w *textWriter) writeUnknownFields(b []byte) {
+	if !w.compact {
+		w.write(fmt.Sprintf("/* %d unknown bytes */\n", len(b)))
+	}
+
+	buf := newCodedBuffer(b)
+	for !buf.eof() {
+		tag, wireType, err := buf.decodeTagAndWireType()
+		if err != nil {
+			return
+		}
+
+		if wireType == proto.WireEndGroup {
+			w.indent--
+			w.write("}\n")
+			continue
+		}
+		w.write(strconv.Itoa(int(tag)))
+		if wireType != proto.WireStartGroup {
+			w.writeByte(':')
+		}
+		if !w.compact || wireType == proto.WireStartGroup {
+			w.writeByte(' ')
+		}
+		switch wireType {
+		case proto.WireVarint:
+			v, err := buf.decodeVarint()
+			if err != nil {
+				return
+			}
+			w.write(strconv.FormatUint(v, 10))
+		case proto.WireFixed32:
+			v, err := buf.decodeFixed32()
+			if err != nil {
+				return
+			}
+			w.write(strconv.FormatUint(v, 10))
+		case proto.WireFixed64:
+			v, err := buf.decodeFixed64()
+			if err != nil {
+				return
+			}
+			w.write(strconv.FormatUint(v, 10))
+		case proto.WireBytes:
+			v, err := buf.decodeRawBytes(false)
+			if err != nil {
+				return
+			}
+			w.write(fmt.Sprintf("%q", v))
+		case proto.WireStartGroup:
+			w.writeByte('{')
+			w.indent++
+		default:
+			w.write(fmt.Sprintf("/* unknown wire type %d */", wireType))
+		}
+		w.writeByte('\n')
+	}
+}
+
+// UnmarshalText de-serializes the message that is present, in text format, in
+// the given bytes into this message. It first resets the current message. It
+// returns an error if the given bytes do not contain a valid encoding of this
+// message type in the standard text format.
+//
+// Extension fields are identified by their fully-qualified name in brackets,
+// for example "[foo.bar.baz]: 123". Such extensions must be known to this
+// message, either because they have already been set on the message or
+// because they are present in the message's ExtensionRegistry. For
+// google.protobuf.Any messages, the expanded form, with the type URL in
+// brackets followed by the text of the embedded message, is also accepted.
+func (m *Message) UnmarshalText(text []byte) error {
+	m.Reset()
+	return m.UnmarshalMergeText(text)
+}
+
+// UnmarshalMergeText de-serializes the message that is present, in text format,
+// in the given bytes into this message. Unlike UnmarshalText, it does not first
+// reset the message, instead merging the data in the given bytes into the
+// existing data in this message.
+func (m *Message) UnmarshalMergeText(text []byte) error {
+	return m.unmarshalText(newTextReader(text), "")
+}
+
+func (m *Message) unmarshalText(tr *textReader, end string) error {
+	for {
+		tok, err := tr.next()
+		if err != nil {
+			return err
+		}
+		if tok.kind == tokenEOF {
+			if end != "" {
+				return tr.errorf(tok, "unexpected EOF; expecting %q", end)
+			}
+			return nil
+		}
+		if end != "" && tok.is(end) {
+			return nil
+		}
+
+		var fd *desc.FieldDescriptor
+		switch {
+		case tok.is("["):
+			name, err := tr.readBracketedName()
+			if err != nil {
+				return err
+			}
+			if strings.Contains(name, "/") {
+				if m.md.GetFullyQualifiedName() != "google.protobuf.Any" {
+					return tr.errorf(tok, "type URL %q can only be used with google.protobuf.Any, not %s", name, m.md.GetFullyQualifiedName())
+				}
+				if err := m.unmarshalExpandedAny(tr, name); err != nil {
+					return err
+				}
+				if err := tr.skipSeparator(); err != nil {
+					return err
+				}
+				continue
+			}
+			fd = m.FindFieldDescriptorByName(name)
+			if fd == nil || !fd.IsExtension() {
+				return tr.errorf(tok, "unrecognized extension %q for message %s", name, m.md.GetFullyQualifiedName())
+			}
+		case tok.kind == tokenIdent:
+			fd = m.md.FindFieldByName(tok.text)
+			if fd == nil {
+				// groups are named by their message type name
+				for _, f := range m.md.GetFields() {
+					if f.GetType() == dpb.FieldDescriptorProto_TYPE_GROUP && f.GetMessageType().GetName() == tok.text {
+						fd = f
+						break
+					}
+				}
+			}
+			if fd == nil {
+				return tr.errorf(tok, "unknown field %q in message %s", tok.text, m.md.GetFullyQualifiedName())
+			}
+		default:
+			return tr.errorf(tok, "unexpected %s; expecting field name", tok)
+		}
+
+		if err := m.unmarshalTextField(tr, fd); err != nil {
+			return err
+		}
+		if err := tr.skipSeparator(); err != nil {
+			return err
+		}
+	}
+}
+
+func (m *Message) unmarshalTextField(tr *textReader, fd *desc.FieldDescriptor) error {
+	tok, err := tr.peek()
+	if err != nil {
+		return err
+	}
+	isMessage := fd.GetMessageType() != nil
+	if tok.is(":") {
+		tr.next()
+		if tok, err = tr.peek(); err != nil {
+			return err
+		}
+	} else if !isMessage {
+		return tr.errorf(tok, "unexpected %s; expecting ':'", tok)
+	}
+
+	if tok.is("[") && fd.IsRepeated() {
+		// list of values
+		tr.next()
+		if tok, err = tr.peek(); err != nil {
+			return err
+		}
+		if tok.is("]") {
+			tr.next()
+			return nil
+		}
+		for {
+			if err := m.unmarshalTextValue(tr, fd); err != nil {
+				return err
+			}
+			tok, err := tr.next()
+			if err != nil {
+				return err
+			}
+			if tok.is("]") {
+				return nil
+			}
+			if !tok.is(",") {
+				return tr.errorf(tok, "unexpected %s; expecting ',' or ']'", tok)
+			}
+		}
+	}
+	return m.unmarshalTextValue(tr, fd)
+}
+
+func (m *Message) unmarshalTextValue(tr *textReader, fd *desc.FieldDescriptor) error {
+	if fd.GetMessageType() == nil {
+		v, err := tr.readScalar(fd)
+		if err != nil {
+			return err
+		}
+		if fd.IsRepeated() {
+			return m.TryAddRepeatedField(fd, v)
+		}
+		return m.TrySetField(fd, v)
+	}
+
+	tok, err := tr.next()
+	if err != nil {
+		return err
+	}
+	var end string
+	switch {
+	case tok.is("{"):
+		end = "}"
+	case tok.is("<"):
+		end = ">"
+	default:
+		return tr.errorf(tok, "unexpected %s; expecting '{' or '<'", tok)
+	}
+
+	if !fd.IsRepeated() {
+		// merge into an existing message if there is one
+		if existing, ok := m.values[fd.GetNumber()].(*Message); ok {
+			return existing.unmarshalText(tr, end)
+		}
+	}
+	msg := m.newMessage(fd.GetMessageType())
+	if err := msg.unmarshalText(tr, end); err != nil {
+		return err
+	}
+	switch {
+	case fd.IsMap():
+		k := msg.GetFieldByNumber(1)
+		v := msg.GetFieldByNumber(2)
+		if v == nil {
+			v = m.newMessage(fd.GetMessageType().FindFieldByNumber(2).GetMessageType())
+		}
+		return m.TryPutMapField(fd, k, v)
+	case fd.IsRepeated():
+		return m.TryAddRepeatedField(fd, msg)
+	default:
+		return m.TrySetField(fd, msg)
+	}
+}
+
+func (m *Message) unmarshalExpandedAny(tr *textReader, typeUrl string) error {
+	msg, err := m.resolveAny(typeUrl, nil)
+	if err != nil {
+		return err
+	}
+	tok, err := tr.next()
+	if err != nil {
+		return err
+	}
+	if tok.is(":") {
+		if tok, err = tr.next(); err != nil {
+			return err
+		}
+	}
+	var end string
+	switch {
+	case tok.is("{"):
+		end = "}"
+	case tok.is("<"):
+		end = ">"
+	default:
+		return tr.errorf(tok, "unexpected %s; expecting '{' or '<'", tok)
+	}
+	if err := msg.unmarshalText(tr, end); err != nil {
+		return err
+	}
+	b, err := msg.Marshal()
+	if err != nil {
+		return err
+	}
+	m.SetFieldByNumber(1, typeUrl)
+	m.SetFieldByNumber(2, b)
+	return nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenPunct
+)
+
+type textToken struct {
+	kind      tokenKind
+	text      string
+	line, col int
+}
+
+func (t textToken) is(punct string) bool {
+	return t.kind == tokenPunct && t.text == punct
+}
+
+func (t textToken) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "EOF"
+	case tokenString:
+		return fmt.Sprintf("string %q", t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+// textReader tokenizes input in the standard text format.
+type textReader struct {
+	data      []byte
+	pos       int
+	line, col int
+	peeked    *textToken
+}
+
+func newTextReader(data []byte) *textReader {
+	return &textReader{data: data, line: 1, col: 1}
+}
+
+func (tr *textReader) errorf(tok textToken, format string, args ...interface{}) error {
+	return fmt.Errorf("line %d, col %d: %s", tok.line, tok.col, fmt.Sprintf(format, args...))
+}
+
+func (tr *textReader) peek() (textToken, error) {
+	if tr.peeked == nil {
+		tok, err := tr.scan()
+		if err != nil {
+			return textToken{}, err
+		}
+		tr.peeked = &tok
+	}
+	return *tr.peeked, nil
+}
+
+func (tr *textReader) next() (textToken, error) {
+	if tr.peeked != nil {
+		tok := *tr.peeked
+		tr.peeked = nil
+		return tok, nil
+	}
+	return tr.scan()
+}
+
+func (tr *textReader) advance() byte {
+	c := tr.data[tr.pos]
+	tr.pos++
+	if c == '\n' {
+		tr.line++
+		tr.col = 1
+	} else {
+		tr.col++
+	}
+	return c
+}
+
+func (tr *textReader) scan() (textToken, error) {
+	// skip whitespace and comments
+	for tr.pos < len(tr.data) {
+		c := tr.data[tr.pos]
+		if c == '#' {
+			for tr.pos < len(tr.data) && tr.data[tr.pos] != '\n' {
+				tr.advance()
+			}
+		} else if unicode.IsSpace(rune(c)) {
+			tr.advance()
+		} else {
+			break
+		}
+	}
+
+	tok := textToken{line: tr.line, col: tr.col}
+	if tr.pos >= len(tr.data) {
+		tok.kind = tokenEOF
+		return tok, nil
+	}
+
+	start := tr.pos
+	c := tr.advance()
+	switch {
+	case c == '_' || unicode.IsLetter(rune(c)):
+		for tr.pos < len(tr.data) && isIdentChar(tr.data[tr.pos]) {
+			tr.advance()
+		}
+		tok.kind = tokenIdent
+		tok.text = string(tr.data[start:tr.pos])
+
+	case (c >= '0' && c <= '9') || (c == '.' && tr.pos < len(tr.data) && tr.data[tr.pos] >= '0' && tr.data[tr.pos] <= '9'):
+		for tr.pos < len(tr.data) {
+			n := tr.data[tr.pos]
+			if isIdentChar(n) || n == '.' {
+				tr.advance()
+			} else if (n == '-' || n == '+') && (tr.data[tr.pos-1] == 'e' || tr.data[tr.pos-1] == 'E') {
+				tr.advance()
+			} else {
+				break
+			}
+		}
+		tok.kind = tokenNumber
+		tok.text = string(tr.data[start:tr.pos])
+
+	case c == '"' || c == '\'':
+		for {
+			if tr.pos >= len(tr.data) || tr.data[tr.pos] == '\n' {
+				return tok, tr.errorf(tok, "unterminated string")
+			}
+			n := tr.advance()
+			if n == c {
+				break
+			}
+			if n == '\\' && tr.pos < len(tr.data) {
+				tr.advance()
+			}
+		}
+		b, err := desc.UnescapeBytes(string(tr.data[start+1 : tr.pos-1]))
+		if err != nil {
+			return tok, tr.errorf(tok, "%v", err)
+		}
+		tok.kind = tokenString
+		tok.text = string(b)
+
+	default:
+		tok.kind = tokenPunct
+		tok.text = string(c)
+	}
+	return tok, nil
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// skipSeparator consumes an optional ',' or ';' that follows a field value.
+func (tr *textReader) skipSeparator() error {
+	tok, err := tr.peek()
+	if err != nil {
+		return err
+	}
+	if tok.is(",") || tok.is(";") {
+		tr.next()
+	}
+	return nil
+}
+
+// readBracketedName reads the name of an extension or the type URL of an
+// expanded Any message. The opening bracket has already been consumed.
+func (tr *textReader) readBracketedName() (string, error) {
+	var buf bytes.Buffer
+	for {
+		tok, err := tr.next()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case tok.is("]"):
+			if buf.Len() == 0 {
+				return "", tr.errorf(tok, "unexpected ']'; expecting name")
+			}
+			return buf.String(), nil
+		case tok.kind == tokenIdent, tok.kind == tokenNumber, tok.is("."), tok.is("/"), tok.is("-"):
+			buf.WriteString(tok.text)
+		case tok.kind == tokenString:
+			// type URLs with unusual characters are quoted
+			buf.WriteString(tok.text)
+		default:
+			return "", tr.errorf(tok, "unexpected %s in bracketed name", tok)
+		}
+	}
+}
+
+// readScalar reads a scalar value for the given field, converting it to the
+// appropriate Go type.
+func (tr *textReader) readScalar(fd *desc.FieldDescriptor) (interface{}, error) {
+	tok, err := tr.next()
+	if err != nil {
+		return nil, err
+	}
+
+	if fd.GetType() == dpb.FieldDescriptorProto_TYPE_STRING || fd.GetType() == dpb.FieldDescriptorProto_TYPE_BYTES {
+		if tok.kind != tokenString {
+			return nil, tr.errorf(tok, "unexpected %s; expecting string for field %s", tok, fd.GetFullyQualifiedName())
+		}
+		// adjacent strings are concatenated
+		s := tok.text
+		for {
+			next, err := tr.peek()
+			if err != nil {
+				return nil, err
+			}
+			if next.kind != tokenString {
+				break
+			}
+			tr.next()
+			s += next.text
+		}
+		if fd.GetType() == dpb.FieldDescriptorProto_TYPE_BYTES {
+			return []byte(s), nil
+		}
+		return s, nil
+	}
+
+	first := tok
+	neg := false
+	if tok.is("-") {
+		neg = true
+		if tok, err = tr.next(); err != nil {
+			return nil, err
+		}
+	}
+	if tok.kind != tokenIdent && tok.kind != tokenNumber {
+		return nil, tr.errorf(tok, "unexpected %s; expecting value for field %s", tok, fd.GetFullyQualifiedName())
+	}
+	s := tok.text
+	if neg {
+		s = "-" + s
+	}
+
+	badValue := func() error {
+		return tr.errorf(first, "invalid value %q for %s field %s", s, fieldTypeName(fd), fd.GetFullyQualifiedName())
+	}
+
+	switch fd.GetType() {
+	case dpb.FieldDescriptorProto_TYPE_ENUM:
+		if tok.kind == tokenIdent && !neg {
+			if vd := fd.GetEnumType().FindValueByName(s); vd != nil {
+				return vd.GetNumber(), nil
+			}
+			return nil, tr.errorf(first, "unknown value %q for enum %s", s, fd.GetEnumType().GetFullyQualifiedName())
+		}
+		i, err := strconv.ParseInt(s, 0, 32)
+		if err != nil {
+			return nil, badValue()
+		}
+		return int32(i), nil
+
+	case dpb.FieldDescriptorProto_TYPE_BOOL:
+		switch s {
+		case "true", "True", "t", "1":
+			return true, nil
+		case "false", "False", "f", "0":
+			return false, nil
+		}
+		return nil, badValue()
+
+	case dpb.FieldDescriptorProto_TYPE_FLOAT, dpb.FieldDescriptorProto_TYPE_DOUBLE:
+		bits := 64
+		if fd.GetType() == dpb.FieldDescriptorProto_TYPE_FLOAT {
+			bits = 32
+		}
+		var f float64
+		switch strings.ToLower(tok.text) {
+		case "inf", "infinity":
+			f = math.Inf(1)
+		case "nan":
+			f = math.NaN()
+		default:
+			if tok.kind != tokenNumber {
+				return nil, badValue()
+			}
+			num := s
+			if !strings.HasPrefix(strings.ToLower(strings.TrimPrefix(num, "-")), "0x") {
+				num = strings.TrimRight(num, "fF")
+			}
+			if f, err = strconv.ParseFloat(num, bits); err != nil {
+				return nil, badValue()
+			}
+		}
+		if neg && (math.IsInf(f, 1) || math.IsNaN(f)) {
+			f = -f
+		}
+		if bits == 32 {
+			return float32(f), nil
+		}
+		return f, nil
+
+	case dpb.FieldDescriptorProto_TYPE_INT32,
+		dpb.FieldDescriptorProto_TYPE_SINT32,
+		dpb.FieldDescriptorProto_TYPE_SFIXED32:
+		i, err := strconv.ParseInt(s, 0, 32)
+		if err != nil {
+			return nil, badValue()
+		}
+		return int32(i), nil
+
+	case dpb.FieldDescriptorProto_TYPE_INT64,
+		dpb.FieldDescriptorProto_TYPE_SINT64,
+		dpb.FieldDescriptorProto_TYPE_SFIXED64:
+		i, err := strconv.ParseInt(s, 0, 64)
+		if err != nil {
+			return nil, badValue()
+		}
+		return i, nil
+
+	case dpb.FieldDescriptorProto_TYPE_UINT32,
+		dpb.FieldDescriptorProto_TYPE_FIXED32:
+		u, err := strconv.ParseUint(s, 0, 32)
+		if err != nil {
+			return nil, badValue()
+		}
+		return uint32(u), nil
+
+	case dpb.FieldDescriptorProto_TYPE_UINT64,
+		dpb.FieldDescriptorProto_TYPE_FIXED64:
+		u, err := strconv.ParseUint(s, 0, 64)
+		if err != nil {
+			return nil, badValue()
+		}
+		return u, nil
+
+	default:
+		return nil, fmt.Errorf("unrecognized field type: %v", fd.GetType())
+	}
+}
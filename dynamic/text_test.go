@@ -0,0 +1,125 @@
+package dynamic
+
+import (
+	"math"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/duration"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/desc_test"
+)
+
+func TestTextMatchesProto(t *testing.T) {
+	dne := desc_test.TestMessage_NestedMessage_AnotherNestedMessage_YetAnotherNestedMessage_VALUE2
+	atm := &desc_test.AnotherTestMessage{
+		Dne:       &dne,
+		MapField1: map[int32]string{1: "one", 2: "two\n\"quoted\""},
+		MapField2: map[int64]float32{-1: 1.5, 100: float32(math.Inf(-1))},
+		MapField3: map[uint32]bool{1: true},
+		MapField4: map[string]*desc_test.AnotherTestMessage{"a": {MapField1: map[int32]string{4: "four"}}},
+		Rocknroll: &desc_test.AnotherTestMessage_RockNRoll{Beatles: proto.String("abc"), Stones: proto.String("\x00\xff")},
+	}
+	ok(t, proto.SetExtension(atm, desc_test.E_Xs, proto.String("ext")))
+	ok(t, proto.SetExtension(atm, desc_test.E_Xui, proto.Uint64(math.MaxUint64)))
+	frob := &desc_test.Frobnitz{
+		A:   &desc_test.TestMessage{Ne: []desc_test.TestMessage_NestedEnum{desc_test.TestMessage_VALUE1, desc_test.TestMessage_VALUE2}},
+		Def: &desc_test.Frobnitz_G2{G2: -12345},
+		F:   []string{"x", "y"},
+	}
+	anyDur, err := marshalAny(&duration.Duration{Seconds: 3, Nanos: 500000000})
+	ok(t, err)
+
+	compact := proto.TextMarshaler{Compact: true, ExpandAny: true}
+	pretty := proto.TextMarshaler{ExpandAny: true}
+	for _, msg := range []proto.Message{atm, frob, anyDur} {
+		md, err := desc.LoadMessageDescriptorForMessage(msg)
+		ok(t, err)
+		dm := NewMessageWithExtensionRegistry(md, NewExtensionRegistryWithDefaults())
+		ok(t, dm.ConvertFrom(msg))
+
+		b, err := dm.MarshalText()
+		ok(t, err)
+		eq(t, compact.Text(msg), string(b), md.GetName())
+		eq(t, compact.Text(msg), dm.String(), md.GetName())
+
+		b, err = dm.MarshalTextIndent()
+		ok(t, err)
+		eq(t, pretty.Text(msg), string(b), md.GetName())
+
+		// and back again
+		dm2 := NewMessageWithExtensionRegistry(md, NewExtensionRegistryWithDefaults())
+		ok(t, dm2.UnmarshalText(b))
+		msg2 := proto.Clone(msg)
+		ok(t, dm2.ConvertTo(msg2))
+		eq(t, true, proto.Equal(msg, msg2), md.GetName())
+	}
+}
+
+func TestTextUnknownFields(t *testing.T) {
+	md, err := desc.LoadMessageDescriptor("desc_test.TestMessage")
+	ok(t, err)
+	var buf codedBuffer
+	buf.encodeTagAndWireType(4, proto.WireVarint)
+	buf.encodeVarint(2)
+	buf.encodeTagAndWireType(20, proto.WireFixed32)
+	buf.encodeFixed32(1234)
+	buf.encodeTagAndWireType(21, proto.WireStartGroup)
+	buf.encodeTagAndWireType(1, proto.WireBytes)
+	buf.encodeRawBytes([]byte("nested"))
+	buf.encodeTagAndWireType(21, proto.WireEndGroup)
+
+	dm := NewMessage(md)
+	ok(t, dm.Unmarshal(buf.buf))
+	var tm desc_test.TestMessage
+	ok(t, proto.Unmarshal(buf.buf, &tm))
+
+	eq(t, proto.CompactTextString(&tm), dm.String())
+	b, err := dm.MarshalTextIndent()
+	ok(t, err)
+	eq(t, proto.MarshalTextString(&tm), string(b))
+}
+
+func TestTextLenientParsing(t *testing.T) {
+	fd, err := desc.LoadFileDescriptor("desc_test1.proto")
+	ok(t, err)
+	md := fd.FindMessage("desc_test.AnotherTestMessage")
+	er := &ExtensionRegistry{}
+	er.AddExtensionsFromFile(fd)
+	dm := NewMessageWithExtensionRegistry(md, er)
+
+	text := `
+	# comments are ignored
+	dne: 2;
+	map_field1 { key: 1 value: 'one' "-uno" },
+	map_field2: [ { key: -1 value: -inf }, < key: 0x10 value: 2.5f > ]
+	rocknroll { beatles: "\x41\102\n" }
+	[desc_test.xi]: -0x10
+	[desc_test.xtm] < ne: [ VALUE1, 2 ] >`
+	ok(t, dm.UnmarshalText([]byte(text)))
+	eq(t, int32(2), dm.GetFieldByName("dne"))
+	eq(t, "one-uno", dm.GetMapFieldByName("map_field1", int32(1)))
+	eq(t, float32(math.Inf(-1)), dm.GetMapFieldByName("map_field2", int64(-1)))
+	eq(t, float32(2.5), dm.GetMapFieldByName("map_field2", int64(16)))
+	eq(t, "AB\n", dm.GetFieldByName("rocknroll").(*Message).GetFieldByName("beatles"))
+	eq(t, int32(-16), dm.GetFieldByName("desc_test.xi"))
+	eq(t, 2, dm.GetFieldByName("desc_test.xtm").(*Message).FieldLengthByName("ne"))
+
+	// group can also be referenced by its message type name
+	ok(t, dm.UnmarshalText([]byte(`RockNRoll { stones: "xyz" }`)))
+	eq(t, "xyz", dm.GetFieldByName("rocknroll").(*Message).GetFieldByName("stones"))
+
+	// merging
+	ok(t, dm.UnmarshalMergeText([]byte(`rocknroll { beatles: "abc" }`)))
+	eq(t, "xyz", dm.GetFieldByName("rocknroll").(*Message).GetFieldByName("stones"))
+	eq(t, "abc", dm.GetFieldByName("rocknroll").(*Message).GetFieldByName("beatles"))
+
+	// errors
+	eq(t, true, dm.UnmarshalText([]byte(`foo: 1`)) != nil)
+	eq(t, true, dm.UnmarshalText([]byte(`dne 1`)) != nil)
+	eq(t, true, dm.UnmarshalText([]byte(`dne: FOO`)) != nil)
+	eq(t, true, dm.UnmarshalText([]byte(`map_field1 { key: 1 `)) != nil)
+	eq(t, true, dm.UnmarshalText([]byte(`[desc_test.unknown]: 1`)) != nil)
+	eq(t, true, dm.UnmarshalText([]byte(`map_field3: { key: 1 value: "abc" }`)) != nil)
+}
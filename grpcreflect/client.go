@@ -1,6 +1,7 @@
 package grpcreflect
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
 	"runtime"
@@ -81,23 +82,12 @@ func NewClient(ctx context.Context, stub rpb.ServerReflectionClient) *Client {
 func (cr *Client) FileByFilename(filename string) (*desc.FileDescriptor, error) {
 	// hit the cache first
 	cr.cacheMu.RLock()
-	if fd, ok := cr.filesByName[filename]; ok {
-		cr.cacheMu.RUnlock()
-		return fd, nil
-	}
-	fdp, ok := cr.protosByName[filename]
+	fd, ok := cr.filesByName[filename]
 	cr.cacheMu.RUnlock()
-	// not there? see if we've downloaded the proto
 	if ok {
-		return cr.descriptorFromProto(fdp)
-	}
-
-	req := &rpb.ServerReflectionRequest{
-		MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{
-			FileByFilename: filename,
-		},
+		return fd, nil
 	}
-	return cr.getAndCacheFileDescriptors(req)
+	return cr.loadFile(filename)
 }
 
 // FileContainingSymbol asks the server for a file descriptor for the proto file
@@ -142,7 +132,35 @@ func (cr *Client) FileContainingExtension(extendedMessageName string, extensionN
 	return cr.getAndCacheFileDescriptors(req)
 }
 
+// FindFileProto asks the server for the descriptor proto for the file with
+// the given name. This method allows a Client to be used as a
+// desc.DescriptorSource.
+func (cr *Client) FindFileProto(filename string) (*dpb.FileDescriptorProto, error) {
+	// see if we've already downloaded the proto
+	cr.cacheMu.RLock()
+	fdp, ok := cr.protosByName[filename]
+	cr.cacheMu.RUnlock()
+	if ok {
+		return fdp, nil
+	}
+
+	req := &rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{
+			FileByFilename: filename,
+		},
+	}
+	return cr.getAndCacheFileProtos(req)
+}
+
 func (cr *Client) getAndCacheFileDescriptors(req *rpb.ServerReflectionRequest) (*desc.FileDescriptor, error) {
+	fdp, err := cr.getAndCacheFileProtos(req)
+	if err != nil {
+		return nil, err
+	}
+	return cr.loadFile(fdp.GetName())
+}
+
+func (cr *Client) getAndCacheFileProtos(req *rpb.ServerReflectionRequest) (*dpb.FileDescriptorProto, error) {
 	resp, err := cr.send(req)
 	if err != nil {
 		return nil, err
@@ -166,13 +184,6 @@ func (cr *Client) getAndCacheFileDescriptors(req *rpb.ServerReflectionRequest) (
 		}
 
 		cr.cacheMu.Lock()
-		// see if this file was created and cached concurrently
-		if firstFd == nil {
-			if d, ok := cr.filesByName[fd.GetName()]; ok {
-				cr.cacheMu.Unlock()
-				return d, nil
-			}
-		}
 		// store in cache of raw descriptor protos, but don't overwrite existing protos
 		if existingFd, ok := cr.protosByName[fd.GetName()]; ok {
 			fd = existingFd
@@ -187,25 +198,28 @@ func (cr *Client) getAndCacheFileDescriptors(req *rpb.ServerReflectionRequest) (
 	if firstFd == nil {
 		return nil, &ProtocolError{reflect.TypeOf(firstFd).Elem()}
 	}
+	return firstFd, nil
+}
 
-	return cr.descriptorFromProto(firstFd)
+// loadFile creates the descriptor for the named file, using the client as
+// the source of it and its dependencies. Descriptors that are created are
+// cached, so each file is only created once.
+func (cr *Client) loadFile(filename string) (*desc.FileDescriptor, error) {
+	return desc.LoadFileDescriptorFromSourceWithCache(cr, (*clientCache)(cr), filename)
 }
 
-func (cr *Client) descriptorFromProto(fd *dpb.FileDescriptorProto) (*desc.FileDescriptor, error) {
-	deps := make([]*desc.FileDescriptor, len(fd.GetDependency()))
-	for i, depName := range fd.GetDependency() {
-		if dep, err := cr.FileByFilename(depName); err != nil {
-			return nil, err
-		} else {
-			deps[i] = dep
-		}
-	}
-	d, err := desc.CreateFileDescriptor(fd, deps...)
-	if err != nil {
-		return nil, err
-	}
-	d = cr.cacheFile(d)
-	return d, nil
+// clientCache adapts a client's cache of file descriptors to the
+// desc.FileDescriptorCache interface.
+type clientCache Client
+
+func (c *clientCache) GetFileDescriptor(filename string) *desc.FileDescriptor {
+	c.cacheMu.RLock()
+	defer c.cacheMu.RUnlock()
+	return c.filesByName[filename]
+}
+
+func (c *clientCache) PutFileDescriptor(fd *desc.FileDescriptor) *desc.FileDescriptor {
+	return (*Client)(c).cacheFile(fd)
 }
 
 func (cr *Client) cacheFile(fd *desc.FileDescriptor) *desc.FileDescriptor {
@@ -220,52 +234,17 @@ func (cr *Client) cacheFile(fd *desc.FileDescriptor) *desc.FileDescriptor {
 	cr.filesByName[fd.GetName()] = fd
 
 	// also cache by symbols and extensions
-	for _, m := range fd.GetMessageTypes() {
-		cr.cacheMessageLocked(fd, m)
-	}
-	for _, e := range fd.GetEnumTypes() {
-		cr.filesBySymbol[e.GetFullyQualifiedName()] = fd
-		for _, v := range e.GetValues() {
-			cr.filesBySymbol[v.GetFullyQualifiedName()] = fd
-		}
-	}
-	for _, e := range fd.GetExtensions() {
-		cr.filesBySymbol[e.GetFullyQualifiedName()] = fd
-		cr.filesByExtension[extDesc{e.GetOwner().GetFullyQualifiedName(), e.GetNumber()}] = fd
-	}
-	for _, s := range fd.GetServices() {
-		cr.filesBySymbol[s.GetFullyQualifiedName()] = fd
-		for _, m := range s.GetMethods() {
-			cr.filesBySymbol[m.GetFullyQualifiedName()] = fd
+	desc.Walk(fd, func(d desc.Descriptor, _ []int32) error {
+		cr.filesBySymbol[d.GetFullyQualifiedName()] = fd
+		if fld, ok := d.(*desc.FieldDescriptor); ok && fld.IsExtension() {
+			cr.filesByExtension[extDesc{fld.GetOwner().GetFullyQualifiedName(), fld.GetNumber()}] = fd
 		}
-	}
+		return nil
+	})
 
 	return fd
 }
 
-func (cr *Client) cacheMessageLocked(fd *desc.FileDescriptor, md *desc.MessageDescriptor) {
-	cr.filesBySymbol[md.GetFullyQualifiedName()] = fd
-	for _, f := range md.GetFields() {
-		cr.filesBySymbol[f.GetFullyQualifiedName()] = fd
-	}
-	for _, o := range md.GetOneOfs() {
-		cr.filesBySymbol[o.GetFullyQualifiedName()] = fd
-	}
-	for _, e := range md.GetNestedEnumTypes() {
-		cr.filesBySymbol[e.GetFullyQualifiedName()] = fd
-		for _, v := range e.GetValues() {
-			cr.filesBySymbol[v.GetFullyQualifiedName()] = fd
-		}
-	}
-	for _, e := range md.GetNestedExtensions()  {
-		cr.filesBySymbol[e.GetFullyQualifiedName()] = fd
-		cr.filesByExtension[extDesc{e.GetOwner().GetFullyQualifiedName(), e.GetNumber()}] = fd
-	}
-	for _, m := range md.GetNestedMessageTypes() {
-		cr.cacheMessageLocked(fd, m) // recurse
-	}
-}
-
 // AllExtensionNumbersForType asks the server for all known extension numbers
 // for the given fully-qualified message name.
 func (cr *Client) AllExtensionNumbersForType(extendedMessageName string) ([]int32, error) {
@@ -470,7 +449,7 @@ func (cr *Client) ResolveExtension(extendedType string, extensionNumber int32) (
 	if err != nil {
 		return nil, err
 	}
-	d := findExtension(extendedType, extensionNumber, fileDescriptorExtensions{file})
+	d := findExtension(extendedType, extensionNumber, file)
 	if d == nil {
 		return nil, FileOrSymbolNotFound
 	} else {
@@ -478,62 +457,18 @@ func (cr *Client) ResolveExtension(extendedType string, extensionNumber int32) (
 	}
 }
 
-func findExtension(extendedType string, extensionNumber int32, scope extensionScope) *desc.FieldDescriptor {
-	// search extensions in this scope
-	for _, ext := range scope.extensions() {
-		if ext.GetNumber() == extensionNumber && ext.GetOwner().GetFullyQualifiedName() == extendedType {
-			return ext
-		}
-	}
+// errFound is used to stop walking a file once the sought element is found
+var errFound = errors.New("found")
 
-	// if not found, search nested scopes
-	for _, nested := range scope.nestedScopes() {
-		ext := findExtension(extendedType, extensionNumber, nested)
-		if ext != nil {
-			return ext
+func findExtension(extendedType string, extensionNumber int32, file *desc.FileDescriptor) *desc.FieldDescriptor {
+	var ext *desc.FieldDescriptor
+	desc.Walk(file, func(d desc.Descriptor, _ []int32) error {
+		if fld, ok := d.(*desc.FieldDescriptor); ok && fld.IsExtension() &&
+			fld.GetNumber() == extensionNumber && fld.GetOwner().GetFullyQualifiedName() == extendedType {
+			ext = fld
+			return errFound
 		}
-	}
-
-	return nil
-}
-
-type extensionScope interface {
-	extensions() []*desc.FieldDescriptor
-	nestedScopes() []extensionScope
-}
-
-// fileDescriptorExtensions implements extensionHolder interface on top of
-// FileDescriptorProto
-type fileDescriptorExtensions struct {
-	proto *desc.FileDescriptor
-}
-
-func (fde fileDescriptorExtensions) extensions() []*desc.FieldDescriptor {
-	return fde.proto.GetExtensions()
-}
-
-func (fde fileDescriptorExtensions) nestedScopes() []extensionScope {
-	scopes := make([]extensionScope, len(fde.proto.GetMessageTypes()))
-	for i, m := range fde.proto.GetMessageTypes() {
-		scopes[i] = msgDescriptorExtensions{m}
-	}
-	return scopes
-}
-
-// msgDescriptorExtensions implements extensionHolder interface on top of
-// DescriptorProto
-type msgDescriptorExtensions struct {
-	proto *desc.MessageDescriptor
-}
-
-func (mde msgDescriptorExtensions) extensions() []*desc.FieldDescriptor {
-	return mde.proto.GetNestedExtensions()
-}
-
-func (mde msgDescriptorExtensions) nestedScopes() []extensionScope {
-	scopes := make([]extensionScope, len(mde.proto.GetNestedMessageTypes()))
-	for i, m := range mde.proto.GetNestedMessageTypes() {
-		scopes[i] = msgDescriptorExtensions{m}
-	}
-	return scopes
+		return nil
+	})
+	return ext
 }
@@ -13,6 +13,7 @@ import (
 	"google.golang.org/grpc/reflection"
 	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
 
+	"github.com/jhump/protoreflect/desc"
 	"github.com/jhump/protoreflect/desc/desc_test"
 )
 
@@ -193,3 +194,20 @@ func TestRecover(t *testing.T) {
 	eq(t, true, client.stream != nil && client.stream != stream)
 }
 
+
+func TestClientAsDescriptorSource(t *testing.T) {
+	var src desc.DescriptorSource = client
+	fd, err := desc.LoadFileDescriptorFromSource(src, "desc_test_proto3.proto")
+	ok(t, err)
+	eq(t, "desc_test_proto3.proto", fd.GetName())
+	eq(t, "desc_test.TestService", fd.GetServices()[0].GetFullyQualifiedName())
+	eq(t, "desc_test1.proto", fd.GetDependencies()[0].GetName())
+
+	_, err = desc.LoadFileDescriptorFromSource(src, "does not exist")
+	eq(t, FileOrSymbolNotFound, err)
+
+	md, err := desc.LoadMessageDescriptorFromSource(src, "desc_test.TestRequest")
+	ok(t, err)
+	eq(t, "desc_test_proto3.proto", md.GetFile().GetName())
+	eq(t, "desc_test1.proto", md.GetFile().GetDependencies()[0].GetName())
+}